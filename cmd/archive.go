@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"bufio"
+	"github.com/spf13/cobra"
+	"github.com/untanky/git-charged/core"
+	"log"
+	"os"
+	"strings"
+)
+
+// archiveCmd represents the archive command
+var archiveCmd = &cobra.Command{
+	Use:   "archive <rev>",
+	Short: "Export a tree as a tar or zip archive",
+	Long: `Write the files of a commit or tree as a tar, tar.gz (tgz) or zip archive,
+to standard output or to the file given with --output. The format is taken
+from the name of the output file unless --format is given.
+
+Paths with the export-ignore attribute are left out. In files with the
+export-subst attribute, placeholders like $Format:%H$ are replaced with the
+details of the commit.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		format, err := cmd.Flags().GetString("format")
+		if err != nil {
+			format = ""
+		}
+
+		prefix, err := cmd.Flags().GetString("prefix")
+		if err != nil {
+			prefix = ""
+		}
+
+		output, err := cmd.Flags().GetString("output")
+		if err != nil {
+			output = ""
+		}
+
+		if format == "" {
+			format, _ = core.ArchiveFormatFromName(output)
+		}
+
+		file := os.Stdout
+		if output != "" {
+			file, err = os.Create(output)
+			if err != nil {
+				log.Fatalf("failed to create archive: %s", err)
+			}
+		}
+
+		writer := bufio.NewWriter(file)
+		err = core.Archive(core.ArchiveParams{
+			Revision: args[0],
+			Format:   format,
+			Prefix:   prefix,
+			Output:   writer,
+		})
+		if err == nil {
+			err = writer.Flush()
+		}
+		if err == nil && output != "" {
+			err = file.Close()
+		}
+		if err != nil {
+			if output != "" {
+				os.Remove(output)
+			}
+			log.Fatalf("failed to create archive: %s", err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(archiveCmd)
+
+	archiveCmd.Flags().String("format", "", "Format of the archive: "+strings.Join(core.ArchiveFormats, ", "))
+	archiveCmd.Flags().String("prefix", "", "Prepend <prefix> to every path in the archive")
+	archiveCmd.Flags().StringP("output", "o", "", "Write the archive to <file> instead of standard output")
+}
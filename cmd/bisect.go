@@ -0,0 +1,200 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/untanky/git-charged/core"
+	"github.com/untanky/git-charged/ui"
+	"log"
+	"os"
+)
+
+// bisectCmd represents the bisect command
+var bisectCmd = &cobra.Command{
+	Use:   "bisect",
+	Short: "Use binary search to find the commit that introduced a bug",
+	Long: `Find the commit that introduced a bug with a binary search.
+
+Start with "bisect start", mark a commit that has the bug with "bisect bad"
+and one that does not with "bisect good". A commit halfway in between is
+checked out to be tested and marked in turn, until the first bad commit is
+found. "bisect run" automates the testing with a script, and "bisect view"
+shows the remaining candidates and lets you mark commits interactively.`,
+}
+
+var bisectStartCmd = &cobra.Command{
+	Use:   "start [<bad> [<good>...]]",
+	Short: "Start bisecting",
+	Run: func(cmd *cobra.Command, args []string) {
+		params := core.BisectStartParams{}
+		if len(args) > 0 {
+			params.Bad = args[0]
+			params.Good = args[1:]
+		}
+
+		state, err := core.BisectStart(params)
+		if err != nil {
+			log.Fatalf("failed to start bisecting: %s", err)
+		}
+
+		printBisectState(state)
+	},
+}
+
+func newBisectMarkCmd(term string, use string, short string, args cobra.PositionalArgs) *cobra.Command {
+	return &cobra.Command{
+		Use:   use,
+		Short: short,
+		Args:  args,
+		Run: func(cmd *cobra.Command, args []string) {
+			state, err := core.BisectMark(term, args)
+			if err != nil {
+				log.Fatalf("failed to mark %s: %s", term, err)
+			}
+
+			printBisectState(state)
+		},
+	}
+}
+
+var bisectResetCmd = &cobra.Command{
+	Use:   "reset [<commit>]",
+	Short: "Stop bisecting and return to the original branch or commit",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		revision := ""
+		if len(args) == 1 {
+			revision = args[0]
+		}
+
+		err := core.BisectReset(revision)
+		if err != nil {
+			log.Fatalf("failed to reset bisect: %s", err)
+		}
+	},
+}
+
+var bisectLogCmd = &cobra.Command{
+	Use:   "log",
+	Short: "Show the commits marked so far",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		content, err := core.BisectLog()
+		if err != nil {
+			log.Fatalf("failed to read bisect log: %s", err)
+		}
+
+		fmt.Print(content)
+	},
+}
+
+var bisectRunCmd = &cobra.Command{
+	Use:   "run <cmd> [<args>...]",
+	Short: "Find the first bad commit automatically with a test script",
+	Long: `Run a command on every commit to test until the first bad commit is
+found. Exit code 0 marks the commit as good, 125 skips it and every other
+code below 128 marks it as bad. Higher codes stop the search.`,
+	Args:               cobra.MinimumNArgs(1),
+	DisableFlagParsing: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		state, err := core.BisectRun(args, os.Stdout)
+		if err != nil {
+			log.Fatalf("failed to run bisect: %s", err)
+		}
+
+		printBisectResult(state)
+	},
+}
+
+var bisectViewCmd = &cobra.Command{
+	Use:   "view",
+	Short: "Show the remaining candidates and mark commits interactively",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		state, err := core.BisectStatus()
+		if err != nil {
+			log.Fatalf("failed to read bisect status: %s", err)
+		}
+
+		mark := func(term string) (ui.BisectStep, error) {
+			state, err := core.BisectMark(term, nil)
+			if err != nil {
+				return ui.BisectStep{}, err
+			}
+			return bisectStep(state), nil
+		}
+
+		err = ui.NewBisectView(bisectStep(state), mark).Run()
+		if err != nil {
+			log.Fatalf("failed to show bisect: %s", err)
+		}
+	},
+}
+
+func bisectStep(state *core.BisectState) ui.BisectStep {
+	step := ui.BisectStep{
+		Status:     state.Describe(),
+		Candidates: make([]string, 0, len(state.Candidates)),
+		Current:    -1,
+		Finished:   state.Finished(),
+	}
+
+	for i, candidate := range state.Candidates {
+		line := fmt.Sprintf("%x %s", candidate.Hash[:4], candidate.Summary)
+		if candidate.Skipped {
+			line += " (skipped)"
+		}
+		if bytes.Equal(candidate.Hash, state.Current) || bytes.Equal(candidate.Hash, state.FirstBad) {
+			step.Current = i
+		}
+		step.Candidates = append(step.Candidates, line)
+	}
+
+	return step
+}
+
+func printBisectState(state *core.BisectState) {
+	if state.Current == nil {
+		printBisectResult(state)
+		return
+	}
+
+	fmt.Println(state.Describe())
+	for _, candidate := range state.Candidates {
+		if bytes.Equal(candidate.Hash, state.Current) {
+			fmt.Printf("[%x] %s\n", candidate.Hash, candidate.Summary)
+		}
+	}
+}
+
+func printBisectResult(state *core.BisectState) {
+	fmt.Println(state.Describe())
+
+	for _, candidate := range state.Candidates {
+		switch {
+		case state.OnlySkipped && (candidate.Skipped || bytes.Equal(candidate.Hash, state.Candidates[0].Hash)):
+			fmt.Printf("%x\n", candidate.Hash)
+		case bytes.Equal(candidate.Hash, state.FirstBad):
+			fmt.Printf("[%x] %s\n", candidate.Hash, candidate.Summary)
+		}
+	}
+
+	if state.OnlySkipped {
+		fmt.Println("We cannot bisect more!")
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(bisectCmd)
+	bisectCmd.AddCommand(
+		bisectStartCmd,
+		newBisectMarkCmd(core.BisectBad, "bad [<rev>]", "Mark a commit that has the bug", cobra.MaximumNArgs(1)),
+		newBisectMarkCmd(core.BisectGood, "good [<rev>...]", "Mark commits that do not have the bug", cobra.ArbitraryArgs),
+		newBisectMarkCmd(core.BisectSkip, "skip [<rev>...]", "Skip commits that cannot be tested", cobra.ArbitraryArgs),
+		bisectResetCmd,
+		bisectLogCmd,
+		bisectRunCmd,
+		bisectViewCmd,
+	)
+}
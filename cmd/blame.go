@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/untanky/git-charged/core"
+	"github.com/untanky/git-charged/ui"
+	"log"
+	"strconv"
+)
+
+// blameCmd represents the blame command
+var blameCmd = &cobra.Command{
+	Use:   "blame [<rev>] <file>",
+	Short: "Show which commit last changed each line of a file",
+	Long: `Annotate each line of a file with the commit that last changed it,
+following the file through renames. Lines that were moved within the file
+or copied from other files can be traced back to where they were written.
+
+With --interactive the annotated file is shown in a viewer where enter
+blames the parent of the commit under the cursor, to look at the history
+before a change, and b goes back again.`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		params := core.BlameParams{Path: args[len(args)-1]}
+		if len(args) == 2 {
+			params.Revision = args[0]
+		}
+
+		var err error
+		params.DetectMoves, err = cmd.Flags().GetBool("moves")
+		if err != nil {
+			params.DetectMoves = false
+		}
+
+		params.DetectCopies, err = cmd.Flags().GetBool("copies")
+		if err != nil {
+			params.DetectCopies = false
+		}
+
+		interactive, err := cmd.Flags().GetBool("interactive")
+		if err != nil {
+			interactive = false
+		}
+
+		if interactive {
+			page, err := blamePage(params, 0)
+			if err != nil {
+				log.Fatalf("failed to blame %s: %s", params.Path, err)
+			}
+
+			err = ui.NewBlameView(page).Run()
+			if err != nil {
+				log.Fatalf("failed to show blame: %s", err)
+			}
+			return
+		}
+
+		lines, err := core.Blame(params)
+		if err != nil {
+			log.Fatalf("failed to blame %s: %s", params.Path, err)
+		}
+
+		for _, line := range formatBlameLines(lines, params.Path) {
+			fmt.Println(line)
+		}
+	},
+}
+
+// formatBlameLines annotates lines like git blame does. The original path
+// is only shown if some lines come from another file.
+func formatBlameLines(lines []core.BlameLine, path string) []string {
+	authorWidth, pathWidth, showPath := 0, 0, false
+	for _, line := range lines {
+		authorWidth = max(authorWidth, len(line.Author.Name))
+		pathWidth = max(pathWidth, len(line.Path))
+		showPath = showPath || line.Path != path
+	}
+	lineWidth := len(strconv.Itoa(len(lines)))
+
+	formatted := make([]string, len(lines))
+	for i, line := range lines {
+		hash := fmt.Sprintf("%x", line.Commit)[:8]
+		if line.Boundary {
+			hash = "^" + hash[:7]
+		}
+		if showPath {
+			hash += fmt.Sprintf(" %-*s", pathWidth, line.Path)
+		}
+
+		formatted[i] = fmt.Sprintf("%s (%-*s %s %*d) %s", hash, authorWidth, line.Author.Name,
+			line.Author.Timestamp.Format("2006-01-02 15:04:05 -0700"), lineWidth, line.Line, line.Content)
+	}
+
+	return formatted
+}
+
+// blamePage blames a file for the interactive viewer, which continues with
+// the parent of a line's commit at the path and line it had there.
+func blamePage(params core.BlameParams, cursor int) (ui.BlamePage, error) {
+	lines, err := core.Blame(params)
+	if err != nil {
+		return ui.BlamePage{}, err
+	}
+
+	revision := params.Revision
+	if revision == "" {
+		revision = "HEAD"
+	}
+
+	return ui.BlamePage{
+		Title:  fmt.Sprintf("%s at %s", params.Path, revision),
+		Lines:  formatBlameLines(lines, params.Path),
+		Cursor: max(0, min(len(lines)-1, cursor)),
+		Parent: func(i int) (ui.BlamePage, error) {
+			line := lines[i]
+			if line.Boundary {
+				return ui.BlamePage{}, errors.New("the commit of this line has no known parent")
+			}
+
+			parent := params
+			parent.Revision = fmt.Sprintf("%x^", line.Commit)
+			parent.Path = line.Path
+			return blamePage(parent, line.OriginalLine-1)
+		},
+	}, nil
+}
+
+func init() {
+	rootCmd.AddCommand(blameCmd)
+
+	blameCmd.Flags().BoolP("moves", "M", false, "Detect lines moved within the file")
+	blameCmd.Flags().BoolP("copies", "C", false, "Detect lines moved or copied from other files changed in the same commit")
+	blameCmd.Flags().BoolP("interactive", "i", false, "Browse the blame and jump to the parents of blamed commits")
+}
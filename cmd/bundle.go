@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/untanky/git-charged/core"
+	"log"
+	"os"
+)
+
+// bundleCmd represents the bundle command
+var bundleCmd = &cobra.Command{
+	Use:   "bundle",
+	Short: "Move objects and refs by archive",
+	Long: `Create, verify and unpack git bundle files.
+
+A bundle contains references and the objects they need in a single file,
+which can be copied to machines without network access and used as a
+source for clone and fetch.`,
+}
+
+var bundleCreateCmd = &cobra.Command{
+	Use:   "create <file> <revision>...",
+	Short: "Create a bundle from a revision range",
+	Long: `Create a bundle containing the given revisions.
+
+Revisions are references like "main", ranges like "v1.0..main", exclusions
+like "^v1.0" or "--all" for every branch and tag. Commits that are
+excluded become prerequisites the receiving repository must have.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		version, err := cmd.Flags().GetInt("version")
+		if err != nil {
+			version = 2
+		}
+
+		revisions := args[1:]
+		if all, _ := cmd.Flags().GetBool("all"); all {
+			revisions = append(revisions, "--all")
+		}
+		if len(revisions) == 0 {
+			log.Fatal("no revisions given for the bundle")
+		}
+
+		err = core.CreateBundle(core.CreateBundleParams{
+			File:      args[0],
+			Revisions: revisions,
+			Version:   version,
+			Progress:  os.Stderr,
+		})
+		if err != nil {
+			log.Fatalf("failed to create bundle: %s", err)
+		}
+	},
+}
+
+var bundleVerifyCmd = &cobra.Command{
+	Use:   "verify <file>",
+	Short: "Check that a bundle is valid and can be applied",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		bundle, err := core.VerifyBundle(args[0])
+		if err != nil {
+			log.Fatalf("%s is not okay: %s", args[0], err)
+		}
+
+		fmt.Printf("The bundle contains %d ref(s)\n", len(bundle.Refs))
+		for _, ref := range bundle.Refs {
+			fmt.Printf("%x %s\n", ref.Hash, ref.Name)
+		}
+		if len(bundle.Prerequisites) == 0 {
+			fmt.Println("The bundle records a complete history.")
+		} else {
+			fmt.Printf("The bundle requires %d ref(s)\n", len(bundle.Prerequisites))
+			for _, prerequisite := range bundle.Prerequisites {
+				fmt.Printf("%x\n", prerequisite)
+			}
+		}
+		fmt.Printf("%s is okay\n", args[0])
+	},
+}
+
+var bundleListHeadsCmd = &cobra.Command{
+	Use:   "list-heads <file>",
+	Short: "List the references in a bundle",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		bundle, err := core.ReadBundle(args[0])
+		if err != nil {
+			log.Fatalf("failed to read bundle: %s", err)
+		}
+
+		for _, ref := range bundle.Refs {
+			fmt.Printf("%x %s\n", ref.Hash, ref.Name)
+		}
+	},
+}
+
+var bundleUnbundleCmd = &cobra.Command{
+	Use:   "unbundle <file>",
+	Short: "Store the objects of a bundle and list its references",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		bundle, err := core.Unbundle(args[0])
+		if err != nil {
+			log.Fatalf("failed to unbundle: %s", err)
+		}
+
+		for _, ref := range bundle.Refs {
+			fmt.Printf("%x %s\n", ref.Hash, ref.Name)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(bundleCmd)
+	bundleCmd.AddCommand(bundleCreateCmd, bundleVerifyCmd, bundleListHeadsCmd, bundleUnbundleCmd)
+
+	bundleCreateCmd.Flags().Int("version", 2, "Bundle format version (2 or 3)")
+	bundleCreateCmd.Flags().Bool("all", false, "Bundle every branch and tag")
+}
@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/untanky/git-charged/core"
+	"log"
+)
+
+// changelogCmd represents the changelog command
+var changelogCmd = &cobra.Command{
+	Use:   "changelog [<from>..<to>]",
+	Short: "Write the changes of a release to CHANGELOG.md",
+	Long: `Group the Conventional Commits between two revisions into the Added,
+Changed and Fixed sections of a Keep a Changelog release, and write it to
+CHANGELOG.md. A section for the same version is replaced, and a new one is
+put above the previous releases.
+
+The release is named after <to> if it is a tag, and "Unreleased"
+otherwise. If remote.origin.url points to GitHub, commits, pull requests
+and issues are linked, and the release links to a comparison with <from>.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		params := core.ChangelogParams{}
+		if len(args) == 1 {
+			params.Range = args[0]
+		}
+
+		var err error
+		params.Version, err = cmd.Flags().GetString("release")
+		if err != nil {
+			params.Version = ""
+		}
+
+		output, err := cmd.Flags().GetString("output")
+		if err != nil {
+			output = core.ChangelogFile
+		}
+
+		stdout, err := cmd.Flags().GetBool("stdout")
+		if err != nil {
+			stdout = false
+		}
+
+		release, err := core.Changelog(params)
+		if err != nil {
+			log.Fatalf("failed to generate changelog: %s", err)
+		}
+
+		if stdout {
+			fmt.Print(release.Markdown)
+			if release.Link != "" {
+				fmt.Printf("\n[%s]: %s\n", release.Version, release.Link)
+			}
+			return
+		}
+
+		err = core.WriteChangelog(output, release)
+		if err != nil {
+			log.Fatalf("failed to write changelog: %s", err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(changelogCmd)
+
+	changelogCmd.Flags().String("release", "", "Name the release instead of using the tag at the end of the range")
+	changelogCmd.Flags().StringP("output", "o", core.ChangelogFile, "Write the changelog to the given file")
+	changelogCmd.Flags().Bool("stdout", false, "Print the release instead of writing the changelog")
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"errors"
+	"github.com/spf13/cobra"
+	"github.com/untanky/git-charged/core"
+	"log"
+	"os"
+)
+
+// cherryPickCmd represents the cherry-pick command
+var cherryPickCmd = &cobra.Command{
+	Use:   "cherry-pick <commit>...",
+	Short: "Apply the changes introduced by existing commits",
+	Long: `Apply the changes introduced by existing commits on top of HEAD and
+commit each of them. A range like main..topic picks every commit of topic
+that is not on main, oldest first.
+
+If a commit cannot be applied cleanly, the conflicts are left in the
+working tree. Resolve them and run cherry-pick --continue, which stages the
+conflicting files and applies the remaining commits, or return to where you
+started with cherry-pick --abort.
+
+Merge commits are only picked with --mainline, which names the parent whose
+side of the merge is taken as the base.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runSequenceCommand(cmd, args, false)
+	},
+}
+
+func runSequenceCommand(cmd *cobra.Command, args []string, revert bool) {
+	name := "cherry-pick"
+	if revert {
+		name = "revert"
+	}
+
+	continueSequence, err := cmd.Flags().GetBool("continue")
+	if err != nil {
+		continueSequence = false
+	}
+
+	abort, err := cmd.Flags().GetBool("abort")
+	if err != nil {
+		abort = false
+	}
+
+	mainline, err := cmd.Flags().GetInt("mainline")
+	if err != nil {
+		mainline = 0
+	}
+
+	switch {
+	case continueSequence && abort:
+		log.Fatalf("failed to %s: --continue and --abort cannot be used together", name)
+	case (continueSequence || abort) && len(args) > 0:
+		log.Fatalf("failed to %s: commits cannot be given with --continue or --abort", name)
+	case !continueSequence && !abort && len(args) == 0:
+		log.Fatalf("failed to %s: no commits given", name)
+	case mainline < 0:
+		log.Fatalf("failed to %s: the mainline must be a parent number starting at 1", name)
+	}
+
+	switch {
+	case continueSequence:
+		err = core.ContinueSequence(os.Stdout)
+	case abort:
+		err = core.AbortSequence(os.Stdout)
+	default:
+		err = core.CherryPick(core.CherryPickParams{Revisions: args, Revert: revert, Mainline: mainline, Progress: os.Stdout})
+	}
+
+	var conflict *core.ConflictError
+	if errors.As(err, &conflict) {
+		log.Fatalf("failed to %s: %s\nresolve the conflicts and run %s --continue, or %s --abort", name, err, name, name)
+	}
+	if err != nil {
+		log.Fatalf("failed to %s: %s", name, err)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(cherryPickCmd)
+
+	cherryPickCmd.Flags().Bool("continue", false, "Commit the resolved conflicts and apply the remaining commits")
+	cherryPickCmd.Flags().Bool("abort", false, "Cancel and return to the commit before the cherry-pick")
+	cherryPickCmd.Flags().IntP("mainline", "m", 0, "Pick merge commits relative to this parent, starting at 1")
+}
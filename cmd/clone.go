@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/untanky/git-charged/core"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var gitHubShorthand = regexp.MustCompile(`^[\w.-]+/[\w.-]+$`)
+
+// cloneCmd represents the clone command
+var cloneCmd = &cobra.Command{
+	Use:   "clone <repository> [directory]",
+	Short: "Clone a repository into a new directory",
+	Long: `Clone a repository into a new directory without calling git.
+
+The repository can be any git URL (https, ssh or file) or a GitHub
+shorthand like owner/repo.`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		url := expandRepositoryURL(args[0])
+
+		directory := strings.TrimSuffix(path.Base(strings.TrimSuffix(url, "/")), ".git")
+		directory = strings.TrimSuffix(directory, ".bundle")
+		if len(args) == 2 {
+			directory = args[1]
+		}
+
+		branch, err := cmd.Flags().GetString("branch")
+		if err != nil {
+			branch = ""
+		}
+
+		singleBranch, _ := cmd.Flags().GetBool("single-branch")
+		depth, _ := cmd.Flags().GetInt("depth")
+		filter, _ := cmd.Flags().GetString("filter")
+		shallowSince, err := shallowSinceFlag(cmd)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if entries, err := os.ReadDir(directory); err == nil && len(entries) > 0 {
+			log.Fatalf("destination path '%s' already exists and is not an empty directory", directory)
+		}
+
+		err = os.MkdirAll(directory, os.ModePerm)
+		if err != nil {
+			log.Fatalf("failed to create directory: %s", err)
+		}
+
+		workingDirectory, err := os.Getwd()
+		if err != nil {
+			log.Fatalf("failed to get working directory: %s", err)
+		}
+
+		err = os.Chdir(directory)
+		if err != nil {
+			log.Fatalf("failed to change directory: %s", err)
+		}
+
+		err = core.Clone(core.CloneParams{
+			URL:          url,
+			Branch:       branch,
+			SingleBranch: singleBranch,
+			Depth:        depth,
+			ShallowSince: shallowSince,
+			Filter:       filter,
+			Progress:     os.Stderr,
+		})
+		if err != nil {
+			os.Chdir(workingDirectory)
+			os.RemoveAll(directory)
+			log.Fatalf("failed to clone: %s", err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(cloneCmd)
+
+	cloneCmd.Flags().StringP("branch", "b", "", "Check out the given branch instead of the remote HEAD")
+	cloneCmd.Flags().Bool("single-branch", false, "Only fetch the branch that is checked out, now and in later fetches")
+	cloneCmd.Flags().Int("depth", 0, "Create a shallow clone with a history truncated to the given number of commits")
+	cloneCmd.Flags().String("shallow-since", "", "Create a shallow clone with a history after the given date")
+	cloneCmd.Flags().String("filter", "", "Create a partial clone without the objects matching the filter, e.g. blob:none")
+}
+
+// shallowSinceFlag parses --shallow-since as a unix timestamp, a date or
+// an RFC 3339 time.
+func shallowSinceFlag(cmd *cobra.Command) (time.Time, error) {
+	value, _ := cmd.Flags().GetString("shallow-since")
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", time.DateOnly} {
+		if date, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return date, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid date for --shallow-since: %s", value)
+}
+
+func expandRepositoryURL(repository string) string {
+	if _, err := os.Stat(repository); err == nil {
+		// Local paths must survive changing into the new directory.
+		if absolute, err := filepath.Abs(repository); err == nil {
+			return absolute
+		}
+		return repository
+	}
+
+	if gitHubShorthand.MatchString(repository) {
+		return fmt.Sprintf("https://github.com/%s.git", strings.TrimSuffix(repository, ".git"))
+	}
+
+	return repository
+}
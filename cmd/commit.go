@@ -0,0 +1,232 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/untanky/git-charged/core"
+	"github.com/untanky/git-charged/ui"
+	"log"
+	"os"
+	"strings"
+)
+
+// commitCmd represents the commit command
+var commitCmd = &cobra.Command{
+	Use:   "commit",
+	Short: "Record the staged changes as a new commit",
+	Long: `Record the changes in the index as a new commit on top of HEAD, or
+replace HEAD with --amend.
+
+Without --message, the message is written in the configured editor. The
+pre-commit, prepare-commit-msg, commit-msg and post-commit hooks run like
+they do for git; --no-verify skips pre-commit and commit-msg.
+
+--trailer, --fixes and --signoff add trailers such as
+"Co-authored-by: Name <email>" to the end of the message. A trailer that
+is already the last one is not added again.
+
+--conventional asks for the type, scope and description of a Conventional
+Commits message, suggesting the scopes used before, or checks the message
+given with --message.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		messages, err := cmd.Flags().GetStringArray("message")
+		if err != nil {
+			messages = nil
+		}
+
+		params := core.CommitParams{
+			Message:     strings.Join(messages, "\n\n"),
+			EditMessage: editMessage,
+			Progress:    os.Stdout,
+		}
+
+		params.Amend, err = cmd.Flags().GetBool("amend")
+		if err != nil {
+			params.Amend = false
+		}
+
+		params.AllowEmpty, err = cmd.Flags().GetBool("allow-empty")
+		if err != nil {
+			params.AllowEmpty = false
+		}
+
+		params.NoVerify, err = cmd.Flags().GetBool("no-verify")
+		if err != nil {
+			params.NoVerify = false
+		}
+
+		signoff, err := cmd.Flags().GetBool("signoff")
+		if err != nil {
+			signoff = false
+		}
+		if signoff {
+			trailer, err := core.SignoffTrailer()
+			if err != nil {
+				log.Fatalf("failed to commit: %s", err)
+			}
+			params.Trailers = append(params.Trailers, trailer)
+		}
+
+		trailers, err := cmd.Flags().GetStringArray("trailer")
+		if err != nil {
+			trailers = nil
+		}
+		for _, trailer := range trailers {
+			parsed, err := core.ParseTrailer(trailer)
+			if err != nil {
+				log.Fatalf("failed to commit: %s", err)
+			}
+			params.Trailers = append(params.Trailers, parsed)
+		}
+
+		fixes, err := cmd.Flags().GetStringArray("fixes")
+		if err != nil {
+			fixes = nil
+		}
+		for _, revision := range fixes {
+			trailer, err := core.FixesTrailer(revision)
+			if err != nil {
+				log.Fatalf("failed to commit: %s", err)
+			}
+			params.Trailers = append(params.Trailers, trailer)
+		}
+
+		conventional, err := cmd.Flags().GetBool("conventional")
+		if err != nil {
+			conventional = false
+		}
+		if conventional && params.Message != "" {
+			err = core.LintCommitMessage(params.Message)
+			if err != nil {
+				log.Fatalf("failed to commit: message is not a conventional commit: %s", err)
+			}
+		} else if conventional {
+			params.Message, err = promptConventionalMessage()
+			if err != nil {
+				log.Fatalf("failed to commit: %s", err)
+			}
+		}
+
+		_, err = core.Commit(params)
+		if err != nil {
+			log.Fatalf("failed to commit: %s", err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(commitCmd)
+
+	commitCmd.Flags().StringArrayP("message", "m", nil, "Use the given message; several are joined as paragraphs")
+	commitCmd.Flags().Bool("amend", false, "Replace the commit HEAD points to")
+	commitCmd.Flags().Bool("allow-empty", false, "Allow a commit that does not change anything")
+	commitCmd.Flags().Bool("conventional", false, "Write or check a Conventional Commits message")
+	commitCmd.Flags().StringArray("trailer", nil, "Add a trailer like \"Reviewed-by: Name <email>\" to the message")
+	commitCmd.Flags().StringArray("fixes", nil, "Add a Fixes trailer naming the given commit")
+	commitCmd.Flags().BoolP("signoff", "s", false, "Add a Signed-off-by trailer for the configured user")
+	commitCmd.Flags().BoolP("no-verify", "n", false, "Do not run the pre-commit and commit-msg hooks")
+}
+
+// promptConventionalMessage asks for the parts of a Conventional Commits
+// message.
+func promptConventionalMessage() (string, error) {
+	types := make([]string, len(core.ConventionalTypes))
+	for i, conventionalType := range core.ConventionalTypes {
+		types[i] = fmt.Sprintf("%-9s %s", conventionalType.Name, conventionalType.Description)
+	}
+
+	selected, err := ui.NewSelect("Select the type of change", types).Run()
+	if err != nil {
+		return "", err
+	}
+	if selected == "" {
+		return "", ui.ErrInterrupted
+	}
+	commit := core.ConventionalCommit{Type: strings.Fields(selected)[0]}
+
+	commit.Scope, err = promptConventionalScope()
+	if err != nil {
+		return "", err
+	}
+
+	commit.Description, err = ui.NewInput("Write a short description in the imperative mood", func(description string) error {
+		commit := commit
+		commit.Description = description
+		_, err := core.ParseConventionalCommit(commit.String())
+		return err
+	}).Run()
+	if err != nil {
+		return "", err
+	}
+
+	commit.Body, err = ui.NewInput("Write a longer description (optional)", nil).Run()
+	if err != nil {
+		return "", err
+	}
+
+	breaking, err := ui.NewSelect("Does this change break compatibility?", []string{"no", "yes"}).Run()
+	if err != nil {
+		return "", err
+	}
+	if breaking == "" {
+		return "", ui.ErrInterrupted
+	}
+	if breaking == "yes" {
+		commit.Breaking = true
+		commit.BreakingChange, err = ui.NewInput("Describe the breaking change", func(description string) error {
+			if strings.TrimSpace(description) == "" {
+				return fmt.Errorf("the description must not be empty")
+			}
+			return nil
+		}).Run()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	message := commit.String()
+	_, err = core.ParseConventionalCommit(message)
+	if err != nil {
+		return "", err
+	}
+
+	return message, nil
+}
+
+// promptConventionalScope offers the scopes of earlier commits, or asks for
+// a new one.
+func promptConventionalScope() (string, error) {
+	const noScope = "(no scope)"
+	const newScope = "(new scope)"
+
+	scopes, err := core.ConventionalScopes()
+	if err != nil {
+		return "", err
+	}
+
+	scope := newScope
+	if len(scopes) > 0 {
+		options := append(append([]string{noScope}, scopes...), newScope)
+		scope, err = ui.NewSelect("Select the scope of the change", options).Run()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	switch scope {
+	case "":
+		return "", ui.ErrInterrupted
+	case noScope:
+		return "", nil
+	case newScope:
+		return ui.NewInput("Name the scope of the change (optional)", func(scope string) error {
+			if strings.ContainsAny(scope, "()") {
+				return fmt.Errorf("the scope must not contain parentheses")
+			}
+			return nil
+		}).Run()
+	}
+
+	return scope, nil
+}
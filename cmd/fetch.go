@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/untanky/git-charged/core"
+	"log"
+	"os"
+)
+
+// fetchCmd represents the fetch command
+var fetchCmd = &cobra.Command{
+	Use:   "fetch [remote]",
+	Short: "Download objects and refs from a remote",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		remote := "origin"
+		if len(args) == 1 {
+			remote = args[0]
+		}
+
+		depth, _ := cmd.Flags().GetInt("depth")
+		unshallow, _ := cmd.Flags().GetBool("unshallow")
+		tags, _ := cmd.Flags().GetBool("tags")
+		shallowSince, err := shallowSinceFlag(cmd)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		_, err = core.Fetch(core.FetchParams{
+			Remote:       remote,
+			Depth:        depth,
+			ShallowSince: shallowSince,
+			Unshallow:    unshallow,
+			Tags:         tags,
+			Progress:     os.Stderr,
+		})
+		if err != nil {
+			log.Fatalf("failed to fetch: %s", err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(fetchCmd)
+
+	fetchCmd.Flags().Int("depth", 0, "Limit the history of a shallow repository to the given number of commits")
+	fetchCmd.Flags().String("shallow-since", "", "Deepen the history of a shallow repository to the given date")
+	fetchCmd.Flags().Bool("unshallow", false, "Fetch the complete history of a shallow repository")
+	fetchCmd.Flags().Bool("tags", false, "Fetch all tags instead of only those pointing into the fetched history")
+}
@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/untanky/git-charged/core"
+	"github.com/untanky/git-charged/ui"
+	"log"
+	"os"
+)
+
+// grepCmd represents the grep command
+var grepCmd = &cobra.Command{
+	Use:   "grep <pattern> [<rev>] [-- <path>...]",
+	Short: "Search tracked files for lines matching a pattern",
+	Long: `Print the lines of tracked files that match a regular expression. The
+working tree is searched unless --cached selects the index or a revision
+is given. Paths after -- limit the search to those files and directories,
+or to files matching them as wildcards.
+
+With --interactive the matches are shown in a picker, and the chosen one
+is opened in the configured editor at its line.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		params := core.GrepParams{Pattern: args[0]}
+
+		positional := args[1:]
+		if dash := cmd.ArgsLenAtDash(); dash >= 0 {
+			if dash == 0 {
+				log.Fatalf("failed to grep: no pattern given")
+			}
+			positional = args[1:dash]
+			params.Pathspecs = args[dash:]
+		}
+		if len(positional) > 1 {
+			log.Fatalf("failed to grep: only one revision can be searched")
+		}
+		if len(positional) == 1 {
+			params.Revision = positional[0]
+		}
+
+		var err error
+		params.IgnoreCase, err = cmd.Flags().GetBool("ignore-case")
+		if err != nil {
+			params.IgnoreCase = false
+		}
+
+		params.FixedStrings, err = cmd.Flags().GetBool("fixed-strings")
+		if err != nil {
+			params.FixedStrings = false
+		}
+
+		params.WordRegexp, err = cmd.Flags().GetBool("word-regexp")
+		if err != nil {
+			params.WordRegexp = false
+		}
+
+		params.Cached, err = cmd.Flags().GetBool("cached")
+		if err != nil {
+			params.Cached = false
+		}
+
+		lineNumbers, err := cmd.Flags().GetBool("line-number")
+		if err != nil {
+			lineNumbers = false
+		}
+
+		filesWithMatches, err := cmd.Flags().GetBool("files-with-matches")
+		if err != nil {
+			filesWithMatches = false
+		}
+
+		interactive, err := cmd.Flags().GetBool("interactive")
+		if err != nil {
+			interactive = false
+		}
+
+		matches, err := core.Grep(params)
+		if err != nil {
+			log.Fatalf("failed to grep: %s", err)
+		}
+		if len(matches) == 0 {
+			os.Exit(1)
+		}
+
+		if interactive {
+			err = pickGrepMatch(matches)
+			if err != nil {
+				log.Fatalf("failed to open match: %s", err)
+			}
+			return
+		}
+
+		previous := ""
+		for _, match := range matches {
+			name := grepMatchName(match)
+			switch {
+			case filesWithMatches:
+				if name != previous {
+					fmt.Println(name)
+				}
+			case match.Binary:
+				fmt.Printf("Binary file %s matches\n", name)
+			case lineNumbers:
+				fmt.Printf("%s:%d:%s\n", name, match.Line, match.Content)
+			default:
+				fmt.Printf("%s:%s\n", name, match.Content)
+			}
+			previous = name
+		}
+	},
+}
+
+func grepMatchName(match core.GrepMatch) string {
+	if match.Revision != "" {
+		return match.Revision + ":" + match.Path
+	}
+
+	return match.Path
+}
+
+// pickGrepMatch lets the user choose a match and opens its file at the
+// matching line. Matches in a revision open the file of the working tree.
+func pickGrepMatch(matches []core.GrepMatch) error {
+	options := make([]string, 0, len(matches))
+	byOption := make(map[string]core.GrepMatch, len(matches))
+	for _, match := range matches {
+		if match.Binary {
+			continue
+		}
+
+		option := fmt.Sprintf("%s:%d: %s", grepMatchName(match), match.Line, match.Content)
+		options = append(options, option)
+		byOption[option] = match
+	}
+
+	selected, err := ui.NewSelect(fmt.Sprintf("%d matches", len(options)), options).Run()
+	if err != nil {
+		return err
+	}
+
+	match, ok := byOption[selected]
+	if !ok {
+		return nil
+	}
+
+	return ui.OpenEditorAt(match.Path, match.Line)
+}
+
+func init() {
+	rootCmd.AddCommand(grepCmd)
+
+	grepCmd.Flags().BoolP("ignore-case", "i", false, "Ignore case differences between the pattern and the files")
+	grepCmd.Flags().BoolP("fixed-strings", "F", false, "Match the pattern as a literal string")
+	grepCmd.Flags().BoolP("word-regexp", "w", false, "Match the pattern only at word boundaries")
+	grepCmd.Flags().Bool("cached", false, "Search the staged content instead of the working tree")
+	grepCmd.Flags().BoolP("line-number", "n", false, "Prefix matching lines with their line number")
+	grepCmd.Flags().BoolP("files-with-matches", "l", false, "Only print the names of files with matches")
+	grepCmd.Flags().Bool("interactive", false, "Pick a match and open it in the editor")
+}
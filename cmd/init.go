@@ -79,10 +79,13 @@ var initCmd = &cobra.Command{
 			}
 		}
 
-		err = core.InitDB(core.InitDBParams{
-			GitIgnoreFile: gitignoreFile,
-			ReadmeFile:    readmeReader,
-			LicenseFile:   licenseReader,
+		err = ui.NewProgress("Creating repository").Run(func(progress io.Writer) error {
+			return core.InitDB(core.InitDBParams{
+				GitIgnoreFile: gitignoreFile,
+				ReadmeFile:    readmeReader,
+				LicenseFile:   licenseReader,
+				Progress:      progress,
+			})
 		})
 		if err != nil {
 			log.Fatalf("failed to init git: %s", err)
@@ -166,7 +169,7 @@ func selectLicense(readmeFile *os.File) error {
 		options = append(options, strings.TrimSuffix(name, ".txt"))
 	}
 
-	selectedOption, err := ui.NewSelect(options).Run()
+	selectedOption, err := ui.NewSelect("Which license do you want to use?", options).Run()
 	if err != nil {
 		return err
 	}
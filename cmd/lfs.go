@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/untanky/git-charged/core"
+	"log"
+	"os"
+)
+
+// lfsCmd represents the lfs command
+var lfsCmd = &cobra.Command{
+	Use:   "lfs",
+	Short: "Store large files with Git LFS",
+	Long: `Store large files with Git LFS.
+
+Files matching a tracked pattern are stored in .git/lfs/objects and only a
+small pointer is committed. The content is uploaded to the LFS server of
+the remote when pushing and downloaded on checkout.`,
+}
+
+var lfsTrackCmd = &cobra.Command{
+	Use:   "track [pattern...]",
+	Short: "Store files matching the patterns with LFS",
+	Long: `Store files matching the patterns with LFS by adding them to
+.gitattributes. Without patterns the tracked patterns are listed.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			patterns, err := core.TrackedLFSPatterns()
+			if err != nil {
+				log.Fatalf("failed to read tracked patterns: %s", err)
+			}
+
+			fmt.Println("Listing tracked patterns")
+			for _, pattern := range patterns {
+				fmt.Printf("    %s (.gitattributes)\n", pattern)
+			}
+			return
+		}
+
+		err := core.TrackLFS(args)
+		if err != nil {
+			log.Fatalf("failed to track patterns: %s", err)
+		}
+
+		for _, pattern := range args {
+			fmt.Printf("Tracking \"%s\"\n", pattern)
+		}
+	},
+}
+
+var lfsLsFilesCmd = &cobra.Command{
+	Use:   "ls-files",
+	Short: "List the files stored with LFS",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		files, err := core.LFSFiles()
+		if err != nil {
+			log.Fatalf("failed to list LFS files: %s", err)
+		}
+
+		for _, file := range files {
+			marker := "-"
+			if file.Local {
+				marker = "*"
+			}
+			fmt.Printf("%s %s %s\n", file.Object.OID[:10], marker, file.Path)
+		}
+	},
+}
+
+var lfsPullCmd = &cobra.Command{
+	Use:   "pull [remote]",
+	Short: "Download LFS content and check it out",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		remote := "origin"
+		if len(args) == 1 {
+			remote = args[0]
+		}
+
+		err := core.PullLFS(remote, os.Stderr)
+		if err != nil {
+			log.Fatalf("failed to pull LFS objects: %s", err)
+		}
+	},
+}
+
+var lfsPushCmd = &cobra.Command{
+	Use:   "push [remote]",
+	Short: "Upload LFS content of local branches",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		remote := "origin"
+		if len(args) == 1 {
+			remote = args[0]
+		}
+
+		err := core.PushLFS(remote)
+		if err != nil {
+			log.Fatalf("failed to push LFS objects: %s", err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(lfsCmd)
+	lfsCmd.AddCommand(lfsTrackCmd, lfsLsFilesCmd, lfsPullCmd, lfsPushCmd)
+}
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/untanky/git-charged/core"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// lintCommitCmd represents the lint-commit command
+var lintCommitCmd = &cobra.Command{
+	Use:   "lint-commit [<message file>]",
+	Short: "Check that a commit message follows Conventional Commits",
+	Long: `Check a commit message file, or the message read from stdin, against
+the Conventional Commits specification. Comments are ignored, and so are
+the merge, revert and fixup messages git writes itself.
+
+--install-hook installs a commit-msg hook running this check, so commits
+made with plain git are checked as well.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		installHook, err := cmd.Flags().GetBool("install-hook")
+		if err != nil {
+			installHook = false
+		}
+
+		if installHook {
+			executable, err := os.Executable()
+			if err != nil {
+				log.Fatalf("failed to install hook: %s", err)
+			}
+
+			command := "'" + strings.ReplaceAll(executable, "'", `'\''`) + "' lint-commit"
+			err = core.InstallConventionalCommitHook(command)
+			if err != nil {
+				log.Fatalf("failed to install hook: %s", err)
+			}
+			return
+		}
+
+		var content []byte
+		if len(args) == 1 {
+			content, err = os.ReadFile(args[0])
+		} else {
+			content, err = io.ReadAll(os.Stdin)
+		}
+		if err != nil {
+			log.Fatalf("failed to read commit message: %s", err)
+		}
+
+		err = core.LintCommitMessage(string(content))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "commit message is not a conventional commit: %s\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(lintCommitCmd)
+
+	lintCommitCmd.Flags().Bool("install-hook", false, "Install a commit-msg hook that checks every commit message")
+}
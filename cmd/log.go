@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/untanky/git-charged/core"
+	"log"
+	"strings"
+)
+
+const logDateFormat = "Mon Jan 2 15:04:05 2006 -0700"
+
+// logCmd represents the log command
+var logCmd = &cobra.Command{
+	Use:   "log [<revision range>...]",
+	Short: "Show commit history",
+	Long: `List the commits reachable from the given revisions, or HEAD, newest
+first. ^<rev> excludes the history of <rev>, and <from>..<to> lists the
+commits of <to> that are not in <from>.
+
+Authors and committers are mapped through .mailmap, mailmap.file and
+mailmap.blob unless --no-mailmap is given. --format takes git's pretty
+format placeholders such as %h, %s, %an, %aN and %ad.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		params := core.LogParams{Revisions: args}
+
+		var err error
+		params.MaxCount, err = cmd.Flags().GetInt("max-count")
+		if err != nil {
+			params.MaxCount = 0
+		}
+
+		params.NoMailmap, err = cmd.Flags().GetBool("no-mailmap")
+		if err != nil {
+			params.NoMailmap = false
+		}
+
+		format, err := cmd.Flags().GetString("format")
+		if err != nil {
+			format = ""
+		}
+
+		oneline, err := cmd.Flags().GetBool("oneline")
+		if err != nil {
+			oneline = false
+		}
+		if oneline {
+			format = "%h %s"
+		}
+
+		entries, err := core.Log(params)
+		if err != nil {
+			log.Fatalf("failed to show log: %s", err)
+		}
+
+		for i, entry := range entries {
+			if format != "" {
+				fmt.Println(entry.Format(format))
+				continue
+			}
+
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Print(formatLogEntry(entry))
+		}
+	},
+}
+
+// formatLogEntry formats a commit like git's default medium format.
+func formatLogEntry(entry core.LogEntry) string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "commit %x\n", entry.Hash)
+	if len(entry.Commit.Parents) > 1 {
+		builder.WriteString(entry.Format("Merge: %p\n"))
+	}
+	fmt.Fprintf(&builder, "Author: %s <%s>\n", entry.Author.Name, entry.Author.Email)
+	fmt.Fprintf(&builder, "Date:   %s\n\n", entry.Author.Timestamp.Format(logDateFormat))
+
+	for _, line := range strings.Split(strings.TrimRight(entry.Commit.Message, "\n"), "\n") {
+		builder.WriteString(strings.TrimRight("    "+line, " ") + "\n")
+	}
+
+	return builder.String()
+}
+
+func init() {
+	rootCmd.AddCommand(logCmd)
+
+	logCmd.Flags().IntP("max-count", "n", 0, "Show at most <n> commits")
+	logCmd.Flags().String("format", "", "Print each commit with a pretty format like \"%h %an %s\"")
+	logCmd.Flags().Bool("oneline", false, "Print each commit on a single line")
+	logCmd.Flags().Bool("no-mailmap", false, "Show authors as recorded instead of mapping them through the mailmap")
+}
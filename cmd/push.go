@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/untanky/git-charged/core"
+	"github.com/untanky/git-charged/ui"
+	"io"
+	"log"
+	"strings"
+)
+
+// pushCmd represents the push command
+var pushCmd = &cobra.Command{
+	Use:   "push [remote] [refspec...]",
+	Short: "Update remote refs along with their objects",
+	Long: `Push local references to a remote without calling git.
+
+Without a refspec the current branch is pushed to its upstream branch.
+A refspec has the form [+]<src>[:<dst>]; an empty <src> deletes <dst>.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		remote := "origin"
+		if len(args) > 0 {
+			remote = args[0]
+		}
+
+		var refSpecs []string
+		if len(args) > 1 {
+			refSpecs = args[1:]
+		}
+
+		force, err := cmd.Flags().GetBool("force")
+		if err != nil {
+			force = false
+		}
+
+		atomic, err := cmd.Flags().GetBool("atomic")
+		if err != nil {
+			atomic = false
+		}
+
+		leases, err := cmd.Flags().GetStringArray("force-with-lease")
+		if err != nil {
+			leases = nil
+		}
+
+		noVerify, err := cmd.Flags().GetBool("no-verify")
+		if err != nil {
+			noVerify = false
+		}
+
+		params := core.PushParams{
+			Remote:   remote,
+			RefSpecs: refSpecs,
+			Force:    force,
+			Leases:   parseLeases(leases),
+			Atomic:   atomic,
+			NoVerify: noVerify,
+		}
+
+		err = ui.NewProgress("Pushing to " + remote).Run(func(progress io.Writer) error {
+			params.Progress = progress
+			_, err := core.Push(params)
+			return err
+		})
+		if err != nil {
+			log.Fatalf("failed to push: %s", err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(pushCmd)
+
+	pushCmd.Flags().BoolP("force", "f", false, "Update remote refs even if they are not ancestors of the local refs")
+	pushCmd.Flags().Bool("atomic", false, "Update either all refs on the remote or none of them")
+	pushCmd.Flags().StringArray("force-with-lease", nil, "Only force the update if the remote ref has the expected value (<refname>[:<expect>])")
+	pushCmd.Flags().Lookup("force-with-lease").NoOptDefVal = "*"
+	pushCmd.Flags().Bool("no-verify", false, "Do not run the pre-push hook")
+}
+
+func parseLeases(values []string) map[string]string {
+	leases := make(map[string]string)
+	for _, value := range values {
+		name, expected, _ := strings.Cut(value, ":")
+		if name != "*" && !strings.HasPrefix(name, "refs/") {
+			name = "refs/heads/" + name
+		}
+		leases[name] = expected
+	}
+
+	return leases
+}
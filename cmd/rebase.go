@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/untanky/git-charged/core"
+	"github.com/untanky/git-charged/ui"
+	"log"
+	"os"
+)
+
+// rebaseCmd represents the rebase command
+var rebaseCmd = &cobra.Command{
+	Use:   "rebase [<upstream> [<branch>]]",
+	Short: "Replay the commits of a branch on top of another",
+	Long: `Replay the commits of the current branch that are not in <upstream>
+on top of it, or on top of --onto. Without <upstream>, the branch the
+current branch tracks is used.
+
+With --interactive the commits are shown in an editor first, where they
+can be reordered and marked to be reworded, edited, squashed into the
+previous commit, squashed without their message (fixup) or dropped.
+
+A rebase stops on conflicts and at commits marked for editing. Resolve the
+conflicts or amend the commit and run rebase --continue, skip the commit
+with rebase --skip, or return to where you started with rebase --abort.`,
+	Args: cobra.MaximumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		continueRebase, err := cmd.Flags().GetBool("continue")
+		if err != nil {
+			continueRebase = false
+		}
+
+		skip, err := cmd.Flags().GetBool("skip")
+		if err != nil {
+			skip = false
+		}
+
+		abort, err := cmd.Flags().GetBool("abort")
+		if err != nil {
+			abort = false
+		}
+
+		continueParams := core.RebaseContinueParams{EditMessage: editMessage, Progress: os.Stdout}
+		switch {
+		case continueRebase:
+			err = core.ContinueRebase(continueParams)
+		case skip:
+			err = core.SkipRebase(continueParams)
+		case abort:
+			err = core.AbortRebase()
+		default:
+			params := core.RebaseParams{
+				EditTodo:    editRebaseTodo,
+				EditMessage: editMessage,
+				Progress:    os.Stdout,
+			}
+			if len(args) > 0 {
+				params.Upstream = args[0]
+			}
+			if len(args) > 1 {
+				params.Branch = args[1]
+			}
+
+			params.Onto, err = cmd.Flags().GetString("onto")
+			if err != nil {
+				params.Onto = ""
+			}
+
+			params.Interactive, err = cmd.Flags().GetBool("interactive")
+			if err != nil {
+				params.Interactive = false
+			}
+
+			err = core.Rebase(params)
+		}
+
+		var conflict *core.ConflictError
+		if errors.As(err, &conflict) {
+			log.Fatalf("failed to rebase: %s\nresolve the conflicts and run rebase --continue, rebase --skip to drop the commit, or rebase --abort", err)
+		}
+		if errors.Is(err, ui.ErrInterrupted) {
+			log.Fatalf("failed to rebase: aborted by user")
+		}
+		if err != nil {
+			log.Fatalf("failed to rebase: %s", err)
+		}
+	},
+}
+
+func editRebaseTodo(steps []core.RebaseStep) ([]core.RebaseStep, error) {
+	items := make([]ui.TodoItem, len(steps))
+	for i, step := range steps {
+		items[i] = ui.TodoItem{Command: step.Command, Description: fmt.Sprintf("%x %s", step.Hash[:4], step.Subject)}
+	}
+
+	title := fmt.Sprintf("Rebase %d commits", len(steps))
+	edited, err := ui.NewTodoEditor(title, items, core.RebaseCommands).Run()
+	if err != nil {
+		return nil, err
+	}
+
+	// The editor only reorders items and changes their commands, so the
+	// steps can be matched by their description.
+	byDescription := make(map[string]core.RebaseStep, len(steps))
+	for i, step := range steps {
+		byDescription[items[i].Description] = step
+	}
+
+	result := make([]core.RebaseStep, 0, len(edited))
+	for _, item := range edited {
+		step := byDescription[item.Description]
+		step.Command = item.Command
+		result = append(result, step)
+	}
+
+	return result, nil
+}
+
+// editMessage opens a commit message in the configured editor and returns
+// the saved result.
+func editMessage(message string) (string, error) {
+	file, err := os.CreateTemp("", "COMMIT_EDITMSG")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file.Name())
+
+	_, err = file.WriteString(message)
+	closeErr := file.Close()
+	if err != nil {
+		return "", err
+	}
+	if closeErr != nil {
+		return "", closeErr
+	}
+
+	err = ui.OpenEditor(file.Name())
+	if err != nil {
+		return "", err
+	}
+
+	content, err := os.ReadFile(file.Name())
+	if err != nil {
+		return "", err
+	}
+
+	return string(content), nil
+}
+
+func init() {
+	rootCmd.AddCommand(rebaseCmd)
+
+	rebaseCmd.Flags().String("onto", "", "Replay the commits on top of this commit instead of <upstream>")
+	rebaseCmd.Flags().BoolP("interactive", "i", false, "Edit the list of commits before rebasing")
+	rebaseCmd.Flags().Bool("continue", false, "Continue after resolving conflicts or editing a commit")
+	rebaseCmd.Flags().Bool("skip", false, "Skip the commit the rebase stopped at")
+	rebaseCmd.Flags().Bool("abort", false, "Cancel the rebase and restore the original branch")
+}
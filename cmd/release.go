@@ -0,0 +1,179 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/go-github/v66/github"
+	"github.com/spf13/cobra"
+	"github.com/untanky/git-charged/core"
+	"github.com/untanky/git-charged/ui"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// releaseTokenVariables are the environment variables a GitHub token is
+// read from, in order.
+var releaseTokenVariables = []string{"GITHUB_TOKEN", "GH_TOKEN"}
+
+// releaseCmd represents the release command
+var releaseCmd = &cobra.Command{
+	Use:   "release",
+	Short: "Tag, push and publish the next semantic version",
+	Long: `Compute the next version from the Conventional Commits since the latest
+version tag HEAD contains: a breaking change bumps the major version, a
+feature the minor version and anything else the patch version.
+
+The release is tagged with an annotated tag, which is pushed, and published
+as a GitHub Release with the changes as its notes. The token is read from
+GITHUB_TOKEN or GH_TOKEN. --asset uploads build artifacts to the release.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		params := core.ReleaseParams{}
+
+		var err error
+		params.Bump, err = cmd.Flags().GetString("bump")
+		if err != nil {
+			params.Bump = ""
+		}
+
+		remote, err := cmd.Flags().GetString("remote")
+		if err != nil {
+			remote = "origin"
+		}
+
+		assets, err := cmd.Flags().GetStringArray("asset")
+		if err != nil {
+			assets = nil
+		}
+
+		dryRun, err := cmd.Flags().GetBool("dry-run")
+		if err != nil {
+			dryRun = false
+		}
+
+		sign, err := cmd.Flags().GetBool("sign")
+		if err != nil {
+			sign = false
+		}
+
+		draft, err := cmd.Flags().GetBool("draft")
+		if err != nil {
+			draft = false
+		}
+
+		prerelease, err := cmd.Flags().GetBool("prerelease")
+		if err != nil {
+			prerelease = false
+		}
+
+		plan, err := core.PlanRelease(params)
+		if err != nil {
+			log.Fatalf("failed to plan release: %s", err)
+		}
+
+		previous := plan.PreviousTag
+		if previous == "" {
+			previous = "no release"
+		}
+		fmt.Printf("Releasing %s (%s after %s)\n", plan.Tag, plan.Bump, previous)
+
+		if dryRun {
+			fmt.Print("\n" + plan.Notes)
+			return
+		}
+
+		// Everything the release needs is checked before the tag is made,
+		// so that a failure does not leave half a release behind.
+		owner, repository, err := core.GitHubRepository(remote)
+		if err != nil {
+			log.Fatalf("failed to release: %s", err)
+		}
+
+		token := ""
+		for _, variable := range releaseTokenVariables {
+			if token = os.Getenv(variable); token != "" {
+				break
+			}
+		}
+		if token == "" {
+			log.Fatalf("failed to release: set GITHUB_TOKEN to publish the release")
+		}
+
+		for _, asset := range assets {
+			_, err = os.Stat(asset)
+			if err != nil {
+				log.Fatalf("failed to release: %s", err)
+			}
+		}
+
+		_, err = core.CreateTag(core.CreateTagParams{
+			Name:     plan.Tag,
+			Revision: "HEAD",
+			Annotate: true,
+			Message:  "Release " + plan.Version,
+			Sign:     sign,
+		})
+		if err != nil {
+			log.Fatalf("failed to create tag: %s", err)
+		}
+
+		err = ui.NewProgress("Pushing " + plan.Tag + " to " + remote).Run(func(progress io.Writer) error {
+			_, err := core.Push(core.PushParams{
+				Remote:   remote,
+				RefSpecs: []string{"refs/tags/" + plan.Tag},
+				Progress: progress,
+			})
+			return err
+		})
+		if err != nil {
+			log.Fatalf("failed to push: %s", err)
+		}
+
+		ctx := context.Background()
+		githubClient := client.WithAuthToken(token)
+		release, _, err := githubClient.Repositories.CreateRelease(ctx, owner, repository, &github.RepositoryRelease{
+			TagName:    github.String(plan.Tag),
+			Name:       github.String(plan.Tag),
+			Body:       github.String(plan.Notes),
+			Draft:      github.Bool(draft),
+			Prerelease: github.Bool(prerelease),
+		})
+		if err != nil {
+			log.Fatalf("failed to create GitHub release: %s", err)
+		}
+
+		for _, asset := range assets {
+			err = uploadReleaseAsset(ctx, githubClient, owner, repository, release.GetID(), asset)
+			if err != nil {
+				log.Fatalf("failed to upload %s: %s", asset, err)
+			}
+		}
+
+		fmt.Println(release.GetHTMLURL())
+	},
+}
+
+func uploadReleaseAsset(ctx context.Context, githubClient *github.Client, owner string, repository string, id int64, asset string) error {
+	file, err := os.Open(asset)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, _, err = githubClient.Repositories.UploadReleaseAsset(ctx, owner, repository, id, &github.UploadOptions{Name: filepath.Base(asset)}, file)
+	return err
+}
+
+func init() {
+	rootCmd.AddCommand(releaseCmd)
+
+	releaseCmd.Flags().String("bump", "", "Increase the given part of the version: major, minor or patch")
+	releaseCmd.Flags().String("remote", "origin", "Push to and publish on the given remote")
+	releaseCmd.Flags().StringArray("asset", nil, "Upload a build artifact to the release")
+	releaseCmd.Flags().Bool("dry-run", false, "Only print the next version and its release notes")
+	releaseCmd.Flags().BoolP("sign", "s", false, "Sign the release tag")
+	releaseCmd.Flags().Bool("draft", false, "Publish the release as a draft")
+	releaseCmd.Flags().Bool("prerelease", false, "Mark the release as a pre-release")
+}
@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// revertCmd represents the revert command
+var revertCmd = &cobra.Command{
+	Use:   "revert <commit>...",
+	Short: "Revert existing commits",
+	Long: `Record new commits that undo the changes introduced by existing
+commits. A range like v1.0..HEAD reverts every commit in it, newest first.
+
+Conflicts are resolved like with cherry-pick: fix them and run revert
+--continue, or cancel with revert --abort. Merge commits are only reverted
+with --mainline, which names the parent that the merge is undone back to.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runSequenceCommand(cmd, args, true)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(revertCmd)
+
+	revertCmd.Flags().Bool("continue", false, "Commit the resolved conflicts and revert the remaining commits")
+	revertCmd.Flags().Bool("abort", false, "Cancel and return to the commit before the revert")
+	revertCmd.Flags().IntP("mainline", "m", 0, "Revert merge commits relative to this parent, starting at 1")
+}
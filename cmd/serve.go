@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/untanky/git-charged/core"
+	"github.com/untanky/git-charged/transport"
+	"log"
+	"net"
+)
+
+// serveCmd represents the serve command
+var serveCmd = &cobra.Command{
+	Use:   "serve [directory]",
+	Short: "Share a repository over smart HTTP and git://",
+	Long: `Share a repository over the smart HTTP protocol and, optionally, the
+git:// protocol, so it can be cloned without a hosting service:
+
+  git-charged serve --listen :8080 --daemon :9418 --enable-push
+
+  git clone http://<host>:8080/
+  git clone git://<host>:9418/
+
+Only protocol version 2 is supported for fetching.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		directory := "."
+		if len(args) == 1 {
+			directory = args[0]
+		}
+
+		listen, err := cmd.Flags().GetString("listen")
+		if err != nil {
+			listen = ""
+		}
+
+		daemon, err := cmd.Flags().GetString("daemon")
+		if err != nil {
+			daemon = ""
+		}
+
+		enablePush, err := cmd.Flags().GetBool("enable-push")
+		if err != nil {
+			enablePush = false
+		}
+
+		maxPackSize, err := cmd.Flags().GetInt64("max-pack-size")
+		if err != nil {
+			maxPackSize = transport.DefaultMaxPackSize
+		}
+
+		err = core.OpenRepository(directory)
+		if err != nil {
+			log.Fatalf("failed to serve: %s", err)
+		}
+
+		server := transport.NewServer(enablePush)
+		server.MaxPackSize = maxPackSize
+		errs := make(chan error, 2)
+
+		if daemon != "" {
+			listener, err := net.Listen("tcp", daemon)
+			if err != nil {
+				log.Fatalf("failed to listen on %s: %s", daemon, err)
+			}
+			log.Printf("serving git://%s/", listener.Addr())
+
+			go func() {
+				errs <- server.ServeDaemon(listener)
+			}()
+		}
+
+		if listen != "" {
+			listener, err := net.Listen("tcp", listen)
+			if err != nil {
+				log.Fatalf("failed to listen on %s: %s", listen, err)
+			}
+			log.Printf("serving http://%s/", listener.Addr())
+
+			go func() {
+				errs <- server.ServeSmartHTTP(listener)
+			}()
+		}
+
+		if listen == "" && daemon == "" {
+			log.Fatalf("nothing to serve: both --listen and --daemon are empty")
+		}
+
+		log.Fatalf("failed to serve: %s", <-errs)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+
+	serveCmd.Flags().String("listen", ":8080", "Address for the smart HTTP server (empty to disable)")
+	serveCmd.Flags().String("daemon", "", "Address for the git:// server, e.g. :9418 (empty to disable)")
+	serveCmd.Flags().Bool("enable-push", false, "Allow clients to push to the repository")
+	serveCmd.Flags().Int64("max-pack-size", transport.DefaultMaxPackSize, "Refuse pushed packs larger than this many bytes (0 for no limit)")
+}
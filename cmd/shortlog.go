@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/untanky/git-charged/core"
+	"log"
+)
+
+// shortlogCmd represents the shortlog command
+var shortlogCmd = &cobra.Command{
+	Use:   "shortlog [<revision range>...]",
+	Short: "Summarize commit history by author",
+	Long: `Group the commits reachable from the given revisions, or HEAD, by
+author. Authors are mapped through .mailmap, mailmap.file and mailmap.blob,
+so one person committing with several emails is counted once.
+
+--summary --numbered --email lists contributors by their number of
+commits.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		params := core.ShortlogParams{LogParams: core.LogParams{Revisions: args}}
+
+		var err error
+		params.Numbered, err = cmd.Flags().GetBool("numbered")
+		if err != nil {
+			params.Numbered = false
+		}
+
+		params.Email, err = cmd.Flags().GetBool("email")
+		if err != nil {
+			params.Email = false
+		}
+
+		params.NoMailmap, err = cmd.Flags().GetBool("no-mailmap")
+		if err != nil {
+			params.NoMailmap = false
+		}
+
+		summary, err := cmd.Flags().GetBool("summary")
+		if err != nil {
+			summary = false
+		}
+
+		groups, err := core.Shortlog(params)
+		if err != nil {
+			log.Fatalf("failed to show shortlog: %s", err)
+		}
+
+		for _, group := range groups {
+			if summary {
+				fmt.Printf("%6d\t%s\n", len(group.Subjects), group.Author)
+				continue
+			}
+
+			fmt.Printf("%s (%d):\n", group.Author, len(group.Subjects))
+			for _, subject := range group.Subjects {
+				fmt.Printf("      %s\n", subject)
+			}
+			fmt.Println()
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(shortlogCmd)
+
+	shortlogCmd.Flags().BoolP("summary", "s", false, "Only print the number of commits of each author")
+	shortlogCmd.Flags().BoolP("numbered", "n", false, "Sort authors by their number of commits")
+	shortlogCmd.Flags().BoolP("email", "e", false, "Show the email of each author")
+	shortlogCmd.Flags().Bool("no-mailmap", false, "Show authors as recorded instead of mapping them through the mailmap")
+}
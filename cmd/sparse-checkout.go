@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/untanky/git-charged/core"
+	"log"
+	"os"
+)
+
+// sparseCheckoutCmd represents the sparse-checkout command
+var sparseCheckoutCmd = &cobra.Command{
+	Use:   "sparse-checkout",
+	Short: "Check out only some directories of the repository",
+	Long: `Restrict the working tree to a set of directories using cone mode patterns
+in .git/info/sparse-checkout. Files at the top level are always present;
+the other files stay in the index but are not written to the working tree.`,
+}
+
+var sparseCheckoutSetCmd = &cobra.Command{
+	Use:   "set [directory...]",
+	Short: "Check out only the given directories",
+	Run: func(cmd *cobra.Command, args []string) {
+		err := core.SetSparseCheckout(args, os.Stderr)
+		if err != nil {
+			log.Fatalf("failed to set sparse checkout: %s", err)
+		}
+	},
+}
+
+var sparseCheckoutAddCmd = &cobra.Command{
+	Use:   "add <directory...>",
+	Short: "Add directories to the sparse checkout",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		err := core.AddSparseCheckout(args, os.Stderr)
+		if err != nil {
+			log.Fatalf("failed to add to sparse checkout: %s", err)
+		}
+	},
+}
+
+var sparseCheckoutListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the directories of the sparse checkout",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		directories, err := core.SparseCheckoutDirectories()
+		if err != nil {
+			log.Fatalf("failed to list sparse checkout: %s", err)
+		}
+
+		for _, directory := range directories {
+			fmt.Println(directory)
+		}
+	},
+}
+
+var sparseCheckoutReapplyCmd = &cobra.Command{
+	Use:   "reapply",
+	Short: "Update the working tree to match the sparse checkout again",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		err := core.ReapplySparseCheckout(os.Stderr)
+		if err != nil {
+			log.Fatalf("failed to reapply sparse checkout: %s", err)
+		}
+	},
+}
+
+var sparseCheckoutDisableCmd = &cobra.Command{
+	Use:   "disable",
+	Short: "Check out all files again",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		err := core.DisableSparseCheckout(os.Stderr)
+		if err != nil {
+			log.Fatalf("failed to disable sparse checkout: %s", err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(sparseCheckoutCmd)
+	sparseCheckoutCmd.AddCommand(sparseCheckoutSetCmd, sparseCheckoutAddCmd, sparseCheckoutListCmd, sparseCheckoutReapplyCmd, sparseCheckoutDisableCmd)
+}
@@ -0,0 +1,209 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/untanky/git-charged/core"
+	"github.com/untanky/git-charged/ui"
+	"log"
+	"os"
+	"strings"
+)
+
+// stashCmd represents the stash command
+var stashCmd = &cobra.Command{
+	Use:   "stash",
+	Short: "Stash away changes in the working directory",
+	Long: `Save the changes of tracked files in the working directory and index on
+refs/stash and reset them to HEAD.
+
+Without a subcommand the changes are stashed like with "stash push". The
+apply, pop, drop and show subcommands accept --interactive to pick the
+stash from a list with a preview of the files it changes.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		pushStash("")
+	},
+}
+
+var stashPushCmd = &cobra.Command{
+	Use:   "push",
+	Short: "Save local changes as a new stash entry",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		message, err := cmd.Flags().GetString("message")
+		if err != nil {
+			message = ""
+		}
+
+		pushStash(message)
+	},
+}
+
+var stashListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the stash entries",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		entries, err := core.ListStashes()
+		if err != nil {
+			log.Fatalf("failed to list stash entries: %s", err)
+		}
+
+		for _, entry := range entries {
+			fmt.Printf("%s: %s\n", entry.Name(), entry.Message)
+		}
+	},
+}
+
+var stashShowCmd = &cobra.Command{
+	Use:   "show [stash]",
+	Short: "Show the files changed by a stash entry",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		entry, err := core.FindStash(selectStash(cmd, args, "Which stash do you want to show?"))
+		if err != nil {
+			log.Fatalf("failed to show stash: %s", err)
+		}
+
+		fmt.Print(describeStashChanges(entry))
+	},
+}
+
+var stashApplyCmd = &cobra.Command{
+	Use:   "apply [stash]",
+	Short: "Apply a stash entry to the working directory",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		_, err := core.ApplyStash(stashApplyParams(cmd, args, "Which stash do you want to apply?"))
+		if err != nil {
+			log.Fatalf("failed to apply stash: %s", err)
+		}
+	},
+}
+
+var stashPopCmd = &cobra.Command{
+	Use:   "pop [stash]",
+	Short: "Apply a stash entry and remove it",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		_, err := core.PopStash(stashApplyParams(cmd, args, "Which stash do you want to pop?"))
+		if err != nil {
+			log.Fatalf("failed to pop stash: %s", err)
+		}
+	},
+}
+
+var stashDropCmd = &cobra.Command{
+	Use:   "drop [stash]",
+	Short: "Remove a stash entry",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		entry, err := core.DropStash(selectStash(cmd, args, "Which stash do you want to drop?"))
+		if err != nil {
+			log.Fatalf("failed to drop stash: %s", err)
+		}
+
+		fmt.Printf("Dropped %s (%x)\n", entry.Name(), entry.Hash)
+	},
+}
+
+func pushStash(message string) {
+	_, err := core.PushStash(core.StashPushParams{
+		Message:  message,
+		Progress: os.Stdout,
+	})
+	if errors.Is(err, core.ErrNoLocalChanges) {
+		fmt.Println("No local changes to save")
+		return
+	}
+	if err != nil {
+		log.Fatalf("failed to stash changes: %s", err)
+	}
+}
+
+func stashApplyParams(cmd *cobra.Command, args []string, title string) core.StashApplyParams {
+	index, err := cmd.Flags().GetBool("index")
+	if err != nil {
+		index = false
+	}
+
+	return core.StashApplyParams{
+		Stash:    selectStash(cmd, args, title),
+		Index:    index,
+		Progress: os.Stdout,
+	}
+}
+
+// selectStash returns the stash named on the command line or, with
+// --interactive, lets the user pick one while previewing its changes.
+func selectStash(cmd *cobra.Command, args []string, title string) string {
+	if len(args) == 1 {
+		return args[0]
+	}
+
+	interactive, err := cmd.Flags().GetBool("interactive")
+	if err != nil || !interactive {
+		return ""
+	}
+
+	entries, err := core.ListStashes()
+	if err != nil {
+		log.Fatalf("failed to list stash entries: %s", err)
+	}
+	if len(entries) == 0 {
+		log.Fatalf("failed to select stash: %s", core.ErrNoStashEntries)
+	}
+
+	options := make([]string, 0, len(entries))
+	entriesByOption := make(map[string]core.StashEntry, len(entries))
+	for _, entry := range entries {
+		option := fmt.Sprintf("%s: %s", entry.Name(), entry.Message)
+		options = append(options, option)
+		entriesByOption[option] = entry
+	}
+
+	selected, err := ui.NewPreviewSelect(title, options, func(option string) string {
+		return describeStashChanges(entriesByOption[option])
+	}).Run()
+	if err != nil {
+		log.Fatalf("failed to select stash: %s", err)
+	}
+	if selected == "" {
+		os.Exit(1)
+	}
+
+	return entriesByOption[selected].Name()
+}
+
+// describeStashChanges lists the files of a stash entry with their status
+// like "git stash show --name-status".
+func describeStashChanges(entry core.StashEntry) string {
+	changes, err := core.StashChanges(entry)
+	if err != nil {
+		return fmt.Sprintf("cannot read %s: %s\n", entry.Name(), err)
+	}
+
+	var builder strings.Builder
+	for _, change := range changes {
+		fmt.Fprintf(&builder, "%c\t%s\n", change.Status, change.Path)
+	}
+
+	return builder.String()
+}
+
+func init() {
+	rootCmd.AddCommand(stashCmd)
+	stashCmd.AddCommand(stashPushCmd, stashListCmd, stashShowCmd, stashApplyCmd, stashPopCmd, stashDropCmd)
+
+	stashPushCmd.Flags().StringP("message", "m", "", "Describe the stash entry")
+
+	for _, command := range []*cobra.Command{stashShowCmd, stashApplyCmd, stashPopCmd, stashDropCmd} {
+		command.Flags().BoolP("interactive", "i", false, "Pick the stash entry from a list")
+	}
+
+	for _, command := range []*cobra.Command{stashApplyCmd, stashPopCmd} {
+		command.Flags().Bool("index", false, "Also restore the staged changes")
+	}
+}
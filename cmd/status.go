@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/untanky/git-charged/core"
+	"log"
+	"sort"
+)
+
+var statusLabels = map[byte]string{
+	'A': "new file:   ",
+	'M': "modified:   ",
+	'D': "deleted:    ",
+}
+
+// statusCmd represents the status command
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the working tree status",
+	Long: `Show the changes staged for the next commit, the changes of the working
+tree that are not staged, and the untracked files that are not ignored.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		short, err := cmd.Flags().GetBool("short")
+		if err != nil {
+			short = false
+		}
+
+		status, err := core.Status()
+		if err != nil {
+			log.Fatalf("failed to read status: %s", err)
+		}
+
+		if short {
+			printShortStatus(status)
+			return
+		}
+
+		printLongStatus(status)
+	},
+}
+
+func printShortStatus(status *core.StatusResult) {
+	codes := make(map[string][2]byte)
+	for _, change := range status.Staged {
+		code := codes[change.Path]
+		code[0] = change.Status
+		codes[change.Path] = code
+	}
+	for _, change := range status.Unstaged {
+		code := codes[change.Path]
+		code[1] = change.Status
+		codes[change.Path] = code
+	}
+	for _, name := range status.Unmerged {
+		codes[name] = [2]byte{'U', 'U'}
+	}
+
+	paths := make([]string, 0, len(codes))
+	for name := range codes {
+		paths = append(paths, name)
+	}
+	sort.Strings(paths)
+
+	for _, name := range paths {
+		code := codes[name]
+		for i := range code {
+			if code[i] == 0 {
+				code[i] = ' '
+			}
+		}
+		fmt.Printf("%c%c %s\n", code[0], code[1], name)
+	}
+
+	for _, name := range status.Untracked {
+		fmt.Printf("?? %s\n", name)
+	}
+}
+
+func printLongStatus(status *core.StatusResult) {
+	switch {
+	case status.Branch != "":
+		fmt.Printf("On branch %s\n", status.Branch)
+	default:
+		fmt.Printf("HEAD detached at %s\n", fmt.Sprintf("%x", status.Head)[:7])
+	}
+
+	if status.Sparse {
+		fmt.Printf("You are in a sparse checkout with %d%% of tracked files present.\n", status.SparsePercentage)
+	}
+
+	if status.Head == nil {
+		fmt.Print("\nNo commits yet\n")
+	}
+
+	if len(status.Unmerged) > 0 {
+		fmt.Print("\nUnmerged paths:\n")
+		for _, name := range status.Unmerged {
+			fmt.Printf("\tboth modified:   %s\n", name)
+		}
+	}
+
+	printStatusSection("Changes to be committed:", status.Staged)
+	printStatusSection("Changes not staged for commit:", status.Unstaged)
+
+	if len(status.Untracked) > 0 {
+		fmt.Print("\nUntracked files:\n")
+		for _, name := range status.Untracked {
+			fmt.Printf("\t%s\n", name)
+		}
+	}
+
+	fmt.Println()
+	switch {
+	case len(status.Staged) > 0:
+	case len(status.Unstaged) > 0:
+		fmt.Println("no changes added to commit")
+	case len(status.Untracked) > 0:
+		fmt.Println("nothing added to commit but untracked files present")
+	case status.Head == nil:
+		fmt.Println("nothing to commit")
+	default:
+		fmt.Println("nothing to commit, working tree clean")
+	}
+}
+
+func printStatusSection(title string, changes []core.FileStatus) {
+	if len(changes) == 0 {
+		return
+	}
+
+	fmt.Printf("\n%s\n", title)
+	for _, change := range changes {
+		fmt.Printf("\t%s%s\n", statusLabels[change.Status], change.Path)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+
+	statusCmd.Flags().BoolP("short", "s", false, "Give the output in the short format")
+}
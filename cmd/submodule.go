@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/untanky/git-charged/core"
+	"log"
+	"os"
+)
+
+// submoduleCmd represents the submodule command
+var submoduleCmd = &cobra.Command{
+	Use:   "submodule",
+	Short: "Initialize, update or inspect submodules",
+	Long: `Initialize, update or inspect submodules.
+
+Without a subcommand the status of all submodules is shown.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		printSubmoduleStatus(nil)
+	},
+}
+
+var submoduleStatusCmd = &cobra.Command{
+	Use:   "status [path...]",
+	Short: "Show the status of submodules",
+	Long: `Show the commit checked out in each submodule.
+
+The commit is prefixed with '-' if the submodule is not initialized and
+with '+' if it does not match the commit recorded in the superproject.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		printSubmoduleStatus(args)
+	},
+}
+
+var submoduleInitCmd = &cobra.Command{
+	Use:   "init [path...]",
+	Short: "Register submodules from .gitmodules in the repository config",
+	Run: func(cmd *cobra.Command, args []string) {
+		err := core.InitSubmodules(args, os.Stderr)
+		if err != nil {
+			log.Fatalf("failed to initialize submodules: %s", err)
+		}
+	},
+}
+
+var submoduleUpdateCmd = &cobra.Command{
+	Use:   "update [path...]",
+	Short: "Clone missing submodules and check out the recorded commits",
+	Run: func(cmd *cobra.Command, args []string) {
+		init, err := cmd.Flags().GetBool("init")
+		if err != nil {
+			init = false
+		}
+
+		recursive, err := cmd.Flags().GetBool("recursive")
+		if err != nil {
+			recursive = false
+		}
+
+		err = core.UpdateSubmodules(core.UpdateSubmodulesParams{
+			Paths:     args,
+			Init:      init,
+			Recursive: recursive,
+			Progress:  os.Stderr,
+		})
+		if err != nil {
+			log.Fatalf("failed to update submodules: %s", err)
+		}
+	},
+}
+
+var submoduleAddCmd = &cobra.Command{
+	Use:   "add <repository> [path]",
+	Short: "Add a repository as a submodule",
+	Args:  cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		branch, err := cmd.Flags().GetString("branch")
+		if err != nil {
+			branch = ""
+		}
+
+		params := core.AddSubmoduleParams{
+			URL:      args[0],
+			Branch:   branch,
+			Progress: os.Stderr,
+		}
+		if len(args) == 2 {
+			params.Path = args[1]
+		}
+
+		err = core.AddSubmodule(params)
+		if err != nil {
+			log.Fatalf("failed to add submodule: %s", err)
+		}
+	},
+}
+
+func printSubmoduleStatus(paths []string) {
+	statuses, err := core.SubmoduleStatuses(paths)
+	if err != nil {
+		log.Fatalf("failed to read submodules: %s", err)
+	}
+
+	for _, status := range statuses {
+		hash := status.Recorded
+		if status.Current != nil {
+			hash = status.Current
+		}
+
+		line := fmt.Sprintf("%c%x %s", status.State, hash, status.Path)
+		if status.Ref != "" {
+			line += fmt.Sprintf(" (%s)", status.Ref)
+		}
+		fmt.Println(line)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(submoduleCmd)
+	submoduleCmd.AddCommand(submoduleStatusCmd, submoduleInitCmd, submoduleUpdateCmd, submoduleAddCmd)
+
+	submoduleUpdateCmd.Flags().Bool("init", false, "Initialize submodules that are not initialized yet")
+	submoduleUpdateCmd.Flags().Bool("recursive", false, "Update nested submodules as well")
+	submoduleAddCmd.Flags().StringP("branch", "b", "", "Branch of the repository to check out")
+}
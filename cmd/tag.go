@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/untanky/git-charged/core"
+	"log"
+)
+
+// tagCmd represents the tag command
+var tagCmd = &cobra.Command{
+	Use:   "tag [<name> [<commit>]]",
+	Short: "Create, list or verify tags",
+	Long: `Without arguments, list the tags. With a name, tag <commit>, or HEAD.
+
+A tag is lightweight unless it is annotated with -a or -m, or signed with
+-s. Signing uses the key in user.signingkey and the tool selected by
+gpg.format, openpgp (gpg), x509 (gpgsm) or ssh (ssh-keygen). Annotated tags
+are also signed if tag.gpgsign is set.
+
+With --verify, check the signatures of the named tags. SSH signatures are
+checked against the signers in gpg.ssh.allowedSignersFile.`,
+	Args: cobra.MaximumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		verify, err := cmd.Flags().GetBool("verify")
+		if err != nil {
+			verify = false
+		}
+
+		if verify {
+			if len(args) == 0 {
+				log.Fatalf("failed to verify tag: no tag given")
+			}
+			for _, name := range args {
+				verification, err := core.VerifyTag(name)
+				fmt.Print(verification.Output)
+				if err != nil {
+					log.Fatalf("failed to verify tag %s: %s", name, err)
+				}
+			}
+			return
+		}
+
+		if len(args) == 0 {
+			tags, err := core.ListTags()
+			if err != nil {
+				log.Fatalf("failed to list tags: %s", err)
+			}
+			for _, tag := range tags {
+				fmt.Println(tag)
+			}
+			return
+		}
+
+		params := core.CreateTagParams{Name: args[0]}
+		if len(args) > 1 {
+			params.Revision = args[1]
+		}
+
+		params.Message, err = cmd.Flags().GetString("message")
+		if err != nil {
+			params.Message = ""
+		}
+
+		params.Sign, err = cmd.Flags().GetBool("sign")
+		if err != nil {
+			params.Sign = false
+		}
+
+		params.Annotate, err = cmd.Flags().GetBool("annotate")
+		if err != nil {
+			params.Annotate = false
+		}
+
+		params.Force, err = cmd.Flags().GetBool("force")
+		if err != nil {
+			params.Force = false
+		}
+
+		if (params.Annotate || params.Sign) && params.Message == "" {
+			params.Message, err = editMessage(fmt.Sprintf("\n#\n# Write a message for tag:\n#   %s\n# Lines starting with '#' will be ignored.\n", params.Name))
+			if err != nil {
+				log.Fatalf("failed to edit tag message: %s", err)
+			}
+		}
+
+		_, err = core.CreateTag(params)
+		if err != nil {
+			log.Fatalf("failed to create tag: %s", err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(tagCmd)
+
+	tagCmd.Flags().BoolP("annotate", "a", false, "Create an annotated tag")
+	tagCmd.Flags().BoolP("sign", "s", false, "Create a signed annotated tag")
+	tagCmd.Flags().StringP("message", "m", "", "Use the given tag message")
+	tagCmd.Flags().BoolP("force", "f", false, "Replace an existing tag")
+	tagCmd.Flags().BoolP("verify", "v", false, "Verify the signatures of the given tags")
+}
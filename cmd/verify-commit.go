@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/untanky/git-charged/core"
+	"log"
+)
+
+// verifyCommitCmd represents the verify-commit command
+var verifyCommitCmd = &cobra.Command{
+	Use:   "verify-commit <commit>...",
+	Short: "Check the signatures of commits",
+	Long: `Check the GPG, x509 or SSH signatures of the given commits. SSH
+signatures are checked against the signers in gpg.ssh.allowedSignersFile.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		for _, revision := range args {
+			verification, err := core.VerifyCommit(revision)
+			fmt.Print(verification.Output)
+			if err != nil {
+				log.Fatalf("failed to verify commit %s: %s", revision, err)
+			}
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCommitCmd)
+}
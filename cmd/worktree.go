@@ -0,0 +1,204 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/untanky/git-charged/core"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// worktreeCmd represents the worktree command
+var worktreeCmd = &cobra.Command{
+	Use:   "worktree",
+	Short: "Manage multiple working trees",
+	Long: `Manage multiple working trees attached to the same repository.
+
+A linked worktree has its own HEAD, index and checked out files but shares
+objects, branches and configuration with the main worktree, so a branch can
+be reviewed in a separate directory without a second clone.`,
+}
+
+var worktreeAddCmd = &cobra.Command{
+	Use:   "add <path> [commit-ish]",
+	Short: "Create a worktree and check out a branch or commit in it",
+	Long: `Create a worktree at <path> and check out <commit-ish> in it.
+
+If <commit-ish> is a local branch it is checked out, otherwise HEAD is
+detached at the commit. Without <commit-ish>, a branch named after the
+last component of <path> is checked out, and created from HEAD if it
+does not exist.`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		params := core.AddWorktreeParams{
+			Path:     args[0],
+			Progress: os.Stdout,
+		}
+		if len(args) == 2 {
+			params.Commitish = args[1]
+		}
+
+		var err error
+		params.NewBranch, err = cmd.Flags().GetString("branch")
+		if err != nil {
+			params.NewBranch = ""
+		}
+
+		params.Detach, err = cmd.Flags().GetBool("detach")
+		if err != nil {
+			params.Detach = false
+		}
+
+		params.Force, err = cmd.Flags().GetBool("force")
+		if err != nil {
+			params.Force = false
+		}
+
+		err = core.AddWorktree(params)
+		if err != nil {
+			log.Fatalf("failed to add worktree: %s", err)
+		}
+	},
+}
+
+var worktreeListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the worktrees of the repository",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		porcelain, err := cmd.Flags().GetBool("porcelain")
+		if err != nil {
+			porcelain = false
+		}
+
+		worktrees, err := core.ListWorktrees()
+		if err != nil {
+			log.Fatalf("failed to list worktrees: %s", err)
+		}
+
+		if porcelain {
+			printWorktreesPorcelain(worktrees)
+			return
+		}
+
+		width := 0
+		for _, worktree := range worktrees {
+			width = max(width, len(worktree.Path))
+		}
+
+		for _, worktree := range worktrees {
+			description := "(detached HEAD)"
+			switch {
+			case worktree.Bare:
+				description = "(bare)"
+			case worktree.Branch != "":
+				description = fmt.Sprintf("[%s]", strings.TrimPrefix(worktree.Branch, "refs/heads/"))
+			}
+
+			head := strings.Repeat("0", 7)
+			if worktree.Head != nil {
+				head = fmt.Sprintf("%x", worktree.Head)[:7]
+			}
+
+			line := fmt.Sprintf("%-*s %s %s", width+1, worktree.Path, head, description)
+			if worktree.Bare {
+				line = fmt.Sprintf("%-*s %s", width+1, worktree.Path, description)
+			}
+			if worktree.Locked {
+				line += " locked"
+			}
+			if worktree.Prunable != "" {
+				line += " prunable"
+			}
+			fmt.Println(line)
+		}
+	},
+}
+
+var worktreeRemoveCmd = &cobra.Command{
+	Use:   "remove <path>",
+	Short: "Delete a worktree",
+	Long: `Delete a linked worktree and its administrative files. Worktrees with
+modified tracked files or a lock are only removed with --force.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		force, err := cmd.Flags().GetBool("force")
+		if err != nil {
+			force = false
+		}
+
+		err = core.RemoveWorktree(args[0], force)
+		if err != nil {
+			log.Fatalf("failed to remove worktree: %s", err)
+		}
+	},
+}
+
+var worktreePruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove the administrative files of deleted worktrees",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		dryRun, err := cmd.Flags().GetBool("dry-run")
+		if err != nil {
+			dryRun = false
+		}
+
+		verbose, err := cmd.Flags().GetBool("verbose")
+		if err != nil {
+			verbose = false
+		}
+
+		var progress io.Writer
+		if verbose || dryRun {
+			progress = os.Stdout
+		}
+
+		err = core.PruneWorktrees(dryRun, progress)
+		if err != nil {
+			log.Fatalf("failed to prune worktrees: %s", err)
+		}
+	},
+}
+
+func printWorktreesPorcelain(worktrees []core.Worktree) {
+	for _, worktree := range worktrees {
+		fmt.Printf("worktree %s\n", worktree.Path)
+		switch {
+		case worktree.Bare:
+			fmt.Println("bare")
+		default:
+			fmt.Printf("HEAD %x\n", worktree.Head)
+			if worktree.Branch != "" {
+				fmt.Printf("branch %s\n", worktree.Branch)
+			} else {
+				fmt.Println("detached")
+			}
+		}
+		if worktree.Locked {
+			fmt.Println(strings.TrimSpace("locked " + worktree.LockReason))
+		}
+		if worktree.Prunable != "" {
+			fmt.Printf("prunable %s\n", worktree.Prunable)
+		}
+		fmt.Println()
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(worktreeCmd)
+	worktreeCmd.AddCommand(worktreeAddCmd, worktreeListCmd, worktreeRemoveCmd, worktreePruneCmd)
+
+	worktreeAddCmd.Flags().StringP("branch", "b", "", "Create a new branch and check it out")
+	worktreeAddCmd.Flags().BoolP("detach", "d", false, "Detach HEAD at the commit")
+	worktreeAddCmd.Flags().BoolP("force", "f", false, "Check out a branch even if it is checked out in another worktree")
+
+	worktreeListCmd.Flags().Bool("porcelain", false, "Print a format that is easy to parse")
+
+	worktreeRemoveCmd.Flags().BoolP("force", "f", false, "Remove worktrees with local changes or a lock")
+
+	worktreePruneCmd.Flags().BoolP("dry-run", "n", false, "Report what would be removed without removing it")
+	worktreePruneCmd.Flags().BoolP("verbose", "v", false, "Report removed worktrees")
+}
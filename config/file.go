@@ -1,6 +1,9 @@
 package config
 
-import "os"
+import (
+	"fmt"
+	"os"
+)
 
 type File interface {
 	Has(key string) bool
@@ -18,9 +21,11 @@ func LoadFile(path string) (File, error) {
 		return nil, err
 	}
 
+	defer file.Close()
+
 	configMap, err := ParseConfigFile(file)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("cannot parse %s: %w", path, err)
 	}
 
 	return gitConfigFile{
@@ -30,12 +35,12 @@ func LoadFile(path string) (File, error) {
 }
 
 func (config gitConfigFile) Has(key string) bool {
-	_, ok := config.configMap[key]
+	_, ok := config.configMap[canonicalKey(key)]
 	return ok
 }
 
 func (config gitConfigFile) Get(key string) (string, bool) {
-	value, ok := config.configMap[key]
+	value, ok := config.configMap[canonicalKey(key)]
 	if !ok {
 		return "", false
 	}
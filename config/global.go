@@ -1,30 +1,58 @@
 package config
 
 import (
+	"errors"
+	"fmt"
+	"io/fs"
 	"os"
 	"path"
+	"syscall"
 )
 
 func init() {
 	ReloadConfig()
 }
 
-var loadedFiles []File
+var (
+	loadedFiles []File
+
+	repositoryConfigPath = path.Join(".git", "config")
+)
+
+// SetRepositoryConfig selects the config file of the current repository,
+// which is read by the next ReloadConfig.
+func SetRepositoryConfig(configPath string) {
+	repositoryConfigPath = configPath
+}
+
+// RepositoryConfig returns the config file of the current repository.
+func RepositoryConfig() string {
+	return repositoryConfigPath
+}
 
 func ReloadConfig() {
 	loadedFiles = []File{}
 
 	paths := []string{
-		path.Join(".git", "config"),
+		repositoryConfigPath,
 		path.Join(os.Getenv("HOME"), ".gitconfig"),
 		path.Join("/etc", "gitconfig"),
 	}
 
 	for _, p := range paths {
 		file, err := LoadFile(p)
-		if err == nil {
-			loadedFiles = append(loadedFiles, file)
+		// In a linked worktree .git is a file until core points the
+		// repository config at the shared git directory.
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ENOTDIR) {
+			continue
+		}
+		if err != nil {
+			// Like git, a broken file is reported, but unlike git the
+			// other files are still used.
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			continue
 		}
+		loadedFiles = append(loadedFiles, file)
 	}
 }
 
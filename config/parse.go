@@ -1,63 +1,305 @@
 package config
 
 import (
-	"bufio"
 	"fmt"
 	"io"
-	"os"
-	"regexp"
 	"strings"
 )
 
-func parseLine(line string, section string, configMap map[string]string) (string, error) {
-	trimmedLine := strings.TrimSpace(line)
+// SyntaxError is a line of a config file that does not follow git's config
+// syntax.
+type SyntaxError struct {
+	Line    int
+	Message string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("bad config line %d: %s", e.Line, e.Message)
+}
 
-	if strings.HasPrefix(trimmedLine, "#") || strings.HasPrefix(trimmedLine, ";") {
-		return section, nil
+// configParser reads config files the way git does. Section and variable
+// names are case-insensitive and stored in lowercase, while subsections
+// keep their case.
+type configParser struct {
+	content string
+	// position is the offset of the next character.
+	position int
+	section  string
+	values   map[string]string
+}
+
+// ParseConfigFile parses a config file into a map from keys like
+// "remote.origin.url" to their last value. Variables without a value, like
+// "bare" in "[core] bare", are true.
+func ParseConfigFile(reader io.Reader) (map[string]string, error) {
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
 	}
 
-	if strings.HasPrefix(trimmedLine, "[") {
-		section = strings.TrimPrefix(trimmedLine, "[")
-		section = strings.TrimSuffix(section, "]")
-		section = strings.ReplaceAll(section, " ", ".")
-		section = strings.ReplaceAll(section, "\"", "")
-		return section, nil
+	parser := &configParser{
+		content: strings.TrimPrefix(string(content), "\ufeff"),
+		values:  make(map[string]string),
 	}
 
-	parts := strings.SplitN(trimmedLine, "=", 2)
-	if len(parts) != 2 {
-		return section, fmt.Errorf("invalid input format")
+	err = parser.parse()
+	if err != nil {
+		return nil, err
 	}
 
-	key := strings.TrimSpace(parts[0])
-	value := strings.TrimSpace(parts[1])
+	return parser.values, nil
+}
 
-	// Remove quotes if present
-	re, err := regexp.Compile(`^"(.*)"$`)
-	if err != nil {
-		return section, err
+func (p *configParser) parse() error {
+	for {
+		c, ok := p.next()
+		switch {
+		case !ok:
+			return nil
+		case isConfigSpace(c):
+		case c == '#' || c == ';':
+			p.skipLine()
+		case c == '[':
+			section, err := p.parseSection()
+			if err != nil {
+				return err
+			}
+			p.section = section
+		case isConfigAlpha(c):
+			err := p.parseVariable(c)
+			if err != nil {
+				return err
+			}
+		default:
+			return p.errorf("unexpected character %q", c)
+		}
 	}
+}
 
-	if match := re.FindStringSubmatch(value); match != nil {
-		value = match[1]
+// next returns the next character, reading "\r\n" as "\n".
+func (p *configParser) next() (byte, bool) {
+	if p.position >= len(p.content) {
+		return 0, false
 	}
 
-	configMap[fmt.Sprintf("%s.%s", section, key)] = value
+	c := p.content[p.position]
+	p.position++
+	if c == '\r' && p.position < len(p.content) && p.content[p.position] == '\n' {
+		p.position++
+		c = '\n'
+	}
 
-	return section, nil
+	return c, true
 }
 
-func ParseConfigFile(reader io.Reader) (map[string]string, error) {
-	var section string
-	configMap := make(map[string]string)
-	scanner := bufio.NewScanner(reader)
-	var err error
-	for scanner.Scan() {
-		section, err = parseLine(scanner.Text(), section, configMap)
+func (p *configParser) skipLine() {
+	for {
+		c, ok := p.next()
+		if !ok || c == '\n' {
+			return
+		}
+	}
+}
+
+// parseSection reads a section header after its "[": either [section],
+// [section "subsection"] or the deprecated [section.subsection], whose
+// subsection is case-insensitive.
+func (p *configParser) parseSection() (string, error) {
+	var name strings.Builder
+	for {
+		c, ok := p.next()
+		switch {
+		case !ok:
+			return "", p.errorf("unterminated section header")
+		case c == ']':
+			if name.Len() == 0 {
+				return "", p.errorf("empty section name")
+			}
+			return name.String(), nil
+		case isConfigAlpha(c) || isConfigDigit(c) || c == '-' || c == '.':
+			name.WriteByte(toLower(c))
+		case c == ' ' || c == '\t':
+			if name.Len() == 0 {
+				return "", p.errorf("empty section name")
+			}
+			subsection, err := p.parseSubsection()
+			if err != nil {
+				return "", err
+			}
+			return name.String() + "." + subsection, nil
+		default:
+			return "", p.errorf("invalid character %q in section name", c)
+		}
+	}
+}
+
+// parseSubsection reads the quoted subsection of a section header and the
+// "]" following it. Backslashes escape the next character.
+func (p *configParser) parseSubsection() (string, error) {
+	c, ok := p.next()
+	for ok && (c == ' ' || c == '\t') {
+		c, ok = p.next()
+	}
+	if !ok || c != '"' {
+		return "", p.errorf("subsection must be quoted")
+	}
+
+	var subsection strings.Builder
+	for {
+		c, ok = p.next()
+		if !ok || c == '\n' {
+			return "", p.errorf("unterminated subsection")
+		}
+		if c == '"' {
+			break
+		}
+		if c == '\\' {
+			c, ok = p.next()
+			if !ok || c == '\n' {
+				return "", p.errorf("unterminated subsection")
+			}
+		}
+		subsection.WriteByte(c)
+	}
+
+	c, ok = p.next()
+	if !ok || c != ']' {
+		return "", p.errorf("expected ']' after subsection")
+	}
+
+	return subsection.String(), nil
+}
+
+// parseVariable reads a variable whose name starts with first, and its
+// value if it has one.
+func (p *configParser) parseVariable(first byte) error {
+	name := []byte{toLower(first)}
+	var c byte
+	ok := true
+	for {
+		c, ok = p.next()
+		if !ok || !(isConfigAlpha(c) || isConfigDigit(c) || c == '-') {
+			break
+		}
+		name = append(name, toLower(c))
+	}
+	for ok && (c == ' ' || c == '\t') {
+		c, ok = p.next()
+	}
+
+	// Like git, variables outside of a section are kept under their name.
+	key := string(name)
+	if p.section != "" {
+		key = p.section + "." + key
+	}
+	if !ok || c == '\n' {
+		p.values[key] = "true"
+		return nil
+	}
+	if c != '=' {
+		return p.errorf("invalid variable name")
+	}
+
+	value, err := p.parseValue()
+	if err != nil {
+		return err
 	}
-	if err = scanner.Err(); err != nil {
-		fmt.Fprintln(os.Stderr, "reading standard input:", err)
+	p.values[key] = value
+	return nil
+}
+
+// parseValue reads a value up to the end of its line. Outside of double
+// quotes, comments are removed, whitespace around the value is dropped and
+// every whitespace character within it becomes a space. A backslash at the
+// end of a line continues the value on the next one, and one at the end of
+// the file is ignored.
+func (p *configParser) parseValue() (string, error) {
+	var value strings.Builder
+	quoted, comment := false, false
+	spaces := 0
+	for {
+		c, ok := p.next()
+		if !ok || c == '\n' {
+			if quoted {
+				return "", p.errorf("unterminated quoted string")
+			}
+			return value.String(), nil
+		}
+		if comment {
+			continue
+		}
+		if isConfigSpace(c) && !quoted {
+			if value.Len() > 0 {
+				spaces++
+			}
+			continue
+		}
+		if !quoted && (c == '#' || c == ';') {
+			comment = true
+			continue
+		}
+
+		for ; spaces > 0; spaces-- {
+			value.WriteByte(' ')
+		}
+
+		switch c {
+		case '"':
+			quoted = !quoted
+		case '\\':
+			c, ok = p.next()
+			switch {
+			case !ok || c == '\n':
+			case c == 't':
+				value.WriteByte('\t')
+			case c == 'b':
+				value.WriteByte('\b')
+			case c == 'n':
+				value.WriteByte('\n')
+			case c == '\\' || c == '"':
+				value.WriteByte(c)
+			default:
+				return "", p.errorf("invalid escape sequence \\%c", c)
+			}
+		default:
+			value.WriteByte(c)
+		}
 	}
+}
 
-	return configMap, nil
+// errorf reports an error on the line of the last character read.
+func (p *configParser) errorf(format string, args ...any) error {
+	line := 1 + strings.Count(p.content[:max(p.position-1, 0)], "\n")
+	return &SyntaxError{Line: line, Message: fmt.Sprintf(format, args...)}
+}
+
+// canonicalKey lowercases the section and variable name of a key, which
+// are case-insensitive unlike the subsection.
+func canonicalKey(key string) string {
+	first := strings.Index(key, ".")
+	last := strings.LastIndex(key, ".")
+	if first < 0 || first == last {
+		return strings.ToLower(key)
+	}
+
+	return strings.ToLower(key[:first]) + key[first:last] + strings.ToLower(key[last:])
+}
+
+func isConfigSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
+
+func isConfigAlpha(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func isConfigDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func toLower(c byte) byte {
+	if 'A' <= c && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
 }
@@ -0,0 +1,147 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// The expected values are the output of git config --list for the same
+// files.
+func TestParseConfigFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		values  map[string]string
+	}{
+		{
+			name:    "valueless boolean",
+			content: "[core]\n\tbare\n\tempty =\n",
+			values:  map[string]string{"core.bare": "true", "core.empty": ""},
+		},
+		{
+			name:    "line continuation",
+			content: "[a]\n\tb = one \\\n  two\n\tc = \"one \\\n  two\"\n",
+			values:  map[string]string{"a.b": "one   two", "a.c": "one   two"},
+		},
+		{
+			name:    "line continuation at end of file",
+			content: "[a]\n\tb = x\\",
+			values:  map[string]string{"a.b": "x"},
+		},
+		{
+			name:    "escapes",
+			content: "[a]\n\tb = x\\ty\\nz\\b\\\"q\\\\\n",
+			values:  map[string]string{"a.b": "x\ty\nz\b\"q\\"},
+		},
+		{
+			name:    "whitespace",
+			content: "[a]\n\tb =   \"  spaced  \\t\"  out  \n\tc = in\t\tside\n",
+			values:  map[string]string{"a.b": "  spaced  \t  out", "a.c": "in  side"},
+		},
+		{
+			name:    "comments",
+			content: "; comment\n# comment\n[a] # comment\n\tb = value ; comment\n\tc = \"v ; not\" # comment\n\td = v#x\n\te = \"#\"\n",
+			values:  map[string]string{"a.b": "value", "a.c": "v ; not", "a.d": "v", "a.e": "#"},
+		},
+		{
+			name:    "case of sections, subsections and keys",
+			content: "[Section \"SubSection\"]\n\tKey = v\n[SECTION.Sub]\n\tK = w\n",
+			values:  map[string]string{"section.SubSection.key": "v", "section.sub.k": "w"},
+		},
+		{
+			name:    "escaped subsection",
+			content: "[remote \"a \\\"b\\\\\"]\n\turl = u\n",
+			values:  map[string]string{"remote.a \"b\\.url": "u"},
+		},
+		{
+			name:    "crlf and byte order mark",
+			content: "\ufeff[a]\r\n\tb = 1\r\n\tc = \"2\"\r\n",
+			values:  map[string]string{"a.b": "1", "a.c": "2"},
+		},
+		{
+			name:    "last value wins",
+			content: "[a]\n\tb = 1\n[A]\n\tB = 2\n",
+			values:  map[string]string{"a.b": "2"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			values, err := ParseConfigFile(strings.NewReader(test.content))
+			if err != nil {
+				t.Fatalf("ParseConfigFile() = %s", err)
+			}
+			if len(values) != len(test.values) {
+				t.Errorf("ParseConfigFile() = %q, want %q", values, test.values)
+			}
+			for key, want := range test.values {
+				if got, ok := values[key]; !ok || got != want {
+					t.Errorf("%s = %q, want %q", key, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseConfigFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		line    int
+	}{
+		{name: "invalid escape", content: "[a]\n\tb = x\\qy\n", line: 2},
+		{name: "unterminated quote", content: "[a]\n\tb = \"open\n\tc = 1\n", line: 2},
+		{name: "unterminated quote after continuation", content: "[a]\n\tb = 1\n\n\tc = 2 \\\n\t3\n\td = \"x\n", line: 6},
+		{name: "unterminated section", content: "[a]\n\tb = 1\n\n[bad\n", line: 4},
+		{name: "unquoted subsection", content: "[a b]\n", line: 1},
+		{name: "invalid section character", content: "# comment\n[a_b]\n", line: 2},
+		{name: "invalid variable name", content: "[a]\n\tb_c = 1\n", line: 2},
+		{name: "variable starting with a digit", content: "[a]\n\t1b = 1\n", line: 2},
+		{name: "empty section", content: "[]\n", line: 1},
+		{name: "crlf line numbers", content: "[a]\r\n\tb = 1\r\n\tc = \"x\r\n", line: 3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ParseConfigFile(strings.NewReader(test.content))
+
+			var syntaxError *SyntaxError
+			if !errors.As(err, &syntaxError) {
+				t.Fatalf("ParseConfigFile() = %v, want a SyntaxError", err)
+			}
+			if syntaxError.Line != test.line {
+				t.Errorf("error on line %d, want line %d: %s", syntaxError.Line, test.line, err)
+			}
+		})
+	}
+}
+
+func TestFileGetIsCaseInsensitiveExceptForSubsections(t *testing.T) {
+	filename := path.Join(t.TempDir(), "config")
+	err := os.WriteFile(filename, []byte("[Core]\n\tHooksPath = hooks\n[remote \"Origin\"]\n\tURL = u\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := LoadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"core.hooksPath", "CORE.HOOKSPATH", "core.hookspath"} {
+		if value, ok := file.Get(key); !ok || value != "hooks" {
+			t.Errorf("Get(%q) = %q, %t", key, value, ok)
+		}
+	}
+	for _, key := range []string{"remote.Origin.url", "REMOTE.Origin.Url"} {
+		if !file.Has(key) {
+			t.Errorf("Has(%q) = false", key)
+		}
+	}
+	if file.Has("remote.origin.url") {
+		t.Errorf("Has(\"remote.origin.url\") = true, but subsections are case-sensitive")
+	}
+}
@@ -0,0 +1,153 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// splitKey splits "remote.origin.url" into its section ("remote"),
+// subsection ("origin") and variable name ("url").
+func splitKey(key string) (string, string, string, error) {
+	first := strings.Index(key, ".")
+	last := strings.LastIndex(key, ".")
+	if first < 0 {
+		return "", "", "", fmt.Errorf("key %q does not contain a section", key)
+	}
+
+	section := key[:first]
+	subsection := ""
+	if last > first {
+		subsection = key[first+1 : last]
+	}
+
+	return section, subsection, key[last+1:], nil
+}
+
+// lineSection returns the section a header line starts, like
+// "remote.origin" for [remote "origin"].
+func lineSection(line string) (string, bool) {
+	trimmedLine := strings.TrimSpace(line)
+	if !strings.HasPrefix(trimmedLine, "[") {
+		return "", false
+	}
+
+	parser := &configParser{content: trimmedLine[1:]}
+	section, err := parser.parseSection()
+	if err != nil {
+		return "", false
+	}
+	return section, true
+}
+
+// lineVariable returns the lowercase name of the variable a line sets.
+func lineVariable(line string) string {
+	name, _, _ := strings.Cut(strings.TrimSpace(line), "=")
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// sectionHeader returns the header line of a section, escaping quotes and
+// backslashes in the subsection as the parser expects.
+func sectionHeader(section string, subsection string) string {
+	if subsection == "" {
+		return fmt.Sprintf("[%s]", section)
+	}
+	subsection = strings.NewReplacer("\\", "\\\\", "\"", "\\\"").Replace(subsection)
+	return fmt.Sprintf("[%s \"%s\"]", section, subsection)
+}
+
+// SetValue sets key in the config file at path, creating the file and the
+// section if necessary.
+func SetValue(path string, key string, value string) error {
+	section, subsection, name, err := splitKey(key)
+	if err != nil {
+		return err
+	}
+	if strings.ContainsAny(subsection, "\n\x00") {
+		return fmt.Errorf("invalid subsection in key %q", key)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	if len(content) == 0 {
+		lines = []string{}
+	}
+
+	wantedSection := strings.TrimSuffix(canonicalKey(key), "."+strings.ToLower(name))
+	entry := fmt.Sprintf("\t%s = %s", name, quoteValue(value))
+
+	currentSection := ""
+	insertAt := -1
+	for i, line := range lines {
+		if section, ok := lineSection(line); ok {
+			currentSection = section
+			if currentSection == wantedSection {
+				insertAt = i + 1
+			}
+			continue
+		}
+		if currentSection != wantedSection {
+			continue
+		}
+
+		insertAt = i + 1
+		if lineVariable(line) == strings.ToLower(name) {
+			lines[i] = entry
+			return writeLines(path, lines)
+		}
+	}
+
+	if insertAt < 0 {
+		lines = append(lines, sectionHeader(section, subsection), entry)
+	} else {
+		lines = append(lines[:insertAt], append([]string{entry}, lines[insertAt:]...)...)
+	}
+
+	return writeLines(path, lines)
+}
+
+// UnsetValue removes key from the config file at path.
+func UnsetValue(path string, key string) error {
+	_, _, name, err := splitKey(key)
+	if err != nil {
+		return err
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	wantedSection := strings.TrimSuffix(canonicalKey(key), "."+strings.ToLower(name))
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	remaining := make([]string, 0, len(lines))
+
+	currentSection := ""
+	for _, line := range lines {
+		if section, ok := lineSection(line); ok {
+			currentSection = section
+		} else if currentSection == wantedSection && lineVariable(line) == strings.ToLower(name) {
+			continue
+		}
+		remaining = append(remaining, line)
+	}
+
+	return writeLines(path, remaining)
+}
+
+func quoteValue(value string) string {
+	if value == "" || strings.ContainsAny(value, "#;\"\\\n\t\b") || strings.TrimSpace(value) != value {
+		value = strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n", "\t", "\\t", "\b", "\\b").Replace(value)
+		return "\"" + value + "\""
+	}
+	return value
+}
+
+func writeLines(path string, lines []string) error {
+	return os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
@@ -0,0 +1,54 @@
+package config
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestSetValueRoundTrips(t *testing.T) {
+	tests := []struct {
+		name  string
+		key   string
+		value string
+	}{
+		{name: "plain", key: "remote.origin.url", value: "https://example.com/repo.git"},
+		{name: "value with comment characters", key: "remote.origin.url", value: "file:///tmp/a#b;c"},
+		{name: "value with a newline", key: "remote.origin.url", value: "u\n[core]\n\thooksPath = /tmp"},
+		{name: "subsection with a quote", key: "submodule.a\"]\n[core.b", value: "x"},
+		{name: "subsection with a quote and backslash", key: "branch.a \"b\\.merge", value: "refs/heads/a \"b\\"},
+		{name: "subsection with brackets", key: "submodule.x] [core.path", value: "x"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filename := path.Join(t.TempDir(), "config")
+			err := SetValue(filename, test.key, test.value)
+			if strings.Contains(test.key, "\n") {
+				if err == nil {
+					t.Fatalf("SetValue(%q) succeeded, want an error", test.key)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			content, err := os.ReadFile(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			file, err := LoadFile(filename)
+			if err != nil {
+				t.Fatalf("LoadFile() = %s for %q", err, content)
+			}
+			if value, ok := file.Get(test.key); !ok || value != test.value {
+				t.Errorf("Get(%q) = %q, %t, want %q in %q", test.key, value, ok, test.value, content)
+			}
+			if file.Has("core.hookspath") || file.Has("core.b") {
+				t.Errorf("SetValue(%q, %q) injected a section: %q", test.key, test.value, content)
+			}
+		})
+	}
+}
@@ -0,0 +1,347 @@
+package core
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"encoding/hex"
+	"fmt"
+	"github.com/untanky/git-charged/plumbing"
+	"io"
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	ArchiveTar   = "tar"
+	ArchiveTarGz = "tar.gz"
+	ArchiveTgz   = "tgz"
+	ArchiveZip   = "zip"
+)
+
+// ArchiveFormats are the formats Archive can write.
+var ArchiveFormats = []string{ArchiveTar, ArchiveTarGz, ArchiveTgz, ArchiveZip}
+
+// archiveUmask is applied to the modes of archived files, like git's
+// default tar.umask.
+const archiveUmask = 0002
+
+var exportSubstPattern = regexp.MustCompile(`\$Format:([^$\n]*)\$`)
+
+type ArchiveParams struct {
+	// Revision is the commit or tree to archive.
+	Revision string
+	// Format is one of ArchiveFormats. It defaults to tar.
+	Format string
+	// Prefix is prepended to every path, so "project/" puts all files into
+	// a directory.
+	Prefix string
+	Output io.Writer
+}
+
+// archiveEntry is a file, directory or symbolic link to add to an archive.
+type archiveEntry struct {
+	name    string
+	mode    fs.FileMode
+	content []byte
+}
+
+type archiveWriter interface {
+	add(entry archiveEntry) error
+	Close() error
+}
+
+// ArchiveFormatFromName guesses the format of an archive from its file
+// name.
+func ArchiveFormatFromName(filename string) (string, bool) {
+	for _, format := range ArchiveFormats {
+		if strings.HasSuffix(filename, "."+format) {
+			return format, true
+		}
+	}
+
+	return "", false
+}
+
+// Archive writes the files of a tree, with the modes and symbolic links
+// recorded in it. Paths with the export-ignore attribute are left out and
+// $Format:...$ placeholders in files with export-subst are expanded for
+// the archived commit. Filters and line ending conversion are applied as on
+// checkout.
+func Archive(params ArchiveParams) error {
+	hash, err := ResolveRevision(params.Revision)
+	if err != nil {
+		return err
+	}
+
+	peeled, kind, err := plumbing.Peel(hash)
+	if err != nil {
+		return err
+	}
+
+	var commit *plumbing.Commit
+	var treeHash []byte
+	modified := time.Now()
+	switch kind {
+	case plumbing.KindCommit:
+		commit, err = plumbing.ReadCommit(peeled)
+		if err != nil {
+			return err
+		}
+		treeHash = commit.Tree
+		modified = commit.Committer.Timestamp
+	case plumbing.KindTree:
+		treeHash = peeled
+	default:
+		return fmt.Errorf("object %x is a %s, not a tree", peeled, kind)
+	}
+
+	var writer archiveWriter
+	switch params.Format {
+	case "", ArchiveTar:
+		writer, err = newTarArchive(params.Output, nil, commit, peeled, modified)
+	case ArchiveTarGz, ArchiveTgz:
+		compressor := gzip.NewWriter(params.Output)
+		writer, err = newTarArchive(compressor, compressor, commit, peeled, modified)
+	case ArchiveZip:
+		writer, err = newZipArchive(params.Output, commit, peeled, modified)
+	default:
+		return fmt.Errorf("unknown archive format '%s'", params.Format)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot write archive: %w", err)
+	}
+
+	mailmap, err := ReadMailmap()
+	if err != nil {
+		return err
+	}
+
+	attributes := treeAttributes(treeHash)
+	archiver := treeArchiver{
+		writer:     writer,
+		attributes: attributes,
+		filter:     newContentFilter(attributes),
+		prefix:     params.Prefix,
+		commit:     commit,
+		hash:       peeled,
+		mailmap:    mailmap,
+	}
+
+	if strings.HasSuffix(params.Prefix, "/") {
+		err = writer.add(archiveEntry{name: params.Prefix, mode: fs.ModeDir | 0777})
+		if err != nil {
+			return fmt.Errorf("cannot write archive: %w", err)
+		}
+	}
+
+	err = archiver.addTree(treeHash, "")
+	if err != nil {
+		return err
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return fmt.Errorf("cannot write archive: %w", err)
+	}
+
+	return nil
+}
+
+type treeArchiver struct {
+	writer     archiveWriter
+	attributes *attributeMatcher
+	filter     *contentFilter
+	prefix     string
+	// commit is nil when a tree is archived, and export-subst has nothing
+	// to expand.
+	commit  *plumbing.Commit
+	hash    []byte
+	mailmap *Mailmap
+}
+
+func (a *treeArchiver) addTree(treeHash []byte, directory string) error {
+	tree, err := plumbing.ReadTree(treeHash)
+	if err != nil {
+		return fmt.Errorf("cannot read tree %x: %w", treeHash, err)
+	}
+
+	for _, entry := range tree.Entries() {
+		name := path.Join(directory, entry.Name)
+		attributes := a.attributes.Attributes(name)
+		if attributes.IsSet("export-ignore") {
+			continue
+		}
+
+		// Submodules are archived as empty directories.
+		if entry.IsDirectory() || entry.IsGitLink() {
+			err = a.writer.add(archiveEntry{name: a.prefix + name + "/", mode: fs.ModeDir | 0777})
+			if err != nil {
+				return fmt.Errorf("cannot write archive: %w", err)
+			}
+			if entry.IsDirectory() {
+				err = a.addTree(entry.Hash, name)
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		content, err := plumbing.ReadObjectOfKind(entry.Hash, plumbing.KindBlob)
+		if err != nil {
+			return fmt.Errorf("cannot read %s: %w", name, err)
+		}
+
+		mode := fs.FileMode(0666)
+		switch {
+		case entry.IsSymbolicLink():
+			mode = fs.ModeSymlink | 0777
+		case entry.Mode&0111 != 0:
+			mode = 0777
+		}
+
+		if !entry.IsSymbolicLink() {
+			if a.commit != nil && attributes.IsSet("export-subst") {
+				content = exportSubstPattern.ReplaceAllFunc(content, func(match []byte) []byte {
+					format := exportSubstPattern.FindSubmatch(match)[1]
+					return []byte(formatCommit(string(format), a.hash, a.commit, a.mailmap.Apply(a.commit.Author), a.mailmap.Apply(a.commit.Committer)))
+				})
+			}
+
+			content, err = a.filter.smudge(name, content)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = a.writer.add(archiveEntry{name: a.prefix + name, mode: mode, content: content})
+		if err != nil {
+			return fmt.Errorf("cannot write archive: %w", err)
+		}
+	}
+
+	return nil
+}
+
+type tarArchive struct {
+	writer     *tar.Writer
+	compressor io.Closer
+	modified   time.Time
+}
+
+// newTarArchive starts a tar archive. For a commit, a global pax header
+// records its id like git does. The compressor, if any, is closed with the
+// archive.
+func newTarArchive(w io.Writer, compressor io.Closer, commit *plumbing.Commit, hash []byte, modified time.Time) (*tarArchive, error) {
+	archive := &tarArchive{writer: tar.NewWriter(w), compressor: compressor, modified: modified}
+
+	if commit != nil {
+		err := archive.writer.WriteHeader(&tar.Header{
+			Typeflag:   tar.TypeXGlobalHeader,
+			Name:       "pax_global_header",
+			PAXRecords: map[string]string{"comment": hex.EncodeToString(hash)},
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return archive, nil
+}
+
+func (t *tarArchive) add(entry archiveEntry) error {
+	header := &tar.Header{
+		Name:    entry.name,
+		Mode:    int64(entry.mode.Perm() &^ archiveUmask),
+		ModTime: t.modified,
+		Uname:   "root",
+		Gname:   "root",
+	}
+
+	switch {
+	case entry.mode.IsDir():
+		header.Typeflag = tar.TypeDir
+	case entry.mode&fs.ModeSymlink != 0:
+		header.Typeflag = tar.TypeSymlink
+		header.Linkname = string(entry.content)
+		header.Mode = int64(entry.mode.Perm())
+	default:
+		header.Typeflag = tar.TypeReg
+		header.Size = int64(len(entry.content))
+	}
+
+	err := t.writer.WriteHeader(header)
+	if err != nil {
+		return err
+	}
+
+	if header.Typeflag == tar.TypeReg {
+		_, err = t.writer.Write(entry.content)
+	}
+	return err
+}
+
+func (t *tarArchive) Close() error {
+	err := t.writer.Close()
+	if err != nil {
+		return err
+	}
+
+	if t.compressor != nil {
+		return t.compressor.Close()
+	}
+	return nil
+}
+
+type zipArchive struct {
+	writer   *zip.Writer
+	modified time.Time
+}
+
+// newZipArchive starts a zip archive. For a commit, the archive comment is
+// its id like git does.
+func newZipArchive(w io.Writer, commit *plumbing.Commit, hash []byte, modified time.Time) (*zipArchive, error) {
+	archive := &zipArchive{writer: zip.NewWriter(w), modified: modified}
+
+	if commit != nil {
+		err := archive.writer.SetComment(hex.EncodeToString(hash))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return archive, nil
+}
+
+func (z *zipArchive) add(entry archiveEntry) error {
+	header := &zip.FileHeader{
+		Name:     entry.name,
+		Modified: z.modified,
+		Method:   zip.Deflate,
+	}
+
+	mode := entry.mode
+	if mode&fs.ModeSymlink == 0 {
+		mode = mode&^fs.ModePerm | mode.Perm()&^archiveUmask
+	}
+	if mode.IsDir() {
+		header.Method = zip.Store
+	}
+	header.SetMode(mode)
+
+	writer, err := z.writer.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+
+	_, err = writer.Write(entry.content)
+	return err
+}
+
+func (z *zipArchive) Close() error {
+	return z.writer.Close()
+}
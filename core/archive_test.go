@@ -0,0 +1,155 @@
+package core
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"github.com/untanky/git-charged/plumbing"
+	"io"
+	"io/fs"
+	"maps"
+	"testing"
+)
+
+// archivedFile is what an archive holds for a path.
+type archivedFile struct {
+	mode    fs.FileMode
+	content string
+}
+
+// readTestArchive reads the files of a tar, tar.gz or zip archive and its
+// commit id.
+func readTestArchive(t *testing.T, format string, archive []byte) (map[string]archivedFile, string) {
+	t.Helper()
+
+	files := make(map[string]archivedFile)
+	if format == ArchiveZip {
+		reader, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, file := range reader.File {
+			content, err := file.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := io.ReadAll(content)
+			content.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			files[file.Name] = archivedFile{mode: file.Mode(), content: string(data)}
+		}
+		return files, reader.Comment
+	}
+
+	var input io.Reader = bytes.NewReader(archive)
+	if format == ArchiveTarGz {
+		decompressor, err := gzip.NewReader(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		input = decompressor
+	}
+
+	comment := ""
+	reader := tar.NewReader(input)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag == tar.TypeXGlobalHeader {
+			comment = header.PAXRecords["comment"]
+			continue
+		}
+
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag == tar.TypeSymlink {
+			data = []byte(header.Linkname)
+		}
+		files[header.Name] = archivedFile{mode: header.FileInfo().Mode(), content: string(data)}
+	}
+
+	return files, comment
+}
+
+func TestArchive(t *testing.T) {
+	initTestRepository(t)
+	file := func(mode uint16, name string, content string) plumbing.TreeEntry {
+		return plumbing.TreeEntry{Mode: mode, Name: name, Hash: writeTestBlob(t, content)}
+	}
+	directory := writeTestTree(t, file(plumbing.ObjectTypeFile|0644, "b", "in a directory\n"))
+	tree := writeTestTree(t,
+		file(plumbing.ObjectTypeFile|0644, ".gitattributes", "secret export-ignore\nversion export-subst\n"),
+		file(plumbing.ObjectTypeFile|0644, "a", "plain\n"),
+		plumbing.TreeEntry{Mode: plumbing.ObjectTypeDirectory, Name: "dir", Hash: directory},
+		file(plumbing.ObjectTypeSymbolicLink, "link", "a"),
+		file(plumbing.ObjectTypeFile|0755, "run.sh", "#!/bin/sh\n"),
+		file(plumbing.ObjectTypeFile|0644, "secret", "not exported\n"),
+		file(plumbing.ObjectTypeFile|0644, "version", "$Format:%H %s$\n"),
+	)
+	commit := writeTestCommit(t, tree, "Release\n")
+	err := plumbing.WriteRef("refs/heads/main", commit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]archivedFile{
+		"project/":               {mode: fs.ModeDir | 0775},
+		"project/.gitattributes": {mode: 0664, content: "secret export-ignore\nversion export-subst\n"},
+		"project/a":              {mode: 0664, content: "plain\n"},
+		"project/dir/":           {mode: fs.ModeDir | 0775},
+		"project/dir/b":          {mode: 0664, content: "in a directory\n"},
+		"project/link":           {mode: fs.ModeSymlink | 0777, content: "a"},
+		"project/run.sh":         {mode: 0775, content: "#!/bin/sh\n"},
+		"project/version":        {mode: 0664, content: hex.EncodeToString(commit) + " Release\n"},
+	}
+
+	for _, format := range []string{ArchiveTar, ArchiveTarGz, ArchiveZip} {
+		t.Run(format, func(t *testing.T) {
+			var output bytes.Buffer
+			err := Archive(ArchiveParams{Revision: "main", Format: format, Prefix: "project/", Output: &output})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			files, comment := readTestArchive(t, format, output.Bytes())
+			if !maps.Equal(files, want) {
+				t.Errorf("archived files = %v, want %v", files, want)
+			}
+			if comment != hex.EncodeToString(commit) {
+				t.Errorf("archive comment = %q, want the commit id %x", comment, commit)
+			}
+		})
+	}
+}
+
+func TestArchiveFormatFromName(t *testing.T) {
+	tests := []struct {
+		filename string
+		format   string
+		ok       bool
+	}{
+		{filename: "project.tar", format: ArchiveTar, ok: true},
+		{filename: "project.tar.gz", format: ArchiveTarGz, ok: true},
+		{filename: "project.tgz", format: ArchiveTgz, ok: true},
+		{filename: "project.zip", format: ArchiveZip, ok: true},
+		{filename: "project.rar"},
+	}
+
+	for _, test := range tests {
+		format, ok := ArchiveFormatFromName(test.filename)
+		if format != test.format || ok != test.ok {
+			t.Errorf("ArchiveFormatFromName(%q) = %q, %t, want %q, %t", test.filename, format, ok, test.format, test.ok)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/untanky/git-charged/plumbing"
+	"os"
+	"path"
+	"strings"
+)
+
+const gitAttributesFile = ".gitattributes"
+
+// Attribute states that are not values. An attribute missing from
+// Attributes is unspecified.
+const (
+	attributeSet   = "\x00set"
+	attributeUnset = "\x00unset"
+)
+
+// Attributes are the gitattributes that apply to a path.
+type Attributes map[string]string
+
+func (a Attributes) IsSet(name string) bool {
+	return a[name] == attributeSet
+}
+
+func (a Attributes) IsUnset(name string) bool {
+	return a[name] == attributeUnset
+}
+
+// Value returns the value of an attribute that was given as name=value.
+func (a Attributes) Value(name string) (string, bool) {
+	value, ok := a[name]
+	if !ok || value == attributeSet || value == attributeUnset {
+		return "", false
+	}
+	return value, true
+}
+
+type attributeAssignment struct {
+	name string
+	// value is attributeSet, attributeUnset, a value, or empty to make the
+	// attribute unspecified again.
+	value string
+}
+
+type attributeRule struct {
+	pattern     *wildcardPattern
+	assignments []attributeAssignment
+}
+
+// attributeMacros are the macros git defines itself.
+var attributeMacros = map[string][]attributeAssignment{
+	"binary": {{"diff", attributeUnset}, {"merge", attributeUnset}, {"text", attributeUnset}},
+}
+
+// attributeMatcher evaluates .gitattributes files. Files are read through
+// source so attributes can come from the working tree or from a tree that
+// is being checked out.
+type attributeMatcher struct {
+	source func(name string) ([]byte, bool)
+	rules  map[string][]attributeRule
+	global []attributeRule
+}
+
+func newAttributeMatcher(source func(name string) ([]byte, bool)) *attributeMatcher {
+	matcher := &attributeMatcher{
+		source: source,
+		rules:  make(map[string][]attributeRule),
+	}
+
+	if content, err := os.ReadFile(path.Join(plumbing.CommonDirectory(), "info", "attributes")); err == nil {
+		matcher.global = parseAttributes(content, "")
+	}
+
+	return matcher
+}
+
+// workingTreeAttributes reads .gitattributes files from the working tree.
+func workingTreeAttributes() *attributeMatcher {
+	return newAttributeMatcher(func(name string) ([]byte, bool) {
+		content, err := os.ReadFile(name)
+		return content, err == nil
+	})
+}
+
+// treeAttributes reads .gitattributes files from a tree object.
+func treeAttributes(treeHash []byte) *attributeMatcher {
+	return newAttributeMatcher(func(name string) ([]byte, bool) {
+		entry, err := findTreeEntry(treeHash, name)
+		if err != nil {
+			return nil, false
+		}
+
+		content, err := plumbing.ReadObjectOfKind(entry.Hash, plumbing.KindBlob)
+		return content, err == nil
+	})
+}
+
+func (m *attributeMatcher) rulesIn(directory string) []attributeRule {
+	if rules, ok := m.rules[directory]; ok {
+		return rules
+	}
+
+	base := directory
+	if base == "." {
+		base = ""
+	}
+
+	var rules []attributeRule
+	if content, ok := m.source(path.Join(directory, gitAttributesFile)); ok {
+		rules = parseAttributes(content, base)
+	}
+	m.rules[directory] = rules
+
+	return rules
+}
+
+// Attributes returns the attributes of a path relative to the top of the
+// working tree. Deeper .gitattributes files take precedence, and
+// $GIT_DIR/info/attributes overrides all of them.
+func (m *attributeMatcher) Attributes(name string) Attributes {
+	directories := []string{"."}
+	for i, c := range name {
+		if c == '/' {
+			directories = append(directories, name[:i])
+		}
+	}
+
+	attributes := make(Attributes)
+	for _, directory := range directories {
+		applyAttributeRules(attributes, m.rulesIn(directory), name)
+	}
+	applyAttributeRules(attributes, m.global, name)
+
+	return attributes
+}
+
+func applyAttributeRules(attributes Attributes, rules []attributeRule, name string) {
+	for _, rule := range rules {
+		if !rule.pattern.matches(name) {
+			continue
+		}
+
+		for _, assignment := range rule.assignments {
+			if assignment.value == "" {
+				delete(attributes, assignment.name)
+				continue
+			}
+			attributes[assignment.name] = assignment.value
+
+			if assignment.value == attributeSet {
+				for _, expanded := range attributeMacros[assignment.name] {
+					attributes[expanded.name] = expanded.value
+				}
+			}
+		}
+	}
+}
+
+func parseAttributes(content []byte, base string) []attributeRule {
+	rules := make([]attributeRule, 0)
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		pattern, err := newWildcardPattern(fields[0], base)
+		if err != nil {
+			continue
+		}
+
+		rule := attributeRule{pattern: pattern}
+		for _, field := range fields[1:] {
+			switch {
+			case strings.HasPrefix(field, "-"):
+				rule.assignments = append(rule.assignments, attributeAssignment{field[1:], attributeUnset})
+			case strings.HasPrefix(field, "!"):
+				rule.assignments = append(rule.assignments, attributeAssignment{field[1:], ""})
+			case strings.Contains(field, "="):
+				name, value, _ := strings.Cut(field, "=")
+				rule.assignments = append(rule.assignments, attributeAssignment{name, value})
+			default:
+				rule.assignments = append(rule.assignments, attributeAssignment{field, attributeSet})
+			}
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules
+}
+
+// findTreeEntry looks up a slash separated path in a tree.
+func findTreeEntry(treeHash []byte, name string) (*plumbing.TreeEntry, error) {
+	components := strings.Split(name, "/")
+	for i, component := range components {
+		tree, err := plumbing.ReadTree(treeHash)
+		if err != nil {
+			return nil, err
+		}
+
+		var found *plumbing.TreeEntry
+		for _, entry := range tree.Entries() {
+			if entry.Name == component {
+				found = &entry
+				break
+			}
+		}
+		if found == nil {
+			return nil, fmt.Errorf("%w: %s", plumbing.ErrObjectNotFound, name)
+		}
+		if i == len(components)-1 {
+			return found, nil
+		}
+		if !found.IsDirectory() {
+			return nil, fmt.Errorf("%w: %s", plumbing.ErrObjectNotFound, name)
+		}
+		treeHash = found.Hash
+	}
+
+	return nil, fmt.Errorf("%w: %s", plumbing.ErrObjectNotFound, name)
+}
@@ -0,0 +1,80 @@
+package core
+
+import (
+	"maps"
+	"testing"
+)
+
+// The expected attributes are the output of git check-attr for the same
+// files.
+func TestAttributeMatcher(t *testing.T) {
+	files := map[string]string{
+		gitAttributesFile:          "*.txt text\n*.bin binary\n*.sh eol=lf -diff\ndocs/ export-ignore\nsub/** custom=top\n",
+		"sub/" + gitAttributesFile: "*.txt -text\ndeep/* !custom\nnote* custom=sub\n",
+	}
+	matcher := newAttributeMatcher(func(name string) ([]byte, bool) {
+		content, ok := files[name]
+		return []byte(content), ok
+	})
+
+	tests := []struct {
+		name       string
+		attributes Attributes
+	}{
+		{name: "a.txt", attributes: Attributes{"text": attributeSet}},
+		{name: "b.bin", attributes: Attributes{"binary": attributeSet, "text": attributeUnset, "diff": attributeUnset, "merge": attributeUnset}},
+		{name: "c.sh", attributes: Attributes{"eol": "lf", "diff": attributeUnset}},
+		{name: "sub/x.txt", attributes: Attributes{"text": attributeUnset, "custom": "top"}},
+		{name: "sub/note.md", attributes: Attributes{"custom": "sub"}},
+		{name: "sub/deep/y.txt", attributes: Attributes{"text": attributeUnset}},
+		{name: "sub/deep/other", attributes: Attributes{}},
+		{name: "docs/file", attributes: Attributes{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			attributes := matcher.Attributes(test.name)
+			if !maps.Equal(attributes, test.attributes) {
+				t.Errorf("Attributes(%q) = %q, want %q", test.name, attributes, test.attributes)
+			}
+		})
+	}
+}
+
+func TestCompileWildcard(t *testing.T) {
+	tests := []struct {
+		pattern string
+		matches []string
+		others  []string
+	}{
+		{pattern: "*.go", matches: []string{"main.go", ".go"}, others: []string{"cmd/main.go", "main.goo"}},
+		{pattern: "?.c", matches: []string{"a.c"}, others: []string{"ab.c", "/.c"}},
+		{pattern: "[ab].md", matches: []string{"a.md", "b.md"}, others: []string{"c.md"}},
+		{pattern: "[!ab].md", matches: []string{"c.md"}, others: []string{"a.md"}},
+		{pattern: "**/build", matches: []string{"build", "a/build", "a/b/build"}, others: []string{"a/builds"}},
+		{pattern: "out/**", matches: []string{"out/a", "out/a/b"}, others: []string{"out", "other/a"}},
+		{pattern: "a/**/z", matches: []string{"a/z", "a/b/z", "a/b/c/z"}, others: []string{"b/a/z"}},
+		{pattern: `\*.txt`, matches: []string{"*.txt"}, others: []string{"a.txt"}},
+		{pattern: "[unclosed", matches: []string{"[unclosed"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.pattern, func(t *testing.T) {
+			compiled, err := compileWildcard(test.pattern)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, name := range test.matches {
+				if !compiled.MatchString(name) {
+					t.Errorf("%q does not match %q", name, test.pattern)
+				}
+			}
+			for _, name := range test.others {
+				if compiled.MatchString(name) {
+					t.Errorf("%q matches %q", name, test.pattern)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,602 @@
+package core
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/untanky/git-charged/plumbing"
+	"io"
+	"math/bits"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const (
+	bisectRefPrefix = "refs/bisect/"
+	bisectBadRef    = bisectRefPrefix + "bad"
+
+	bisectStartFile    = "BISECT_START"
+	bisectTermsFile    = "BISECT_TERMS"
+	bisectLogFile      = "BISECT_LOG"
+	bisectNamesFile    = "BISECT_NAMES"
+	bisectExpectedFile = "BISECT_EXPECTED_REV"
+
+	BisectGood = "good"
+	BisectBad  = "bad"
+	BisectSkip = "skip"
+
+	// Scripts run by bisect run exit with this code if the commit cannot
+	// be tested.
+	bisectRunSkipCode = 125
+)
+
+var ErrNotBisecting = errors.New("not bisecting, start with bisect start")
+
+// BisectCandidate is a commit that can still be the first bad commit.
+type BisectCandidate struct {
+	Hash    []byte
+	Summary string
+	Skipped bool
+}
+
+// BisectState describes the progress of a bisection.
+type BisectState struct {
+	// MissingBad and MissingGood are set while the search waits for a bad
+	// commit or for at least one good commit.
+	MissingBad  bool
+	MissingGood bool
+	// Current is the commit that is checked out to be tested next.
+	Current []byte
+	// Remaining is the number of revisions left to test after Current,
+	// which takes roughly Steps more steps.
+	Remaining int
+	Steps     int
+	// Candidates are the commits that can be the first bad commit, newest
+	// first.
+	Candidates []BisectCandidate
+	// FirstBad is set once the first bad commit is found.
+	FirstBad []byte
+	// OnlySkipped is set if the first bad commit is one of Candidates, but
+	// all of them except the bad commit were skipped.
+	OnlySkipped bool
+}
+
+func (s *BisectState) Finished() bool {
+	return s.FirstBad != nil || s.OnlySkipped
+}
+
+// Describe summarizes the state the way git bisect reports it.
+func (s *BisectState) Describe() string {
+	switch {
+	case s.MissingBad && s.MissingGood:
+		return "status: waiting for both good and bad commits"
+	case s.MissingBad:
+		return "status: waiting for bad commit, good commit(s) known"
+	case s.MissingGood:
+		return "status: waiting for good commit(s), bad commit known"
+	case s.FirstBad != nil:
+		return fmt.Sprintf("%x is the first bad commit", s.FirstBad)
+	case s.OnlySkipped:
+		return "There are only 'skip'ped commits left to test.\nThe first bad commit could be any of:"
+	}
+
+	return fmt.Sprintf("Bisecting: %d %s left to test after this (roughly %d %s)",
+		s.Remaining, plural(s.Remaining, "revision", "revisions"), s.Steps, plural(s.Steps, "step", "steps"))
+}
+
+func plural(n int, singular string, plural string) string {
+	if n == 1 {
+		return singular
+	}
+	return plural
+}
+
+type BisectStartParams struct {
+	// Bad and Good mark commits right away.
+	Bad  string
+	Good []string
+}
+
+func isBisecting() bool {
+	_, err := os.Stat(gitFile(bisectStartFile))
+	return err == nil
+}
+
+func appendBisectLog(lines ...string) error {
+	file, err := os.OpenFile(gitFile(bisectLogFile), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	for _, line := range lines {
+		_, err = fmt.Fprintln(file, line)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// bisectLogCommit formats a commit like git does in the bisect log, for
+// example "[<hash>] Fix typo".
+func bisectLogCommit(hash []byte) string {
+	commit, err := plumbing.ReadCommit(hash)
+	if err != nil {
+		return fmt.Sprintf("[%x]", hash)
+	}
+
+	subject, _, _ := strings.Cut(commit.Message, "\n")
+	return fmt.Sprintf("[%x] %s", hash, subject)
+}
+
+// BisectStart starts a binary search for the commit that introduced a bug.
+// The branch or commit that is checked out is restored by BisectReset.
+// Starting again while bisecting discards the commits marked so far.
+func BisectStart(params BisectStartParams) (*BisectState, error) {
+	head, err := plumbing.ResolveRef(plumbing.HEAD)
+	if err != nil {
+		return nil, fmt.Errorf("cannot resolve HEAD: %w", err)
+	}
+
+	// Revisions are resolved before any state is written, so that a typo
+	// does not leave a half started bisection behind.
+	var bad []byte
+	if params.Bad != "" {
+		bad, err = resolveCommit(params.Bad)
+		if err != nil {
+			return nil, err
+		}
+	}
+	good := make([][]byte, 0, len(params.Good))
+	for _, revision := range params.Good {
+		hash, err := resolveCommit(revision)
+		if err != nil {
+			return nil, err
+		}
+		good = append(good, hash)
+	}
+
+	if isBisecting() {
+		err = clearBisectState(false)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		start := hex.EncodeToString(head)
+		if branch, ok := currentBranch(); ok {
+			start = branch
+		}
+
+		err = os.WriteFile(gitFile(bisectStartFile), []byte(start+"\n"), 0644)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = os.WriteFile(gitFile(bisectTermsFile), []byte(BisectBad+"\n"+BisectGood+"\n"), 0644)
+	if err != nil {
+		return nil, err
+	}
+	err = os.WriteFile(gitFile(bisectNamesFile), []byte("\n"), 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	log := make([]string, 0)
+	if bad != nil {
+		log = append(log, fmt.Sprintf("# %s: %s", BisectBad, bisectLogCommit(bad)))
+		err = plumbing.WriteRef(bisectBadRef, bad)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, hash := range good {
+		log = append(log, fmt.Sprintf("# %s: %s", BisectGood, bisectLogCommit(hash)))
+		err = plumbing.WriteRef(bisectMarkRef(BisectGood, hash), hash)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	command := "git bisect start"
+	if bad != nil {
+		command += " " + hex.EncodeToString(bad)
+	}
+	for _, hash := range good {
+		command += " " + hex.EncodeToString(hash)
+	}
+	err = appendBisectLog(append(log, command)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return bisectNext()
+}
+
+func resolveCommit(revision string) ([]byte, error) {
+	hash, err := ResolveRevision(revision)
+	if err != nil {
+		return nil, err
+	}
+
+	return peelTo(hash, plumbing.KindCommit)
+}
+
+func bisectMarkRef(term string, hash []byte) string {
+	if term == BisectBad {
+		return bisectBadRef
+	}
+
+	return fmt.Sprintf("%s%s-%x", bisectRefPrefix, term, hash)
+}
+
+// BisectMark marks commits as good, bad or skipped and checks out the next
+// commit to test. Without revisions, HEAD is marked.
+func BisectMark(term string, revisions []string) (*BisectState, error) {
+	if !isBisecting() {
+		return nil, ErrNotBisecting
+	}
+	if term != BisectGood && term != BisectBad && term != BisectSkip {
+		return nil, fmt.Errorf("unknown bisect term %q", term)
+	}
+
+	if len(revisions) == 0 {
+		revisions = []string{plumbing.HEAD}
+	}
+	if term == BisectBad && len(revisions) > 1 {
+		return nil, fmt.Errorf("only one commit can be marked as %s", BisectBad)
+	}
+
+	hashes := make([][]byte, 0, len(revisions))
+	for _, revision := range revisions {
+		hash, err := resolveCommit(revision)
+		if err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+
+	for _, hash := range hashes {
+		err := plumbing.WriteRef(bisectMarkRef(term, hash), hash)
+		if err != nil {
+			return nil, err
+		}
+
+		err = appendBisectLog(fmt.Sprintf("# %s: %s", term, bisectLogCommit(hash)), fmt.Sprintf("git bisect %s %x", term, hash))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return bisectNext()
+}
+
+// BisectStatus returns the progress of the current bisection without
+// changing it.
+func BisectStatus() (*BisectState, error) {
+	if !isBisecting() {
+		return nil, ErrNotBisecting
+	}
+
+	state, err := bisectSearch()
+	return state, err
+}
+
+// bisectNext computes the next commit to test and checks it out.
+func bisectNext() (*BisectState, error) {
+	state, err := bisectSearch()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case state.MissingBad || state.MissingGood:
+		return state, appendBisectLog("# " + state.Describe())
+	case state.FirstBad != nil:
+		return state, appendBisectLog("# first bad commit: " + bisectLogCommit(state.FirstBad))
+	case state.OnlySkipped:
+		lines := make([]string, 0, len(state.Candidates))
+		for _, candidate := range state.Candidates {
+			lines = append(lines, "# possible first bad commit: "+bisectLogCommit(candidate.Hash))
+		}
+		return state, appendBisectLog(lines...)
+	}
+
+	if head, err := plumbing.ResolveRef(plumbing.HEAD); err != nil || !bytes.Equal(head, state.Current) {
+		err = checkoutCommit(state.Current, "")
+		if err != nil {
+			return nil, fmt.Errorf("cannot check out %s: %w", shortHash(state.Current), err)
+		}
+	}
+
+	return state, os.WriteFile(gitFile(bisectExpectedFile), []byte(hex.EncodeToString(state.Current)+"\n"), 0644)
+}
+
+// bisectSearch reads the marked commits and picks the candidate that
+// splits the remaining candidates most evenly, like git bisect does.
+func bisectSearch() (*BisectState, error) {
+	state := &BisectState{}
+
+	bad, err := plumbing.ResolveRef(bisectBadRef)
+	if err != nil {
+		state.MissingBad = true
+	}
+
+	good, err := bisectMarkedCommits(BisectGood)
+	if err != nil {
+		return nil, err
+	}
+	state.MissingGood = len(good) == 0
+
+	if state.MissingBad || state.MissingGood {
+		return state, nil
+	}
+
+	skipped, err := bisectMarkedCommits(BisectSkip)
+	if err != nil {
+		return nil, err
+	}
+	isSkipped := make(map[string]bool, len(skipped))
+	for _, hash := range skipped {
+		isSkipped[string(hash)] = true
+	}
+
+	walker := plumbing.NewCommitWalker()
+	err = walker.Push(bad)
+	if err != nil {
+		return nil, err
+	}
+	for _, hash := range good {
+		err = walker.Hide(hash)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	commits := make(map[string]*plumbing.Commit)
+	for {
+		hash, commit, err := walker.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		commits[string(hash)] = commit
+		subject, _, _ := strings.Cut(commit.Message, "\n")
+		state.Candidates = append(state.Candidates, BisectCandidate{Hash: hash, Summary: subject, Skipped: isSkipped[string(hash)]})
+	}
+
+	if len(state.Candidates) == 0 {
+		return nil, fmt.Errorf("the bad commit %s is an ancestor of a good commit", shortHash(bad))
+	}
+
+	// The weight of a candidate is the number of candidates it contains:
+	// if it is bad, the first bad commit is among them.
+	weights := make(map[string]int, len(commits))
+	for _, candidate := range state.Candidates {
+		weights[string(candidate.Hash)] = countCandidateAncestors(candidate.Hash, commits)
+	}
+
+	// Of two equally good commits the older one is tested, like git does.
+	all := len(state.Candidates)
+	best, bestScore, bestWeight := []byte(nil), -1, 0
+	for _, candidate := range state.Candidates {
+		if candidate.Skipped || bytes.Equal(candidate.Hash, bad) {
+			continue
+		}
+
+		weight := weights[string(candidate.Hash)]
+		score := min(weight, all-weight)
+		if score > bestScore || (score == bestScore && weight < bestWeight) {
+			best, bestScore, bestWeight = candidate.Hash, score, weight
+		}
+	}
+
+	if best == nil {
+		if all == 1 || !hasSkippedCandidate(state.Candidates) {
+			state.FirstBad = bad
+			return state, nil
+		}
+
+		state.OnlySkipped = true
+		return state, nil
+	}
+
+	state.Current = best
+	state.Remaining = all - weights[string(best)] - 1
+	state.Steps = estimateBisectSteps(all)
+
+	return state, nil
+}
+
+func hasSkippedCandidate(candidates []BisectCandidate) bool {
+	for _, candidate := range candidates {
+		if candidate.Skipped {
+			return true
+		}
+	}
+	return false
+}
+
+func bisectMarkedCommits(term string) ([][]byte, error) {
+	refs, err := plumbing.ListRefs(bisectRefPrefix + term + "-")
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([][]byte, 0, len(refs))
+	for _, ref := range refs {
+		hashes = append(hashes, ref.Hash)
+	}
+	return hashes, nil
+}
+
+// countCandidateAncestors counts the candidates reachable from a commit,
+// including the commit itself.
+func countCandidateAncestors(hash []byte, commits map[string]*plumbing.Commit) int {
+	seen := map[string]bool{string(hash): true}
+	pending := [][]byte{hash}
+	for len(pending) > 0 {
+		commit := commits[string(pending[len(pending)-1])]
+		pending = pending[:len(pending)-1]
+
+		for _, parent := range commit.Parents {
+			if _, ok := commits[string(parent)]; ok && !seen[string(parent)] {
+				seen[string(parent)] = true
+				pending = append(pending, parent)
+			}
+		}
+	}
+
+	return len(seen)
+}
+
+// estimateBisectSteps estimates the number of steps left for all
+// candidates the same way git does.
+func estimateBisectSteps(all int) int {
+	if all < 3 {
+		return 0
+	}
+
+	n := bits.Len(uint(all)) - 1
+	e := 1 << n
+	if e < 3*(all-e) {
+		return n
+	}
+	return n - 1
+}
+
+// BisectReset ends the bisection and checks out the branch or commit that
+// was checked out when it started, or the given revision instead.
+func BisectReset(revision string) error {
+	if !isBisecting() {
+		return ErrNotBisecting
+	}
+
+	if revision == "" {
+		content, err := os.ReadFile(gitFile(bisectStartFile))
+		if err != nil {
+			return err
+		}
+		revision = strings.TrimSpace(string(content))
+	}
+
+	branch := ""
+	if _, err := plumbing.ResolveRef(headsPrefix + revision); err == nil {
+		branch = revision
+	}
+
+	hash, err := resolveCommit(revision)
+	if err != nil {
+		return err
+	}
+
+	head, _ := plumbing.ResolveRef(plumbing.HEAD)
+	current, _ := currentBranch()
+	if !bytes.Equal(head, hash) || current != branch {
+		err = checkoutCommit(hash, branch)
+		if err != nil {
+			return fmt.Errorf("cannot check out %s: %w", revision, err)
+		}
+	}
+
+	return clearBisectState(true)
+}
+
+// clearBisectState removes the marked commits and the bisect files. The
+// commit to return to is only removed at the very end.
+func clearBisectState(all bool) error {
+	refs, err := plumbing.ListRefs(bisectRefPrefix)
+	if err != nil {
+		return err
+	}
+	for _, ref := range refs {
+		err = plumbing.DeleteRef(ref.Name)
+		if err != nil {
+			return err
+		}
+	}
+
+	files := []string{bisectLogFile, bisectTermsFile, bisectNamesFile, bisectExpectedFile}
+	if all {
+		files = append(files, bisectStartFile)
+	}
+	for _, name := range files {
+		err = os.Remove(gitFile(name))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// BisectLog returns the log of the current bisection.
+func BisectLog() (string, error) {
+	if !isBisecting() {
+		return "", ErrNotBisecting
+	}
+
+	content, err := os.ReadFile(gitFile(bisectLogFile))
+	if err != nil {
+		return "", err
+	}
+
+	return string(content), nil
+}
+
+// BisectRun automates the bisection by running command on every commit to
+// test. An exit code of 0 marks the commit as good, 125 skips it and any
+// other code below 128 marks it as bad. Other codes abort the search.
+func BisectRun(command []string, output io.Writer) (*BisectState, error) {
+	state, err := BisectStatus()
+	if err != nil {
+		return nil, err
+	}
+	if state.MissingBad || state.MissingGood {
+		return nil, fmt.Errorf("bisect run needs a good and a bad commit, %s", state.Describe())
+	}
+
+	for !state.Finished() {
+		fmt.Fprintf(output, "running %s\n", strings.Join(command, " "))
+
+		run := exec.Command(command[0], command[1:]...)
+		run.Stdout = output
+		run.Stderr = output
+		err = run.Run()
+
+		code := 0
+		var exitError *exec.ExitError
+		if errors.As(err, &exitError) {
+			code = exitError.ExitCode()
+		} else if err != nil {
+			return nil, fmt.Errorf("cannot run %s: %w", command[0], err)
+		}
+
+		term := BisectBad
+		switch {
+		case code == 0:
+			term = BisectGood
+		case code == bisectRunSkipCode:
+			term = BisectSkip
+		case code < 0 || code >= 128:
+			return nil, fmt.Errorf("bisect run failed: exit code %d from %s is < 0 or >= 128", code, strings.Join(command, " "))
+		}
+
+		state, err = BisectMark(term, nil)
+		if err != nil {
+			return nil, err
+		}
+		if !state.Finished() {
+			fmt.Fprintln(output, state.Describe())
+		}
+	}
+
+	return state, nil
+}
@@ -0,0 +1,133 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/untanky/git-charged/plumbing"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// initBisectTest creates a main branch of count commits whose file "value"
+// is "ok" before commit firstBad and "bug" from it on, and checks it out.
+func initBisectTest(t *testing.T, count int, firstBad int) [][]byte {
+	t.Helper()
+
+	initTestRepository(t)
+	commits := make([][]byte, 0, count)
+	var parents [][]byte
+	var tree []byte
+	for i := 0; i < count; i++ {
+		value := "ok\n"
+		if i >= firstBad {
+			value = "bug\n"
+		}
+		tree = writeTestTree(t,
+			plumbing.TreeEntry{Mode: plumbing.ObjectTypeFile | 0644, Name: "number", Hash: writeTestBlob(t, fmt.Sprintf("%d\n", i))},
+			plumbing.TreeEntry{Mode: plumbing.ObjectTypeFile | 0644, Name: "value", Hash: writeTestBlob(t, value)},
+		)
+		commit := writeTestCommit(t, tree, fmt.Sprintf("Commit %d\n", i), parents...)
+		commits = append(commits, commit)
+		parents = [][]byte{commit}
+	}
+
+	err := plumbing.WriteRef("refs/heads/main", commits[count-1])
+	if err == nil {
+		err = plumbing.WriteSymbolicRef(plumbing.HEAD, "refs/heads/main")
+	}
+	if err == nil {
+		err = checkoutTree(tree, false)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return commits
+}
+
+func TestBisectRun(t *testing.T) {
+	for _, firstBad := range []int{1, 4, 7} {
+		t.Run(fmt.Sprintf("first bad commit %d", firstBad), func(t *testing.T) {
+			commits := initBisectTest(t, 8, firstBad)
+
+			state, err := BisectStart(BisectStartParams{Bad: "main", Good: []string{fmt.Sprintf("%x", commits[0])}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if state.Finished() || state.Current == nil {
+				t.Fatalf("BisectStart() = %+v, want a commit to test", state)
+			}
+
+			state, err = BisectRun([]string{"sh", "-c", "grep -q ok value"}, io.Discard)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(state.FirstBad, commits[firstBad]) {
+				t.Errorf("first bad commit = %x, want %x", state.FirstBad, commits[firstBad])
+			}
+
+			log, err := BisectLog()
+			if err != nil || !strings.Contains(log, fmt.Sprintf("# first bad commit: [%x] Commit %d", commits[firstBad], firstBad)) {
+				t.Errorf("BisectLog() = %q, %v", log, err)
+			}
+
+			err = BisectReset("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if branch, ok := currentBranch(); !ok || branch != "main" {
+				t.Errorf("current branch = %q, %t after reset, want main", branch, ok)
+			}
+			if content, _ := os.ReadFile("number"); string(content) != "7\n" {
+				t.Errorf("number = %q after reset, want the tip of main", content)
+			}
+			if isBisecting() {
+				t.Errorf("still bisecting after reset")
+			}
+		})
+	}
+}
+
+func TestBisectMarkWaitsForGoodAndBad(t *testing.T) {
+	commits := initBisectTest(t, 4, 2)
+
+	state, err := BisectStart(BisectStartParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !state.MissingBad || !state.MissingGood {
+		t.Errorf("BisectStart() = %s, want to wait for both", state.Describe())
+	}
+
+	state, err = BisectMark(BisectBad, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.MissingBad || !state.MissingGood {
+		t.Errorf("after marking bad: %s", state.Describe())
+	}
+
+	state, err = BisectMark(BisectGood, []string{fmt.Sprintf("%x", commits[0])})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.MissingGood || state.Current == nil {
+		t.Errorf("after marking good: %s", state.Describe())
+	}
+
+	_, err = BisectMark("ugly", nil)
+	if err == nil {
+		t.Errorf("BisectMark() accepted an unknown term")
+	}
+}
+
+// The expected steps are those of git's estimate_bisect_steps.
+func TestEstimateBisectSteps(t *testing.T) {
+	for all, steps := range map[int]int{0: 0, 2: 0, 3: 1, 4: 1, 5: 1, 6: 2, 8: 2, 11: 3, 12: 3, 100: 6, 1000: 9} {
+		if estimated := estimateBisectSteps(all); estimated != steps {
+			t.Errorf("estimateBisectSteps(%d) = %d, want %d", all, estimated, steps)
+		}
+	}
+}
@@ -0,0 +1,466 @@
+package core
+
+import (
+	"bytes"
+	"container/heap"
+	"fmt"
+	"github.com/untanky/git-charged/plumbing"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	// Moved and copied blocks need at least this many alphanumeric
+	// characters to be attributed to another place, like git's defaults.
+	blameMoveScore = 20
+	blameCopyScore = 40
+
+	// renameSimilarity is the share of common lines, in percent, that a
+	// deleted file needs to be taken as the previous name of a file.
+	renameSimilarity = 50
+)
+
+type BlameParams struct {
+	// Revision is the commit to start from. HEAD is used if it is empty.
+	Revision string
+	Path     string
+	// DetectMoves attributes lines moved within a file to the commit that
+	// wrote them rather than the one that moved them.
+	DetectMoves bool
+	// DetectCopies also looks for lines copied or moved from other files
+	// that were modified in the same commit.
+	DetectCopies bool
+}
+
+// BlameLine is a line of a file with the commit that last changed it.
+type BlameLine struct {
+	Commit []byte
+	Author plumbing.AuthorData
+	// Summary is the first line of the commit message.
+	Summary string
+	// Path and OriginalLine locate the line in Commit, which can differ from
+	// the blamed file after renames, moves and copies.
+	Path         string
+	OriginalLine int
+	Line         int
+	Content      string
+	// Boundary is set for lines of root and shallow commits, whose history
+	// is not known.
+	Boundary bool
+}
+
+// suspectLine is a line of the blamed file together with its position in
+// the version of an origin.
+type suspectLine struct {
+	final int
+	line  int
+}
+
+// blameOrigin is a version of a file in a commit that is suspected to have
+// written some lines of the blamed file.
+type blameOrigin struct {
+	hash     []byte
+	commit   *plumbing.Commit
+	path     string
+	blob     []byte
+	content  []string
+	suspects []suspectLine
+}
+
+type blameQueue []*blameOrigin
+
+func (q blameQueue) Len() int { return len(q) }
+func (q blameQueue) Less(i, j int) bool {
+	return q[i].commit.Committer.Timestamp.After(q[j].commit.Committer.Timestamp)
+}
+func (q blameQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *blameQueue) Push(x any)   { *q = append(*q, x.(*blameOrigin)) }
+func (q *blameQueue) Pop() any {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+type blamer struct {
+	params  BlameParams
+	queue   blameQueue
+	pending map[string]*blameOrigin
+	blobs   map[string][]string
+	mailmap *Mailmap
+	result  []BlameLine
+}
+
+// Blame attributes each line of a file to the commit that last changed it.
+// Renames are followed, and moved or copied lines are traced back to their
+// origin if requested. Authors are mapped through the mailmap.
+func Blame(params BlameParams) ([]BlameLine, error) {
+	if params.Revision == "" {
+		params.Revision = plumbing.HEAD
+	}
+
+	hash, err := ResolveRevision(params.Revision)
+	if err != nil {
+		return nil, err
+	}
+	hash, err = peelTo(hash, plumbing.KindCommit)
+	if err != nil {
+		return nil, err
+	}
+
+	commit, err := plumbing.ReadCommit(hash)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read commit %x: %w", hash, err)
+	}
+
+	entry, err := findTreeEntry(commit.Tree, params.Path)
+	if err != nil || entry.IsDirectory() {
+		return nil, fmt.Errorf("no such path %s in %s", params.Path, params.Revision)
+	}
+
+	mailmap, err := ReadMailmap()
+	if err != nil {
+		return nil, err
+	}
+
+	b := &blamer{
+		params:  params,
+		queue:   make(blameQueue, 0),
+		pending: make(map[string]*blameOrigin),
+		blobs:   make(map[string][]string),
+		mailmap: mailmap,
+	}
+
+	content, err := b.readLines(entry.Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	b.result = make([]BlameLine, len(content))
+	suspects := make([]suspectLine, len(content))
+	for i, line := range content {
+		b.result[i] = BlameLine{Line: i + 1, Content: line}
+		suspects[i] = suspectLine{final: i, line: i}
+	}
+
+	b.push(&blameOrigin{hash: hash, commit: commit, path: params.Path, blob: entry.Hash, content: content}, suspects)
+	for b.queue.Len() > 0 {
+		origin := heap.Pop(&b.queue).(*blameOrigin)
+		delete(b.pending, origin.key())
+
+		err = b.pass(origin)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return b.result, nil
+}
+
+func (o *blameOrigin) key() string {
+	return string(o.hash) + "\000" + o.path
+}
+
+func (b *blamer) readLines(blob []byte) ([]string, error) {
+	if lines, ok := b.blobs[string(blob)]; ok {
+		return lines, nil
+	}
+
+	data, err := plumbing.ReadObjectOfKind(blob, plumbing.KindBlob)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read blob %x: %w", blob, err)
+	}
+
+	lines := splitLines(string(data))
+	b.blobs[string(blob)] = lines
+	return lines, nil
+}
+
+// push hands suspects over to an origin. Origins for the same commit and
+// path are merged so that every version is only examined once.
+func (b *blamer) push(origin *blameOrigin, suspects []suspectLine) {
+	if len(suspects) == 0 {
+		return
+	}
+
+	if existing, ok := b.pending[origin.key()]; ok {
+		existing.suspects = append(existing.suspects, suspects...)
+		return
+	}
+
+	origin.suspects = suspects
+	b.pending[origin.key()] = origin
+	heap.Push(&b.queue, origin)
+}
+
+// pass hands the lines of an origin that already existed in a parent over
+// to that parent and blames the rest on the origin's commit.
+func (b *blamer) pass(origin *blameOrigin) error {
+	// Suspects are kept in the order of the origin's lines so that blocks of
+	// moved lines can be found.
+	remaining := origin.suspects
+	sort.Slice(remaining, func(i, j int) bool { return remaining[i].line < remaining[j].line })
+	boundary := len(origin.commit.Parents) == 0 || plumbing.IsShallow(origin.hash)
+
+	parents := make([]*blameOrigin, 0, len(origin.commit.Parents))
+	if !boundary {
+		for _, parentHash := range origin.commit.Parents {
+			parent, err := b.parentOrigin(origin, parentHash)
+			if err != nil {
+				return err
+			}
+			if parent == nil {
+				continue
+			}
+			parents = append(parents, parent)
+
+			if bytes.Equal(parent.blob, origin.blob) {
+				b.push(parent, remaining)
+				return nil
+			}
+
+			var passed []suspectLine
+			passed, remaining = passMatchingLines(origin, parent.content, remaining)
+			b.push(parent, passed)
+			if len(remaining) == 0 {
+				return nil
+			}
+		}
+	}
+
+	if b.params.DetectMoves || b.params.DetectCopies {
+		for _, parent := range parents {
+			var passed []suspectLine
+			passed, remaining = passMovedLines(origin, parent.content, remaining, blameMoveScore)
+			b.push(parent, passed)
+		}
+	}
+
+	if b.params.DetectCopies && len(remaining) > 0 && !boundary {
+		sources, err := b.copySources(origin)
+		if err != nil {
+			return err
+		}
+
+		for _, source := range sources {
+			var passed []suspectLine
+			passed, remaining = passMovedLines(origin, source.content, remaining, blameCopyScore)
+			b.push(source, passed)
+		}
+	}
+
+	summary, _, _ := strings.Cut(origin.commit.Message, "\n")
+	for _, suspect := range remaining {
+		line := &b.result[suspect.final]
+		line.Commit = origin.hash
+		line.Author = b.mailmap.Apply(origin.commit.Author)
+		line.Summary = summary
+		line.Path = origin.path
+		line.OriginalLine = suspect.line + 1
+		line.Boundary = boundary
+	}
+
+	return nil
+}
+
+// parentOrigin finds the version of an origin's file in a parent commit,
+// following a rename if the path does not exist there. It returns nil if
+// the file was added in the origin's commit.
+func (b *blamer) parentOrigin(origin *blameOrigin, parentHash []byte) (*blameOrigin, error) {
+	parent, err := plumbing.ReadCommit(parentHash)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read commit %x: %w", parentHash, err)
+	}
+
+	name := origin.path
+	entry, err := findTreeEntry(parent.Tree, name)
+	if err != nil || entry.IsDirectory() {
+		name, entry, err = b.findRenameSource(origin, parent)
+		if err != nil || entry == nil {
+			return nil, err
+		}
+	}
+
+	content, err := b.readLines(entry.Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return &blameOrigin{hash: parentHash, commit: parent, path: name, blob: entry.Hash, content: content}, nil
+}
+
+// findRenameSource looks for the file that an origin's file was renamed
+// from: a file deleted in the origin's commit with the same or mostly the
+// same content.
+func (b *blamer) findRenameSource(origin *blameOrigin, parent *plumbing.Commit) (string, *plumbing.TreeEntry, error) {
+	before, err := treeVersions(parent.Tree)
+	if err != nil {
+		return "", nil, err
+	}
+	after, err := treeVersions(origin.commit.Tree)
+	if err != nil {
+		return "", nil, err
+	}
+
+	names := make([]string, 0, len(before))
+	for name := range before {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	best, bestScore := "", renameSimilarity-1
+	for _, name := range names {
+		version := before[name]
+		if after[name].exists() || version.mode&0xf000 != 0x8000 {
+			continue
+		}
+
+		if bytes.Equal(version.hash, origin.blob) {
+			best = name
+			break
+		}
+
+		content, err := b.readLines(version.hash)
+		if err != nil {
+			return "", nil, err
+		}
+		score := lineSimilarity(content, origin.content)
+		if score > bestScore {
+			best, bestScore = name, score
+		}
+	}
+
+	if best == "" {
+		return "", nil, nil
+	}
+
+	entry := &plumbing.TreeEntry{Name: best, Mode: uint16(before[best].mode), Hash: before[best].hash}
+	return best, entry, nil
+}
+
+// copySources returns the parent versions of the other files changed in an
+// origin's commit, which lines may have been copied or moved from.
+func (b *blamer) copySources(origin *blameOrigin) ([]*blameOrigin, error) {
+	parentHash := origin.commit.Parents[0]
+	parent, err := plumbing.ReadCommit(parentHash)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read commit %x: %w", parentHash, err)
+	}
+
+	before, err := treeVersions(parent.Tree)
+	if err != nil {
+		return nil, err
+	}
+	after, err := treeVersions(origin.commit.Tree)
+	if err != nil {
+		return nil, err
+	}
+
+	sources := make([]*blameOrigin, 0)
+	for _, name := range changedPaths(before, after) {
+		version := before[name]
+		if name == origin.path || !version.exists() || version.mode&0xf000 != 0x8000 {
+			continue
+		}
+
+		content, err := b.readLines(version.hash)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, &blameOrigin{hash: parentHash, commit: parent, path: name, blob: version.hash, content: content})
+	}
+
+	return sources, nil
+}
+
+// passMatchingLines splits suspects into the lines that a diff against the
+// parent's content matches and the ones it does not.
+func passMatchingLines(origin *blameOrigin, parent []string, suspects []suspectLine) ([]suspectLine, []suspectLine) {
+	parentLines := make(map[int]int)
+	for _, match := range diffLines(parent, origin.content) {
+		parentLines[match.new] = match.old
+	}
+
+	passed := make([]suspectLine, 0)
+	remaining := make([]suspectLine, 0)
+	for _, suspect := range suspects {
+		if line, ok := parentLines[suspect.line]; ok {
+			passed = append(passed, suspectLine{final: suspect.final, line: line})
+		} else {
+			remaining = append(remaining, suspect)
+		}
+	}
+
+	return passed, remaining
+}
+
+// passMovedLines finds blocks of consecutive suspects anywhere in the
+// parent's content and passes those that are long enough to count as moved
+// rather than rewritten.
+func passMovedLines(origin *blameOrigin, parent []string, suspects []suspectLine, minimumScore int) ([]suspectLine, []suspectLine) {
+	if len(suspects) == 0 || len(parent) == 0 {
+		return nil, suspects
+	}
+
+	positions := make(map[string][]int)
+	for i, line := range parent {
+		positions[line] = append(positions[line], i)
+	}
+
+	passed := make([]suspectLine, 0)
+	remaining := make([]suspectLine, 0)
+	for i := 0; i < len(suspects); {
+		start := suspects[i].line
+
+		bestLength, bestStart := 0, 0
+		for _, candidate := range positions[origin.content[start]] {
+			length := 0
+			for i+length < len(suspects) && suspects[i+length].line == start+length &&
+				candidate+length < len(parent) && parent[candidate+length] == origin.content[start+length] {
+				length++
+			}
+			if length > bestLength {
+				bestLength, bestStart = length, candidate
+			}
+		}
+
+		score := 0
+		for j := 0; j < bestLength; j++ {
+			score += alphanumericCount(origin.content[start+j])
+		}
+
+		if bestLength == 0 || score < minimumScore {
+			remaining = append(remaining, suspects[i])
+			i++
+			continue
+		}
+
+		for j := 0; j < bestLength; j++ {
+			passed = append(passed, suspectLine{final: suspects[i+j].final, line: bestStart + j})
+		}
+		i += bestLength
+	}
+
+	return passed, remaining
+}
+
+// lineSimilarity returns the share of lines two versions have in common in
+// percent.
+func lineSimilarity(old []string, new []string) int {
+	if len(old)+len(new) == 0 {
+		return 100
+	}
+
+	return 200 * len(diffLines(old, new)) / (len(old) + len(new))
+}
+
+func alphanumericCount(line string) int {
+	count := 0
+	for _, c := range line {
+		if unicode.IsLetter(c) || unicode.IsDigit(c) {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,350 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/untanky/git-charged/plumbing"
+	"github.com/untanky/git-charged/transport"
+	"io"
+	"os"
+	"strings"
+)
+
+type CreateBundleParams struct {
+	File string
+	// Revisions selects what to bundle like `git rev-list` arguments:
+	// "main", "^v1.0", "v1.0..main" or "--all".
+	Revisions []string
+	// Version is the bundle format version, 2 or 3.
+	Version  int
+	Progress io.Writer
+}
+
+// CreateBundle writes the selected references and the objects they need to
+// a bundle file. Commits excluded by the revisions become prerequisites.
+func CreateBundle(params CreateBundleParams) error {
+	refs, include, exclude, err := parseBundleRevisions(params.Revisions)
+	if err != nil {
+		return err
+	}
+	if len(refs) == 0 {
+		return errors.New("refusing to create empty bundle")
+	}
+
+	prerequisites, err := bundlePrerequisites(include, exclude)
+	if err != nil {
+		return err
+	}
+
+	bundle := &plumbing.Bundle{
+		Version:       params.Version,
+		Capabilities:  make(map[string]string),
+		Prerequisites: prerequisites,
+		Refs:          refs,
+	}
+	if bundle.Version == 3 {
+		bundle.Capabilities["object-format"] = "sha1"
+	}
+
+	file, err := os.Create(params.File)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	count, err := plumbing.WriteBundle(file, bundle)
+	if err != nil {
+		os.Remove(params.File)
+		return fmt.Errorf("cannot write bundle: %w", err)
+	}
+
+	if params.Progress != nil {
+		fmt.Fprintf(params.Progress, "Total %d (delta 0), reused 0 (delta 0)\n", count)
+	}
+
+	return file.Close()
+}
+
+// parseBundleRevisions splits revisions into the references recorded in
+// the bundle and the commits to include and exclude.
+func parseBundleRevisions(revisions []string) ([]plumbing.Ref, [][]byte, [][]byte, error) {
+	refs := make([]plumbing.Ref, 0)
+	include := make([][]byte, 0)
+	exclude := make([][]byte, 0)
+
+	addInclude := func(revision string) error {
+		hash, err := ResolveRevision(revision)
+		if err != nil {
+			return err
+		}
+		include = append(include, hash)
+
+		if name, ok := ExpandRefName(revision); ok {
+			refs = append(refs, plumbing.Ref{Name: name, Hash: hash})
+		}
+		return nil
+	}
+
+	addExclude := func(revision string) error {
+		hash, err := ResolveRevision(revision)
+		if err != nil {
+			return err
+		}
+		exclude = append(exclude, hash)
+		return nil
+	}
+
+	for _, revision := range revisions {
+		var err error
+		switch {
+		case revision == "--all":
+			var all []plumbing.Ref
+			all, err = plumbing.ListRefs("refs/")
+			if head, headErr := plumbing.ResolveRef(plumbing.HEAD); headErr == nil {
+				all = append([]plumbing.Ref{{Name: plumbing.HEAD, Hash: head}}, all...)
+			}
+			if err == nil {
+				for _, ref := range all {
+					if ref.Name == plumbing.HEAD || strings.HasPrefix(ref.Name, headsPrefix) || strings.HasPrefix(ref.Name, tagsPrefix) {
+						refs = append(refs, ref)
+						include = append(include, ref.Hash)
+					}
+				}
+			}
+		case strings.HasPrefix(revision, "^"):
+			err = addExclude(revision[1:])
+		case strings.Contains(revision, ".."):
+			from, to, _ := strings.Cut(revision, "..")
+			if from == "" {
+				from = plumbing.HEAD
+			}
+			if to == "" {
+				to = plumbing.HEAD
+			}
+			err = addExclude(from)
+			if err == nil {
+				err = addInclude(to)
+			}
+		default:
+			err = addInclude(revision)
+		}
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	return refs, include, exclude, nil
+}
+
+// bundlePrerequisites walks the included history and returns the excluded
+// commits its boundary depends on.
+func bundlePrerequisites(include [][]byte, exclude [][]byte) ([][]byte, error) {
+	walker := plumbing.NewCommitWalker()
+	for _, hash := range exclude {
+		commit, kind, err := plumbing.Peel(hash)
+		if err != nil {
+			return nil, err
+		}
+		if kind == plumbing.KindCommit {
+			err = walker.Hide(commit)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, hash := range include {
+		commit, kind, err := plumbing.Peel(hash)
+		if err != nil {
+			return nil, err
+		}
+		if kind == plumbing.KindCommit {
+			err = walker.Push(commit)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	included := make(map[string]bool)
+	parents := make([][]byte, 0)
+	for {
+		hash, commit, err := walker.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		included[string(hash)] = true
+		parents = append(parents, commit.Parents...)
+	}
+
+	prerequisites := make([][]byte, 0)
+	seen := make(map[string]bool)
+	for _, parent := range parents {
+		if included[string(parent)] || seen[string(parent)] || !plumbing.HasObject(parent) {
+			continue
+		}
+		seen[string(parent)] = true
+		prerequisites = append(prerequisites, parent)
+	}
+
+	return prerequisites, nil
+}
+
+// ReadBundle returns the header of a bundle file.
+func ReadBundle(path string) (*plumbing.Bundle, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return plumbing.ReadBundleHeader(bufio.NewReader(file))
+}
+
+// VerifyBundle checks that a bundle is complete and that the current
+// repository has all of its prerequisites.
+func VerifyBundle(path string) (*plumbing.Bundle, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	bundle, err := plumbing.ReadBundleHeader(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkBundlePrerequisites(bundle)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = plumbing.ReadPackStream(reader, 0)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read bundle pack: %w", err)
+	}
+
+	return bundle, nil
+}
+
+func checkBundlePrerequisites(bundle *plumbing.Bundle) error {
+	missing := make([]string, 0)
+	for _, prerequisite := range bundle.Prerequisites {
+		if !plumbing.HasObject(prerequisite) {
+			missing = append(missing, fmt.Sprintf("%x", prerequisite))
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("repository lacks these prerequisite commits: %s", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// Unbundle stores the objects of a bundle in the object store and returns
+// the references it contains without updating any local reference.
+// References with invalid names are left out.
+func Unbundle(path string) (*plumbing.Bundle, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	bundle, err := plumbing.ReadBundleHeader(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkBundlePrerequisites(bundle)
+	if err != nil {
+		return nil, err
+	}
+
+	pack, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = plumbing.IndexPack(bytes.NewReader(pack))
+	if err != nil {
+		return nil, fmt.Errorf("cannot store bundle pack: %w", err)
+	}
+
+	refs := make([]plumbing.Ref, 0, len(bundle.Refs))
+	for _, ref := range bundle.Refs {
+		if plumbing.CheckRefFormat(ref.Name) == nil {
+			refs = append(refs, ref)
+		}
+	}
+	bundle.Refs = refs
+
+	return bundle, nil
+}
+
+// fetchFromBundle fetches from a bundle file as if it were a remote.
+func fetchFromBundle(path string, params FetchParams) (*FetchResult, error) {
+	if params.Depth > 0 || !params.ShallowSince.IsZero() || params.Unshallow || params.Filter != "" {
+		return nil, errors.New("shallow and partial fetches are not supported from bundles")
+	}
+
+	bundle, err := Unbundle(path)
+	if err != nil {
+		return nil, err
+	}
+
+	refs := make([]transport.RemoteRef, 0, len(bundle.Refs))
+	var head *plumbing.Ref
+	for i, ref := range bundle.Refs {
+		if ref.Name == plumbing.HEAD {
+			head = &bundle.Refs[i]
+			continue
+		}
+		refs = append(refs, transport.RemoteRef{Name: ref.Name, Hash: ref.Hash})
+	}
+
+	// Bundles do not record symbolic references, so HEAD is guessed from
+	// the branch it points at.
+	if head != nil {
+		ref := transport.RemoteRef{Name: plumbing.HEAD, Hash: head.Hash}
+		for _, candidate := range refs {
+			if strings.HasPrefix(candidate.Name, headsPrefix) && bytes.Equal(candidate.Hash, head.Hash) {
+				ref.SymrefTarget = candidate.Name
+				break
+			}
+		}
+		refs = append([]transport.RemoteRef{ref}, refs...)
+	}
+
+	if params.SingleBranch {
+		refs = singleBranchRefs(refs, params.Branch)
+	}
+
+	refSpec, err := remoteRefSpec(params)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &FetchResult{Refs: refs}
+	for _, ref := range refs {
+		err = updateRemoteRef(params, refSpec, ref)
+		if err != nil {
+			return nil, err
+		}
+
+		if ref.Name == plumbing.HEAD && ref.SymrefTarget != "" {
+			result.DefaultBranch = strings.TrimPrefix(ref.SymrefTarget, headsPrefix)
+		}
+	}
+
+	return result, nil
+}
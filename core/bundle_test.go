@@ -0,0 +1,77 @@
+package core
+
+import (
+	"bytes"
+	"github.com/untanky/git-charged/plumbing"
+	"os/exec"
+	"path"
+	"testing"
+)
+
+func TestBundleRoundTrip(t *testing.T) {
+	bundles := t.TempDir()
+	full, incremental := path.Join(bundles, "full.bundle"), path.Join(bundles, "incremental.bundle")
+
+	commits := writeTestHistory(t, "First\n", "Second\n", "Third\n")
+	err := plumbing.WriteRef(tagsPrefix+"v1", commits[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, params := range []CreateBundleParams{
+		{File: full, Revisions: []string{"main"}, Version: 2},
+		{File: incremental, Revisions: []string{"v1..main"}, Version: 3},
+	} {
+		err = CreateBundle(params)
+		if err != nil {
+			t.Fatalf("CreateBundle(%+v) = %s", params, err)
+		}
+	}
+
+	bundle, err := ReadBundle(incremental)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bundle.Prerequisites) != 1 || !bytes.Equal(bundle.Prerequisites[0], commits[0]) {
+		t.Errorf("prerequisites = %x, want %x", bundle.Prerequisites, commits[0])
+	}
+	if len(bundle.Refs) != 1 || bundle.Refs[0].Name != "refs/heads/main" || !bytes.Equal(bundle.Refs[0].Hash, commits[2]) {
+		t.Errorf("refs = %v, want refs/heads/main at %x", bundle.Refs, commits[2])
+	}
+
+	if _, err := exec.LookPath("git"); err == nil {
+		for _, file := range []string{full, incremental} {
+			output, err := exec.Command("git", "bundle", "list-heads", file).CombinedOutput()
+			if err != nil {
+				t.Errorf("git bundle list-heads %s = %s: %s", path.Base(file), err, output)
+			}
+		}
+	}
+
+	// A new repository lacks the prerequisite of the incremental bundle
+	// until the full bundle is unbundled.
+	initTestRepository(t)
+	_, err = VerifyBundle(incremental)
+	if err == nil {
+		t.Errorf("VerifyBundle() succeeded without the prerequisite")
+	}
+	_, err = Unbundle(incremental)
+	if err == nil {
+		t.Errorf("Unbundle() succeeded without the prerequisite")
+	}
+
+	for _, file := range []string{full, incremental} {
+		_, err = VerifyBundle(file)
+		if err == nil {
+			_, err = Unbundle(file)
+		}
+		if err != nil {
+			t.Fatalf("cannot unbundle %s: %s", path.Base(file), err)
+		}
+	}
+	for _, commit := range commits {
+		if !plumbing.HasObject(commit) {
+			t.Errorf("commit %x is missing after unbundling", commit)
+		}
+	}
+}
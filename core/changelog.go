@@ -0,0 +1,360 @@
+package core
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/untanky/git-charged/config"
+	"github.com/untanky/git-charged/plumbing"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	ChangelogFile       = "CHANGELOG.md"
+	gitHubHost          = "github.com"
+	unreleasedVersion   = "Unreleased"
+	changelogDateFormat = "2006-01-02"
+)
+
+const changelogHeader = `# Changelog
+
+All notable changes to this project will be documented in this file.
+
+The format is based on [Keep a Changelog](https://keepachangelog.com/en/1.1.0/),
+and this project adheres to [Semantic Versioning](https://semver.org/spec/v2.0.0.html).
+`
+
+// changelogSections are the Keep a Changelog sections commits are listed
+// in, by their Conventional Commits type. Other types are not notable.
+var changelogSections = []struct {
+	title string
+	types []string
+}{
+	{title: "Added", types: []string{"feat"}},
+	{title: "Changed", types: []string{"perf", "refactor", "revert"}},
+	{title: "Fixed", types: []string{"fix"}},
+}
+
+var (
+	// pullRequestPattern matches the pull request number GitHub appends to
+	// the subjects of squashed and merged pull requests.
+	pullRequestPattern    = regexp.MustCompile(`\s*\(#(\d+)\)$`)
+	issueReferencePattern = regexp.MustCompile(`(^|[\s(])#(\d+)\b`)
+	issueFooterTokens     = []string{"Closes", "Fixes", "Resolves", "Refs"}
+	changelogLinkPattern  = regexp.MustCompile(`^\[[^\]]+\]: `)
+)
+
+type ChangelogParams struct {
+	// Range selects the commits like "v1.0.0..v1.1.0". All commits of HEAD
+	// are used if it is empty.
+	Range string
+	// Version names the release. It defaults to the end of the range if
+	// that is a tag, and to "Unreleased" otherwise.
+	Version string
+}
+
+// ChangelogRelease is the section of a changelog for one release.
+type ChangelogRelease struct {
+	Version string
+	// Markdown is the section, starting with its "## " heading.
+	Markdown string
+	// Link compares the release to the previous one on GitHub, if the
+	// repository is hosted there.
+	Link string
+}
+
+// Changelog lists the commits of a range that follow Conventional Commits
+// in the sections of Keep a Changelog: features are added, fixes fixed and
+// refactorings, performance improvements and reverts changed. If
+// remote.origin.url is a GitHub repository, commits, pull requests and
+// issues are linked to it.
+func Changelog(params ChangelogParams) (ChangelogRelease, error) {
+	from, to, isRange := strings.Cut(params.Range, "..")
+	if !isRange {
+		from, to = "", params.Range
+	}
+	if to == "" {
+		to = plumbing.HEAD
+	}
+
+	revisions := []string{to}
+	if from != "" {
+		revisions = append(revisions, "^"+from)
+	}
+	entries, err := Log(LogParams{Revisions: revisions, NoMailmap: true})
+	if err != nil {
+		return ChangelogRelease{}, err
+	}
+
+	repository, _ := gitHubRepositoryURL(defaultRemote)
+	release := ChangelogRelease{Version: params.Version}
+	_, tagErr := plumbing.ResolveRef(tagsPrefix + to)
+	if release.Version == "" {
+		release.Version = unreleasedVersion
+		if tagErr == nil {
+			release.Version = strings.TrimPrefix(to, "v")
+		}
+	}
+
+	if repository != "" && from != "" {
+		end := to
+		if release.Version == unreleasedVersion {
+			end = plumbing.HEAD
+		}
+		release.Link = fmt.Sprintf("%s/compare/%s...%s", repository, from, end)
+	}
+
+	var builder strings.Builder
+	builder.WriteString("## ")
+	if release.Link != "" {
+		builder.WriteString("[" + release.Version + "]")
+	} else {
+		builder.WriteString(release.Version)
+	}
+	if release.Version != unreleasedVersion && len(entries) > 0 {
+		builder.WriteString(" - " + entries[0].Commit.Committer.Timestamp.Format(changelogDateFormat))
+	}
+	builder.WriteString("\n")
+
+	for _, section := range changelogSections {
+		lines := make([]string, 0)
+		// Like the rest of the changelog, the oldest change comes last.
+		for _, entry := range entries {
+			commit, err := ParseConventionalCommit(entry.Commit.Message)
+			if err != nil || !containsString(section.types, commit.Type) {
+				continue
+			}
+			lines = append(lines, changelogLine(commit, entry.Hash, repository))
+		}
+
+		if len(lines) > 0 {
+			builder.WriteString("\n### " + section.title + "\n\n")
+			builder.WriteString(strings.Join(lines, ""))
+		}
+	}
+
+	release.Markdown = builder.String()
+	return release, nil
+}
+
+func changelogLine(commit ConventionalCommit, hash []byte, repository string) string {
+	description := commit.Description
+	pullRequest := ""
+	if match := pullRequestPattern.FindStringSubmatch(description); match != nil {
+		description = strings.TrimSuffix(description, match[0])
+		pullRequest = match[1]
+	}
+
+	var builder strings.Builder
+	builder.WriteString("- ")
+	if commit.Breaking {
+		builder.WriteString("**BREAKING:** ")
+	}
+	if commit.Scope != "" {
+		builder.WriteString("**" + commit.Scope + ":** ")
+	}
+	builder.WriteString(linkIssues(description, repository))
+
+	if pullRequest != "" {
+		builder.WriteString(" (" + linkReference(repository, "pull", pullRequest) + ")")
+	}
+
+	issues := make([]string, 0)
+	for _, footer := range commit.Footers {
+		for _, token := range issueFooterTokens {
+			if !strings.EqualFold(footer.Token, token) {
+				continue
+			}
+			// "Closes #12" loses its # as the footer separator.
+			value := footer.Value
+			if _, err := strconv.Atoi(value); err == nil {
+				value = "#" + value
+			}
+			issues = append(issues, linkIssues(value, repository))
+		}
+	}
+	if len(issues) > 0 {
+		builder.WriteString(", closes " + strings.Join(issues, ", "))
+	}
+
+	short := shortHash(hash)
+	if repository != "" {
+		short = fmt.Sprintf("[%s](%s/commit/%s)", short, repository, hex.EncodeToString(hash))
+	}
+	builder.WriteString(" (" + short + ")\n")
+
+	if commit.BreakingChange != "" {
+		builder.WriteString("  - " + strings.Join(strings.Fields(commit.BreakingChange), " ") + "\n")
+	}
+
+	return builder.String()
+}
+
+// linkIssues links references like #12 to the issues of the repository.
+// GitHub redirects issue links to pull requests with the same number.
+func linkIssues(text string, repository string) string {
+	if repository == "" {
+		return text
+	}
+
+	return issueReferencePattern.ReplaceAllStringFunc(text, func(match string) string {
+		parts := issueReferencePattern.FindStringSubmatch(match)
+		return parts[1] + linkReference(repository, "issues", parts[2])
+	})
+}
+
+func linkReference(repository string, kind string, number string) string {
+	if repository == "" {
+		return "#" + number
+	}
+	return fmt.Sprintf("[#%s](%s/%s/%s)", number, repository, kind, number)
+}
+
+// gitHubRepositoryURL returns the web URL of the GitHub repository a
+// remote points to.
+func gitHubRepositoryURL(remote string) (string, bool) {
+	host, owner, name, ok := parseGitHubRemote(remote)
+	if !ok {
+		return "", false
+	}
+
+	return fmt.Sprintf("https://%s/%s/%s", host, owner, name), true
+}
+
+// GitHubRepository returns the owner and name of the github.com repository
+// a remote points to.
+func GitHubRepository(remote string) (string, string, error) {
+	host, owner, name, ok := parseGitHubRemote(remote)
+	if !ok || host != gitHubHost {
+		return "", "", fmt.Errorf("remote %s is not a repository on %s", remote, gitHubHost)
+	}
+
+	return owner, name, nil
+}
+
+// parseGitHubRemote splits the URL of a remote on a GitHub host, like
+// https://github.com/owner/repo.git, git@github.com:owner/repo.git or
+// ssh://git@github.com/owner/repo.
+func parseGitHubRemote(remote string) (string, string, string, bool) {
+	remoteURL, ok := config.Get(fmt.Sprintf("remote.%s.url", remote))
+	if !ok {
+		return "", "", "", false
+	}
+
+	var host, repositoryPath string
+	if parsed, err := url.Parse(remoteURL); err == nil && parsed.Scheme != "" && parsed.Host != "" {
+		host, repositoryPath = parsed.Hostname(), parsed.Path
+	} else if userHost, scpPath, ok := strings.Cut(remoteURL, ":"); ok && !strings.Contains(userHost, "/") {
+		// scp-like URLs such as git@github.com:owner/repo.git
+		_, host, _ = strings.Cut(userHost, "@")
+		if host == "" {
+			host = userHost
+		}
+		repositoryPath = scpPath
+	}
+
+	repositoryPath = strings.TrimSuffix(strings.Trim(repositoryPath, "/"), ".git")
+	owner, name, ok := strings.Cut(repositoryPath, "/")
+	if !strings.Contains(host, "github") || !ok || strings.Contains(name, "/") {
+		return "", "", "", false
+	}
+
+	return host, owner, name, true
+}
+
+// WriteChangelog adds a release to a changelog file, which is created with
+// the Keep a Changelog header if it does not exist. A section for the same
+// version is replaced, otherwise the release is put above the newest one,
+// below unreleased changes. Its comparison link is added to the link
+// references at the end.
+func WriteChangelog(filename string, release ChangelogRelease) error {
+	content, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		content = []byte(changelogHeader)
+	} else if err != nil {
+		return fmt.Errorf("cannot read %s: %w", filename, err)
+	}
+
+	lines := strings.SplitAfter(strings.TrimRight(string(content), "\n")+"\n", "\n")
+	lines = lines[:len(lines)-1]
+
+	links := len(lines)
+	for i := len(lines); i > 0 && (changelogLinkPattern.MatchString(lines[i-1]) || isBlankLine(lines[i-1])); i-- {
+		if !isBlankLine(lines[i-1]) {
+			links = i - 1
+		}
+	}
+
+	start, end := -1, -1
+	for i, line := range lines[:links] {
+		heading, ok := strings.CutPrefix(line, "## ")
+		if !ok {
+			continue
+		}
+		if start >= 0 {
+			end = i
+			break
+		}
+
+		version := strings.Trim(strings.Fields(heading + " ")[0], "[]")
+		if version == release.Version {
+			start = i
+		} else if version != unreleasedVersion || release.Version == unreleasedVersion {
+			start, end = i, i
+			break
+		}
+	}
+	if start < 0 {
+		start = links
+	}
+	if end < 0 {
+		end = links
+	}
+
+	before := strings.TrimRight(strings.Join(lines[:start], ""), "\n") + "\n\n"
+	after := lines[end:]
+	if release.Link != "" {
+		link := fmt.Sprintf("[%s]: %s\n", release.Version, release.Link)
+		position := len(after)
+		for i, line := range after {
+			if strings.HasPrefix(line, "["+release.Version+"]: ") {
+				after[i] = link
+				position = -1
+				break
+			}
+			if changelogLinkPattern.MatchString(line) && !strings.HasPrefix(line, "["+unreleasedVersion+"]: ") && position == len(after) {
+				position = i
+			}
+		}
+		if position == len(after) && len(after) == 0 {
+			// The release is already followed by a blank line.
+			after = append(after, link)
+		} else if position == len(after) && !changelogLinkPattern.MatchString(after[len(after)-1]) {
+			after = append(after, "\n", link)
+		} else if position >= 0 {
+			after = append(after[:position], append([]string{link}, after[position:]...)...)
+		}
+	}
+
+	result := before + release.Markdown + "\n" + strings.Join(after, "")
+	result = strings.TrimRight(result, "\n") + "\n"
+	err = os.WriteFile(filename, []byte(result), 0644)
+	if err != nil {
+		return fmt.Errorf("cannot write %s: %w", filename, err)
+	}
+
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,200 @@
+package core
+
+import (
+	"encoding/hex"
+	"github.com/untanky/git-charged/config"
+	"github.com/untanky/git-charged/plumbing"
+	"os"
+	"testing"
+)
+
+// writeTestHistory commits the messages in order on the main branch of a
+// new repository and returns the commits.
+func writeTestHistory(t *testing.T, messages ...string) [][]byte {
+	t.Helper()
+
+	initTestRepository(t)
+	tree := writeTestTree(t)
+	commits := make([][]byte, 0, len(messages))
+	var parents [][]byte
+	for _, message := range messages {
+		commit := writeTestCommit(t, tree, message, parents...)
+		commits = append(commits, commit)
+		parents = [][]byte{commit}
+	}
+
+	err := plumbing.WriteRef("refs/heads/main", commits[len(commits)-1])
+	if err == nil {
+		err = plumbing.WriteSymbolicRef(plumbing.HEAD, "refs/heads/main")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return commits
+}
+
+// setTestConfig sets values in the config of the test repository.
+func setTestConfig(t *testing.T, values map[string]string) {
+	t.Helper()
+
+	for key, value := range values {
+		err := config.SetValue(config.RepositoryConfig(), key, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	config.ReloadConfig()
+}
+
+func TestChangelog(t *testing.T) {
+	commits := writeTestHistory(t,
+		"feat: first release\n",
+		"fix(parser): handle empty input (#7)\n\nCloses #3\n",
+		"chore: update tools\n",
+		"feat!: new api\n\nBREAKING CHANGE: the old api\nis gone\n",
+		"Not conventional\n",
+	)
+	err := plumbing.WriteRef("refs/tags/v1.0.0", commits[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	short := func(i int) string { return shortHash(commits[i]) }
+	full := func(i int) string { return hex.EncodeToString(commits[i]) }
+
+	tests := []struct {
+		name     string
+		params   ChangelogParams
+		remote   string
+		markdown string
+		link     string
+	}{
+		{
+			name:   "unreleased changes",
+			params: ChangelogParams{Range: "v1.0.0.."},
+			markdown: "## Unreleased\n\n### Added\n\n- **BREAKING:** new api (" + short(3) + ")\n  - the old api is gone\n" +
+				"\n### Fixed\n\n- **parser:** handle empty input (#7), closes #3 (" + short(1) + ")\n",
+		},
+		{
+			name:     "tagged release",
+			params:   ChangelogParams{Range: "v1.0.0"},
+			markdown: "## 1.0.0 - 2023-11-14\n\n### Added\n\n- first release (" + short(0) + ")\n",
+		},
+		{
+			name:   "linked to GitHub",
+			params: ChangelogParams{Range: "v1.0.0..HEAD", Version: "2.0.0"},
+			remote: "git@github.com:owner/repo.git",
+			markdown: "## [2.0.0] - 2023-11-14\n\n### Added\n\n- **BREAKING:** new api ([" + short(3) + "](https://github.com/owner/repo/commit/" + full(3) + "))\n  - the old api is gone\n" +
+				"\n### Fixed\n\n- **parser:** handle empty input ([#7](https://github.com/owner/repo/pull/7)), closes [#3](https://github.com/owner/repo/issues/3) ([" + short(1) + "](https://github.com/owner/repo/commit/" + full(1) + "))\n",
+			link: "https://github.com/owner/repo/compare/v1.0.0...HEAD",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.remote != "" {
+				setTestConfig(t, map[string]string{"remote.origin.url": test.remote})
+				t.Cleanup(func() {
+					config.UnsetValue(config.RepositoryConfig(), "remote.origin.url")
+					config.ReloadConfig()
+				})
+			}
+
+			release, err := Changelog(test.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if release.Markdown != test.markdown {
+				t.Errorf("Changelog(%+v).Markdown =\n%s\nwant\n%s", test.params, release.Markdown, test.markdown)
+			}
+			if release.Link != test.link {
+				t.Errorf("Changelog(%+v).Link = %q, want %q", test.params, release.Link, test.link)
+			}
+		})
+	}
+}
+
+func TestParseGitHubRemote(t *testing.T) {
+	initTestRepository(t)
+
+	tests := []struct {
+		url   string
+		owner string
+		name  string
+		ok    bool
+	}{
+		{url: "https://github.com/owner/repo.git", owner: "owner", name: "repo", ok: true},
+		{url: "git@github.com:owner/repo.git", owner: "owner", name: "repo", ok: true},
+		{url: "ssh://git@github.com/owner/repo", owner: "owner", name: "repo", ok: true},
+		{url: "https://gitlab.com/owner/repo.git"},
+		{url: "https://github.com/owner"},
+		{url: "https://github.com/group/owner/repo"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.url, func(t *testing.T) {
+			setTestConfig(t, map[string]string{"remote.origin.url": test.url})
+
+			_, owner, name, ok := parseGitHubRemote(defaultRemote)
+			if ok != test.ok || owner != test.owner || name != test.name {
+				t.Errorf("parseGitHubRemote() = %q, %q, %t, want %q, %q, %t", owner, name, ok, test.owner, test.name, test.ok)
+			}
+		})
+	}
+}
+
+func TestWriteChangelog(t *testing.T) {
+	release := ChangelogRelease{
+		Version:  "1.1.0",
+		Markdown: "## [1.1.0] - 2024-01-01\n\n### Fixed\n\n- a fix\n",
+		Link:     "https://github.com/o/r/compare/v1.0.0...v1.1.0",
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name: "new file",
+			want: changelogHeader + "\n" + release.Markdown + "\n[1.1.0]: " + release.Link + "\n",
+		},
+		{
+			name: "below unreleased changes",
+			content: "# Changelog\n\n## [Unreleased]\n\n- pending\n\n## [1.0.0] - 2023-01-01\n\n- first\n\n" +
+				"[Unreleased]: https://github.com/o/r/compare/v1.0.0...HEAD\n[1.0.0]: https://github.com/o/r/releases/tag/v1.0.0\n",
+			want: "# Changelog\n\n## [Unreleased]\n\n- pending\n\n" + release.Markdown + "\n## [1.0.0] - 2023-01-01\n\n- first\n\n" +
+				"[Unreleased]: https://github.com/o/r/compare/v1.0.0...HEAD\n[1.1.0]: " + release.Link + "\n[1.0.0]: https://github.com/o/r/releases/tag/v1.0.0\n",
+		},
+		{
+			name:    "replaces the same version",
+			content: "# Changelog\n\n## [1.1.0] - 2023-12-31\n\n- old\n\n## 1.0.0\n\n- first\n\n[1.1.0]: old link\n",
+			want:    "# Changelog\n\n" + release.Markdown + "\n## 1.0.0\n\n- first\n\n[1.1.0]: " + release.Link + "\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			changeToTestDirectory(t)
+			if test.content != "" {
+				err := os.WriteFile(ChangelogFile, []byte(test.content), 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := WriteChangelog(ChangelogFile, release)
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, err := os.ReadFile(ChangelogFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != test.want {
+				t.Errorf("WriteChangelog() wrote\n%s\nwant\n%s", content, test.want)
+			}
+		})
+	}
+}
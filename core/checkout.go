@@ -0,0 +1,319 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"github.com/untanky/git-charged/plumbing"
+	"os"
+	"path"
+)
+
+var (
+	ErrLocalChanges   = errors.New("the working tree has local changes, commit or stash them first")
+	ErrUntrackedFiles = errors.New("untracked working tree file would be overwritten")
+)
+
+// checkoutCommit checks out a commit and points HEAD at branch, or directly
+// at the commit if branch is empty. Local changes are never overwritten.
+func checkoutCommit(hash []byte, branch string) error {
+	changed, err := hasLocalChanges()
+	if err != nil {
+		return err
+	}
+	if changed {
+		return ErrLocalChanges
+	}
+
+	commit, err := plumbing.ReadCommit(hash)
+	if err != nil {
+		return fmt.Errorf("cannot read commit %x: %w", hash, err)
+	}
+
+	previous, _ := plumbing.ResolveRef(plumbing.HEAD)
+
+	err = checkoutTree(commit.Tree, false)
+	if err != nil {
+		return err
+	}
+
+	if branch != "" {
+		err = plumbing.WriteSymbolicRef(plumbing.HEAD, headsPrefix+branch)
+	} else {
+		err = plumbing.WriteRef(plumbing.HEAD, hash)
+	}
+	if err != nil {
+		return err
+	}
+
+	runPostCheckoutHook(previous, hash)
+	return nil
+}
+
+// checkoutTree materializes a tree in the working directory and replaces
+// the index with its contents. Files tracked by the previous index that are
+// not part of the tree are removed first, so that directories and files may
+// replace each other. Untracked files in the way are only overwritten with
+// force.
+//
+// In a sparse checkout, files outside of the cone are only recorded in the
+// index with the skip-worktree flag.
+func checkoutTree(treeHash []byte, force bool) error {
+	cone, err := readSparseCheckout()
+	if err != nil {
+		return err
+	}
+
+	err = prefetchTree(treeHash, cone)
+	if err != nil {
+		return fmt.Errorf("cannot fetch missing objects: %w", err)
+	}
+
+	previous, err := plumbing.ReadIndex()
+	if err != nil {
+		return fmt.Errorf("cannot read index: %w", err)
+	}
+
+	entries := make([]checkoutItem, 0)
+	err = collectCheckoutItems(treeHash, "", cone, &entries)
+	if err != nil {
+		return err
+	}
+
+	checkedOut := make(map[string]bool, len(entries))
+	names := make([]string, 0, len(entries))
+	for _, item := range entries {
+		checkedOut[item.name] = !item.skipWorktree
+		if !item.skipWorktree {
+			names = append(names, item.name)
+		}
+	}
+
+	if !force {
+		err = checkUntrackedPaths(names, previous)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, entry := range previous.Entries {
+		if checkedOut[entry.Name] || entry.SkipWorktree || entry.Mode&0xf000 == gitLinkMode {
+			continue
+		}
+		err = removeWorkingFile(entry.Name)
+		if err != nil {
+			return err
+		}
+	}
+
+	index := &plumbing.Index{Entries: make([]plumbing.IndexEntry, 0, len(entries))}
+	filter := newContentFilter(treeAttributes(treeHash))
+	for _, item := range entries {
+		if item.skipWorktree {
+			skipped := plumbing.NewIndexEntry(item.name, item.entry.Hash, uint32(item.entry.Mode), nil)
+			skipped.SkipWorktree = true
+			index.Entries = append(index.Entries, skipped)
+			continue
+		}
+
+		err = createParentDirectories(item.name)
+		if err != nil {
+			return err
+		}
+
+		err = checkoutEntry(item.name, item.entry, filter)
+		if err != nil {
+			return fmt.Errorf("cannot check out %s: %w", item.name, err)
+		}
+
+		info, err := os.Lstat(item.name)
+		if err != nil {
+			return err
+		}
+
+		index.Entries = append(index.Entries, plumbing.NewIndexEntry(item.name, item.entry.Hash, uint32(item.entry.Mode), info))
+	}
+
+	err = plumbing.WriteIndex(index)
+	if err != nil {
+		return fmt.Errorf("cannot write index: %w", err)
+	}
+
+	return nil
+}
+
+// checkoutItem is a file of a tree that is about to be checked out.
+type checkoutItem struct {
+	name         string
+	entry        plumbing.TreeEntry
+	skipWorktree bool
+}
+
+func collectCheckoutItems(treeHash []byte, prefix string, cone *sparseCone, items *[]checkoutItem) error {
+	tree, err := plumbing.ReadTree(treeHash)
+	if err != nil {
+		return fmt.Errorf("cannot read tree %x: %w", treeHash, err)
+	}
+
+	for _, entry := range tree.Entries() {
+		name := path.Join(prefix, entry.Name)
+
+		if entry.IsDirectory() {
+			err = collectCheckoutItems(entry.Hash, name, cone, items)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		*items = append(*items, checkoutItem{
+			name:         name,
+			entry:        entry,
+			skipWorktree: cone != nil && !cone.includes(name),
+		})
+	}
+
+	return nil
+}
+
+// checkUntrackedPaths refuses to write files over untracked files, or over
+// directories that contain some, unless they are ignored. Paths tracked by
+// the previous index are expendable, since they are removed or replaced.
+func checkUntrackedPaths(names []string, previous *plumbing.Index) error {
+	tracked := trackedPaths(previous)
+
+	ignore := newIgnoreMatcher()
+	isIgnored := func(name string, isDirectory bool) bool {
+		for directory := path.Dir(name); directory != "."; directory = path.Dir(directory) {
+			if ignore.isIgnored(directory, true) {
+				return true
+			}
+		}
+		return ignore.isIgnored(name, isDirectory)
+	}
+
+	for _, name := range names {
+		if tracked[name] {
+			continue
+		}
+
+		for directory := path.Dir(name); directory != "."; directory = path.Dir(directory) {
+			info, err := os.Lstat(directory)
+			if err == nil && !info.IsDir() && !tracked[directory] && !isIgnored(directory, false) {
+				return fmt.Errorf("%w: %s", ErrUntrackedFiles, directory)
+			}
+		}
+
+		info, err := os.Lstat(name)
+		if err != nil {
+			continue
+		}
+		if !info.IsDir() {
+			if !isIgnored(name, false) {
+				return fmt.Errorf("%w: %s", ErrUntrackedFiles, name)
+			}
+			continue
+		}
+
+		if isIgnored(name, true) {
+			continue
+		}
+		if isWorkingTree(name) {
+			return fmt.Errorf("%w: %s/", ErrUntrackedFiles, name)
+		}
+		files, err := untrackedFiles(name, tracked, ignore)
+		if err != nil {
+			return err
+		}
+		if len(files) > 0 {
+			return fmt.Errorf("%w: %s", ErrUntrackedFiles, files[0])
+		}
+	}
+
+	return nil
+}
+
+func checkoutEntry(name string, entry plumbing.TreeEntry, filter *contentFilter) error {
+	if entry.IsGitLink() {
+		return os.MkdirAll(name, os.ModePerm)
+	}
+
+	content, err := plumbing.ReadObjectOfKind(entry.Hash, plumbing.KindBlob)
+	if err != nil {
+		return err
+	}
+
+	if !entry.IsSymbolicLink() {
+		content, err = filter.smudge(name, content)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = removeInTheWay(name)
+	if err != nil {
+		return err
+	}
+
+	if entry.IsSymbolicLink() {
+		return os.Symlink(string(content), name)
+	}
+
+	mode := os.FileMode(0644)
+	if entry.Mode&0111 != 0 {
+		mode = 0755
+	}
+
+	return os.WriteFile(name, content, mode)
+}
+
+// removeInTheWay removes what is at the path of a file about to be written.
+// A directory is removed with its content, which callers have checked to be
+// expendable, unless it is a nested repository.
+func removeInTheWay(name string) error {
+	info, err := os.Lstat(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir() && !isWorkingTree(name) {
+		return os.RemoveAll(name)
+	}
+
+	return os.Remove(name)
+}
+
+// createParentDirectories creates the directories of a file in the working
+// tree. It refuses to follow a symbolic link, which would let a file be
+// written outside of the working tree.
+func createParentDirectories(name string) error {
+	for directory := path.Dir(name); directory != "." && directory != "/"; directory = path.Dir(directory) {
+		info, err := os.Lstat(directory)
+		if err != nil {
+			continue
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("refusing to write %s beyond symbolic link %s", name, directory)
+		}
+	}
+
+	return os.MkdirAll(path.Dir(name), os.ModePerm)
+}
+
+// removeWorkingFile deletes a file and any directories that become empty.
+func removeWorkingFile(name string) error {
+	err := os.Remove(name)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for directory := path.Dir(name); directory != "."; directory = path.Dir(directory) {
+		if os.Remove(directory) != nil {
+			break
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,256 @@
+package core
+
+import (
+	"errors"
+	"github.com/untanky/git-charged/config"
+	"github.com/untanky/git-charged/plumbing"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+// changeToTestDirectory changes into an empty temporary directory for the
+// duration of the test and restores the current repository afterwards.
+func changeToTestDirectory(t *testing.T) string {
+	t.Helper()
+
+	directory := t.TempDir()
+	previous, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Chdir(previous)
+		plumbing.SetDirectory(gitDirectoryName)
+		config.SetRepositoryConfig(path.Join(gitDirectoryName, "config"))
+		config.ReloadConfig()
+	})
+
+	return directory
+}
+
+// initTestRepository creates an empty repository in a temporary directory
+// and changes into it for the duration of the test.
+func initTestRepository(t *testing.T) string {
+	t.Helper()
+
+	directory := changeToTestDirectory(t)
+	err := initGitDirectory(gitDirectoryName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return directory
+}
+
+func writeTestBlob(t *testing.T, content string) []byte {
+	t.Helper()
+
+	hash, err := plumbing.WriteObject(plumbing.NewBlob(uint32(len(content)), strings.NewReader(content)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+// writeTestTree writes a tree without checking its entry names, like a
+// malicious repository would.
+func writeTestTree(t *testing.T, entries ...plumbing.TreeEntry) []byte {
+	t.Helper()
+
+	tree := plumbing.NewTree()
+	for _, entry := range entries {
+		tree.AddObject(entry.Mode, entry.Name, entry.Hash)
+	}
+
+	hash, err := plumbing.WriteObject(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+func writeTestCommit(t *testing.T, tree []byte, message string, parents ...[]byte) []byte {
+	t.Helper()
+
+	identity := plumbing.AuthorData{Name: "Test", Email: "test@example.com", Timestamp: time.Unix(1700000000, 0).UTC()}
+	hash, err := plumbing.WriteObject(&plumbing.Commit{
+		Tree:      tree,
+		Parents:   parents,
+		Author:    identity,
+		Committer: identity,
+		Message:   message,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+func TestCheckoutTreeRejectsMaliciousTrees(t *testing.T) {
+	directory := initTestRepository(t)
+	outside := t.TempDir()
+
+	hook := writeTestBlob(t, "#!/bin/sh\necho pwned\n")
+	hooks := writeTestTree(t, plumbing.TreeEntry{Mode: plumbing.ObjectTypeFile | 0755, Name: "post-checkout", Hash: hook})
+	gitDirectory := writeTestTree(t, plumbing.TreeEntry{Mode: plumbing.ObjectTypeDirectory, Name: "hooks", Hash: hooks})
+	escape := writeTestTree(t, plumbing.TreeEntry{Mode: plumbing.ObjectTypeFile | 0644, Name: "escaped", Hash: hook})
+	link := writeTestBlob(t, outside)
+
+	tests := []struct {
+		name    string
+		tree    []byte
+		written string
+	}{
+		{
+			name:    "git directory",
+			tree:    writeTestTree(t, plumbing.TreeEntry{Mode: plumbing.ObjectTypeDirectory, Name: ".git", Hash: gitDirectory}),
+			written: path.Join(directory, ".git", "hooks", "post-checkout"),
+		},
+		{
+			name:    "git directory in other case",
+			tree:    writeTestTree(t, plumbing.TreeEntry{Mode: plumbing.ObjectTypeDirectory, Name: ".GIT", Hash: gitDirectory}),
+			written: path.Join(directory, ".git", "hooks", "post-checkout"),
+		},
+		{
+			name:    "parent directory",
+			tree:    writeTestTree(t, plumbing.TreeEntry{Mode: plumbing.ObjectTypeDirectory, Name: "..", Hash: escape}),
+			written: path.Join(path.Dir(directory), "escaped"),
+		},
+		{
+			name: "symbolic link parent",
+			tree: writeTestTree(t,
+				plumbing.TreeEntry{Mode: plumbing.ObjectTypeSymbolicLink, Name: "link", Hash: link},
+				plumbing.TreeEntry{Mode: plumbing.ObjectTypeDirectory, Name: "link", Hash: escape},
+			),
+			written: path.Join(outside, "escaped"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkoutTree(test.tree, false)
+			if err == nil {
+				t.Errorf("checkoutTree succeeded")
+			}
+			if _, err := os.Lstat(test.written); err == nil {
+				t.Errorf("checkoutTree wrote %s", test.written)
+			}
+			os.Remove("link")
+		})
+	}
+}
+
+func TestCheckoutTreeReplacesDirectoriesAndFiles(t *testing.T) {
+	initTestRepository(t)
+
+	blob := writeTestBlob(t, "content\n")
+	file := plumbing.TreeEntry{Mode: plumbing.ObjectTypeFile | 0644, Name: "a", Hash: blob}
+	directory := plumbing.TreeEntry{Mode: plumbing.ObjectTypeDirectory, Name: "a", Hash: writeTestTree(t, plumbing.TreeEntry{Mode: plumbing.ObjectTypeFile | 0644, Name: "b", Hash: blob})}
+	trees := []struct {
+		tree     []byte
+		expected string
+	}{
+		{writeTestTree(t, directory), "a/b"},
+		{writeTestTree(t, file), "a"},
+		{writeTestTree(t, directory), "a/b"},
+	}
+
+	for _, tree := range trees {
+		err := checkoutTree(tree.tree, false)
+		if err != nil {
+			t.Fatalf("checkoutTree() = %s", err)
+		}
+
+		content, err := os.ReadFile(tree.expected)
+		if err != nil || string(content) != "content\n" {
+			t.Errorf("%s = %q, %v", tree.expected, content, err)
+		}
+		index, err := plumbing.ReadIndex()
+		if err != nil || len(index.Entries) != 1 || index.Entries[0].Name != tree.expected {
+			t.Errorf("index = %v, %v, want only %s", index, err, tree.expected)
+		}
+	}
+}
+
+func TestCheckoutTreeKeepsUntrackedFiles(t *testing.T) {
+	initTestRepository(t)
+
+	blob := writeTestBlob(t, "tracked\n")
+	tree := writeTestTree(t,
+		plumbing.TreeEntry{Mode: plumbing.ObjectTypeFile | 0644, Name: "file", Hash: blob},
+		plumbing.TreeEntry{Mode: plumbing.ObjectTypeFile | 0644, Name: "ignored", Hash: blob},
+		plumbing.TreeEntry{Mode: plumbing.ObjectTypeFile | 0644, Name: "directory", Hash: blob},
+	)
+	tests := []struct {
+		name  string
+		setup func() error
+	}{
+		{"file", func() error { return os.WriteFile("file", []byte("untracked\n"), 0644) }},
+		{"directory", func() error {
+			err := os.Mkdir("directory", os.ModePerm)
+			if err != nil {
+				return err
+			}
+			return os.WriteFile("directory/untracked", []byte("untracked\n"), 0644)
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Cleanup(func() {
+				for _, name := range []string{"file", "ignored", "directory", ".gitignore"} {
+					os.RemoveAll(name)
+				}
+				os.Remove(path.Join(gitDirectoryName, "index"))
+			})
+
+			err := test.setup()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = checkoutTree(tree, false)
+			if !errors.Is(err, ErrUntrackedFiles) {
+				t.Fatalf("checkoutTree() = %v, want ErrUntrackedFiles", err)
+			}
+			if _, err := os.Lstat("ignored"); err == nil {
+				t.Errorf("checkoutTree() wrote files before refusing")
+			}
+
+			err = checkoutTree(tree, true)
+			if err != nil {
+				t.Fatalf("checkoutTree() with force = %s", err)
+			}
+			content, err := os.ReadFile(test.name)
+			if err != nil || string(content) != "tracked\n" {
+				t.Errorf("%s = %q, %v after a forced checkout", test.name, content, err)
+			}
+		})
+	}
+
+	t.Run("ignored", func(t *testing.T) {
+		err := os.WriteFile(".gitignore", []byte("ignored\n"), 0644)
+		if err == nil {
+			err = os.WriteFile("ignored", []byte("untracked\n"), 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = checkoutTree(tree, false)
+		if err != nil {
+			t.Fatalf("checkoutTree() = %s", err)
+		}
+		content, err := os.ReadFile("ignored")
+		if err != nil || string(content) != "tracked\n" {
+			t.Errorf("ignored = %q, %v", content, err)
+		}
+	})
+}
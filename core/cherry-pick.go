@@ -0,0 +1,631 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/untanky/git-charged/config"
+	"github.com/untanky/git-charged/plumbing"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	cherryPickHeadFile = "CHERRY_PICK_HEAD"
+	revertHeadFile     = "REVERT_HEAD"
+	mergeMessageFile   = "MERGE_MSG"
+
+	// The sequencer directory holds the commits that are left to apply
+	// and the commit to return to on abort.
+	sequencerDirectoryName = "sequencer"
+	sequencerHeadFile      = "head"
+	sequencerTodoFile      = "todo"
+	// abort-safety holds HEAD after the last applied commit, so that abort
+	// can tell if HEAD was moved in between.
+	sequencerAbortSafetyFile = "abort-safety"
+	// opts holds the options of the sequence, like git's sequencer.
+	sequencerOptionsFile = "opts"
+
+	pickCommand   = "pick"
+	revertCommand = "revert"
+)
+
+var ErrNoSequenceInProgress = errors.New("no cherry-pick or revert in progress")
+
+type CherryPickParams struct {
+	// Revisions are single commits or ranges like "main..topic". Ranges
+	// are picked oldest commit first and reverted newest commit first.
+	Revisions []string
+	// Revert applies the inverse of the commits instead.
+	Revert bool
+	// Mainline is the number, starting at 1, of the parent that the changes
+	// of merge commits are taken relative to. Merges are refused without
+	// it.
+	Mainline int
+	Progress io.Writer
+}
+
+type sequenceStep struct {
+	command string
+	hash    []byte
+}
+
+func sequencerDirectory() string {
+	return path.Join(plumbing.Directory(), sequencerDirectoryName)
+}
+
+func sequenceInProgress() bool {
+	for _, name := range []string{gitFile(cherryPickHeadFile), gitFile(revertHeadFile), sequencerDirectory()} {
+		if _, err := os.Stat(name); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// CherryPick applies the changes introduced by existing commits on top of
+// HEAD and commits each of them, or reverts them with new commits. On a
+// conflict it stops with a ConflictError; ContinueSequence commits the
+// resolved changes and applies the remaining commits.
+func CherryPick(params CherryPickParams) error {
+	if sequenceInProgress() {
+		return fmt.Errorf("a cherry-pick or revert is already in progress, continue or abort it first")
+	}
+
+	head, err := plumbing.ResolveRef(plumbing.HEAD)
+	if err != nil {
+		return fmt.Errorf("you do not have the initial commit yet")
+	}
+
+	changed, err := hasLocalChanges()
+	if err != nil {
+		return err
+	}
+	if changed {
+		return ErrLocalChanges
+	}
+
+	steps, err := sequenceSteps(params.Revisions, params.Revert)
+	if err != nil {
+		return err
+	}
+	if len(steps) == 0 {
+		return fmt.Errorf("no commits to apply")
+	}
+
+	err = checkMainline(steps, params.Mainline)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(sequencerDirectory(), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(path.Join(sequencerDirectory(), sequencerHeadFile), []byte(hex.EncodeToString(head)+"\n"), 0644)
+	if err != nil {
+		return err
+	}
+	if params.Mainline > 0 {
+		err = config.SetValue(path.Join(sequencerDirectory(), sequencerOptionsFile), "options.mainline", strconv.Itoa(params.Mainline))
+		if err != nil {
+			return err
+		}
+	}
+
+	return runSequence(steps, params.Mainline, params.Progress)
+}
+
+// checkMainline refuses a sequence before it starts if it contains merge
+// commits but no mainline, or a mainline that a merge does not have.
+func checkMainline(steps []sequenceStep, mainline int) error {
+	for _, step := range steps {
+		commit, err := plumbing.ReadCommit(step.hash)
+		if err != nil {
+			return fmt.Errorf("cannot read commit %x: %w", step.hash, err)
+		}
+
+		switch {
+		case len(commit.Parents) > 1 && mainline == 0:
+			return fmt.Errorf("commit %s is a merge but no mainline was given", shortHash(step.hash))
+		case len(commit.Parents) > 1 && mainline > len(commit.Parents):
+			return fmt.Errorf("commit %s does not have parent %d", shortHash(step.hash), mainline)
+		}
+	}
+
+	return nil
+}
+
+// readSequencerMainline returns the mainline of the sequence in progress,
+// or 0 if none was given.
+func readSequencerMainline() (int, error) {
+	options, err := config.LoadFile(path.Join(sequencerDirectory(), sequencerOptionsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	value, ok := options.Get("options.mainline")
+	if !ok {
+		return 0, nil
+	}
+	return strconv.Atoi(value)
+}
+
+func sequenceSteps(revisions []string, revert bool) ([]sequenceStep, error) {
+	command := pickCommand
+	if revert {
+		command = revertCommand
+	}
+
+	steps := make([]sequenceStep, 0, len(revisions))
+	for _, revision := range revisions {
+		from, to, isRange := strings.Cut(revision, "..")
+		if !isRange {
+			hash, err := resolveCommit(revision)
+			if err != nil {
+				return nil, err
+			}
+			steps = append(steps, sequenceStep{command: command, hash: hash})
+			continue
+		}
+
+		hashes, err := rangeCommits(from, to)
+		if err != nil {
+			return nil, err
+		}
+		if !revert {
+			for i, j := 0, len(hashes)-1; i < j; i, j = i+1, j-1 {
+				hashes[i], hashes[j] = hashes[j], hashes[i]
+			}
+		}
+		for _, hash := range hashes {
+			steps = append(steps, sequenceStep{command: command, hash: hash})
+		}
+	}
+
+	return steps, nil
+}
+
+// rangeCommits lists the commits reachable from to but not from from,
+// newest first. Empty ends of the range default to HEAD.
+func rangeCommits(from string, to string) ([][]byte, error) {
+	if from == "" {
+		from = plumbing.HEAD
+	}
+	if to == "" {
+		to = plumbing.HEAD
+	}
+
+	exclude, err := resolveCommit(from)
+	if err != nil {
+		return nil, err
+	}
+	include, err := resolveCommit(to)
+	if err != nil {
+		return nil, err
+	}
+
+	walker := plumbing.NewCommitWalker()
+	err = walker.Push(include)
+	if err != nil {
+		return nil, err
+	}
+	err = walker.Hide(exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([][]byte, 0)
+	for {
+		hash, _, err := walker.Next()
+		if errors.Is(err, io.EOF) {
+			return hashes, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+}
+
+func runSequence(steps []sequenceStep, mainline int, progress io.Writer) error {
+	for i, step := range steps {
+		// The step being applied stays in the todo list until it is
+		// committed, like git does.
+		err := writeSequencerTodo(steps[i:])
+		if err != nil {
+			return err
+		}
+
+		head, err := plumbing.ResolveRef(plumbing.HEAD)
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(path.Join(sequencerDirectory(), sequencerAbortSafetyFile), []byte(hex.EncodeToString(head)+"\n"), 0644)
+		if err != nil {
+			return err
+		}
+
+		err = applySequenceStep(step, mainline, progress)
+		if err != nil {
+			return err
+		}
+	}
+
+	return os.RemoveAll(sequencerDirectory())
+}
+
+func writeSequencerTodo(steps []sequenceStep) error {
+	var builder strings.Builder
+	for _, step := range steps {
+		subject := ""
+		if commit, err := plumbing.ReadCommit(step.hash); err == nil {
+			subject, _, _ = strings.Cut(commit.Message, "\n")
+		}
+		fmt.Fprintf(&builder, "%s %x %s\n", step.command, step.hash, subject)
+	}
+
+	return os.WriteFile(path.Join(sequencerDirectory(), sequencerTodoFile), []byte(builder.String()), 0644)
+}
+
+func readSequencerTodo() ([]sequenceStep, error) {
+	content, err := os.ReadFile(path.Join(sequencerDirectory(), sequencerTodoFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	steps := make([]sequenceStep, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 || (fields[0] != pickCommand && fields[0] != revertCommand) {
+			return nil, fmt.Errorf("malformed sequencer instruction %q", scanner.Text())
+		}
+
+		hash, err := resolveCommit(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, sequenceStep{command: fields[0], hash: hash})
+	}
+
+	return steps, scanner.Err()
+}
+
+// applySequenceStep cherry-picks or reverts a single commit and commits the
+// result. Conflicts are recorded for ContinueSequence.
+func applySequenceStep(step sequenceStep, mainline int, progress io.Writer) error {
+	commit, err := plumbing.ReadCommit(step.hash)
+	if err != nil {
+		return fmt.Errorf("cannot read commit %x: %w", step.hash, err)
+	}
+
+	subject, _, _ := strings.Cut(commit.Message, "\n")
+	message, author, stateFile := commit.Message, &commit.Author, cherryPickHeadFile
+	if step.command == revertCommand {
+		message = fmt.Sprintf("Revert \"%s\"\n\nThis reverts commit %x.\n", subject, step.hash)
+		if len(commit.Parents) > 1 && mainline > 0 && mainline <= len(commit.Parents) {
+			message = fmt.Sprintf("Revert \"%s\"\n\nThis reverts commit %x, reversing\nchanges made to %x.\n", subject, step.hash, commit.Parents[mainline-1])
+		}
+		author, stateFile = nil, revertHeadFile
+	}
+
+	conflicts, headTree, err := mergeCommitChanges(step.hash, commit, mainline, step.command == revertCommand)
+	if err != nil {
+		return err
+	}
+
+	if len(conflicts) > 0 {
+		err = os.WriteFile(gitFile(stateFile), []byte(hex.EncodeToString(step.hash)+"\n"), 0644)
+		if err != nil {
+			return err
+		}
+
+		err = os.WriteFile(gitFile(mergeMessageFile), []byte(conflictMessage(message, conflicts)), 0644)
+		if err != nil {
+			return err
+		}
+
+		return fmt.Errorf("could not apply %s... %s: %w", shortHash(step.hash), subject, &ConflictError{Paths: conflicts})
+	}
+
+	return commitSequenceStep(step.hash, message, author, headTree, progress)
+}
+
+// mergeCommitChanges merges the changes a commit introduced, or their
+// inverse, into HEAD, the index and the working tree. The changes of a
+// merge commit are taken relative to its mainline parent. It returns the
+// paths with conflicts and the tree of HEAD.
+func mergeCommitChanges(hash []byte, commit *plumbing.Commit, mainline int, revert bool) ([]string, []byte, error) {
+	parentIndex := 0
+	if len(commit.Parents) > 1 {
+		if mainline < 1 || mainline > len(commit.Parents) {
+			return nil, nil, fmt.Errorf("commit %s is a merge but no valid mainline was given", shortHash(hash))
+		}
+		parentIndex = mainline - 1
+	}
+
+	var parentTree []byte
+	if len(commit.Parents) > 0 {
+		parent, err := plumbing.ReadCommit(commit.Parents[parentIndex])
+		if err != nil {
+			return nil, nil, err
+		}
+		parentTree = parent.Tree
+	}
+
+	head, err := plumbing.ResolveRef(plumbing.HEAD)
+	if err != nil {
+		return nil, nil, err
+	}
+	headCommit, err := plumbing.ReadCommit(head)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	subject, _, _ := strings.Cut(commit.Message, "\n")
+	description := fmt.Sprintf("%s (%s)", shortHash(hash), subject)
+
+	base, theirs := parentTree, commit.Tree
+	labels := mergeLabels{base: "parent of " + description, ours: plumbing.HEAD, theirs: description}
+	if revert {
+		base, theirs = commit.Tree, parentTree
+		labels = mergeLabels{base: description, ours: plumbing.HEAD, theirs: "parent of " + description}
+	}
+
+	merge, err := mergeTrees(base, headCommit.Tree, theirs, labels)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = applyMerge(merge, headCommit.Tree)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return merge.conflictPaths(), headCommit.Tree, nil
+}
+
+// conflictMessage lists the conflicting paths below a commit message as
+// comments, like git does in MERGE_MSG.
+func conflictMessage(message string, paths []string) string {
+	message = strings.TrimRight(message, "\n") + "\n\n# Conflicts:\n"
+	for _, name := range paths {
+		message += "#\t" + name + "\n"
+	}
+	return message
+}
+
+// commitSequenceStep commits the index unless the changes of a commit were
+// already applied, in which case there is nothing to commit.
+func commitSequenceStep(hash []byte, message string, author *plumbing.AuthorData, headTree []byte, progress io.Writer) error {
+	index, err := plumbing.ReadIndex()
+	if err != nil {
+		return fmt.Errorf("cannot read index: %w", err)
+	}
+
+	tree, err := writeIndexTree(index)
+	if err != nil {
+		return err
+	}
+
+	if bytes.Equal(tree, headTree) {
+		if progress != nil {
+			fmt.Fprintf(progress, "skipping %s, its changes are already applied\n", shortHash(hash))
+		}
+		return nil
+	}
+
+	message, err = runCommitMessageHook(hookPrepareCommitMsg, message, "message")
+	if err != nil {
+		return err
+	}
+
+	commit, err := commitIndex(message, author)
+	if err != nil {
+		return err
+	}
+
+	if progress != nil {
+		fmt.Fprintln(progress, commitSummary(commit, message))
+	}
+
+	return nil
+}
+
+// ContinueSequence commits the resolved conflicts of the commit that could
+// not be applied and applies the remaining commits. Conflicting paths are
+// staged from the working tree.
+func ContinueSequence(progress io.Writer) error {
+	if !sequenceInProgress() {
+		return ErrNoSequenceInProgress
+	}
+
+	for _, stateFile := range []string{cherryPickHeadFile, revertHeadFile} {
+		content, err := os.ReadFile(gitFile(stateFile))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		hash, err := hex.DecodeString(strings.TrimSpace(string(content)))
+		if err != nil {
+			return fmt.Errorf("malformed %s: %w", stateFile, err)
+		}
+
+		index, err := plumbing.ReadIndex()
+		if err != nil {
+			return fmt.Errorf("cannot read index: %w", err)
+		}
+		err = resolveConflicts(index)
+		if err != nil {
+			return err
+		}
+		err = plumbing.WriteIndex(index)
+		if err != nil {
+			return err
+		}
+
+		message, err := os.ReadFile(gitFile(mergeMessageFile))
+		if err != nil {
+			return err
+		}
+
+		var author *plumbing.AuthorData
+		if stateFile == cherryPickHeadFile {
+			commit, err := plumbing.ReadCommit(hash)
+			if err != nil {
+				return err
+			}
+			author = &commit.Author
+		}
+
+		head, err := plumbing.ResolveRef(plumbing.HEAD)
+		if err != nil {
+			return err
+		}
+		headCommit, err := plumbing.ReadCommit(head)
+		if err != nil {
+			return err
+		}
+
+		err = commitSequenceStep(hash, stripComments(string(message)), author, headCommit.Tree, progress)
+		if err != nil {
+			return err
+		}
+
+		for _, name := range []string{stateFile, mergeMessageFile} {
+			err = os.Remove(gitFile(name))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	steps, err := readSequencerTodo()
+	if err != nil {
+		return err
+	}
+	if len(steps) > 0 {
+		steps = steps[1:]
+	}
+
+	mainline, err := readSequencerMainline()
+	if err != nil {
+		return err
+	}
+
+	return runSequence(steps, mainline, progress)
+}
+
+// readSequencerHash reads a commit id from a file of the sequencer
+// directory, or returns nil if there is no such file.
+func readSequencerHash(name string) ([]byte, error) {
+	content, err := os.ReadFile(path.Join(sequencerDirectory(), name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := hex.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil {
+		return nil, fmt.Errorf("malformed %s: %w", name, err)
+	}
+	return hash, nil
+}
+
+// stripComments removes the comment lines of a commit message, trailing
+// whitespace and the blank lines around it, and collapses runs of blank
+// lines like git does.
+func stripComments(message string) string {
+	lines := make([]string, 0)
+	for _, line := range strings.Split(message, "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" && len(lines) > 0 && lines[len(lines)-1] == "" {
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	message = strings.TrimSpace(strings.Join(lines, "\n"))
+	if message == "" {
+		return ""
+	}
+	return message + "\n"
+}
+
+// AbortSequence stops a cherry-pick or revert and returns to the commit
+// that was checked out before it started. If HEAD was moved since the
+// sequence stopped, such as by committing, it is left alone with a warning
+// so that no work is lost.
+func AbortSequence(progress io.Writer) error {
+	if !sequenceInProgress() {
+		return ErrNoSequenceInProgress
+	}
+
+	head, err := readSequencerHash(sequencerHeadFile)
+	if err != nil {
+		return err
+	}
+	safeHead, err := readSequencerHash(sequencerAbortSafetyFile)
+	if err != nil {
+		return err
+	}
+	current, err := plumbing.ResolveRef(plumbing.HEAD)
+	if err != nil {
+		return err
+	}
+
+	if head == nil {
+		head = current
+	}
+
+	if safeHead == nil || bytes.Equal(safeHead, current) {
+		commit, err := plumbing.ReadCommit(head)
+		if err != nil {
+			return err
+		}
+
+		err = checkoutTree(commit.Tree, true)
+		if err != nil {
+			return err
+		}
+
+		err = plumbing.UpdateRef(plumbing.HEAD, head)
+		if err != nil {
+			return err
+		}
+	} else if progress != nil {
+		fmt.Fprintln(progress, "warning: You seem to have moved HEAD. Not rewinding, check your HEAD!")
+	}
+
+	for _, name := range []string{cherryPickHeadFile, revertHeadFile, mergeMessageFile} {
+		err = os.Remove(gitFile(name))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	return os.RemoveAll(sequencerDirectory())
+}
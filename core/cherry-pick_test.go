@@ -0,0 +1,154 @@
+package core
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"github.com/untanky/git-charged/config"
+	"github.com/untanky/git-charged/plumbing"
+	"os"
+	"strings"
+	"testing"
+)
+
+// initSequenceTest creates a repository whose main branch conflicts with
+// the commit it returns, which changes the same line of "file".
+func initSequenceTest(t *testing.T) ([]byte, []byte) {
+	t.Helper()
+
+	initTestRepository(t)
+	file := func(content string) []byte {
+		return writeTestTree(t, plumbing.TreeEntry{Mode: plumbing.ObjectTypeFile | 0644, Name: "file", Hash: writeTestBlob(t, content)})
+	}
+
+	base := writeTestCommit(t, file("base\n"), "Base\n")
+	main := writeTestCommit(t, file("main\n"), "Main\n", base)
+	topic := writeTestCommit(t, file("topic\n"), "Topic\n", base)
+
+	err := plumbing.WriteRef("refs/heads/main", main)
+	if err == nil {
+		err = plumbing.WriteSymbolicRef(plumbing.HEAD, "refs/heads/main")
+	}
+	if err == nil {
+		err = checkoutTree(file("main\n"), false)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return main, topic
+}
+
+func TestAbortSequence(t *testing.T) {
+	tests := []struct {
+		name       string
+		moveHead   bool
+		rewound    bool
+		warnedMove bool
+	}{
+		{name: "rewinds", rewound: true},
+		{name: "keeps a moved HEAD", moveHead: true, warnedMove: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			main, topic := initSequenceTest(t)
+
+			err := CherryPick(CherryPickParams{Revisions: []string{hex.EncodeToString(topic)}})
+			var conflict *ConflictError
+			if !errors.As(err, &conflict) {
+				t.Fatalf("CherryPick() = %v, want a conflict", err)
+			}
+
+			moved := main
+			if test.moveHead {
+				commit, err := plumbing.ReadCommit(main)
+				if err != nil {
+					t.Fatal(err)
+				}
+				moved = writeTestCommit(t, commit.Tree, "Resolved by hand\n", main)
+				err = plumbing.UpdateRef(plumbing.HEAD, moved)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var progress bytes.Buffer
+			err = AbortSequence(&progress)
+			if err != nil {
+				t.Fatalf("AbortSequence() = %s", err)
+			}
+
+			head, err := plumbing.ResolveRef(plumbing.HEAD)
+			if err != nil || !bytes.Equal(head, moved) {
+				t.Errorf("HEAD = %x, %v, want %x", head, err, moved)
+			}
+			if content, _ := os.ReadFile("file"); test.rewound && string(content) != "main\n" {
+				t.Errorf("file = %q after abort, want %q", content, "main\n")
+			}
+			if strings.Contains(progress.String(), "moved HEAD") != test.warnedMove {
+				t.Errorf("AbortSequence() progress = %q", progress.String())
+			}
+			if sequenceInProgress() {
+				t.Errorf("the sequence is still in progress")
+			}
+		})
+	}
+}
+
+func TestCherryPickMergeCommits(t *testing.T) {
+	tests := []struct {
+		name     string
+		mainline int
+		fails    bool
+	}{
+		{name: "refuses without a mainline", fails: true},
+		{name: "refuses a missing parent", mainline: 3, fails: true},
+		{name: "applies relative to the mainline", mainline: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			main, topic := initSequenceTest(t)
+			tree := writeTestTree(t,
+				plumbing.TreeEntry{Mode: plumbing.ObjectTypeFile | 0644, Name: "file", Hash: writeTestBlob(t, "main\n")},
+				plumbing.TreeEntry{Mode: plumbing.ObjectTypeFile | 0644, Name: "other", Hash: writeTestBlob(t, "merged\n")},
+			)
+			merge := writeTestCommit(t, tree, "Merge topic\n", main, topic)
+
+			for key, value := range map[string]string{"user.name": "Tester", "user.email": "tester@example.com"} {
+				err := config.SetValue(config.RepositoryConfig(), key, value)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			config.ReloadConfig()
+
+			err := CherryPick(CherryPickParams{Revisions: []string{hex.EncodeToString(merge)}, Mainline: test.mainline})
+			if (err != nil) != test.fails {
+				t.Fatalf("CherryPick() = %v, want failure %t", err, test.fails)
+			}
+			if sequenceInProgress() {
+				t.Errorf("the sequence is still in progress")
+			}
+
+			head, err := plumbing.ResolveRef(plumbing.HEAD)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.fails {
+				if !bytes.Equal(head, main) {
+					t.Errorf("HEAD = %x, want %x", head, main)
+				}
+				return
+			}
+
+			if content, err := os.ReadFile("other"); err != nil || string(content) != "merged\n" {
+				t.Errorf("other = %q, %v, want %q", content, err, "merged\n")
+			}
+			if content, _ := os.ReadFile("file"); string(content) != "main\n" {
+				t.Errorf("file = %q, want %q", content, "main\n")
+			}
+		})
+	}
+}
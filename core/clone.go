@@ -0,0 +1,133 @@
+package core
+
+import (
+	"fmt"
+	"github.com/untanky/git-charged/config"
+	"github.com/untanky/git-charged/plumbing"
+	"io"
+	"time"
+)
+
+const defaultRemote = "origin"
+
+type CloneParams struct {
+	URL    string
+	Branch string
+	// SingleBranch only fetches Branch, or the remote HEAD, now and in
+	// later fetches.
+	SingleBranch bool
+	// Depth and ShallowSince create a shallow clone of a single branch.
+	Depth        int
+	ShallowSince time.Time
+	// Filter creates a partial clone that fetches the filtered objects
+	// from the remote when they are needed.
+	Filter   string
+	Progress io.Writer
+}
+
+// Clone creates a repository in the current directory from a remote and
+// checks out its default branch.
+func Clone(params CloneParams) error {
+	err := initGitDirectory(gitDirectoryName)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(params.Progress, "Cloning from %s...\n", params.URL)
+
+	singleBranch := params.SingleBranch || params.Depth > 0 || !params.ShallowSince.IsZero()
+	result, err := fetchFrom(params.URL, FetchParams{
+		Remote:       defaultRemote,
+		Depth:        params.Depth,
+		ShallowSince: params.ShallowSince,
+		Filter:       params.Filter,
+		SingleBranch: singleBranch,
+		Branch:       params.Branch,
+		Tags:         !singleBranch,
+		Progress:     params.Progress,
+	})
+	if err != nil {
+		return err
+	}
+
+	branch := params.Branch
+	if branch == "" {
+		branch = result.DefaultBranch
+	}
+	if branch == "" {
+		branch = "main"
+	}
+
+	err = setGitConfig(params.URL, branch)
+	if err != nil {
+		return fmt.Errorf("cannot set git config: %w", err)
+	}
+
+	err = setCloneConfig(params, branch, singleBranch)
+	if err != nil {
+		return fmt.Errorf("cannot set git config: %w", err)
+	}
+	config.ReloadConfig()
+
+	err = plumbing.WriteSymbolicRef(plumbing.HEAD, headsPrefix+branch)
+	if err != nil {
+		return fmt.Errorf("cannot create HEAD: %w", err)
+	}
+
+	hash, err := plumbing.ResolveRef(fmt.Sprintf("refs/remotes/%s/%s", defaultRemote, branch))
+	if err != nil {
+		if params.Branch != "" {
+			return fmt.Errorf("remote branch %s not found", branch)
+		}
+		fmt.Fprintln(params.Progress, "warning: You appear to have cloned an empty repository.")
+		return nil
+	}
+
+	err = plumbing.WriteRef(headsPrefix+branch, hash)
+	if err != nil {
+		return fmt.Errorf("cannot create branch %s: %w", branch, err)
+	}
+
+	commit, err := plumbing.ReadCommit(hash)
+	if err != nil {
+		return err
+	}
+
+	err = checkoutTree(commit.Tree, false)
+	if err != nil {
+		return err
+	}
+
+	runPostCheckoutHook(nil, hash)
+	return nil
+}
+
+// setCloneConfig records how a shallow or partial clone was made so later
+// fetches and lazy object downloads behave the same way.
+func setCloneConfig(params CloneParams, branch string, singleBranch bool) error {
+	configPath := localConfigPath()
+	remote := fmt.Sprintf("remote.%s.", defaultRemote)
+
+	values := make([][2]string, 0)
+	if singleBranch {
+		refSpec := fmt.Sprintf("+%s%s:refs/remotes/%s/%s", headsPrefix, branch, defaultRemote, branch)
+		values = append(values, [2]string{remote + "fetch", refSpec})
+	}
+	if params.Filter != "" {
+		values = append(values,
+			[2]string{"core.repositoryformatversion", "1"},
+			[2]string{remote + "promisor", "true"},
+			[2]string{remote + "partialclonefilter", params.Filter},
+			[2]string{"extensions.partialclone", defaultRemote},
+		)
+	}
+
+	for _, value := range values {
+		err := config.SetValue(configPath, value[0], value[1])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,184 @@
+package core
+
+import (
+	"bytes"
+	"github.com/untanky/git-charged/config"
+	"github.com/untanky/git-charged/plumbing"
+	"github.com/untanky/git-charged/transport"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path"
+	"sync"
+	"testing"
+)
+
+// serveTestRepository serves a bare repository over smart HTTP. Client and
+// server share the plumbing package, so every request switches to the
+// served repository and is answered in full before the client continues.
+func serveTestRepository(t *testing.T, directory string, receivePack bool) *httptest.Server {
+	t.Helper()
+
+	server := transport.NewServer(receivePack)
+	var mutex sync.Mutex
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		recorder := httptest.NewRecorder()
+		err := withRepository(directory, func() error {
+			server.ServeHTTP(recorder, r)
+			return nil
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		for key, values := range recorder.Header() {
+			w.Header()[key] = values
+		}
+		w.WriteHeader(recorder.Code)
+		w.Write(recorder.Body.Bytes())
+	}))
+	t.Cleanup(httpServer.Close)
+
+	return httpServer
+}
+
+// initTestServer creates a bare repository with a main branch and returns
+// its directory and the first commit.
+func initTestServer(t *testing.T) (string, []byte) {
+	t.Helper()
+
+	directory := path.Join(t.TempDir(), "server.git")
+	var commit []byte
+	err := os.Mkdir(directory, os.ModePerm)
+	if err == nil {
+		err = inDirectory(directory, func() error {
+			err := initGitDirectory(".")
+			if err != nil {
+				return err
+			}
+
+			tree := writeTestTree(t, plumbing.TreeEntry{Mode: plumbing.ObjectTypeFile | 0644, Name: "README.md", Hash: writeTestBlob(t, "hello\n")})
+			commit = writeTestCommit(t, tree, "Initial commit\n")
+			err = plumbing.WriteRef("refs/heads/main", commit)
+			if err != nil {
+				return err
+			}
+			return plumbing.WriteSymbolicRef(plumbing.HEAD, "refs/heads/main")
+		})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return directory, commit
+}
+
+func TestCloneAndFetchOverHTTP(t *testing.T) {
+	serverDirectory, first := initTestServer(t)
+	server := serveTestRepository(t, serverDirectory, false)
+
+	changeToTestDirectory(t)
+	err := Clone(CloneParams{URL: server.URL, Progress: io.Discard})
+	if err != nil {
+		t.Fatalf("Clone() = %s", err)
+	}
+
+	content, err := os.ReadFile("README.md")
+	if err != nil || string(content) != "hello\n" {
+		t.Errorf("README.md = %q, %v", content, err)
+	}
+	for _, name := range []string{"refs/heads/main", "refs/remotes/origin/main", plumbing.HEAD} {
+		hash, err := plumbing.ResolveRef(name)
+		if err != nil || !bytes.Equal(hash, first) {
+			t.Errorf("%s = %x, %v, want %x", name, hash, err, first)
+		}
+	}
+
+	var second []byte
+	err = withRepository(serverDirectory, func() error {
+		tree := writeTestTree(t, plumbing.TreeEntry{Mode: plumbing.ObjectTypeFile | 0644, Name: "README.md", Hash: writeTestBlob(t, "hello again\n")})
+		second = writeTestCommit(t, tree, "Second commit\n", first)
+		err := plumbing.WriteRef("refs/heads/main", second)
+		if err != nil {
+			return err
+		}
+		return plumbing.WriteRef("refs/tags/v1.0.0", second)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Fetch(FetchParams{Remote: defaultRemote, Progress: io.Discard})
+	if err != nil {
+		t.Fatalf("Fetch() = %s", err)
+	}
+
+	for _, name := range []string{"refs/remotes/origin/main", "refs/tags/v1.0.0"} {
+		hash, err := plumbing.ResolveRef(name)
+		if err != nil || !bytes.Equal(hash, second) {
+			t.Errorf("%s = %x, %v, want %x", name, hash, err, second)
+		}
+	}
+	commit, err := plumbing.ReadCommit(second)
+	if err != nil || len(commit.Parents) != 1 || !bytes.Equal(commit.Parents[0], first) {
+		t.Errorf("fetched commit = %v, %v", commit, err)
+	}
+}
+
+func TestPartialClone(t *testing.T) {
+	if _, err := exec.LookPath(transport.ServiceUploadPack); err != nil {
+		t.Skip("git-upload-pack is not installed")
+	}
+
+	serverDirectory, first := initTestServer(t)
+	var old []byte
+	err := withRepository(serverDirectory, func() error {
+		commit, err := plumbing.ReadCommit(first)
+		if err != nil {
+			return err
+		}
+		tree, err := plumbing.ReadTree(commit.Tree)
+		if err != nil {
+			return err
+		}
+		old = tree.Entries()[0].Hash
+
+		second := writeTestCommit(t, writeTestTree(t, plumbing.TreeEntry{Mode: plumbing.ObjectTypeFile | 0644, Name: "README.md", Hash: writeTestBlob(t, "second\n")}), "Second commit\n", first)
+		err = plumbing.WriteRef("refs/heads/main", second)
+		if err != nil {
+			return err
+		}
+		return config.SetValue(config.RepositoryConfig(), "uploadpack.allowFilter", "true")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changeToTestDirectory(t)
+	err = Clone(CloneParams{URL: serverDirectory, Filter: "blob:none", Progress: io.Discard})
+	if err != nil {
+		t.Fatalf("Clone() = %s", err)
+	}
+
+	content, err := os.ReadFile("README.md")
+	if err != nil || string(content) != "second\n" {
+		t.Errorf("README.md = %q, %v, want the blobs of the checkout", content, err)
+	}
+	if remote, ok := config.Get("extensions.partialclone"); !ok || remote != defaultRemote {
+		t.Errorf("extensions.partialclone = %q, %t", remote, ok)
+	}
+
+	if plumbing.HasObject(old) {
+		t.Fatalf("blob %x of an older commit was fetched by the clone", old)
+	}
+	kind, data, err := plumbing.ReadObject(old)
+	if err != nil || kind != plumbing.KindBlob || string(data) != "hello\n" {
+		t.Errorf("ReadObject() of a promised blob = %v, %q, %v", kind, data, err)
+	}
+}
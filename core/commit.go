@@ -0,0 +1,271 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/untanky/git-charged/plumbing"
+	"io"
+	"os"
+	"strings"
+)
+
+const commitMessageFile = "COMMIT_EDITMSG"
+
+const commitMessageHelp = `
+# Please enter the commit message for your changes. Lines starting
+# with '#' will be ignored, and an empty message aborts the commit.
+`
+
+var ErrNothingToCommit = errors.New("nothing to commit")
+
+// MessageEditor lets the user change a commit message. Lines starting with
+// '#' are removed from the result.
+type MessageEditor func(message string) (string, error)
+
+type CommitParams struct {
+	Message string
+	// EditMessage is used to write the message if none is given.
+	EditMessage MessageEditor
+	// Amend replaces the commit HEAD points to, keeping its message unless
+	// another one is given.
+	Amend      bool
+	AllowEmpty bool
+	// Trailers are added to the message before it is edited, as configured
+	// with trailer.ifexists.
+	Trailers []Trailer
+	// NoVerify skips the pre-commit and commit-msg hooks.
+	NoVerify bool
+	Progress io.Writer
+}
+
+// Commit records the index as a new commit, running the commit hooks the
+// way git does: pre-commit before anything else, prepare-commit-msg before
+// the message is edited, commit-msg on the final message and post-commit
+// after the commit was made.
+func Commit(params CommitParams) ([]byte, error) {
+	head, headErr := plumbing.ResolveRef(plumbing.HEAD)
+	if params.Amend && headErr != nil {
+		return nil, fmt.Errorf("you have nothing to amend")
+	}
+
+	if !params.NoVerify {
+		err := runHook(hookPreCommit, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The pre-commit hook may have staged changes, so the index is read
+	// after it ran.
+	index, err := plumbing.ReadIndex()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read index: %w", err)
+	}
+	for _, entry := range index.Entries {
+		if entry.Stage != 0 {
+			return nil, fmt.Errorf("cannot commit because you have unmerged files")
+		}
+	}
+
+	if !params.Amend && !params.AllowEmpty {
+		tree, err := writeIndexTree(index)
+		if err != nil {
+			return nil, err
+		}
+
+		var headTree []byte
+		if headErr == nil {
+			headCommit, err := plumbing.ReadCommit(head)
+			if err != nil {
+				return nil, err
+			}
+			headTree = headCommit.Tree
+		}
+		if bytes.Equal(tree, headTree) || (headTree == nil && len(index.Entries) == 0) {
+			return nil, ErrNothingToCommit
+		}
+	}
+
+	message := params.Message
+	hookArgs := []string{}
+	switch {
+	case message != "":
+		if !strings.HasSuffix(message, "\n") {
+			message += "\n"
+		}
+		hookArgs = append(hookArgs, "message")
+	case params.Amend:
+		headCommit, err := plumbing.ReadCommit(head)
+		if err != nil {
+			return nil, err
+		}
+		message = headCommit.Message
+		hookArgs = append(hookArgs, "commit", hookObjectName(head))
+	}
+	message = AddTrailers(message, params.Trailers)
+
+	edit := params.Message == "" && params.EditMessage != nil
+	if edit {
+		message += commitMessageHelp
+	}
+
+	message, err = runCommitMessageHook(hookPrepareCommitMsg, message, hookArgs...)
+	if err != nil {
+		return nil, err
+	}
+
+	if edit {
+		message, err = params.EditMessage(message)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !params.NoVerify {
+		message, err = runCommitMessageHook(hookCommitMsg, message)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Like git, comments are only removed from messages that were edited.
+	if edit {
+		message = stripComments(message)
+	} else if message = strings.TrimSpace(message); message != "" {
+		message += "\n"
+	}
+	if message == "" {
+		return nil, fmt.Errorf("aborting commit due to empty commit message")
+	}
+
+	var hash []byte
+	if params.Amend {
+		hash, err = amendCommit(message)
+	} else {
+		hash, err = commitIndex(message, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if params.Progress != nil {
+		fmt.Fprintln(params.Progress, commitSummary(hash, message))
+	}
+
+	return hash, nil
+}
+
+// runCommitMessageHook passes a commit message to a hook in
+// .git/COMMIT_EDITMSG, which the hook may change, and returns the message
+// the file holds afterwards.
+func runCommitMessageHook(name string, message string, args ...string) (string, error) {
+	filename := gitFile(commitMessageFile)
+	err := os.WriteFile(filename, []byte(message), 0644)
+	if err != nil {
+		return "", fmt.Errorf("cannot write %s: %w", commitMessageFile, err)
+	}
+
+	err = runHook(name, nil, append([]string{filename}, args...)...)
+	if err != nil {
+		return "", err
+	}
+
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return "", fmt.Errorf("cannot read %s: %w", commitMessageFile, err)
+	}
+
+	return string(content), nil
+}
+
+// commitIndex records the index as a commit on top of HEAD and moves HEAD,
+// or the branch it points to, to the new commit. The committer is the
+// current user, and so is the author unless one is given.
+func commitIndex(message string, author *plumbing.AuthorData) ([]byte, error) {
+	parents := make([][]byte, 0, 1)
+	if head, err := plumbing.ResolveRef(plumbing.HEAD); err == nil {
+		parents = append(parents, head)
+	}
+
+	return writeIndexCommit(parents, message, author)
+}
+
+// amendCommit replaces the commit HEAD points to with a commit of the
+// index that has the same parents and author.
+func amendCommit(message string) ([]byte, error) {
+	head, err := plumbing.ResolveRef(plumbing.HEAD)
+	if err != nil {
+		return nil, fmt.Errorf("you do not have the initial commit yet")
+	}
+
+	commit, err := plumbing.ReadCommit(head)
+	if err != nil {
+		return nil, err
+	}
+
+	return writeIndexCommit(commit.Parents, message, &commit.Author)
+}
+
+func writeIndexCommit(parents [][]byte, message string, author *plumbing.AuthorData) ([]byte, error) {
+	index, err := plumbing.ReadIndex()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read index: %w", err)
+	}
+
+	tree, err := writeIndexTree(index)
+	if err != nil {
+		return nil, err
+	}
+
+	me, err := currentIdentity()
+	if err != nil {
+		return nil, err
+	}
+	if author == nil {
+		author = &me
+	}
+
+	commit := &plumbing.Commit{
+		Tree:      tree,
+		Parents:   parents,
+		Author:    *author,
+		Committer: me,
+		Message:   message,
+	}
+	err = signCommit(commit)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := plumbing.WriteObject(commit)
+	if err != nil {
+		return nil, err
+	}
+
+	err = plumbing.UpdateRef(plumbing.HEAD, hash)
+	if err != nil {
+		return nil, fmt.Errorf("cannot update HEAD: %w", err)
+	}
+
+	// The commit is made by then, so a failing post-commit hook is only
+	// reported.
+	err = runHook(hookPostCommit, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", err)
+	}
+
+	return hash, nil
+}
+
+// commitSummary describes a new commit like git does after committing, for
+// example "[main 1a2b3c4] Fix typo".
+func commitSummary(hash []byte, message string) string {
+	branch, ok := currentBranch()
+	if !ok {
+		branch = "detached HEAD"
+	}
+
+	subject, _, _ := strings.Cut(message, "\n")
+	return fmt.Sprintf("[%s %s] %s", branch, shortHash(hash), subject)
+}
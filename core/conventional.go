@@ -0,0 +1,290 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"github.com/untanky/git-charged/plumbing"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ConventionalType is a commit type of the Conventional Commits
+// specification, like "feat" or "fix".
+type ConventionalType struct {
+	Name        string
+	Description string
+}
+
+// ConventionalTypes are the types commits may have, as used by the
+// conventional preset of commitlint.
+var ConventionalTypes = []ConventionalType{
+	{Name: "feat", Description: "A new feature"},
+	{Name: "fix", Description: "A bug fix"},
+	{Name: "docs", Description: "Documentation only changes"},
+	{Name: "style", Description: "Changes that do not affect the meaning of the code"},
+	{Name: "refactor", Description: "A code change that neither fixes a bug nor adds a feature"},
+	{Name: "perf", Description: "A code change that improves performance"},
+	{Name: "test", Description: "Adding missing tests or correcting existing tests"},
+	{Name: "build", Description: "Changes that affect the build system or dependencies"},
+	{Name: "ci", Description: "Changes to the CI configuration"},
+	{Name: "chore", Description: "Other changes that don't modify source or test files"},
+	{Name: "revert", Description: "Reverts a previous commit"},
+}
+
+const (
+	breakingChangeToken      = "BREAKING CHANGE"
+	breakingChangeTokenDash  = "BREAKING-CHANGE"
+	conventionalScopeHistory = 1000
+	// ConventionalHeaderMaxLength is the longest header commitlint accepts.
+	ConventionalHeaderMaxLength = 100
+)
+
+// conventionalHookMarker identifies a commit-msg hook installed by
+// InstallConventionalCommitHook.
+const conventionalHookMarker = "# Installed by git-charged to lint Conventional Commits."
+
+var (
+	conventionalHeaderPattern = regexp.MustCompile(`^(\w+)(?:\(([^()]*)\))?(!)?: (.*)$`)
+	conventionalFooterPattern = regexp.MustCompile(`^((?i:BREAKING CHANGE)|[\w-]+)(?:: | #)`)
+
+	// conventionalExemptPrefixes start messages git writes itself, which
+	// are not linted.
+	conventionalExemptPrefixes = []string{"Merge ", "Revert \"", "fixup! ", "squash! ", "amend! "}
+)
+
+// ConventionalCommit is a commit message following the Conventional
+// Commits specification:
+//
+//	<type>[(<scope>)][!]: <description>
+//
+//	[body]
+//
+//	[footers]
+type ConventionalCommit struct {
+	Type        string
+	Scope       string
+	Breaking    bool
+	Description string
+	Body        string
+	// BreakingChange describes a breaking change in a BREAKING CHANGE
+	// footer.
+	BreakingChange string
+	Footers        []Trailer
+}
+
+// ParseConventionalCommit parses a commit message and checks that it
+// follows the Conventional Commits specification and uses one of the
+// ConventionalTypes.
+func ParseConventionalCommit(message string) (ConventionalCommit, error) {
+	message = strings.TrimSpace(message)
+	header, rest, _ := strings.Cut(message, "\n")
+
+	match := conventionalHeaderPattern.FindStringSubmatch(header)
+	if match == nil {
+		return ConventionalCommit{}, fmt.Errorf("header '%s' is not of the form '<type>[(<scope>)][!]: <description>'", header)
+	}
+
+	commit := ConventionalCommit{
+		Type:        strings.ToLower(match[1]),
+		Scope:       match[2],
+		Breaking:    match[3] == "!",
+		Description: match[4],
+	}
+
+	if !isConventionalType(commit.Type) {
+		return ConventionalCommit{}, fmt.Errorf("type '%s' is not one of %s", match[1], strings.Join(conventionalTypeNames(), ", "))
+	}
+	if strings.Contains(header, "(") && strings.TrimSpace(commit.Scope) == "" {
+		return ConventionalCommit{}, fmt.Errorf("scope must not be empty")
+	}
+	if strings.TrimSpace(commit.Description) == "" || commit.Description[0] == ' ' {
+		return ConventionalCommit{}, fmt.Errorf("description must follow the colon after a single space")
+	}
+
+	if len(header) > ConventionalHeaderMaxLength {
+		return ConventionalCommit{}, fmt.Errorf("header is longer than %d characters", ConventionalHeaderMaxLength)
+	}
+
+	if rest == "" {
+		return commit, nil
+	}
+	if !strings.HasPrefix(rest, "\n") {
+		return ConventionalCommit{}, fmt.Errorf("body must be separated from the header by a blank line")
+	}
+
+	// Footers are the last paragraphs whose lines each start with a token,
+	// or continue the footer above.
+	paragraphs := strings.Split(strings.TrimSpace(rest), "\n\n")
+	for len(paragraphs) > 0 {
+		footers, ok := parseConventionalFooters(paragraphs[len(paragraphs)-1])
+		if !ok {
+			break
+		}
+		commit.Footers = append(footers, commit.Footers...)
+		paragraphs = paragraphs[:len(paragraphs)-1]
+	}
+	commit.Body = strings.Join(paragraphs, "\n\n")
+
+	for _, footer := range commit.Footers {
+		switch {
+		case footer.Token == breakingChangeToken || footer.Token == breakingChangeTokenDash:
+			commit.Breaking = true
+			commit.BreakingChange = footer.Value
+		case strings.EqualFold(footer.Token, breakingChangeToken) || strings.EqualFold(footer.Token, breakingChangeTokenDash):
+			return ConventionalCommit{}, fmt.Errorf("footer token '%s' must be written in uppercase", footer.Token)
+		}
+	}
+
+	return commit, nil
+}
+
+func parseConventionalFooters(paragraph string) ([]Trailer, bool) {
+	footers := make([]Trailer, 0)
+	for _, line := range strings.Split(paragraph, "\n") {
+		match := conventionalFooterPattern.FindStringSubmatch(line)
+		if match == nil {
+			if len(footers) == 0 {
+				return nil, false
+			}
+			footers[len(footers)-1].Value += "\n" + line
+			continue
+		}
+
+		footers = append(footers, Trailer{Token: match[1], Value: strings.TrimSpace(line[len(match[0]):])})
+	}
+
+	return footers, true
+}
+
+// LintCommitMessage checks a commit message as given to a commit-msg hook
+// against the Conventional Commits specification. Comments are ignored,
+// and so are merges, reverts and fixups written by git.
+func LintCommitMessage(message string) error {
+	if i := strings.Index(message, trailerCutLine); i >= 0 {
+		message = message[:i]
+	}
+	message = stripComments(message)
+	if message == "" {
+		return nil
+	}
+
+	for _, prefix := range conventionalExemptPrefixes {
+		if strings.HasPrefix(message, prefix) {
+			return nil
+		}
+	}
+
+	_, err := ParseConventionalCommit(message)
+	return err
+}
+
+// String returns the commit message.
+func (c ConventionalCommit) String() string {
+	var builder strings.Builder
+	builder.WriteString(c.Type)
+	if c.Scope != "" {
+		builder.WriteString("(" + c.Scope + ")")
+	}
+	if c.Breaking {
+		builder.WriteString("!")
+	}
+	builder.WriteString(": " + c.Description + "\n")
+
+	if c.Body != "" {
+		builder.WriteString("\n" + strings.TrimSpace(c.Body) + "\n")
+	}
+
+	footers := c.Footers
+	if c.BreakingChange != "" {
+		footers = append([]Trailer{{Token: breakingChangeToken, Value: c.BreakingChange}}, footers...)
+	}
+	if len(footers) > 0 {
+		builder.WriteString("\n")
+		for _, footer := range footers {
+			builder.WriteString(footer.Token + ": " + footer.Value + "\n")
+		}
+	}
+
+	return builder.String()
+}
+
+// ConventionalScopes returns the scopes of recent commits on HEAD, the most
+// used first.
+func ConventionalScopes() ([]string, error) {
+	if _, err := plumbing.ResolveRef(plumbing.HEAD); err != nil {
+		return []string{}, nil
+	}
+
+	entries, err := Log(LogParams{MaxCount: conventionalScopeHistory, NoMailmap: true})
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int)
+	scopes := make([]string, 0)
+	for _, entry := range entries {
+		header, _, _ := strings.Cut(entry.Commit.Message, "\n")
+		match := conventionalHeaderPattern.FindStringSubmatch(header)
+		if match == nil || match[2] == "" {
+			continue
+		}
+
+		if counts[match[2]] == 0 {
+			scopes = append(scopes, match[2])
+		}
+		counts[match[2]]++
+	}
+
+	sort.SliceStable(scopes, func(i, j int) bool {
+		return counts[scopes[i]] > counts[scopes[j]]
+	})
+
+	return scopes, nil
+}
+
+// InstallConventionalCommitHook installs a commit-msg hook that runs
+// command with the message file, so that commits made with plain git are
+// linted too. A hook that was not installed this way is not replaced.
+func InstallConventionalCommitHook(command string) error {
+	filename := hookPath(hookCommitMsg)
+	content, err := os.ReadFile(filename)
+	if err == nil && !strings.Contains(string(content), conventionalHookMarker) {
+		return fmt.Errorf("a %s hook already exists at %s", hookCommitMsg, filename)
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("cannot read %s hook: %w", hookCommitMsg, err)
+	}
+
+	err = os.MkdirAll(path.Dir(filename), 0755)
+	if err != nil {
+		return fmt.Errorf("cannot create hooks directory: %w", err)
+	}
+
+	script := fmt.Sprintf("#!/bin/sh\n%s\nexec %s \"$1\"\n", conventionalHookMarker, command)
+	err = os.WriteFile(filename, []byte(script), 0755)
+	if err != nil {
+		return fmt.Errorf("cannot write %s hook: %w", hookCommitMsg, err)
+	}
+
+	return nil
+}
+
+func isConventionalType(name string) bool {
+	for _, conventionalType := range ConventionalTypes {
+		if conventionalType.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func conventionalTypeNames() []string {
+	names := make([]string, len(ConventionalTypes))
+	for i, conventionalType := range ConventionalTypes {
+		names[i] = conventionalType.Name
+	}
+	return names
+}
@@ -0,0 +1,151 @@
+package core
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseConventionalCommit(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		commit  ConventionalCommit
+	}{
+		{
+			name:    "type and description",
+			message: "feat: add a thing\n",
+			commit:  ConventionalCommit{Type: "feat", Description: "add a thing"},
+		},
+		{
+			name:    "scope and breaking mark",
+			message: "fix(parser)!: drop the old syntax",
+			commit:  ConventionalCommit{Type: "fix", Scope: "parser", Breaking: true, Description: "drop the old syntax"},
+		},
+		{
+			name:    "uppercase type",
+			message: "Docs: explain",
+			commit:  ConventionalCommit{Type: "docs", Description: "explain"},
+		},
+		{
+			name:    "body",
+			message: "docs: explain\n\nFirst paragraph.\n\nSecond paragraph.\n",
+			commit:  ConventionalCommit{Type: "docs", Description: "explain", Body: "First paragraph.\n\nSecond paragraph."},
+		},
+		{
+			name:    "footers",
+			message: "fix: crash\n\nBody.\n\nReviewed-by: A\nRefs #123\n",
+			commit: ConventionalCommit{
+				Type:        "fix",
+				Description: "crash",
+				Body:        "Body.",
+				Footers:     []Trailer{{"Reviewed-by", "A"}, {"Refs", "123"}},
+			},
+		},
+		{
+			name:    "breaking change footer",
+			message: "feat: new api\n\nBREAKING CHANGE: the old api\nis gone\n",
+			commit: ConventionalCommit{
+				Type:           "feat",
+				Breaking:       true,
+				Description:    "new api",
+				BreakingChange: "the old api\nis gone",
+				Footers:        []Trailer{{"BREAKING CHANGE", "the old api\nis gone"}},
+			},
+		},
+		{
+			name:    "breaking change footer with a dash",
+			message: "feat: new api\n\nBREAKING-CHANGE: gone\n",
+			commit: ConventionalCommit{
+				Type:           "feat",
+				Breaking:       true,
+				Description:    "new api",
+				BreakingChange: "gone",
+				Footers:        []Trailer{{"BREAKING-CHANGE", "gone"}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			commit, err := ParseConventionalCommit(test.message)
+			if err != nil {
+				t.Fatalf("ParseConventionalCommit(%q) = %s", test.message, err)
+			}
+			if !reflect.DeepEqual(commit, test.commit) {
+				t.Errorf("ParseConventionalCommit(%q) = %#v, want %#v", test.message, commit, test.commit)
+			}
+		})
+	}
+}
+
+func TestParseConventionalCommitRejectsInvalidMessages(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+	}{
+		{name: "no type", message: "add a thing"},
+		{name: "unknown type", message: "feature: add a thing"},
+		{name: "empty scope", message: "feat(): add a thing"},
+		{name: "no space after the colon", message: "feat:add a thing"},
+		{name: "two spaces after the colon", message: "feat:  add a thing"},
+		{name: "long header", message: "feat: " + strings.Repeat("a", ConventionalHeaderMaxLength)},
+		{name: "no blank line before the body", message: "feat: add\nbody"},
+		{name: "lowercase breaking change", message: "feat: add\n\nbreaking change: gone"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ParseConventionalCommit(test.message)
+			if err == nil {
+				t.Errorf("ParseConventionalCommit(%q) succeeded", test.message)
+			}
+		})
+	}
+}
+
+func TestLintCommitMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		valid   bool
+	}{
+		{name: "conventional", message: "feat: add\n", valid: true},
+		{name: "comments are ignored", message: "# Please enter the commit message\nfix: crash\n# comment\n", valid: true},
+		{name: "empty", message: "# only comments\n", valid: true},
+		{name: "merge", message: "Merge branch 'topic'\n", valid: true},
+		{name: "revert", message: "Revert \"feat: add\"\n", valid: true},
+		{name: "fixup", message: "fixup! feat: add\n", valid: true},
+		{name: "cut line", message: "feat: add\n" + trailerCutLine + "\ndiff --git\n", valid: true},
+		{name: "not conventional", message: "Add a thing\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := LintCommitMessage(test.message)
+			if (err == nil) != test.valid {
+				t.Errorf("LintCommitMessage(%q) = %v, want valid %t", test.message, err, test.valid)
+			}
+		})
+	}
+}
+
+func TestConventionalCommitString(t *testing.T) {
+	commit := ConventionalCommit{
+		Type:           "feat",
+		Scope:          "api",
+		Breaking:       true,
+		Description:    "new api",
+		Body:           "Body.\n",
+		BreakingChange: "gone",
+		Footers:        []Trailer{{"Reviewed-by", "A"}},
+	}
+	want := "feat(api)!: new api\n\nBody.\n\nBREAKING CHANGE: gone\nReviewed-by: A\n"
+
+	if commit.String() != want {
+		t.Errorf("String() = %q, want %q", commit.String(), want)
+	}
+	if _, err := ParseConventionalCommit(commit.String()); err != nil {
+		t.Errorf("ParseConventionalCommit(String()) = %s", err)
+	}
+}
@@ -0,0 +1,175 @@
+package core
+
+import (
+	"strings"
+)
+
+// lineMatch pairs a line of the old version of a file with an equal line of
+// the new version. Both are 0-based.
+type lineMatch struct {
+	old int
+	new int
+}
+
+// splitLines splits file content into lines without their line endings.
+// A missing newline at the end of the file does not add an empty line.
+func splitLines(content string) []string {
+	if content == "" {
+		return []string{}
+	}
+
+	return strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+}
+
+// diffLines returns the lines that two versions of a file have in common,
+// in order, using Myers' algorithm for the shortest edit script. Everything
+// else was removed from old or added in new.
+func diffLines(old []string, new []string) []lineMatch {
+	matches := make([]lineMatch, 0, min(len(old), len(new)))
+
+	// Common prefixes and suffixes are matched without searching.
+	prefix := 0
+	for prefix < len(old) && prefix < len(new) && old[prefix] == new[prefix] {
+		matches = append(matches, lineMatch{prefix, prefix})
+		prefix++
+	}
+
+	suffix := 0
+	for suffix < len(old)-prefix && suffix < len(new)-prefix && old[len(old)-1-suffix] == new[len(new)-1-suffix] {
+		suffix++
+	}
+
+	middle := myersMatches(old[prefix:len(old)-suffix], new[prefix:len(new)-suffix])
+	for _, match := range middle {
+		matches = append(matches, lineMatch{match.old + prefix, match.new + prefix})
+	}
+
+	for i := suffix; i > 0; i-- {
+		matches = append(matches, lineMatch{len(old) - i, len(new) - i})
+	}
+
+	return matches
+}
+
+// myersMatches finds the matching lines with the linear space variant of
+// Myers' algorithm, which splits the files at the middle snake of the
+// shortest edit script instead of keeping the trace of every step.
+func myersMatches(old []string, new []string) []lineMatch {
+	size := (len(old)+len(new)+1)/2 + 1
+	search := &myersSearch{
+		old:      old,
+		new:      new,
+		forward:  make([]int, 2*size+1),
+		backward: make([]int, 2*size+1),
+		matches:  make([]lineMatch, 0),
+	}
+
+	search.compare(0, len(old), 0, len(new))
+	return search.matches
+}
+
+// myersSearch holds the state of myersMatches. The frontiers are shared by
+// all parts of the files because each part is done with them before it
+// recurses.
+type myersSearch struct {
+	old      []string
+	new      []string
+	forward  []int
+	backward []int
+	matches  []lineMatch
+}
+
+// compare adds the matches between old[oldStart:oldEnd] and
+// new[newStart:newEnd] in order.
+func (s *myersSearch) compare(oldStart int, oldEnd int, newStart int, newEnd int) {
+	for oldStart < oldEnd && newStart < newEnd && s.old[oldStart] == s.new[newStart] {
+		s.matches = append(s.matches, lineMatch{oldStart, newStart})
+		oldStart++
+		newStart++
+	}
+
+	suffix := 0
+	for oldStart < oldEnd-suffix && newStart < newEnd-suffix && s.old[oldEnd-1-suffix] == s.new[newEnd-1-suffix] {
+		suffix++
+	}
+	oldEnd, newEnd = oldEnd-suffix, newEnd-suffix
+
+	// With the common ends removed, two parts that are both not empty need
+	// at least two edits, so both halves around the middle snake need fewer.
+	if oldStart < oldEnd && newStart < newEnd {
+		x, y, u, v := s.middleSnake(oldStart, oldEnd, newStart, newEnd)
+		s.compare(oldStart, x, newStart, y)
+		for ; x < u; x, y = x+1, y+1 {
+			s.matches = append(s.matches, lineMatch{x, y})
+		}
+		s.compare(u, oldEnd, v, newEnd)
+	}
+
+	for i := suffix; i > 0; i-- {
+		s.matches = append(s.matches, lineMatch{oldEnd + suffix - i, newEnd + suffix - i})
+	}
+}
+
+// middleSnake searches the shortest edit script of the two parts from both
+// ends at once and returns the start and end of the diagonal where the
+// searches meet.
+func (s *myersSearch) middleSnake(oldStart int, oldEnd int, newStart int, newEnd int) (int, int, int, int) {
+	n, m := oldEnd-oldStart, newEnd-newStart
+	delta := n - m
+	odd := delta%2 != 0
+
+	// The frontiers hold, for each diagonal, how far the search from the
+	// start (forward) or from the end (backward) got in old.
+	offset := (n+m+1)/2 + 1
+	s.forward[offset+1] = 0
+	s.backward[offset+1] = 0
+
+	for d := 0; d <= (n+m+1)/2; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && s.forward[offset+k-1] < s.forward[offset+k+1]) {
+				x = s.forward[offset+k+1]
+			} else {
+				x = s.forward[offset+k-1] + 1
+			}
+
+			y := x - k
+			startX, startY := x, y
+			for x < n && y < m && s.old[oldStart+x] == s.new[newStart+y] {
+				x++
+				y++
+			}
+			s.forward[offset+k] = x
+
+			c := delta - k
+			if odd && c >= -(d-1) && c <= d-1 && x+s.backward[offset+c] >= n {
+				return oldStart + startX, newStart + startY, oldStart + x, newStart + y
+			}
+		}
+
+		for c := -d; c <= d; c += 2 {
+			var x int
+			if c == -d || (c != d && s.backward[offset+c-1] < s.backward[offset+c+1]) {
+				x = s.backward[offset+c+1]
+			} else {
+				x = s.backward[offset+c-1] + 1
+			}
+
+			y := x - c
+			startX, startY := x, y
+			for x < n && y < m && s.old[oldEnd-1-x] == s.new[newEnd-1-y] {
+				x++
+				y++
+			}
+			s.backward[offset+c] = x
+
+			k := delta - c
+			if !odd && k >= -d && k <= d && x+s.forward[offset+k] >= n {
+				return oldEnd - x, newEnd - y, oldEnd - startX, newEnd - startY
+			}
+		}
+	}
+
+	// The searches meet after at most half of the longest possible script.
+	return oldStart, newStart, oldStart, newStart
+}
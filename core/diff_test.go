@@ -0,0 +1,100 @@
+package core
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// commonLength returns the length of the longest common subsequence of
+// two files, which the matches of diffLines must reach.
+func commonLength(old []string, new []string) int {
+	lengths := make([][]int, len(old)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(new)+1)
+	}
+
+	for i := len(old) - 1; i >= 0; i-- {
+		for j := len(new) - 1; j >= 0; j-- {
+			if old[i] == new[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else {
+				lengths[i][j] = max(lengths[i+1][j], lengths[i][j+1])
+			}
+		}
+	}
+
+	return lengths[0][0]
+}
+
+// checkMatches fails the test if matches do not pair equal lines in order
+// or are not as many as the longest common subsequence.
+func checkMatches(t *testing.T, old []string, new []string, matches []lineMatch) {
+	t.Helper()
+
+	for i, match := range matches {
+		if match.old < 0 || match.old >= len(old) || match.new < 0 || match.new >= len(new) || old[match.old] != new[match.new] {
+			t.Fatalf("match %d pairs %q with %q: %v", i, old, new, match)
+		}
+		if i > 0 && (match.old <= matches[i-1].old || match.new <= matches[i-1].new) {
+			t.Fatalf("match %d is out of order for %q and %q: %v", i, old, new, matches)
+		}
+	}
+	if length := commonLength(old, new); len(matches) != length {
+		t.Fatalf("diffLines(%q, %q) found %d matches, want %d: %v", old, new, len(matches), length, matches)
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name string
+		old  string
+		new  string
+	}{
+		{name: "empty", old: "", new: ""},
+		{name: "added", old: "", new: "a\nb\n"},
+		{name: "removed", old: "a\nb\n", new: ""},
+		{name: "equal", old: "a\nb\nc\n", new: "a\nb\nc\n"},
+		{name: "changed line", old: "a\nb\nc\n", new: "a\nx\nc\n"},
+		{name: "inserted in the middle", old: "a\nc\n", new: "a\nb\nc\n"},
+		{name: "moved line", old: "a\nb\nc\nd\n", new: "b\nc\nd\na\n"},
+		{name: "repeated lines", old: "a\na\nb\na\n", new: "b\na\na\na\nb\n"},
+		{name: "nothing in common", old: "a\nb\nc\n", new: "x\ny\n"},
+		{name: "classic example", old: "a\nb\nc\na\nb\nb\na\n", new: "c\nb\na\nb\na\nc\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			old, new := splitLines(test.old), splitLines(test.new)
+			checkMatches(t, old, new, diffLines(old, new))
+		})
+	}
+}
+
+func TestDiffLinesFindsTheLongestCommonSubsequence(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	file := func() []string {
+		lines := make([]string, random.Intn(40))
+		for i := range lines {
+			lines[i] = string(rune('a' + random.Intn(4)))
+		}
+		return lines
+	}
+
+	for i := 0; i < 500; i++ {
+		old, new := file(), file()
+		checkMatches(t, old, new, diffLines(old, new))
+	}
+}
+
+func TestDiffLinesOfLargeFiles(t *testing.T) {
+	// Two files without a common line need an edit script as long as both
+	// files, which used to keep a frontier for every step.
+	old := strings.Split(strings.Repeat("old\n", 5000), "\n")
+	new := strings.Split(strings.Repeat("new\n", 5000), "\n")
+
+	matches := diffLines(old, new)
+	if len(matches) != 1 {
+		t.Errorf("diffLines() found %d matches, want only the empty last line", len(matches))
+	}
+}
@@ -0,0 +1,370 @@
+package core
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"github.com/untanky/git-charged/config"
+	"github.com/untanky/git-charged/plumbing"
+	"github.com/untanky/git-charged/transport"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	headsPrefix = "refs/heads/"
+	tagsPrefix  = "refs/tags/"
+)
+
+type FetchParams struct {
+	Remote string
+	// Depth limits the fetched history to the given number of commits.
+	Depth int
+	// ShallowSince limits the fetched history to commits newer than it.
+	ShallowSince time.Time
+	// Unshallow fetches the complete history of a shallow repository.
+	Unshallow bool
+	// Filter is a partial clone filter like "blob:none" or "blob:limit=1m".
+	// It defaults to remote.<name>.partialclonefilter.
+	Filter string
+	// SingleBranch fetches only Branch, or the remote HEAD if Branch is
+	// empty. Otherwise the branches matching remote.<name>.fetch are
+	// fetched.
+	SingleBranch bool
+	Branch       string
+	// Tags fetches every tag of the remote, instead of only the tags that
+	// point into the fetched history.
+	Tags     bool
+	Progress io.Writer
+}
+
+type FetchResult struct {
+	// DefaultBranch is the branch the remote HEAD points to, if any.
+	DefaultBranch string
+	Refs          []transport.RemoteRef
+}
+
+func Fetch(params FetchParams) (*FetchResult, error) {
+	url, ok := config.Get(fmt.Sprintf("remote.%s.url", params.Remote))
+	if !ok {
+		return nil, fmt.Errorf("no url configured for remote %s", params.Remote)
+	}
+
+	return fetchFrom(url, params)
+}
+
+func fetchFrom(url string, params FetchParams) (*FetchResult, error) {
+	if plumbing.IsBundle(url) {
+		return fetchFromBundle(url, params)
+	}
+
+	endpoint, err := transport.ParseEndpoint(url)
+	if err != nil {
+		return nil, err
+	}
+
+	if params.Unshallow {
+		params.Depth = transport.InfiniteDepth
+	}
+	if params.Filter == "" {
+		params.Filter, _ = config.Get(fmt.Sprintf("remote.%s.partialclonefilter", params.Remote))
+	}
+
+	shallow, err := plumbing.ReadShallow()
+	if err != nil {
+		return nil, err
+	}
+	if params.Unshallow && len(shallow) == 0 {
+		return nil, fmt.Errorf("--unshallow on a complete repository does not make sense")
+	}
+	deepen := params.Depth > 0 || !params.ShallowSince.IsZero()
+
+	session, err := transport.NewUploadPackSession(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	refSpec, err := remoteRefSpec(params)
+	if err != nil {
+		return nil, err
+	}
+
+	source, _, _ := strings.Cut(refSpec.source, "*")
+	if params.SingleBranch && params.Branch != "" {
+		source = headsPrefix + params.Branch
+	}
+
+	refs, err := session.LsRefs(plumbing.HEAD, source, tagsPrefix)
+	if err != nil {
+		return nil, err
+	}
+	refs = validRemoteRefs(refs)
+	if params.SingleBranch {
+		refs = singleBranchRefs(refs, params.Branch)
+	}
+	followTags := !params.Tags
+
+	wants := make([][]byte, 0)
+	wanted := make(map[string]bool)
+	for _, ref := range refs {
+		if ref.Hash == nil || wanted[string(ref.Hash)] || ref.Name == plumbing.HEAD {
+			continue
+		}
+		if _, ok := refSpec.mapRef(ref.Name); !ok && !strings.HasPrefix(ref.Name, tagsPrefix) {
+			continue
+		}
+		if followTags && strings.HasPrefix(ref.Name, tagsPrefix) {
+			continue
+		}
+		// Commits we already have are wanted again to deepen their history.
+		if plumbing.HasObject(ref.Hash) && !(deepen && len(shallow) > 0) {
+			continue
+		}
+		wanted[string(ref.Hash)] = true
+		wants = append(wants, ref.Hash)
+	}
+
+	if len(wants) > 0 {
+		haves, err := localHaves()
+		if err != nil {
+			return nil, err
+		}
+
+		pack := bytes.NewBuffer(make([]byte, 0, 1024*1024))
+		response, err := session.Fetch(transport.FetchRequest{
+			Wants:       wants,
+			Haves:       haves,
+			Shallow:     shallow,
+			Depth:       params.Depth,
+			DeepenSince: params.ShallowSince,
+			Filter:      params.Filter,
+			IncludeTag:  followTags,
+			Pack:        pack,
+			Progress:    params.Progress,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("cannot fetch from %s: %w", endpoint, err)
+		}
+
+		checksum, err := plumbing.IndexPack(pack)
+		if err != nil {
+			return nil, fmt.Errorf("cannot store pack: %w", err)
+		}
+
+		if promisor, _ := config.Get(fmt.Sprintf("remote.%s.promisor", params.Remote)); promisor == "true" || params.Filter != "" {
+			err = plumbing.MarkPromisorPack(checksum)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		err = updateShallow(shallow, response)
+		if err != nil {
+			return nil, fmt.Errorf("cannot update shallow commits: %w", err)
+		}
+	}
+
+	result := &FetchResult{Refs: refs}
+	for _, ref := range refs {
+		// Followed tags are only stored if the fetched history contains them.
+		if followTags && strings.HasPrefix(ref.Name, tagsPrefix) && !plumbing.HasObject(ref.Hash) {
+			continue
+		}
+
+		err = updateRemoteRef(params, refSpec, ref)
+		if err != nil {
+			return nil, err
+		}
+
+		if ref.Name == plumbing.HEAD && ref.SymrefTarget != "" {
+			result.DefaultBranch = strings.TrimPrefix(ref.SymrefTarget, headsPrefix)
+		}
+	}
+
+	return result, nil
+}
+
+// fetchRefSpec maps remote references to local ones, like the refspec
+// "+refs/heads/*:refs/remotes/origin/*". Updates are always forced.
+type fetchRefSpec struct {
+	source      string
+	destination string
+}
+
+func parseFetchRefSpec(value string) (fetchRefSpec, error) {
+	source, destination, ok := strings.Cut(strings.TrimPrefix(value, "+"), ":")
+	wildcards := strings.Count(source, "*")
+	if !ok || source == "" || destination == "" || wildcards > 1 || strings.Count(destination, "*") != wildcards {
+		return fetchRefSpec{}, fmt.Errorf("invalid refspec %q", value)
+	}
+
+	return fetchRefSpec{source: source, destination: destination}, nil
+}
+
+// remoteRefSpec returns the refspec of a fetch: remote.<name>.fetch, or
+// all branches for single branch fetches and remotes without one.
+func remoteRefSpec(params FetchParams) (fetchRefSpec, error) {
+	if value, ok := config.Get(fmt.Sprintf("remote.%s.fetch", params.Remote)); ok && !params.SingleBranch {
+		return parseFetchRefSpec(value)
+	}
+
+	return fetchRefSpec{source: headsPrefix + "*", destination: fmt.Sprintf("refs/remotes/%s/*", params.Remote)}, nil
+}
+
+// mapRef returns the local reference a remote reference is fetched into.
+func (spec fetchRefSpec) mapRef(name string) (string, bool) {
+	prefix, suffix, wildcard := strings.Cut(spec.source, "*")
+	if !wildcard {
+		if name != spec.source {
+			return "", false
+		}
+		return spec.destination, true
+	}
+
+	if len(name) <= len(prefix)+len(suffix) || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+		return "", false
+	}
+
+	matched := name[len(prefix) : len(name)-len(suffix)]
+	return strings.Replace(spec.destination, "*", matched, 1), true
+}
+
+// validRemoteRefs drops advertised references whose names are not valid,
+// as they could be written anywhere.
+func validRemoteRefs(refs []transport.RemoteRef) []transport.RemoteRef {
+	valid := make([]transport.RemoteRef, 0, len(refs))
+	for _, ref := range refs {
+		if plumbing.CheckRefFormat(ref.Name) != nil {
+			continue
+		}
+		if ref.SymrefTarget != "" && plumbing.CheckRefFormat(ref.SymrefTarget) != nil {
+			ref.SymrefTarget = ""
+		}
+		valid = append(valid, ref)
+	}
+
+	return valid
+}
+
+// singleBranchRefs keeps HEAD, tags and the branch that is fetched, which
+// is the remote HEAD if branch is empty.
+func singleBranchRefs(refs []transport.RemoteRef, branch string) []transport.RemoteRef {
+	if branch == "" {
+		for _, ref := range refs {
+			if ref.Name == plumbing.HEAD {
+				branch = strings.TrimPrefix(ref.SymrefTarget, headsPrefix)
+			}
+		}
+	}
+
+	selected := make([]transport.RemoteRef, 0, len(refs))
+	for _, ref := range refs {
+		if strings.HasPrefix(ref.Name, headsPrefix) && ref.Name != headsPrefix+branch {
+			continue
+		}
+		selected = append(selected, ref)
+	}
+
+	return selected
+}
+
+func updateShallow(shallow [][]byte, response *transport.FetchResponse) error {
+	if len(response.Shallow) == 0 && len(response.Unshallow) == 0 {
+		return nil
+	}
+
+	unshallow := make(map[string]bool, len(response.Unshallow))
+	for _, hash := range response.Unshallow {
+		unshallow[string(hash)] = true
+	}
+
+	seen := make(map[string]bool)
+	updated := make([][]byte, 0, len(shallow)+len(response.Shallow))
+	for _, hash := range append(shallow, response.Shallow...) {
+		if unshallow[string(hash)] || seen[string(hash)] {
+			continue
+		}
+		seen[string(hash)] = true
+		updated = append(updated, hash)
+	}
+
+	return plumbing.WriteShallow(updated)
+}
+
+func updateRemoteRef(params FetchParams, refSpec fetchRefSpec, ref transport.RemoteRef) error {
+	switch {
+	case ref.Name == plumbing.HEAD:
+		target, ok := refSpec.mapRef(ref.SymrefTarget)
+		if !ok || !strings.HasPrefix(ref.SymrefTarget, headsPrefix) {
+			return nil
+		}
+		return plumbing.WriteSymbolicRef(fmt.Sprintf("refs/remotes/%s/%s", params.Remote, plumbing.HEAD), target)
+	case strings.HasPrefix(ref.Name, tagsPrefix):
+		if _, err := plumbing.ResolveRef(ref.Name); err == nil {
+			return nil
+		}
+		tag := strings.TrimPrefix(ref.Name, tagsPrefix)
+		return updateRef(params.Progress, ref.Name, ref.Hash, "[new tag]", tag+" -> "+tag)
+	}
+
+	destination, ok := refSpec.mapRef(ref.Name)
+	if !ok {
+		return nil
+	}
+	description := strings.TrimPrefix(ref.Name, headsPrefix) + " -> " + strings.TrimPrefix(destination, "refs/remotes/")
+	return updateRef(params.Progress, destination, ref.Hash, "[new branch]", description)
+}
+
+func updateRef(progress io.Writer, name string, hash []byte, label string, description string) error {
+	current, err := plumbing.ResolveRef(name)
+	if err == nil && bytes.Equal(current, hash) {
+		return nil
+	}
+
+	err = plumbing.WriteRef(name, hash)
+	if err != nil {
+		return fmt.Errorf("cannot update %s: %w", name, err)
+	}
+
+	if progress != nil {
+		if current == nil {
+			fmt.Fprintf(progress, " * %-17s %s\n", label, description)
+		} else {
+			fmt.Fprintf(progress, "   %s..%s  %s\n", shortHash(current), shortHash(hash), description)
+		}
+	}
+
+	return nil
+}
+
+func shortHash(hash []byte) string {
+	return hex.EncodeToString(hash)[:7]
+}
+
+func localHaves() (*plumbing.CommitWalker, error) {
+	refs, err := plumbing.ListRefs("refs/")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(refs) == 0 {
+		return nil, nil
+	}
+
+	walker := plumbing.NewCommitWalker()
+	for _, ref := range refs {
+		kind, _, err := plumbing.ReadObject(ref.Hash)
+		if err != nil || kind != plumbing.KindCommit {
+			continue
+		}
+
+		err = walker.Push(ref.Hash)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return walker, nil
+}
@@ -0,0 +1,135 @@
+package core
+
+import (
+	"bytes"
+	"github.com/untanky/git-charged/plumbing"
+	"github.com/untanky/git-charged/transport"
+	"io"
+	"os/exec"
+	"testing"
+)
+
+func TestFetchRefSpec(t *testing.T) {
+	tests := []struct {
+		refSpec     string
+		name        string
+		destination string
+		matches     bool
+	}{
+		{"+refs/heads/*:refs/remotes/origin/*", "refs/heads/main", "refs/remotes/origin/main", true},
+		{"+refs/heads/*:refs/remotes/origin/*", "refs/heads/feature/x", "refs/remotes/origin/feature/x", true},
+		{"+refs/heads/*:refs/remotes/origin/*", "refs/tags/v1", "", false},
+		{"+refs/heads/*:refs/remotes/origin/*", "refs/heads/", "", false},
+		{"refs/heads/main:refs/remotes/origin/main", "refs/heads/main", "refs/remotes/origin/main", true},
+		{"refs/heads/main:refs/remotes/origin/main", "refs/heads/maint", "", false},
+		{"refs/heads/release-*-fix:refs/remotes/origin/*", "refs/heads/release-1-fix", "refs/remotes/origin/1", true},
+	}
+
+	for _, test := range tests {
+		refSpec, err := parseFetchRefSpec(test.refSpec)
+		if err != nil {
+			t.Fatalf("parseFetchRefSpec(%q) = %s", test.refSpec, err)
+		}
+		destination, matches := refSpec.mapRef(test.name)
+		if destination != test.destination || matches != test.matches {
+			t.Errorf("%q.mapRef(%q) = %q, %v, want %q, %v", test.refSpec, test.name, destination, matches, test.destination, test.matches)
+		}
+	}
+
+	for _, invalid := range []string{"refs/heads/main", ":refs/remotes/origin/main", "refs/heads/*:refs/remotes/origin/main", "refs/*/*:refs/*/*"} {
+		if _, err := parseFetchRefSpec(invalid); err == nil {
+			t.Errorf("parseFetchRefSpec(%q) succeeded", invalid)
+		}
+	}
+}
+
+func TestFetchKeepsTheShapeOfTheClone(t *testing.T) {
+	tests := []struct {
+		name   string
+		params CloneParams
+		// local fetches from git-upload-pack instead of the HTTP server,
+		// which does not support shallow fetches.
+		local bool
+	}{
+		{name: "single branch", params: CloneParams{SingleBranch: true}},
+		{name: "shallow", params: CloneParams{Depth: 1}, local: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			serverDirectory, first := initTestServer(t)
+			url := serverDirectory
+			if test.local {
+				if _, err := exec.LookPath(transport.ServiceUploadPack); err != nil {
+					t.Skip("git-upload-pack is not installed")
+				}
+			} else {
+				url = serveTestRepository(t, serverDirectory, false).URL
+			}
+
+			var second, other []byte
+			err := withRepository(serverDirectory, func() error {
+				tree := writeTestTree(t, plumbing.TreeEntry{Mode: plumbing.ObjectTypeFile | 0644, Name: "README.md", Hash: writeTestBlob(t, "second\n")})
+				second = writeTestCommit(t, tree, "Second commit\n", first)
+				other = writeTestCommit(t, tree, "Other commit\n", first)
+				err := plumbing.WriteRef("refs/heads/main", second)
+				if err != nil {
+					return err
+				}
+				return plumbing.WriteRef("refs/heads/other", other)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			changeToTestDirectory(t)
+			params := test.params
+			params.URL = url
+			params.Progress = io.Discard
+			err = Clone(params)
+			if err != nil {
+				t.Fatalf("Clone() = %s", err)
+			}
+
+			var third []byte
+			err = withRepository(serverDirectory, func() error {
+				tree := writeTestTree(t, plumbing.TreeEntry{Mode: plumbing.ObjectTypeFile | 0644, Name: "README.md", Hash: writeTestBlob(t, "third\n")})
+				third = writeTestCommit(t, tree, "Third commit\n", second)
+				err := plumbing.WriteRef("refs/heads/main", third)
+				if err != nil {
+					return err
+				}
+				return plumbing.WriteRef("refs/heads/feature", third)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = Fetch(FetchParams{Remote: defaultRemote, Progress: io.Discard})
+			if err != nil {
+				t.Fatalf("Fetch() = %s", err)
+			}
+
+			hash, err := plumbing.ResolveRef("refs/remotes/origin/main")
+			if err != nil || !bytes.Equal(hash, third) {
+				t.Errorf("refs/remotes/origin/main = %x, %v, want %x", hash, err, third)
+			}
+			for _, name := range []string{"refs/remotes/origin/other", "refs/remotes/origin/feature"} {
+				if hash, err := plumbing.ResolveRef(name); err == nil {
+					t.Errorf("%s = %x, want only the cloned branch", name, hash)
+				}
+			}
+			if plumbing.HasObject(other) {
+				t.Errorf("the commit of another branch was fetched")
+			}
+
+			shallow, err := plumbing.ReadShallow()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.params.Depth > 0 && (len(shallow) != 1 || !bytes.Equal(shallow[0], second) || plumbing.HasObject(first)) {
+				t.Errorf("shallow commits = %x, history beyond them fetched: %v, want only %x", shallow, plumbing.HasObject(first), second)
+			}
+		})
+	}
+}
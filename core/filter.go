@@ -0,0 +1,145 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/untanky/git-charged/config"
+	"github.com/untanky/git-charged/transport"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// filterDriver converts content like the clean and smudge commands of a
+// configured filter driver.
+type filterDriver interface {
+	clean(name string, content []byte) ([]byte, error)
+	smudge(name string, content []byte) ([]byte, error)
+}
+
+// builtinFilterDrivers are implemented without running external commands.
+var builtinFilterDrivers = map[string]filterDriver{
+	lfsFilterName: lfsFilterDriver{},
+}
+
+// contentFilter converts file content between the working tree and the
+// object store according to gitattributes: line endings for text files
+// and the clean and smudge commands of filter drivers.
+type contentFilter struct {
+	attributes *attributeMatcher
+}
+
+func newContentFilter(attributes *attributeMatcher) *contentFilter {
+	return &contentFilter{attributes: attributes}
+}
+
+// clean converts working tree content to the content that is stored.
+func (f *contentFilter) clean(name string, content []byte) ([]byte, error) {
+	attributes := f.attributes.Attributes(name)
+
+	content, err := runFilterDriver(attributes, "clean", name, content)
+	if err != nil {
+		return nil, err
+	}
+
+	if convert, _ := textConversion(attributes, content); convert {
+		content = bytes.ReplaceAll(content, []byte("\r\n"), []byte("\n"))
+	}
+
+	return content, nil
+}
+
+// smudge converts stored content to the content written to the working
+// tree.
+func (f *contentFilter) smudge(name string, content []byte) ([]byte, error) {
+	attributes := f.attributes.Attributes(name)
+
+	if convert, eol := textConversion(attributes, content); convert && eol == "crlf" {
+		content = bytes.ReplaceAll(content, []byte("\r\n"), []byte("\n"))
+		content = bytes.ReplaceAll(content, []byte("\n"), []byte("\r\n"))
+	}
+
+	return runFilterDriver(attributes, "smudge", name, content)
+}
+
+// textConversion decides whether line endings of a file are converted and
+// which line ending is used in the working tree.
+func textConversion(attributes Attributes, content []byte) (bool, string) {
+	autoCRLF, _ := config.Get("core.autocrlf")
+	textValue, _ := attributes.Value("text")
+	eol, hasEOL := attributes.Value("eol")
+
+	var convert bool
+	switch {
+	case attributes.IsUnset("text"):
+		return false, ""
+	case attributes.IsSet("text"):
+		convert = true
+	case textValue == "auto":
+		convert = !isBinary(content)
+	case hasEOL:
+		convert = true
+	case autoCRLF == "true" || autoCRLF == "input":
+		convert = !isBinary(content)
+	}
+
+	if !hasEOL {
+		switch {
+		case autoCRLF == "true":
+			eol = "crlf"
+		case autoCRLF == "input":
+			eol = "lf"
+		default:
+			eol, _ = config.Get("core.eol")
+		}
+	}
+	if eol != "crlf" {
+		eol = "lf"
+	}
+
+	return convert, eol
+}
+
+// runFilterDriver pipes content through the clean or smudge command of the
+// filter driver selected by the filter attribute.
+func runFilterDriver(attributes Attributes, direction string, name string, content []byte) ([]byte, error) {
+	driver, ok := attributes.Value("filter")
+	if !ok {
+		return content, nil
+	}
+
+	if builtin, ok := builtinFilterDrivers[driver]; ok {
+		if direction == "clean" {
+			return builtin.clean(name, content)
+		}
+		return builtin.smudge(name, content)
+	}
+
+	command, ok := config.Get(fmt.Sprintf("filter.%s.%s", driver, direction))
+	required, _ := config.Get(fmt.Sprintf("filter.%s.required", driver))
+	if !ok {
+		if required == "true" {
+			return nil, fmt.Errorf("%s: %s filter '%s' is required but not configured", name, direction, driver)
+		}
+		return content, nil
+	}
+
+	command = strings.ReplaceAll(command, "%f", transport.ShellQuote(name))
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdin = bytes.NewReader(content)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		if required == "true" {
+			return nil, fmt.Errorf("%s: %s filter '%s' failed: %w: %s", name, direction, driver, err, strings.TrimSpace(stderr.String()))
+		}
+		fmt.Fprintf(os.Stderr, "error: external filter '%s' failed for %s\n", command, name)
+		return content, nil
+	}
+
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,117 @@
+package core
+
+import (
+	"testing"
+)
+
+func TestContentFilter(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     map[string]string
+		attributes string
+		file       string
+		worktree   string
+		stored     string
+	}{
+		{
+			name:     "no conversion",
+			file:     "a.txt",
+			worktree: "a\r\nb\n",
+			stored:   "a\r\nb\n",
+		},
+		{
+			name:       "text with crlf",
+			attributes: "*.txt text eol=crlf\n",
+			file:       "a.txt",
+			worktree:   "a\r\nb\r\n",
+			stored:     "a\nb\n",
+		},
+		{
+			name:       "text with the default line ending",
+			attributes: "*.txt text\n",
+			file:       "a.txt",
+			worktree:   "a\nb\n",
+			stored:     "a\nb\n",
+		},
+		{
+			name:     "autocrlf",
+			config:   map[string]string{"core.autocrlf": "true"},
+			file:     "a.txt",
+			worktree: "a\r\nb\r\n",
+			stored:   "a\nb\n",
+		},
+		{
+			name:     "autocrlf leaves binary files",
+			config:   map[string]string{"core.autocrlf": "true"},
+			file:     "a.bin",
+			worktree: "a\r\n\x00",
+			stored:   "a\r\n\x00",
+		},
+		{
+			name:       "unset text",
+			config:     map[string]string{"core.autocrlf": "true"},
+			attributes: "*.txt -text\n",
+			file:       "a.txt",
+			worktree:   "a\r\nb\n",
+			stored:     "a\r\nb\n",
+		},
+		{
+			name: "filter driver",
+			config: map[string]string{
+				"filter.upper.clean":  "tr a-z A-Z",
+				"filter.upper.smudge": "tr A-Z a-z",
+			},
+			attributes: "*.txt filter=upper\n",
+			file:       "a.txt",
+			worktree:   "hello\n",
+			stored:     "HELLO\n",
+		},
+		{
+			name:       "filter driver with the file name",
+			config:     map[string]string{"filter.name.clean": "echo %f", "filter.name.smudge": "cat"},
+			attributes: "*.txt filter=name\n",
+			file:       "it's.txt",
+			worktree:   "it's.txt\n",
+			stored:     "it's.txt\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			initTestRepository(t)
+			setTestConfig(t, test.config)
+			filter := newContentFilter(newAttributeMatcher(func(name string) ([]byte, bool) {
+				return []byte(test.attributes), name == gitAttributesFile
+			}))
+
+			stored, err := filter.clean(test.file, []byte(test.worktree))
+			if err != nil || string(stored) != test.stored {
+				t.Errorf("clean(%q) = %q, %v, want %q", test.worktree, stored, err, test.stored)
+			}
+
+			worktree, err := filter.smudge(test.file, []byte(test.stored))
+			if err != nil || string(worktree) != test.worktree {
+				t.Errorf("smudge(%q) = %q, %v, want %q", test.stored, worktree, err, test.worktree)
+			}
+		})
+	}
+}
+
+func TestRequiredFilterDriverFailures(t *testing.T) {
+	initTestRepository(t)
+	setTestConfig(t, map[string]string{
+		"filter.broken.clean":     "exit 1",
+		"filter.broken.required":  "true",
+		"filter.missing.required": "true",
+	})
+	filter := newContentFilter(newAttributeMatcher(func(name string) ([]byte, bool) {
+		return []byte("broken filter=broken\nmissing filter=missing\n"), name == gitAttributesFile
+	}))
+
+	for _, name := range []string{"broken", "missing"} {
+		_, err := filter.clean(name, []byte("content"))
+		if err == nil {
+			t.Errorf("clean(%q) succeeded with a failing required filter", name)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package core
+
+import (
+	"encoding/hex"
+	"github.com/untanky/git-charged/plumbing"
+	"strconv"
+	"strings"
+)
+
+// commitDateFormats are the layouts of git's date placeholders, by the
+// letter following %a or %c.
+var commitDateFormats = map[byte]string{
+	'd': "Mon Jan 2 15:04:05 2006 -0700",
+	'D': "Mon, 2 Jan 2006 15:04:05 -0700",
+	'i': "2006-01-02 15:04:05 -0700",
+	'I': "2006-01-02T15:04:05-07:00",
+}
+
+// formatCommit expands the placeholders of a git pretty format, such as
+// "%h %s (%an, %ad)", for a commit. %aN, %aE, %cN and %cE use the given
+// author and committer, which are mapped through the mailmap. Unknown
+// placeholders are kept as they are, like git does.
+func formatCommit(format string, hash []byte, commit *plumbing.Commit, author plumbing.AuthorData, committer plumbing.AuthorData) string {
+	subject, body := splitCommitMessage(commit.Message)
+
+	var builder strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			builder.WriteByte(format[i])
+			continue
+		}
+
+		placeholder := format[i+1]
+		expansion, ok := "", true
+		switch placeholder {
+		case '%':
+			expansion = "%"
+		case 'n':
+			expansion = "\n"
+		case 'H':
+			expansion = hex.EncodeToString(hash)
+		case 'h':
+			expansion = shortHash(hash)
+		case 'T':
+			expansion = hex.EncodeToString(commit.Tree)
+		case 't':
+			expansion = shortHash(commit.Tree)
+		case 'P', 'p':
+			parents := make([]string, len(commit.Parents))
+			for j, parent := range commit.Parents {
+				parents[j] = hex.EncodeToString(parent)
+				if placeholder == 'p' {
+					parents[j] = shortHash(parent)
+				}
+			}
+			expansion = strings.Join(parents, " ")
+		case 's':
+			expansion = subject
+		case 'b':
+			expansion = body
+		case 'B':
+			expansion = commit.Message
+		case 'a', 'c':
+			identity, mapped := commit.Author, author
+			if placeholder == 'c' {
+				identity, mapped = commit.Committer, committer
+			}
+			if i+2 == len(format) {
+				ok = false
+				break
+			}
+			expansion, ok = formatIdentity(identity, mapped, format[i+2])
+			if ok {
+				i++
+			}
+		default:
+			ok = false
+		}
+
+		if !ok {
+			builder.WriteByte('%')
+			continue
+		}
+		builder.WriteString(expansion)
+		i++
+	}
+
+	return builder.String()
+}
+
+func formatIdentity(identity plumbing.AuthorData, mapped plumbing.AuthorData, field byte) (string, bool) {
+	switch field {
+	case 'n':
+		return identity.Name, true
+	case 'N':
+		return mapped.Name, true
+	case 'e':
+		return identity.Email, true
+	case 'E':
+		return mapped.Email, true
+	case 't':
+		return strconv.FormatInt(identity.Timestamp.Unix(), 10), true
+	}
+
+	if layout, ok := commitDateFormats[field]; ok {
+		return identity.Timestamp.Format(layout), true
+	}
+	return "", false
+}
+
+// splitCommitMessage returns the subject of a commit message, its first
+// paragraph joined into one line, and the body after it.
+func splitCommitMessage(message string) (string, string) {
+	subject, body, _ := strings.Cut(strings.TrimLeft(message, "\n"), "\n\n")
+	lines := strings.Split(strings.TrimRight(subject, "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	subject = strings.Join(lines, " ")
+
+	return subject, strings.TrimLeft(body, "\n")
+}
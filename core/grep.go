@@ -0,0 +1,292 @@
+package core
+
+import (
+	"fmt"
+	"github.com/untanky/git-charged/plumbing"
+	"os"
+	"path"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type GrepParams struct {
+	// Pattern is a regular expression in Go syntax.
+	Pattern      string
+	IgnoreCase   bool
+	FixedStrings bool
+	WordRegexp   bool
+	// Revision searches the tree of a commit instead of the working tree.
+	Revision string
+	// Cached searches the staged content instead of the working tree.
+	Cached bool
+	// Pathspecs limit the search to paths equal to or below one of them,
+	// or matching one of them as a wildcard where "*" also matches slashes.
+	Pathspecs []string
+}
+
+// GrepMatch is a line that matched, or a binary file that matched as a
+// whole with a Line of 0.
+type GrepMatch struct {
+	// Revision is set when a revision was searched.
+	Revision string
+	Path     string
+	Line     int
+	// Column is the byte offset of the first match in the line, from 1.
+	Column  int
+	Content string
+	Binary  bool
+}
+
+// grepFile is a file to search, read through load so that blobs are only
+// read by the worker that searches them.
+type grepFile struct {
+	name string
+	load func() ([]byte, error)
+}
+
+// Grep searches the files of the working tree, the index or a revision for
+// lines matching a pattern. Files are searched in parallel, and the
+// matches are returned sorted by path and line.
+func Grep(params GrepParams) ([]GrepMatch, error) {
+	pattern := params.Pattern
+	if params.FixedStrings {
+		pattern = regexp.QuoteMeta(pattern)
+	}
+	if params.WordRegexp {
+		pattern = `\b(?:` + pattern + `)\b`
+	}
+	if params.IgnoreCase {
+		pattern = "(?i)" + pattern
+	}
+	expression, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern: %w", err)
+	}
+
+	matchesPathspec, err := compilePathspecs(params.Pathspecs)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []grepFile
+	if params.Revision != "" {
+		files, err = revisionGrepFiles(params.Revision)
+	} else {
+		files, err = indexGrepFiles(params.Cached)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	work := make(chan grepFile)
+	results := make(chan []GrepMatch)
+	errs := make(chan error, 1)
+
+	var workers sync.WaitGroup
+	for i := 0; i < runtime.NumCPU(); i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for file := range work {
+				matches, err := grepContent(file, expression, params.Revision)
+				if err != nil {
+					select {
+					case errs <- err:
+					default:
+					}
+					continue
+				}
+				results <- matches
+			}
+		}()
+	}
+
+	go func() {
+		for _, file := range files {
+			if matchesPathspec(file.name) {
+				work <- file
+			}
+		}
+		close(work)
+		workers.Wait()
+		close(results)
+	}()
+
+	matches := make([]GrepMatch, 0)
+	for fileMatches := range results {
+		matches = append(matches, fileMatches...)
+	}
+
+	select {
+	case err = <-errs:
+		return nil, err
+	default:
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Path != matches[j].Path {
+			return matches[i].Path < matches[j].Path
+		}
+		return matches[i].Line < matches[j].Line
+	})
+
+	return matches, nil
+}
+
+func grepContent(file grepFile, expression *regexp.Regexp, revision string) ([]GrepMatch, error) {
+	content, err := file.load()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read %s: %w", file.name, err)
+	}
+
+	if isBinary(content) {
+		if expression.Match(content) {
+			return []GrepMatch{{Revision: revision, Path: file.name, Binary: true}}, nil
+		}
+		return nil, nil
+	}
+
+	var matches []GrepMatch
+	for i, line := range strings.Split(strings.TrimSuffix(string(content), "\n"), "\n") {
+		location := expression.FindStringIndex(line)
+		if location == nil {
+			continue
+		}
+
+		matches = append(matches, GrepMatch{
+			Revision: revision,
+			Path:     file.name,
+			Line:     i + 1,
+			Column:   location[0] + 1,
+			Content:  line,
+		})
+	}
+
+	return matches, nil
+}
+
+// indexGrepFiles lists the tracked files, read from the working tree or
+// from the index. Submodules and files outside of a sparse checkout are
+// not searched in the working tree.
+func indexGrepFiles(cached bool) ([]grepFile, error) {
+	index, err := plumbing.ReadIndex()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read index: %w", err)
+	}
+
+	files := make([]grepFile, 0, len(index.Entries))
+	seen := make(map[string]bool, len(index.Entries))
+	for _, entry := range index.Entries {
+		if entry.Mode&0xf000 == gitLinkMode || seen[entry.Name] {
+			continue
+		}
+		seen[entry.Name] = true
+
+		name, hash := entry.Name, entry.Hash
+		switch {
+		case cached:
+			files = append(files, grepFile{name: name, load: func() ([]byte, error) {
+				return plumbing.ReadObjectOfKind(hash, plumbing.KindBlob)
+			}})
+		case !entry.SkipWorktree:
+			files = append(files, grepFile{name: name, load: func() ([]byte, error) {
+				if info, err := os.Lstat(name); err == nil && !info.Mode().IsRegular() {
+					return nil, nil
+				}
+				content, err := os.ReadFile(name)
+				if os.IsNotExist(err) {
+					return nil, nil
+				}
+				return content, err
+			}})
+		}
+	}
+
+	return files, nil
+}
+
+// revisionGrepFiles lists the files of the tree of a revision.
+func revisionGrepFiles(revision string) ([]grepFile, error) {
+	hash, err := ResolveRevision(revision)
+	if err != nil {
+		return nil, err
+	}
+
+	tree, err := peelTo(hash, plumbing.KindTree)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]grepFile, 0)
+	err = collectGrepFiles(tree, "", &files)
+	return files, err
+}
+
+func collectGrepFiles(treeHash []byte, directory string, files *[]grepFile) error {
+	tree, err := plumbing.ReadTree(treeHash)
+	if err != nil {
+		return fmt.Errorf("cannot read tree %x: %w", treeHash, err)
+	}
+
+	for _, entry := range tree.Entries() {
+		name := path.Join(directory, entry.Name)
+		switch {
+		case entry.IsDirectory():
+			err = collectGrepFiles(entry.Hash, name, files)
+			if err != nil {
+				return err
+			}
+		case !entry.IsGitLink():
+			hash := entry.Hash
+			*files = append(*files, grepFile{name: name, load: func() ([]byte, error) {
+				return plumbing.ReadObjectOfKind(hash, plumbing.KindBlob)
+			}})
+		}
+	}
+
+	return nil
+}
+
+// compilePathspecs returns a function telling whether a path is selected
+// by any of the pathspecs, or by none given at all.
+func compilePathspecs(pathspecs []string) (func(name string) bool, error) {
+	prefixes := make([]string, 0, len(pathspecs))
+	wildcards := make([]*regexp.Regexp, 0)
+	for _, pathspec := range pathspecs {
+		pathspec = strings.TrimPrefix(path.Clean(pathspec), "./")
+		if pathspec == "." {
+			return func(string) bool { return true }, nil
+		}
+		prefixes = append(prefixes, pathspec)
+
+		if strings.ContainsAny(pathspec, "*?[") {
+			// Unlike in gitignore patterns, wildcards in pathspecs match
+			// across directories.
+			compiled, err := compileWildcard(strings.ReplaceAll(pathspec, "*", "**"))
+			if err != nil {
+				return nil, fmt.Errorf("invalid pathspec %s: %w", pathspec, err)
+			}
+			wildcards = append(wildcards, compiled)
+		}
+	}
+
+	return func(name string) bool {
+		if len(pathspecs) == 0 {
+			return true
+		}
+		for _, prefix := range prefixes {
+			if name == prefix || strings.HasPrefix(name, prefix+"/") {
+				return true
+			}
+		}
+		for _, wildcard := range wildcards {
+			if wildcard.MatchString(name) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
@@ -0,0 +1,152 @@
+package core
+
+import (
+	"github.com/untanky/git-charged/plumbing"
+	"reflect"
+	"testing"
+)
+
+func TestCompilePathspecs(t *testing.T) {
+	tests := []struct {
+		name      string
+		pathspecs []string
+		matches   []string
+		others    []string
+	}{
+		{
+			name:    "no pathspecs",
+			matches: []string{"file", "dir/file"},
+		},
+		{
+			name:      "current directory",
+			pathspecs: []string{"./"},
+			matches:   []string{"file", "dir/file"},
+		},
+		{
+			name:      "file",
+			pathspecs: []string{"./dir/file"},
+			matches:   []string{"dir/file"},
+			others:    []string{"dir/file2", "file"},
+		},
+		{
+			name:      "directory",
+			pathspecs: []string{"dir/"},
+			matches:   []string{"dir/file", "dir/sub/file"},
+			others:    []string{"dir2/file", "dirfile"},
+		},
+		{
+			name:      "wildcard across directories",
+			pathspecs: []string{"*.go"},
+			matches:   []string{"main.go", "cmd/root.go"},
+			others:    []string{"main.go.orig", "README"},
+		},
+		{
+			name:      "wildcard below a directory",
+			pathspecs: []string{"src/*.c"},
+			matches:   []string{"src/a.c", "src/x/y.c"},
+			others:    []string{"a.c", "lib/src/a.c"},
+		},
+		{
+			name:      "single character",
+			pathspecs: []string{"?.txt"},
+			matches:   []string{"a.txt"},
+			others:    []string{"ab.txt"},
+		},
+		{
+			name:      "several pathspecs",
+			pathspecs: []string{"docs", "*.md"},
+			matches:   []string{"docs/guide.txt", "sub/README.md"},
+			others:    []string{"main.go"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			matchesPathspec, err := compilePathspecs(test.pathspecs)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, name := range test.matches {
+				if !matchesPathspec(name) {
+					t.Errorf("%q does not match %q", name, test.pathspecs)
+				}
+			}
+			for _, name := range test.others {
+				if matchesPathspec(name) {
+					t.Errorf("%q matches %q", name, test.pathspecs)
+				}
+			}
+		})
+	}
+}
+
+func TestGrepRevision(t *testing.T) {
+	initTestRepository(t)
+	file := func(name string, content string) plumbing.TreeEntry {
+		return plumbing.TreeEntry{Mode: plumbing.ObjectTypeFile | 0644, Name: name, Hash: writeTestBlob(t, content)}
+	}
+	directory := writeTestTree(t, file("b.go", "package b\n\nfunc Needle() {}\n"))
+	tree := writeTestTree(t,
+		file("a.txt", "a needle\nno match\nNEEDLES\n"),
+		file("binary", "needle\x00"),
+		plumbing.TreeEntry{Mode: plumbing.ObjectTypeDirectory, Name: "dir", Hash: directory},
+	)
+	commit := writeTestCommit(t, tree, "Files\n")
+	err := plumbing.WriteRef("refs/heads/main", commit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		params  GrepParams
+		matches []GrepMatch
+	}{
+		{
+			name:   "pattern",
+			params: GrepParams{Pattern: "need[a-z]e"},
+			matches: []GrepMatch{
+				{Revision: "main", Path: "a.txt", Line: 1, Column: 3, Content: "a needle"},
+				{Revision: "main", Path: "binary", Binary: true},
+			},
+		},
+		{
+			name:   "ignore case and whole words",
+			params: GrepParams{Pattern: "needle", IgnoreCase: true, WordRegexp: true},
+			matches: []GrepMatch{
+				{Revision: "main", Path: "a.txt", Line: 1, Column: 3, Content: "a needle"},
+				{Revision: "main", Path: "binary", Binary: true},
+				{Revision: "main", Path: "dir/b.go", Line: 3, Column: 6, Content: "func Needle() {}"},
+			},
+		},
+		{
+			name:   "fixed strings",
+			params: GrepParams{Pattern: "Needle()", FixedStrings: true},
+			matches: []GrepMatch{
+				{Revision: "main", Path: "dir/b.go", Line: 3, Column: 6, Content: "func Needle() {}"},
+			},
+		},
+		{
+			name:   "pathspec",
+			params: GrepParams{Pattern: "(?i)needle", Pathspecs: []string{"*.txt"}},
+			matches: []GrepMatch{
+				{Revision: "main", Path: "a.txt", Line: 1, Column: 3, Content: "a needle"},
+				{Revision: "main", Path: "a.txt", Line: 3, Column: 1, Content: "NEEDLES"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.params.Revision = "main"
+			matches, err := Grep(test.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(matches, test.matches) {
+				t.Errorf("Grep(%+v) = %+v, want %+v", test.params, matches, test.matches)
+			}
+		})
+	}
+}
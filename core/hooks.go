@@ -0,0 +1,84 @@
+package core
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/untanky/git-charged/config"
+	"github.com/untanky/git-charged/plumbing"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+)
+
+const (
+	hookPreCommit        = "pre-commit"
+	hookPrepareCommitMsg = "prepare-commit-msg"
+	hookCommitMsg        = "commit-msg"
+	hookPostCommit       = "post-commit"
+	hookPrePush          = "pre-push"
+	hookPostCheckout     = "post-checkout"
+
+	// zeroObjectName stands for a missing object in the arguments and input
+	// of hooks, such as the old HEAD of a clone.
+	zeroObjectName = "0000000000000000000000000000000000000000"
+)
+
+// hookPath returns where a hook is looked up: core.hooksPath, relative to
+// the top of the worktree, or the hooks directory of the repository.
+func hookPath(name string) string {
+	if hooksPath, ok := config.Get("core.hooksPath"); ok {
+		return path.Join(expandHome(hooksPath), name)
+	}
+
+	return path.Join(plumbing.CommonDirectory(), "hooks", name)
+}
+
+// runHook runs a hook if it exists and is executable. Like git, the hook's
+// output goes to stderr, and a hook that fails makes the operation fail.
+func runHook(name string, stdin io.Reader, args ...string) error {
+	filename := hookPath(name)
+	info, err := os.Stat(filename)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && info.IsDir()) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot run %s hook: %w", name, err)
+	}
+	if info.Mode()&0111 == 0 {
+		fmt.Fprintf(os.Stderr, "hint: The '%s' hook was ignored because it's not set as executable.\n", filename)
+		return nil
+	}
+
+	cmd := exec.Command(filename, args...)
+	cmd.Stdin = stdin
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+
+	err = cmd.Run()
+	if err != nil {
+		return fmt.Errorf("%s hook failed: %w", name, err)
+	}
+
+	return nil
+}
+
+func hookObjectName(hash []byte) string {
+	if hash == nil {
+		return zeroObjectName
+	}
+
+	return hex.EncodeToString(hash)
+}
+
+// runPostCheckoutHook tells the post-checkout hook that HEAD moved from one
+// commit to another. The checkout has already happened by then, so a
+// failing hook is only reported.
+func runPostCheckoutHook(previous []byte, next []byte) {
+	err := runHook(hookPostCheckout, nil, hookObjectName(previous), hookObjectName(next), "1")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", err)
+	}
+}
@@ -0,0 +1,192 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"github.com/untanky/git-charged/plumbing"
+	"github.com/untanky/git-charged/transport"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// initHookTest creates a repository and returns the log that the hooks
+// written by writeTestHook append to.
+func initHookTest(t *testing.T) string {
+	t.Helper()
+
+	initTestRepository(t)
+	setTestConfig(t, map[string]string{"user.name": "Hooker", "user.email": "hooker@example.com"})
+	err := plumbing.WriteSymbolicRef(plumbing.HEAD, "refs/heads/main")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path.Join(t.TempDir(), "hooks.log")
+}
+
+// writeTestHook writes a hook that logs its name and arguments before it
+// runs script.
+func writeTestHook(t *testing.T, directory string, name string, log string, script string) {
+	t.Helper()
+
+	err := os.MkdirAll(directory, os.ModePerm)
+	if err == nil {
+		content := fmt.Sprintf("#!/bin/sh\necho \"%s $*\" >> %s\n%s\n", name, transport.ShellQuote(log), script)
+		err = os.WriteFile(path.Join(directory, name), []byte(content), 0755)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readHookLog(t *testing.T, log string) []string {
+	t.Helper()
+
+	content, err := os.ReadFile(log)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+}
+
+func TestCommitHooks(t *testing.T) {
+	log := initHookTest(t)
+	hooks := path.Join(plumbing.CommonDirectory(), "hooks")
+	for _, name := range []string{hookPreCommit, hookPrepareCommitMsg, hookPostCommit} {
+		writeTestHook(t, hooks, name, log, "")
+	}
+	writeTestHook(t, hooks, hookCommitMsg, log, `echo "Checked-by: hook" >> "$1"`)
+
+	hash, err := Commit(CommitParams{Message: "Hooked", AllowEmpty: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messageFile := gitFile(commitMessageFile)
+	want := []string{
+		"pre-commit ",
+		"prepare-commit-msg " + messageFile + " message",
+		"commit-msg " + messageFile,
+		"post-commit ",
+	}
+	if got := readHookLog(t, log); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("hooks ran as %q, want %q", got, want)
+	}
+
+	commit, err := plumbing.ReadCommit(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commit.Message != "Hooked\nChecked-by: hook\n" {
+		t.Errorf("message = %q, want the change of commit-msg", commit.Message)
+	}
+}
+
+func TestFailingHooks(t *testing.T) {
+	tests := []struct {
+		name      string
+		hook      string
+		noVerify  bool
+		committed bool
+	}{
+		{name: "pre-commit aborts", hook: hookPreCommit},
+		{name: "commit-msg aborts", hook: hookCommitMsg},
+		{name: "prepare-commit-msg aborts", hook: hookPrepareCommitMsg},
+		{name: "no-verify skips pre-commit", hook: hookPreCommit, noVerify: true, committed: true},
+		{name: "no-verify skips commit-msg", hook: hookCommitMsg, noVerify: true, committed: true},
+		{name: "post-commit only warns", hook: hookPostCommit, committed: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			log := initHookTest(t)
+			writeTestHook(t, path.Join(plumbing.CommonDirectory(), "hooks"), test.hook, log, "exit 1")
+
+			_, err := Commit(CommitParams{Message: "Hooked", AllowEmpty: true, NoVerify: test.noVerify})
+			if test.committed != (err == nil) {
+				t.Errorf("Commit() = %v, want committed %t", err, test.committed)
+			}
+			if err != nil && !strings.Contains(err.Error(), test.hook+" hook failed") {
+				t.Errorf("Commit() = %v, want the %s hook to fail", err, test.hook)
+			}
+
+			_, headErr := plumbing.ResolveRef(plumbing.HEAD)
+			if test.committed != (headErr == nil) {
+				t.Errorf("HEAD resolves with %v, want committed %t", headErr, test.committed)
+			}
+			if ran := len(readHookLog(t, log)) > 0; ran == test.noVerify {
+				t.Errorf("hook ran %t with no-verify %t", ran, test.noVerify)
+			}
+		})
+	}
+}
+
+func TestHookLookup(t *testing.T) {
+	t.Run("core.hooksPath", func(t *testing.T) {
+		log := initHookTest(t)
+		writeTestHook(t, path.Join(plumbing.CommonDirectory(), "hooks"), hookPreCommit, log, "exit 1")
+		writeTestHook(t, "custom-hooks", hookPreCommit, log, "")
+		setTestConfig(t, map[string]string{"core.hooksPath": "custom-hooks"})
+
+		_, err := Commit(CommitParams{Message: "Hooked", AllowEmpty: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := readHookLog(t, log); len(got) != 1 {
+			t.Errorf("hooks ran as %q, want the one of core.hooksPath", got)
+		}
+	})
+
+	t.Run("not executable", func(t *testing.T) {
+		log := initHookTest(t)
+		hooks := path.Join(plumbing.CommonDirectory(), "hooks")
+		writeTestHook(t, hooks, hookPreCommit, log, "exit 1")
+		err := os.Chmod(path.Join(hooks, hookPreCommit), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = Commit(CommitParams{Message: "Hooked", AllowEmpty: true})
+		if err != nil {
+			t.Fatalf("Commit() = %v, want the hook to be ignored", err)
+		}
+	})
+}
+
+func TestPrePushHook(t *testing.T) {
+	log := initHookTest(t)
+	input := path.Join(t.TempDir(), "input")
+	writeTestHook(t, path.Join(plumbing.CommonDirectory(), "hooks"), hookPrePush, log, "cat > "+transport.ShellQuote(input))
+
+	old := []byte("\x01\x02\x03\x04\x05\x06\x07\x08\x09\x0a\x0b\x0c\x0d\x0e\x0f\x10\x11\x12\x13\x14")
+	next := []byte("\x14\x13\x12\x11\x10\x0f\x0e\x0d\x0c\x0b\x0a\x09\x08\x07\x06\x05\x04\x03\x02\x01")
+	updates := []*pushUpdate{
+		{RefUpdate: transport.RefUpdate{Name: "refs/heads/main", Old: old, New: next}, source: "HEAD"},
+		{RefUpdate: transport.RefUpdate{Name: "refs/heads/new", New: next}, source: "refs/heads/topic"},
+		{RefUpdate: transport.RefUpdate{Name: "refs/heads/gone", Old: old}},
+		{RefUpdate: transport.RefUpdate{Name: "refs/heads/rejected", Old: old, New: next}, source: "main", status: "non-fast-forward"},
+	}
+
+	err := runPrePushHook("origin", "https://example.com/repo.git", updates)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readHookLog(t, log); len(got) != 1 || got[0] != "pre-push origin https://example.com/repo.git" {
+		t.Errorf("hooks ran as %q", got)
+	}
+	content, err := os.ReadFile(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf("HEAD %x refs/heads/main %x\nrefs/heads/topic %x refs/heads/new %s\n(delete) %s refs/heads/gone %x\n",
+		next, old, next, zeroObjectName, zeroObjectName, old)
+	if string(content) != want {
+		t.Errorf("pre-push input = %q, want %q", content, want)
+	}
+}
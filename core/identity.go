@@ -0,0 +1,28 @@
+package core
+
+import (
+	"fmt"
+	"github.com/untanky/git-charged/config"
+	"github.com/untanky/git-charged/plumbing"
+	"time"
+)
+
+// currentIdentity returns the configured user with the current time, as
+// recorded in commits and reflogs.
+func currentIdentity() (plumbing.AuthorData, error) {
+	name, ok := config.Get("user.name")
+	if !ok {
+		return plumbing.AuthorData{}, fmt.Errorf("no user name found")
+	}
+
+	email, ok := config.Get("user.email")
+	if !ok {
+		return plumbing.AuthorData{}, fmt.Errorf("no user email found")
+	}
+
+	return plumbing.AuthorData{
+		Name:      name,
+		Email:     email,
+		Timestamp: time.Now(),
+	}, nil
+}
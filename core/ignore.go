@@ -0,0 +1,121 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/untanky/git-charged/config"
+	"github.com/untanky/git-charged/plumbing"
+	"os"
+	"path"
+	"strings"
+)
+
+const gitIgnoreFile = ".gitignore"
+
+type ignoreRule struct {
+	pattern       *wildcardPattern
+	negated       bool
+	directoryOnly bool
+}
+
+// ignoreMatcher decides which untracked files are ignored according to the
+// .gitignore files of the working tree, $GIT_DIR/info/exclude and
+// core.excludesFile.
+type ignoreMatcher struct {
+	rules  map[string][]ignoreRule
+	global []ignoreRule
+}
+
+func newIgnoreMatcher() *ignoreMatcher {
+	matcher := &ignoreMatcher{rules: make(map[string][]ignoreRule)}
+
+	excludeFiles := []string{}
+	if excludesFile, ok := config.Get("core.excludesFile"); ok {
+		excludeFiles = append(excludeFiles, expandHome(excludesFile))
+	}
+	excludeFiles = append(excludeFiles, path.Join(plumbing.CommonDirectory(), "info", "exclude"))
+
+	for _, name := range excludeFiles {
+		if content, err := os.ReadFile(name); err == nil {
+			matcher.global = append(matcher.global, parseIgnoreRules(content, "")...)
+		}
+	}
+
+	return matcher
+}
+
+func parseIgnoreRules(content []byte, base string) []ignoreRule {
+	rules := make([]ignoreRule, 0)
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rule := ignoreRule{}
+		if strings.HasPrefix(line, "!") {
+			rule.negated = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.directoryOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+
+		pattern, err := newWildcardPattern(line, base)
+		if err != nil {
+			continue
+		}
+		rule.pattern = pattern
+		rules = append(rules, rule)
+	}
+
+	return rules
+}
+
+func (m *ignoreMatcher) rulesIn(directory string) []ignoreRule {
+	if rules, ok := m.rules[directory]; ok {
+		return rules
+	}
+
+	var rules []ignoreRule
+	if content, err := os.ReadFile(path.Join(directory, gitIgnoreFile)); err == nil {
+		base := directory
+		if base == "." {
+			base = ""
+		}
+		rules = parseIgnoreRules(content, base)
+	}
+	m.rules[directory] = rules
+
+	return rules
+}
+
+// isIgnored reports whether a path relative to the top of the working tree
+// is ignored. The last matching pattern wins, and patterns of deeper
+// .gitignore files take precedence over the exclude files.
+func (m *ignoreMatcher) isIgnored(name string, isDirectory bool) bool {
+	ignored := false
+	apply := func(rules []ignoreRule) {
+		for _, rule := range rules {
+			if rule.directoryOnly && !isDirectory {
+				continue
+			}
+			if rule.pattern.matches(name) {
+				ignored = !rule.negated
+			}
+		}
+	}
+
+	apply(m.global)
+	apply(m.rulesIn("."))
+	for i, c := range name {
+		if c == '/' {
+			apply(m.rulesIn(name[:i]))
+		}
+	}
+
+	return ignored
+}
@@ -9,9 +9,7 @@ import (
 	"github.com/untanky/git-charged/plumbing"
 	"io"
 	"os"
-	"os/exec"
 	"path"
-	"time"
 )
 
 const gitDirectoryName = ".git"
@@ -22,31 +20,17 @@ type InitDBParams struct {
 	GitIgnoreFile *os.File
 	ReadmeFile    *os.File
 	LicenseFile   *os.File
+	Progress      io.Writer
 }
 
 func InitDB(params InitDBParams) error {
 	gitDirectory := gitDirectoryName
 
-	err := os.MkdirAll(gitDirectory, os.ModePerm)
-	if err != nil {
-		return fmt.Errorf("cannot create .git directory: %w", err)
-	}
-
-	err = createDirs(gitDirectory, "objects",
-		"refs",
-		path.Join("refs", "heads"),
-		path.Join("refs", "tags"),
-		"hooks",
-		"info",
-		"logs",
-		"temp",
-	)
+	err := initGitDirectory(gitDirectory)
 	if err != nil {
-		return fmt.Errorf("cannot create .git directory: %w", err)
+		return err
 	}
 
-	plumbing.SetDirectory(gitDirectory)
-
 	tree := plumbing.NewTree()
 
 	if params.GitIgnoreFile != nil {
@@ -78,20 +62,9 @@ func InitDB(params InitDBParams) error {
 
 	hash, err := plumbing.WriteObject(tree)
 
-	name, ok := config.Get("user.name")
-	if !ok {
-		return fmt.Errorf("no user name found")
-	}
-
-	email, ok := config.Get("user.email")
-	if !ok {
-		return fmt.Errorf("no user email found")
-	}
-
-	me := plumbing.AuthorData{
-		Name:      name,
-		Email:     email,
-		Timestamp: time.Now(),
+	me, err := currentIdentity()
+	if err != nil {
+		return err
 	}
 	commit := plumbing.Commit{
 		Tree:      hash,
@@ -99,8 +72,15 @@ func InitDB(params InitDBParams) error {
 		Committer: me,
 		Message:   "Initial commit\n",
 	}
+	err = signCommit(&commit)
+	if err != nil {
+		return err
+	}
 
 	hash, err = plumbing.WriteObject(&commit)
+	if err != nil {
+		return fmt.Errorf("cannot write initial commit: %w", err)
+	}
 
 	err = os.WriteFile(path.Join(gitDirectory, "refs", "heads", "main"), []byte(hex.EncodeToString(hash)), 0644)
 	if err != nil {
@@ -119,7 +99,7 @@ func InitDB(params InitDBParams) error {
 		return fmt.Errorf("cannot create repository: %w", err)
 	}
 
-	err = setGitConfig(repository.GetSSHURL())
+	err = setGitConfig(repository.GetSSHURL(), "main")
 	if err != nil {
 		return fmt.Errorf("cannot set git config: %w", err)
 	}
@@ -129,7 +109,12 @@ func InitDB(params InitDBParams) error {
 		return fmt.Errorf("cannot create HEAD: %w", err)
 	}
 
-	err = exec.Command("git", "push").Run()
+	config.ReloadConfig()
+
+	_, err = Push(PushParams{
+		Remote:   defaultRemote,
+		Progress: params.Progress,
+	})
 	if err != nil {
 		return fmt.Errorf("cannot push HEAD: %w", err)
 	}
@@ -137,6 +122,32 @@ func InitDB(params InitDBParams) error {
 	return nil
 }
 
+func initGitDirectory(gitDirectory string) error {
+	err := os.MkdirAll(gitDirectory, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("cannot create .git directory: %w", err)
+	}
+
+	err = createDirs(gitDirectory, "objects",
+		path.Join("objects", "info"),
+		path.Join("objects", "pack"),
+		"refs",
+		path.Join("refs", "heads"),
+		path.Join("refs", "tags"),
+		"hooks",
+		"info",
+		"logs",
+	)
+	if err != nil {
+		return fmt.Errorf("cannot create .git directory: %w", err)
+	}
+
+	plumbing.SetDirectory(gitDirectory)
+	config.SetRepositoryConfig(localConfigPath())
+
+	return nil
+}
+
 func createDirs(gitDirectory string, directories ...string) error {
 	for _, directory := range directories {
 		err := os.Mkdir(path.Join(gitDirectory, directory), os.ModePerm)
@@ -169,28 +180,38 @@ func addFile(filepath string, file *os.File) ([]byte, error) {
 	return hash, nil
 }
 
-func setGitConfig(remoteUrl string) error {
+func setGitConfig(remoteUrl string, branch string) error {
 	file, err := os.OpenFile(".git/config", os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
 	if err != nil {
 		return err
 	}
+	defer file.Close()
 
-	_, err = file.WriteString(fmt.Sprintf(`[core]
+	_, err = file.WriteString(`[core]
     repositoryformatversion = 0
     filemode = true
     bare = false
     ignorecase = true
     precomposeunicode = true
-[remote "origin"]
-    url = %s
-    fetch = +refs/heads/*:refs/remotes/origin/*
-[branch "main"]
-    remote = origin
-    merge = refs/heads/main
-`, remoteUrl))
+`)
 	if err != nil {
 		return err
 	}
 
+	// The URL and branch come from the user or the remote, so they are
+	// written with the escaping of the config writer.
+	values := [][2]string{
+		{"remote.origin.url", remoteUrl},
+		{"remote.origin.fetch", "+refs/heads/*:refs/remotes/origin/*"},
+		{"branch." + branch + ".remote", "origin"},
+		{"branch." + branch + ".merge", "refs/heads/" + branch},
+	}
+	for _, value := range values {
+		err = config.SetValue(".git/config", value[0], value[1])
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
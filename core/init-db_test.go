@@ -0,0 +1,41 @@
+package core
+
+import (
+	"github.com/untanky/git-charged/config"
+	"os"
+	"testing"
+)
+
+func TestSetGitConfigEscapesValues(t *testing.T) {
+	changeToTestDirectory(t)
+	err := os.Mkdir(gitDirectoryName, os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url := "https://example.com/repo.git\n[core]\n\thooksPath = /tmp"
+	branch := "a \"b\\c"
+	err = setGitConfig(url, branch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := config.LoadFile(".git/config")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := map[string]string{
+		"remote.origin.url":           url,
+		"branch." + branch + ".merge": "refs/heads/" + branch,
+		"core.bare":                   "false",
+	}
+	for key, want := range values {
+		if value, ok := file.Get(key); !ok || value != want {
+			t.Errorf("Get(%q) = %q, %t, want %q", key, value, ok, want)
+		}
+	}
+	if file.Has("core.hookspath") {
+		t.Errorf("the remote URL injected core.hooksPath")
+	}
+}
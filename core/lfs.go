@@ -0,0 +1,429 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/untanky/git-charged/config"
+	"github.com/untanky/git-charged/plumbing"
+	"github.com/untanky/git-charged/transport"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	lfsPointerVersion = "version https://git-lfs.github.com/spec/v1"
+	lfsOIDPrefix      = "sha256:"
+	lfsFilterName     = "lfs"
+
+	// maxLFSPointerSize bounds the size of blobs that may be pointers.
+	maxLFSPointerSize = 1024
+)
+
+// lfsOIDPattern matches the lowercase hex SHA-256 that names an object, and
+// keeps an OID from leaving the LFS storage.
+var lfsOIDPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// LFSFile is a file in the index that is stored with Git LFS.
+type LFSFile struct {
+	Path   string
+	Object transport.LFSObject
+	// Local reports whether the content is in the local LFS storage.
+	Local bool
+}
+
+func parseLFSPointer(content []byte) (transport.LFSObject, bool) {
+	if len(content) > maxLFSPointerSize || !bytes.HasPrefix(content, []byte(lfsPointerVersion+"\n")) {
+		return transport.LFSObject{}, false
+	}
+
+	object := transport.LFSObject{Size: -1}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), " ")
+		switch key {
+		case "oid":
+			object.OID = strings.TrimPrefix(value, lfsOIDPrefix)
+		case "size":
+			size, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return transport.LFSObject{}, false
+			}
+			object.Size = size
+		}
+	}
+
+	if !lfsOIDPattern.MatchString(object.OID) || object.Size < 0 {
+		return transport.LFSObject{}, false
+	}
+
+	return object, true
+}
+
+func formatLFSPointer(object transport.LFSObject) []byte {
+	return []byte(fmt.Sprintf("%s\noid %s%s\nsize %d\n", lfsPointerVersion, lfsOIDPrefix, object.OID, object.Size))
+}
+
+func lfsDirectory() string {
+	return path.Join(plumbing.CommonDirectory(), "lfs")
+}
+
+func lfsObjectPath(oid string) string {
+	return path.Join(lfsDirectory(), "objects", oid[:2], oid[2:4], oid)
+}
+
+func hasLFSObject(object transport.LFSObject) bool {
+	info, err := os.Stat(lfsObjectPath(object.OID))
+	return err == nil && info.Size() == object.Size
+}
+
+// storeLFSObject copies content into the local LFS storage and verifies
+// that it matches object if object.OID is set.
+func storeLFSObject(object transport.LFSObject, content io.Reader) (transport.LFSObject, error) {
+	temporaryDirectory := path.Join(lfsDirectory(), "tmp")
+	err := os.MkdirAll(temporaryDirectory, os.ModePerm)
+	if err != nil {
+		return object, err
+	}
+
+	file, err := os.CreateTemp(temporaryDirectory, "object-")
+	if err != nil {
+		return object, err
+	}
+	defer os.Remove(file.Name())
+
+	hashWriter := sha256.New()
+	size, err := io.Copy(io.MultiWriter(file, hashWriter), content)
+	file.Close()
+	if err != nil {
+		return object, err
+	}
+
+	oid := hex.EncodeToString(hashWriter.Sum(nil))
+	if object.OID != "" && (object.OID != oid || object.Size != size) {
+		return object, fmt.Errorf("LFS object %s is corrupt", object.OID)
+	}
+	object = transport.LFSObject{OID: oid, Size: size}
+
+	err = os.MkdirAll(path.Dir(lfsObjectPath(oid)), os.ModePerm)
+	if err != nil {
+		return object, err
+	}
+
+	return object, os.Rename(file.Name(), lfsObjectPath(oid))
+}
+
+// lfsClient returns a client for the LFS server of a remote, which can be
+// configured with lfs.url or remote.<name>.lfsurl.
+func lfsClient(remote string) (*transport.LFSClient, error) {
+	for _, key := range []string{"lfs.url", fmt.Sprintf("remote.%s.lfsurl", remote)} {
+		if lfsURL, ok := config.Get(key); ok {
+			return transport.NewLFSClientForURL(lfsURL)
+		}
+	}
+
+	remoteURL, ok := config.Get(fmt.Sprintf("remote.%s.url", remote))
+	if !ok && isRemoteURL(remote) {
+		remoteURL, ok = remote, true
+	}
+	if !ok {
+		return nil, fmt.Errorf("no url configured for remote %s", remote)
+	}
+
+	return transport.NewLFSClient(remoteURL)
+}
+
+func downloadLFSObjects(remote string, objects []transport.LFSObject) error {
+	missing := make([]transport.LFSObject, 0, len(objects))
+	for _, object := range objects {
+		if !hasLFSObject(object) {
+			missing = append(missing, object)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	client, err := lfsClient(remote)
+	if err != nil {
+		return err
+	}
+
+	return client.Download(missing, func(object transport.LFSObject, content io.Reader) error {
+		_, err := storeLFSObject(object, content)
+		return err
+	})
+}
+
+func uploadLFSObjects(remote string, objects []transport.LFSObject) error {
+	if len(objects) == 0 {
+		return nil
+	}
+
+	client, err := lfsClient(remote)
+	if err != nil {
+		return err
+	}
+
+	return client.Upload(objects, func(object transport.LFSObject) (io.ReadCloser, error) {
+		file, err := os.Open(lfsObjectPath(object.OID))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("LFS object %s is missing locally", object.OID)
+		}
+		return file, err
+	})
+}
+
+// lfsFilterDriver is the built-in implementation of filter=lfs. Clean
+// moves content into the LFS storage and stores a pointer instead; smudge
+// replaces pointers with the content, downloading it if necessary.
+type lfsFilterDriver struct{}
+
+func (lfsFilterDriver) clean(name string, content []byte) ([]byte, error) {
+	if _, ok := parseLFSPointer(content); ok {
+		return content, nil
+	}
+
+	object, err := storeLFSObject(transport.LFSObject{}, bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("cannot store %s in LFS: %w", name, err)
+	}
+
+	return formatLFSPointer(object), nil
+}
+
+func (lfsFilterDriver) smudge(name string, content []byte) ([]byte, error) {
+	object, ok := parseLFSPointer(content)
+	if !ok {
+		return content, nil
+	}
+
+	err := downloadLFSObjects(defaultRemote, []transport.LFSObject{object})
+	if err != nil {
+		// Like git-lfs, leave the pointer in place so the checkout succeeds.
+		fmt.Fprintf(os.Stderr, "warning: cannot download LFS object for %s: %s\n", name, err)
+		return content, nil
+	}
+
+	return os.ReadFile(lfsObjectPath(object.OID))
+}
+
+// lfsPointersIn returns the LFS objects referenced by pointer blobs among
+// hashes.
+func lfsPointersIn(hashes [][]byte) ([]transport.LFSObject, error) {
+	objects := make([]transport.LFSObject, 0)
+	seen := make(map[string]bool)
+	for _, hash := range hashes {
+		kind, content, err := plumbing.ReadObject(hash)
+		if err != nil {
+			return nil, err
+		}
+		if kind != plumbing.KindBlob {
+			continue
+		}
+
+		object, ok := parseLFSPointer(content)
+		if ok && !seen[object.OID] {
+			seen[object.OID] = true
+			objects = append(objects, object)
+		}
+	}
+
+	return objects, nil
+}
+
+// pushLFSObjects uploads the LFS objects referenced by the commits being
+// pushed before the references are updated, like git-lfs's pre-push hook.
+func pushLFSObjects(remote string, include [][]byte, exclude [][]byte) error {
+	hashes, err := plumbing.ReachableObjects(include, exclude)
+	if err != nil {
+		return err
+	}
+
+	objects, err := lfsPointersIn(hashes)
+	if err != nil {
+		return err
+	}
+
+	local := make([]transport.LFSObject, 0, len(objects))
+	for _, object := range objects {
+		if hasLFSObject(object) {
+			local = append(local, object)
+		}
+	}
+
+	return uploadLFSObjects(remote, local)
+}
+
+// TrackLFS adds patterns to .gitattributes so matching files are stored
+// with LFS.
+func TrackLFS(patterns []string) error {
+	content, err := os.ReadFile(gitAttributesFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	if len(content) == 0 {
+		lines = []string{}
+	}
+
+	for _, pattern := range patterns {
+		line := fmt.Sprintf("%s filter=%s diff=%s merge=%s -text", pattern, lfsFilterName, lfsFilterName, lfsFilterName)
+		tracked := false
+		for _, existing := range lines {
+			if fields := strings.Fields(existing); len(fields) > 0 && fields[0] == pattern {
+				tracked = true
+			}
+		}
+		if !tracked {
+			lines = append(lines, line)
+		}
+	}
+
+	return os.WriteFile(gitAttributesFile, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
+
+// TrackedLFSPatterns lists the patterns of the top-level .gitattributes
+// that use the LFS filter.
+func TrackedLFSPatterns() ([]string, error) {
+	content, err := os.ReadFile(gitAttributesFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	patterns := make([]string, 0)
+	for _, rule := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(rule)
+		for _, field := range fields[min(len(fields), 1):] {
+			if field == "filter="+lfsFilterName {
+				patterns = append(patterns, fields[0])
+			}
+		}
+	}
+
+	return patterns, nil
+}
+
+// LFSFiles lists the files of the index that are LFS pointers.
+func LFSFiles() ([]LFSFile, error) {
+	index, err := plumbing.ReadIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]LFSFile, 0)
+	for _, entry := range index.Entries {
+		if entry.Mode&0xf000 != plumbing.ObjectTypeFile || entry.Stage != 0 {
+			continue
+		}
+
+		content, err := plumbing.ReadObjectOfKind(entry.Hash, plumbing.KindBlob)
+		if err != nil {
+			return nil, err
+		}
+
+		if object, ok := parseLFSPointer(content); ok {
+			files = append(files, LFSFile{Path: entry.Name, Object: object, Local: hasLFSObject(object)})
+		}
+	}
+
+	return files, nil
+}
+
+// PullLFS downloads the LFS objects of the index in one batch and replaces
+// pointer files in the working tree with their content.
+func PullLFS(remote string, progress io.Writer) error {
+	files, err := LFSFiles()
+	if err != nil {
+		return err
+	}
+
+	objects := make([]transport.LFSObject, 0, len(files))
+	for _, file := range files {
+		objects = append(objects, file.Object)
+	}
+
+	err = downloadLFSObjects(remote, objects)
+	if err != nil {
+		return err
+	}
+
+	index, err := plumbing.ReadIndex()
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		content, err := os.ReadFile(file.Path)
+		if err != nil {
+			continue
+		}
+		if _, ok := parseLFSPointer(content); !ok {
+			continue
+		}
+
+		info, err := os.Stat(file.Path)
+		if err != nil {
+			return err
+		}
+
+		content, err = os.ReadFile(lfsObjectPath(file.Object.OID))
+		if err != nil {
+			return err
+		}
+
+		err = os.WriteFile(file.Path, content, info.Mode().Perm())
+		if err != nil {
+			return err
+		}
+
+		if entry, ok := index.Find(file.Path); ok {
+			if info, err := os.Lstat(file.Path); err == nil {
+				*entry = plumbing.NewIndexEntry(entry.Name, entry.Hash, entry.Mode, info)
+			}
+		}
+
+		if progress != nil {
+			fmt.Fprintf(progress, "Downloaded %s\n", file.Path)
+		}
+	}
+
+	return plumbing.WriteIndex(index)
+}
+
+// PushLFS uploads the LFS objects of all local branches that the remote
+// tracking branches do not contain yet.
+func PushLFS(remote string) error {
+	branches, err := plumbing.ListRefs(headsPrefix)
+	if err != nil {
+		return err
+	}
+
+	tracking, err := plumbing.ListRefs(fmt.Sprintf("refs/remotes/%s/", remote))
+	if err != nil {
+		return err
+	}
+
+	include := make([][]byte, 0, len(branches))
+	for _, ref := range branches {
+		include = append(include, ref.Hash)
+	}
+
+	exclude := make([][]byte, 0, len(tracking))
+	for _, ref := range tracking {
+		exclude = append(exclude, ref.Hash)
+	}
+
+	return pushLFSObjects(remote, include, exclude)
+}
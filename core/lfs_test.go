@@ -0,0 +1,61 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/untanky/git-charged/transport"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseLFSPointer(t *testing.T) {
+	oid := strings.Repeat("0123456789abcdef", 4)
+	pointer := func(oid string) []byte {
+		return []byte(lfsPointerVersion + "\noid sha256:" + oid + "\nsize 12\n")
+	}
+
+	object, ok := parseLFSPointer(pointer(oid))
+	if !ok || object.OID != oid || object.Size != 12 {
+		t.Errorf("parseLFSPointer(valid) = %v, %t", object, ok)
+	}
+
+	invalid := []string{
+		"",
+		oid[:63],
+		strings.ToUpper(oid),
+		strings.Repeat("../", 18) + "etc/passwd",
+	}
+	for _, oid := range invalid {
+		if _, ok := parseLFSPointer(pointer(oid)); ok {
+			t.Errorf("parseLFSPointer accepted OID %q", oid)
+		}
+	}
+}
+
+func TestStoreLFSObjectVerifiesContent(t *testing.T) {
+	initTestRepository(t)
+
+	content := "large file\n"
+	hash := sha256.Sum256([]byte(content))
+	object := transport.LFSObject{OID: hex.EncodeToString(hash[:]), Size: int64(len(content))}
+
+	for _, corrupt := range []string{"other file\n", content + "more"} {
+		_, err := storeLFSObject(object, strings.NewReader(corrupt))
+		if err == nil {
+			t.Errorf("storeLFSObject accepted %q", corrupt)
+		}
+		if hasLFSObject(object) {
+			t.Errorf("storeLFSObject stored %q", corrupt)
+		}
+	}
+
+	stored, err := storeLFSObject(object, strings.NewReader(content))
+	if err != nil || stored != object {
+		t.Fatalf("storeLFSObject() = %v, %v", stored, err)
+	}
+	data, err := os.ReadFile(lfsObjectPath(object.OID))
+	if err != nil || string(data) != content {
+		t.Errorf("stored content = %q, %v", data, err)
+	}
+}
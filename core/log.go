@@ -0,0 +1,189 @@
+package core
+
+import (
+	"errors"
+	"github.com/untanky/git-charged/plumbing"
+	"io"
+	"sort"
+	"strings"
+)
+
+type LogParams struct {
+	// Revisions select commits like git log does: a revision includes its
+	// history, ^<rev> excludes the history of rev, and <from>..<to> is a
+	// range. HEAD is used if there are none.
+	Revisions []string
+	// MaxCount limits the number of commits if it is positive.
+	MaxCount int
+	// NoMailmap shows authors and committers as they are recorded.
+	NoMailmap bool
+}
+
+// LogEntry is a commit with its author and committer mapped through the
+// mailmap.
+type LogEntry struct {
+	Hash      []byte
+	Commit    *plumbing.Commit
+	Author    plumbing.AuthorData
+	Committer plumbing.AuthorData
+}
+
+// Format expands a git pretty format such as "%h %s" for the entry.
+func (e LogEntry) Format(format string) string {
+	return formatCommit(format, e.Hash, e.Commit, e.Author, e.Committer)
+}
+
+type ShortlogParams struct {
+	LogParams
+	// Email groups by name and email instead of by name only.
+	Email bool
+	// Numbered sorts by the number of commits instead of by name.
+	Numbered bool
+}
+
+// ShortlogGroup is the work of one author.
+type ShortlogGroup struct {
+	Author   string
+	Subjects []string
+}
+
+// Log lists commits newest first.
+func Log(params LogParams) ([]LogEntry, error) {
+	walker, err := revisionWalker(params.Revisions)
+	if err != nil {
+		return nil, err
+	}
+
+	mailmap := NewMailmap()
+	if !params.NoMailmap {
+		mailmap, err = ReadMailmap()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	entries := make([]LogEntry, 0)
+	for params.MaxCount <= 0 || len(entries) < params.MaxCount {
+		hash, commit, err := walker.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, LogEntry{
+			Hash:      hash,
+			Commit:    commit,
+			Author:    mailmap.Apply(commit.Author),
+			Committer: mailmap.Apply(commit.Committer),
+		})
+	}
+
+	return entries, nil
+}
+
+// Shortlog groups the subjects of commits by author, like git shortlog.
+// The subjects of each author are oldest first.
+func Shortlog(params ShortlogParams) ([]ShortlogGroup, error) {
+	entries, err := Log(params.LogParams)
+	if err != nil {
+		return nil, err
+	}
+
+	byAuthor := make(map[string]*ShortlogGroup)
+	groups := make([]*ShortlogGroup, 0)
+	for i := len(entries) - 1; i >= 0; i-- {
+		author := entries[i].Author.Name
+		if params.Email {
+			author += " <" + entries[i].Author.Email + ">"
+		}
+
+		group, ok := byAuthor[author]
+		if !ok {
+			group = &ShortlogGroup{Author: author}
+			byAuthor[author] = group
+			groups = append(groups, group)
+		}
+
+		subject, _ := splitCommitMessage(entries[i].Commit.Message)
+		group.Subjects = append(group.Subjects, subject)
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		if params.Numbered && len(groups[i].Subjects) != len(groups[j].Subjects) {
+			return len(groups[i].Subjects) > len(groups[j].Subjects)
+		}
+		return groups[i].Author < groups[j].Author
+	})
+
+	result := make([]ShortlogGroup, len(groups))
+	for i, group := range groups {
+		result[i] = *group
+	}
+
+	return result, nil
+}
+
+// revisionWalker walks the commits selected by revision arguments.
+func revisionWalker(revisions []string) (*plumbing.CommitWalker, error) {
+	if len(revisions) == 0 {
+		revisions = []string{plumbing.HEAD}
+	}
+
+	include := make([][]byte, 0, len(revisions))
+	exclude := make([][]byte, 0)
+	for _, revision := range revisions {
+		if excluded, ok := strings.CutPrefix(revision, "^"); ok {
+			hash, err := resolveCommit(excluded)
+			if err != nil {
+				return nil, err
+			}
+			exclude = append(exclude, hash)
+			continue
+		}
+
+		from, to, isRange := strings.Cut(revision, "..")
+		if !isRange {
+			hash, err := resolveCommit(revision)
+			if err != nil {
+				return nil, err
+			}
+			include = append(include, hash)
+			continue
+		}
+
+		if from == "" {
+			from = plumbing.HEAD
+		}
+		if to == "" {
+			to = plumbing.HEAD
+		}
+		fromHash, err := resolveCommit(from)
+		if err != nil {
+			return nil, err
+		}
+		toHash, err := resolveCommit(to)
+		if err != nil {
+			return nil, err
+		}
+		exclude = append(exclude, fromHash)
+		include = append(include, toHash)
+	}
+
+	walker := plumbing.NewCommitWalker()
+	for _, hash := range exclude {
+		err := walker.Hide(hash)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, hash := range include {
+		err := walker.Push(hash)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return walker, nil
+}
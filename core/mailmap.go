@@ -0,0 +1,213 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/untanky/git-charged/config"
+	"github.com/untanky/git-charged/plumbing"
+	"os"
+	"strings"
+)
+
+const mailmapFile = ".mailmap"
+
+// mailmapIdentity is what an identity is mapped to. Empty fields are kept
+// as they are.
+type mailmapIdentity struct {
+	name  string
+	email string
+}
+
+// mailmapEntry holds the mappings for one email address: one for any name
+// and one for each name it was used with.
+type mailmapEntry struct {
+	fallback *mailmapIdentity
+	byName   map[string]mailmapIdentity
+}
+
+// Mailmap maps the names and emails recorded in commits to canonical
+// identities, as described by .mailmap files. Emails and names are
+// compared case-insensitively.
+type Mailmap struct {
+	entries map[string]*mailmapEntry
+}
+
+func NewMailmap() *Mailmap {
+	return &Mailmap{entries: make(map[string]*mailmapEntry)}
+}
+
+// ReadMailmap reads the .mailmap file of the working tree, or of HEAD in a
+// bare repository, then mailmap.blob and mailmap.file. Later files take
+// precedence.
+func ReadMailmap() (*Mailmap, error) {
+	mailmap := NewMailmap()
+
+	bare, _ := config.Get("core.bare")
+	if bare != "true" {
+		content, err := os.ReadFile(mailmapFile)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("cannot read %s: %w", mailmapFile, err)
+		}
+		mailmap.Parse(content)
+	}
+
+	blob, ok := config.Get("mailmap.blob")
+	if !ok && bare == "true" {
+		blob = plumbing.HEAD + ":" + mailmapFile
+	}
+	if blob != "" {
+		content, err := readRevisionFile(blob)
+		// A missing default blob is not an error, but a configured one is.
+		if err != nil && ok {
+			return nil, fmt.Errorf("cannot read mailmap.blob %s: %w", blob, err)
+		}
+		mailmap.Parse(content)
+	}
+
+	if filename, ok := config.Get("mailmap.file"); ok {
+		content, err := os.ReadFile(expandHome(filename))
+		if err != nil {
+			return nil, fmt.Errorf("cannot read mailmap.file: %w", err)
+		}
+		mailmap.Parse(content)
+	}
+
+	return mailmap, nil
+}
+
+// readRevisionFile reads a file from a revision given as <rev>:<path>.
+func readRevisionFile(spec string) ([]byte, error) {
+	revision, name, ok := strings.Cut(spec, ":")
+	if !ok {
+		return nil, fmt.Errorf("%s is not of the form <rev>:<path>", spec)
+	}
+
+	hash, err := ResolveRevision(revision)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := peelTo(hash, plumbing.KindTree)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := findTreeEntry(tree, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return plumbing.ReadObjectOfKind(entry.Hash, plumbing.KindBlob)
+}
+
+// Parse adds the lines of a mailmap file, which have one of the forms
+//
+//	Proper Name <commit@email>
+//	<proper@email> <commit@email>
+//	Proper Name <proper@email> <commit@email>
+//	Proper Name <proper@email> Commit Name <commit@email>
+func (m *Mailmap) Parse(content []byte) {
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		properName, properEmail, rest, ok := parseMailmapIdentity(line)
+		if !ok {
+			continue
+		}
+
+		commitName, commitEmail, _, ok := parseMailmapIdentity(rest)
+		if !ok {
+			// The only email is the one in commits, and only the name is
+			// replaced.
+			commitName, commitEmail, properEmail = "", properEmail, ""
+		}
+
+		m.add(mailmapIdentity{name: properName, email: properEmail}, commitName, commitEmail)
+	}
+}
+
+// parseMailmapIdentity reads an optional name followed by an email in
+// angle brackets, and returns what follows them.
+func parseMailmapIdentity(line string) (string, string, string, bool) {
+	start := strings.IndexByte(line, '<')
+	if start < 0 {
+		return "", "", "", false
+	}
+	end := strings.IndexByte(line[start:], '>')
+	if end < 0 {
+		return "", "", "", false
+	}
+	end += start
+
+	return strings.TrimSpace(line[:start]), line[start+1 : end], line[end+1:], true
+}
+
+func (m *Mailmap) add(proper mailmapIdentity, commitName string, commitEmail string) {
+	key := strings.ToLower(commitEmail)
+	entry, ok := m.entries[key]
+	if !ok {
+		entry = &mailmapEntry{byName: make(map[string]mailmapIdentity)}
+		m.entries[key] = entry
+	}
+
+	// Like git, a later line only replaces the parts it gives.
+	var mapped mailmapIdentity
+	if commitName == "" {
+		if entry.fallback == nil {
+			entry.fallback = &mailmapIdentity{}
+		}
+		mapped = *entry.fallback
+	} else {
+		mapped = entry.byName[strings.ToLower(commitName)]
+	}
+
+	if proper.name != "" {
+		mapped.name = proper.name
+	}
+	if proper.email != "" {
+		mapped.email = proper.email
+	}
+
+	if commitName == "" {
+		*entry.fallback = mapped
+	} else {
+		entry.byName[strings.ToLower(commitName)] = mapped
+	}
+}
+
+// Map returns the canonical identity for a name and email. A mapping for
+// the exact name takes precedence over one for the email alone.
+func (m *Mailmap) Map(name string, email string) (string, string) {
+	entry, ok := m.entries[strings.ToLower(email)]
+	if !ok {
+		return name, email
+	}
+
+	proper, ok := entry.byName[strings.ToLower(name)]
+	if !ok {
+		if entry.fallback == nil {
+			return name, email
+		}
+		proper = *entry.fallback
+	}
+
+	if proper.name != "" {
+		name = proper.name
+	}
+	if proper.email != "" {
+		email = proper.email
+	}
+	return name, email
+}
+
+// Apply returns an identity with its name and email mapped, keeping the
+// timestamp.
+func (m *Mailmap) Apply(identity plumbing.AuthorData) plumbing.AuthorData {
+	identity.Name, identity.Email = m.Map(identity.Name, identity.Email)
+	return identity
+}
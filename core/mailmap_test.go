@@ -0,0 +1,90 @@
+package core
+
+import (
+	"github.com/untanky/git-charged/plumbing"
+	"os"
+	"testing"
+	"time"
+)
+
+// The expected identities are the output of git check-mailmap for the same
+// mailmap.
+func TestMailmapMap(t *testing.T) {
+	mailmap := NewMailmap()
+	mailmap.Parse([]byte(`# comment
+Proper Name <commit@example.com>
+<proper@example.com> <old@example.com>
+Joe Dev <joe@example.com> <JOE@Example.COM>
+Jane Doe <jane@example.com> jane <shared@example.com>
+John Roe <john@example.com> John <shared@example.com>
+Other Name <other@example.com>
+<other-new@example.com> <other@example.com>
+not an identity
+`))
+
+	tests := []struct {
+		name  string
+		email string
+		want  string
+	}{
+		{name: "Someone", email: "commit@example.com", want: "Proper Name <commit@example.com>"},
+		{name: "Old", email: "old@example.com", want: "Old <proper@example.com>"},
+		{name: "Joe", email: "joe@EXAMPLE.com", want: "Joe Dev <joe@example.com>"},
+		{name: "Jane", email: "shared@example.com", want: "Jane Doe <jane@example.com>"},
+		{name: "JOHN", email: "shared@example.com", want: "John Roe <john@example.com>"},
+		{name: "Nobody", email: "shared@example.com", want: "Nobody <shared@example.com>"},
+		{name: "X", email: "other@example.com", want: "Other Name <other-new@example.com>"},
+		{name: "Unknown", email: "unknown@example.com", want: "Unknown <unknown@example.com>"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name+" <"+test.email+">", func(t *testing.T) {
+			name, email := mailmap.Map(test.name, test.email)
+			if mapped := name + " <" + email + ">"; mapped != test.want {
+				t.Errorf("Map(%q, %q) = %s, want %s", test.name, test.email, mapped, test.want)
+			}
+		})
+	}
+}
+
+func TestMailmapApplyKeepsTheTimestamp(t *testing.T) {
+	mailmap := NewMailmap()
+	mailmap.Parse([]byte("Proper Name <proper@example.com> <commit@example.com>\n"))
+
+	identity := plumbing.AuthorData{Name: "Name", Email: "commit@example.com", Timestamp: time.Unix(1700000000, 0)}
+	mapped := mailmap.Apply(identity)
+	if mapped.Name != "Proper Name" || mapped.Email != "proper@example.com" || !mapped.Timestamp.Equal(identity.Timestamp) {
+		t.Errorf("Apply(%+v) = %+v", identity, mapped)
+	}
+}
+
+func TestReadMailmap(t *testing.T) {
+	initTestRepository(t)
+	files := map[string]string{
+		mailmapFile: "From File <file@example.com> <commit@example.com>\n",
+		"extra":     "From Config <config@example.com> <commit@example.com>\n",
+	}
+	for name, content := range files {
+		err := os.WriteFile(name, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mailmap, err := ReadMailmap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, _ := mailmap.Map("Name", "commit@example.com"); name != "From File" {
+		t.Errorf("Map() = %q with .mailmap, want %q", name, "From File")
+	}
+
+	setTestConfig(t, map[string]string{"mailmap.file": "extra"})
+	mailmap, err = ReadMailmap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, _ := mailmap.Map("Name", "commit@example.com"); name != "From Config" {
+		t.Errorf("Map() = %q with mailmap.file, want %q", name, "From Config")
+	}
+}
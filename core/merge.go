@@ -0,0 +1,418 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/untanky/git-charged/config"
+	"github.com/untanky/git-charged/plumbing"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+const (
+	conflictMarkerOurs   = "<<<<<<<"
+	conflictMarkerBase   = "|||||||"
+	conflictMarkerSplit  = "======="
+	conflictMarkerTheirs = ">>>>>>>"
+
+	// binaryCheckSize is how much of a file is searched for NUL bytes to
+	// decide whether it is binary, like git does.
+	binaryCheckSize = 8000
+)
+
+// isBinary reports whether content has a NUL byte in its first bytes.
+// Binary files are never merged line by line, converted or searched.
+func isBinary(content []byte) bool {
+	return bytes.IndexByte(content[:min(len(content), binaryCheckSize)], 0) >= 0
+}
+
+// ConflictError is returned when changes cannot be merged automatically.
+// The conflicts are left in the index and the working tree.
+type ConflictError struct {
+	Paths []string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("conflicts in %s", strings.Join(e.Paths, ", "))
+}
+
+// mergeLabels name the three sides of a merge in conflict markers.
+type mergeLabels struct {
+	base   string
+	ours   string
+	theirs string
+}
+
+type mergeConflict struct {
+	base   fileVersion
+	ours   fileVersion
+	theirs fileVersion
+	// content is the file with conflict markers, or nil if the file cannot
+	// be merged line by line.
+	content []byte
+}
+
+// treeMerge is the result of a three-way merge of trees. Clean paths are
+// resolved; conflicting paths need to be resolved by the user.
+type treeMerge struct {
+	clean     map[string]fileVersion
+	conflicts map[string]mergeConflict
+}
+
+func (m *treeMerge) conflictPaths() []string {
+	paths := make([]string, 0, len(m.conflicts))
+	for name := range m.conflicts {
+		paths = append(paths, name)
+	}
+	sort.Strings(paths)
+
+	return paths
+}
+
+// mergeTrees merges the changes from base to theirs into ours, path by
+// path. Files changed on both sides are merged line by line.
+func mergeTrees(base []byte, ours []byte, theirs []byte, labels mergeLabels) (*treeMerge, error) {
+	baseVersions, err := treeVersions(base)
+	if err != nil {
+		return nil, err
+	}
+	ourVersions, err := treeVersions(ours)
+	if err != nil {
+		return nil, err
+	}
+	theirVersions, err := treeVersions(theirs)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make(map[string]bool)
+	for _, versions := range []map[string]fileVersion{baseVersions, ourVersions, theirVersions} {
+		for name := range versions {
+			paths[name] = true
+		}
+	}
+
+	merge := &treeMerge{clean: make(map[string]fileVersion), conflicts: make(map[string]mergeConflict)}
+	for name := range paths {
+		b, o, t := baseVersions[name], ourVersions[name], theirVersions[name]
+
+		var result fileVersion
+		switch {
+		case o.equal(t):
+			result = o
+		case b.equal(o):
+			result = t
+		case b.equal(t):
+			result = o
+		default:
+			conflict := mergeConflict{base: b, ours: o, theirs: t}
+			if o.exists() && t.exists() && isRegularFile(o.mode) && isRegularFile(t.mode) {
+				var clean bool
+				result, conflict.content, clean, err = mergeFiles(b, o, t, labels)
+				if err != nil {
+					return nil, fmt.Errorf("cannot merge %s: %w", name, err)
+				}
+				if clean {
+					break
+				}
+			}
+			merge.conflicts[name] = conflict
+			continue
+		}
+
+		if result.exists() {
+			merge.clean[name] = result
+		}
+	}
+
+	return merge, nil
+}
+
+func isRegularFile(mode uint32) bool {
+	return mode&0xf000 == 0x8000
+}
+
+// mergeFiles merges two versions of a file line by line. If the merge has
+// conflicts, the content with conflict markers is returned instead of a
+// version.
+func mergeFiles(base fileVersion, ours fileVersion, theirs fileVersion, labels mergeLabels) (fileVersion, []byte, bool, error) {
+	contents := make([][]byte, 3)
+	for i, version := range []fileVersion{base, ours, theirs} {
+		if !version.exists() {
+			continue
+		}
+
+		content, err := plumbing.ReadObjectOfKind(version.hash, plumbing.KindBlob)
+		if err != nil {
+			return fileVersion{}, nil, false, err
+		}
+		if isBinary(content) {
+			return fileVersion{}, nil, false, nil
+		}
+		contents[i] = content
+	}
+
+	style, _ := config.Get("merge.conflictStyle")
+	merged, clean := mergeLines(splitRawLines(contents[0]), splitRawLines(contents[1]), splitRawLines(contents[2]), labels, style == "diff3" || style == "zdiff3")
+	content := []byte(strings.Join(merged, ""))
+	if !clean {
+		return fileVersion{}, content, false, nil
+	}
+
+	hash, err := plumbing.WriteObject(plumbing.NewBlob(uint32(len(content)), bytes.NewReader(content)))
+	if err != nil {
+		return fileVersion{}, nil, false, err
+	}
+
+	// A mode change on one side is kept.
+	mode := ours.mode
+	if base.exists() && base.mode == ours.mode {
+		mode = theirs.mode
+	}
+
+	return fileVersion{hash: hash, mode: mode}, nil, true, nil
+}
+
+// splitRawLines splits content into lines that keep their line endings, so
+// that joining them restores the content exactly.
+func splitRawLines(content []byte) []string {
+	if len(content) == 0 {
+		return []string{}
+	}
+
+	lines := strings.SplitAfter(string(content), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// mergeLines is a diff3 merge: the lines both sides kept from base split
+// the files into chunks, and a chunk changed on both sides in different
+// ways is a conflict.
+func mergeLines(base []string, ours []string, theirs []string, labels mergeLabels, showBase bool) ([]string, bool) {
+	ourLines := make(map[int]int)
+	for _, match := range diffLines(base, ours) {
+		ourLines[match.old] = match.new
+	}
+	theirLines := make(map[int]int)
+	for _, match := range diffLines(base, theirs) {
+		theirLines[match.old] = match.new
+	}
+
+	merged := make([]string, 0, max(len(ours), len(theirs)))
+	clean := true
+	i, a, b := 0, 0, 0
+	for {
+		j := i
+		for j < len(base) {
+			_, inOurs := ourLines[j]
+			_, inTheirs := theirLines[j]
+			if inOurs && inTheirs {
+				break
+			}
+			j++
+		}
+
+		endA, endB := len(ours), len(theirs)
+		if j < len(base) {
+			endA, endB = ourLines[j], theirLines[j]
+		}
+
+		baseChunk, ourChunk, theirChunk := base[i:j], ours[a:endA], theirs[b:endB]
+		switch {
+		case equalLines(ourChunk, baseChunk):
+			merged = append(merged, theirChunk...)
+		case equalLines(theirChunk, baseChunk) || equalLines(ourChunk, theirChunk):
+			merged = append(merged, ourChunk...)
+		default:
+			clean = false
+			merged = appendConflictSection(merged, conflictMarkerOurs+" "+labels.ours, ourChunk)
+			if showBase {
+				merged = appendConflictSection(merged, conflictMarkerBase+" "+labels.base, baseChunk)
+			}
+			merged = appendConflictSection(merged, conflictMarkerSplit, theirChunk)
+			merged = append(merged, conflictMarkerTheirs+" "+labels.theirs+"\n")
+		}
+
+		if j == len(base) {
+			return merged, clean
+		}
+
+		merged = append(merged, ours[endA])
+		i, a, b = j+1, endA+1, endB+1
+	}
+}
+
+// appendConflictSection adds a conflict marker and the lines of one side.
+// A missing newline at the end of the file would swallow the next marker,
+// so one is added.
+func appendConflictSection(merged []string, marker string, lines []string) []string {
+	merged = append(merged, marker+"\n")
+	merged = append(merged, lines...)
+	if len(lines) > 0 && !strings.HasSuffix(lines[len(lines)-1], "\n") {
+		merged[len(merged)-1] += "\n"
+	}
+	return merged
+}
+
+func equalLines(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// applyMerge writes the result of a merge into the index and the working
+// tree, which must match the tree ours. Conflicting paths are recorded in
+// stages 1 to 3 of the index, and their working tree files contain the
+// conflict markers, or the version that was not deleted.
+func applyMerge(merge *treeMerge, ours []byte) error {
+	previous, err := plumbing.ReadIndex()
+	if err != nil {
+		return fmt.Errorf("cannot read index: %w", err)
+	}
+
+	current, err := treeVersions(ours)
+	if err != nil {
+		return err
+	}
+
+	written := make([]string, 0, len(merge.conflicts))
+	for name, version := range merge.clean {
+		if _, ok := previous.Find(name); !ok || !version.equal(current[name]) {
+			written = append(written, name)
+		}
+	}
+	for name := range merge.conflicts {
+		written = append(written, name)
+	}
+	err = checkUntrackedPaths(written, previous)
+	if err != nil {
+		return err
+	}
+
+	// Removed files go first, so that directories and files may replace
+	// each other.
+	for name := range current {
+		_, clean := merge.clean[name]
+		_, conflicting := merge.conflicts[name]
+		if !clean && !conflicting {
+			err = removeWorkingFile(name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	filter := newContentFilter(workingTreeAttributes())
+	index := &plumbing.Index{Entries: make([]plumbing.IndexEntry, 0, len(merge.clean))}
+
+	for name, version := range merge.clean {
+		if entry, ok := previous.Find(name); ok && version.equal(current[name]) {
+			index.Entries = append(index.Entries, *entry)
+			continue
+		}
+
+		err = writeWorkingVersion(name, version, filter)
+		if err != nil {
+			return err
+		}
+		setIndexEntry(index, name, version, true)
+	}
+
+	for name, conflict := range merge.conflicts {
+		switch {
+		case conflict.content != nil:
+			err = createParentDirectories(name)
+			if err == nil {
+				err = removeInTheWay(name)
+			}
+			if err == nil {
+				err = os.WriteFile(name, conflict.content, os.FileMode(0644|conflict.ours.mode&0111))
+			}
+		case conflict.ours.exists():
+			err = writeWorkingVersion(name, conflict.ours, filter)
+		case conflict.theirs.exists():
+			err = writeWorkingVersion(name, conflict.theirs, filter)
+		}
+		if err != nil {
+			return err
+		}
+
+		for stage, version := range []fileVersion{conflict.base, conflict.ours, conflict.theirs} {
+			if version.exists() {
+				index.Entries = append(index.Entries, plumbing.IndexEntry{Name: name, Hash: version.hash, Mode: version.mode, Stage: stage + 1})
+			}
+		}
+	}
+
+	index.Sort()
+	return plumbing.WriteIndex(index)
+}
+
+func writeWorkingVersion(name string, version fileVersion, filter *contentFilter) error {
+	err := createParentDirectories(name)
+	if err != nil {
+		return err
+	}
+
+	entry := plumbing.TreeEntry{Name: path.Base(name), Mode: uint16(version.mode), Hash: version.hash}
+	err = checkoutEntry(name, entry, filter)
+	if err != nil {
+		return fmt.Errorf("cannot check out %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// resolveConflicts stages the working tree version of every path that is
+// still unmerged. Files that still contain conflict markers are refused.
+func resolveConflicts(index *plumbing.Index) error {
+	unmerged := make([]string, 0)
+	for _, entry := range index.Entries {
+		if entry.Stage != 0 && (len(unmerged) == 0 || unmerged[len(unmerged)-1] != entry.Name) {
+			unmerged = append(unmerged, entry.Name)
+		}
+	}
+
+	filter := newContentFilter(workingTreeAttributes())
+	for _, name := range unmerged {
+		removeIndexEntry(index, name)
+
+		content, err := os.ReadFile(name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if hasConflictMarkers(content) {
+			return fmt.Errorf("%s still contains conflict markers", name)
+		}
+
+		hash, mode, _, err := hashWorkingPath(name, filter)
+		if err != nil {
+			return err
+		}
+		setIndexEntry(index, name, fileVersion{hash: hash, mode: uint32(mode)}, true)
+	}
+
+	return nil
+}
+
+func hasConflictMarkers(content []byte) bool {
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.HasPrefix(line, conflictMarkerOurs+" ") || strings.HasPrefix(line, conflictMarkerTheirs+" ") {
+			return true
+		}
+	}
+	return false
+}
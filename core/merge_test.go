@@ -0,0 +1,92 @@
+package core
+
+import (
+	"strings"
+	"testing"
+)
+
+// The expected results are the output of git merge-file -p with the labels
+// ours, base and theirs for the same files.
+func TestMergeLines(t *testing.T) {
+	tests := []struct {
+		name     string
+		base     string
+		ours     string
+		theirs   string
+		showBase bool
+		merged   string
+		clean    bool
+	}{
+		{
+			name:   "changes apart",
+			base:   "a\nb\nc\nd\ne\n",
+			ours:   "a\nB\nc\nd\ne\n",
+			theirs: "a\nb\nc\nD\ne\n",
+			merged: "a\nB\nc\nD\ne\n",
+			clean:  true,
+		},
+		{
+			name:   "same change",
+			base:   "a\nb\nc\n",
+			ours:   "a\nX\nc\n",
+			theirs: "a\nX\nc\n",
+			merged: "a\nX\nc\n",
+			clean:  true,
+		},
+		{
+			name:   "removed and added",
+			base:   "a\nb\nc\n",
+			ours:   "a\nc\n",
+			theirs: "a\nb\nc\nd\n",
+			merged: "a\nc\nd\n",
+			clean:  true,
+		},
+		{
+			name:   "only theirs changed",
+			base:   "a\n",
+			ours:   "a\n",
+			theirs: "",
+			merged: "",
+			clean:  true,
+		},
+		{
+			name:   "conflict",
+			base:   "a\nb\nc\n",
+			ours:   "a\nX\nc\n",
+			theirs: "a\nY\nc\n",
+			merged: "a\n<<<<<<< ours\nX\n=======\nY\n>>>>>>> theirs\nc\n",
+		},
+		{
+			name:     "conflict with the base",
+			base:     "a\nb\nc\n",
+			ours:     "a\nX\nc\n",
+			theirs:   "a\nY\nc\n",
+			showBase: true,
+			merged:   "a\n<<<<<<< ours\nX\n||||||| base\nb\n=======\nY\n>>>>>>> theirs\nc\n",
+		},
+		{
+			name:   "adjacent changes",
+			base:   "a\nb\nc\n",
+			ours:   "a\nB\nc\n",
+			theirs: "a\nb\nC\n",
+			merged: "a\n<<<<<<< ours\nB\nc\n=======\nb\nC\n>>>>>>> theirs\n",
+		},
+		{
+			name:   "no newline at the end",
+			base:   "a\nb",
+			ours:   "a\nX",
+			theirs: "a\nY",
+			merged: "a\n<<<<<<< ours\nX\n=======\nY\n>>>>>>> theirs\n",
+		},
+	}
+
+	labels := mergeLabels{base: "base", ours: "ours", theirs: "theirs"}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			merged, clean := mergeLines(splitRawLines([]byte(test.base)), splitRawLines([]byte(test.ours)), splitRawLines([]byte(test.theirs)), labels, test.showBase)
+			if result := strings.Join(merged, ""); result != test.merged || clean != test.clean {
+				t.Errorf("mergeLines() = %q, %t, want %q, %t", result, clean, test.merged, test.clean)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/untanky/git-charged/config"
+	"github.com/untanky/git-charged/plumbing"
+	"github.com/untanky/git-charged/transport"
+	"path"
+)
+
+func init() {
+	plumbing.SetMissingObjectHandler(fetchPromisedObjects)
+}
+
+// fetchPromisedObjects lazily downloads objects that were left out of a
+// partial clone from the promisor remote.
+func fetchPromisedObjects(hashes [][]byte) error {
+	remote, ok := config.Get("extensions.partialclone")
+	if !ok {
+		return nil
+	}
+
+	url, ok := config.Get(fmt.Sprintf("remote.%s.url", remote))
+	if !ok {
+		return fmt.Errorf("no url configured for promisor remote %s", remote)
+	}
+
+	endpoint, err := transport.ParseEndpoint(url)
+	if err != nil {
+		return err
+	}
+
+	session, err := transport.NewUploadPackSession(endpoint)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	pack := bytes.NewBuffer(make([]byte, 0, 64*1024))
+	_, err = session.Fetch(transport.FetchRequest{
+		Wants: hashes,
+		Pack:  pack,
+	})
+	if err != nil {
+		return err
+	}
+
+	checksum, err := plumbing.IndexPack(pack)
+	if err != nil {
+		return err
+	}
+
+	return plumbing.MarkPromisorPack(checksum)
+}
+
+// prefetchTree downloads all blobs of a tree that are missing from a
+// partial clone in a single request instead of one request per file. In a
+// sparse checkout only the blobs inside the cone are needed.
+func prefetchTree(treeHash []byte, cone *sparseCone) error {
+	if !config.Has("extensions.partialclone") {
+		return nil
+	}
+
+	blobs := make([][]byte, 0)
+	err := collectTreeBlobs(treeHash, "", cone, &blobs)
+	if err != nil {
+		return err
+	}
+
+	return plumbing.FetchMissingObjects(blobs)
+}
+
+func collectTreeBlobs(treeHash []byte, prefix string, cone *sparseCone, blobs *[][]byte) error {
+	tree, err := plumbing.ReadTree(treeHash)
+	if err != nil {
+		return fmt.Errorf("cannot read tree %x: %w", treeHash, err)
+	}
+
+	for _, entry := range tree.Entries() {
+		name := path.Join(prefix, entry.Name)
+		switch {
+		case entry.IsGitLink():
+			continue
+		case entry.IsDirectory():
+			if cone != nil && !cone.includesDirectory(name) {
+				continue
+			}
+			err = collectTreeBlobs(entry.Hash, name, cone, blobs)
+			if err != nil {
+				return err
+			}
+		case cone == nil || cone.includes(name):
+			*blobs = append(*blobs, entry.Hash)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,460 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/untanky/git-charged/config"
+	"github.com/untanky/git-charged/plumbing"
+	"github.com/untanky/git-charged/transport"
+	"io"
+	"os"
+	"strings"
+)
+
+var ErrPushRejected = errors.New("failed to push some refs")
+
+type PushParams struct {
+	Remote   string
+	RefSpecs []string
+	Force    bool
+	// Leases maps remote references to the object id they are expected to
+	// have before the push. An empty value expects the remote-tracking
+	// reference. A lease on "*" applies to every pushed reference.
+	Leases map[string]string
+	Atomic bool
+	// NoVerify skips the pre-push hook.
+	NoVerify bool
+	Progress io.Writer
+}
+
+type pushUpdate struct {
+	transport.RefUpdate
+	source string
+	force  bool
+	// forced reports that the update was allowed by force or a lease
+	// although it is not a fast-forward.
+	forced bool
+	status string
+}
+
+func Push(params PushParams) ([]transport.RefStatus, error) {
+	progress := params.Progress
+	if progress == nil {
+		progress = io.Discard
+	}
+
+	url, err := pushURL(params.Remote)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint, err := transport.ParseEndpoint(url)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := transport.NewReceivePackSession(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	remoteRefs := make(map[string][]byte)
+	for _, ref := range session.Refs() {
+		remoteRefs[ref.Name] = ref.Hash
+	}
+
+	updates, err := planPush(params, remoteRefs)
+	if err != nil {
+		return nil, err
+	}
+
+	accepted := make([]transport.RefUpdate, 0, len(updates))
+	rejected := false
+	for _, update := range updates {
+		if update.status == "" {
+			accepted = append(accepted, update.RefUpdate)
+		} else if update.status != "up to date" {
+			rejected = true
+		}
+	}
+
+	fmt.Fprintf(progress, "To %s\n", endpoint)
+
+	if rejected && params.Atomic {
+		reportPush(progress, params.Remote, updates, nil)
+		return nil, fmt.Errorf("%w: atomic push rejected", ErrPushRejected)
+	}
+
+	if len(accepted) > 0 && !params.NoVerify {
+		err = runPrePushHook(params.Remote, url, updates)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrPushRejected, err)
+		}
+	}
+
+	statuses := make([]transport.RefStatus, 0)
+	if len(accepted) > 0 {
+		err = pushLFSObjects(params.Remote, pushedCommits(accepted), knownRemoteCommits(remoteRefs))
+		if err != nil {
+			return nil, fmt.Errorf("cannot upload LFS objects: %w", err)
+		}
+
+		statuses, err = session.Push(transport.PushRequest{
+			Updates: accepted,
+			Atomic:  params.Atomic,
+			WritePack: func(w io.Writer) error {
+				return writePushPack(w, accepted, remoteRefs, progress)
+			},
+			Progress: params.Progress,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	reportPush(progress, params.Remote, updates, statuses)
+
+	for _, status := range statuses {
+		if !status.OK {
+			rejected = true
+			continue
+		}
+
+		err = updateTrackingRef(params.Remote, status.Name, updates)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if rejected {
+		return statuses, ErrPushRejected
+	}
+
+	return statuses, nil
+}
+
+// pushURL returns the url to push to. Like git push <url>, a url or path
+// that is not the name of a remote is pushed to directly.
+func pushURL(remote string) (string, error) {
+	for _, key := range []string{"remote.%s.pushurl", "remote.%s.url"} {
+		if url, ok := config.Get(fmt.Sprintf(key, remote)); ok {
+			return url, nil
+		}
+	}
+
+	if isRemoteURL(remote) {
+		return remote, nil
+	}
+
+	return "", fmt.Errorf("no url configured for remote %s", remote)
+}
+
+// isRemoteURL reports whether a remote that is not configured names a
+// repository directly by its url or path.
+func isRemoteURL(remote string) bool {
+	if strings.ContainsAny(remote, ":/") {
+		return true
+	}
+
+	_, err := os.Stat(remote)
+	return err == nil
+}
+
+func planPush(params PushParams, remoteRefs map[string][]byte) ([]*pushUpdate, error) {
+	refSpecs := params.RefSpecs
+	if len(refSpecs) == 0 {
+		branch, ok := currentBranch()
+		if !ok {
+			return nil, errors.New("you are not currently on a branch")
+		}
+
+		destination := headsPrefix + branch
+		if remote, ok := config.Get(fmt.Sprintf("branch.%s.remote", branch)); ok && remote == params.Remote {
+			if merge, ok := config.Get(fmt.Sprintf("branch.%s.merge", branch)); ok {
+				destination = merge
+			}
+		}
+		refSpecs = []string{headsPrefix + branch + ":" + destination}
+	}
+
+	updates := make([]*pushUpdate, 0, len(refSpecs))
+	for _, refSpec := range refSpecs {
+		update, err := parsePushRefSpec(refSpec, remoteRefs)
+		if err != nil {
+			return nil, err
+		}
+		update.force = update.force || params.Force
+		update.Old = remoteRefs[update.Name]
+
+		update.status, err = checkPushUpdate(params, update)
+		if err != nil {
+			return nil, err
+		}
+
+		updates = append(updates, update)
+	}
+
+	return updates, nil
+}
+
+func parsePushRefSpec(refSpec string, remoteRefs map[string][]byte) (*pushUpdate, error) {
+	update := &pushUpdate{}
+	if strings.HasPrefix(refSpec, "+") {
+		update.force = true
+		refSpec = strings.TrimPrefix(refSpec, "+")
+	}
+
+	source, destination, hasDestination := strings.Cut(refSpec, ":")
+	update.source = source
+
+	if source != "" {
+		name, ok := ExpandRefName(source)
+		if ok && !strings.HasPrefix(name, "refs/remotes/") && name != plumbing.HEAD {
+			source = name
+		}
+
+		hash, err := ResolveRevision(source)
+		if err != nil {
+			return nil, fmt.Errorf("src refspec %s does not match any", update.source)
+		}
+		update.New = hash
+
+		if !hasDestination {
+			if !strings.HasPrefix(source, "refs/") {
+				return nil, fmt.Errorf("cannot determine destination for %s", update.source)
+			}
+			destination = source
+		}
+	}
+
+	if !strings.HasPrefix(destination, "refs/") {
+		switch {
+		case remoteRefs[headsPrefix+destination] != nil:
+			destination = headsPrefix + destination
+		case remoteRefs[tagsPrefix+destination] != nil:
+			destination = tagsPrefix + destination
+		case strings.HasPrefix(source, tagsPrefix):
+			destination = tagsPrefix + destination
+		default:
+			destination = headsPrefix + destination
+		}
+	}
+	update.Name = destination
+
+	return update, nil
+}
+
+// checkPushUpdate applies the client side rules git enforces before
+// sending an update: fast-forward only unless forced, and leases.
+func checkPushUpdate(params PushParams, update *pushUpdate) (string, error) {
+	if bytes.Equal(update.Old, update.New) {
+		return "up to date", nil
+	}
+
+	reason, err := pushRejectReason(update)
+	if err != nil {
+		return "", err
+	}
+
+	expected, hasLease := params.Leases[update.Name]
+	if !hasLease {
+		expected, hasLease = params.Leases["*"]
+	}
+	if hasLease {
+		var expectedHash []byte
+		if expected != "" {
+			hash, err := ResolveRevision(expected)
+			if err != nil {
+				return "", err
+			}
+			expectedHash = hash
+		} else if tracking, ok := trackingRefName(params.Remote, update.Name); ok {
+			expectedHash, _ = plumbing.ResolveRef(tracking)
+		}
+
+		if !bytes.Equal(expectedHash, update.Old) {
+			return "stale info", nil
+		}
+		update.forced = reason != ""
+		return "", nil
+	}
+
+	if update.force {
+		update.forced = reason != ""
+		return "", nil
+	}
+
+	return reason, nil
+}
+
+// pushRejectReason returns why an update would be rejected without force,
+// or the empty string for fast-forwards, creations and deletions.
+func pushRejectReason(update *pushUpdate) (string, error) {
+	if update.Old == nil || update.New == nil {
+		return "", nil
+	}
+
+	if strings.HasPrefix(update.Name, tagsPrefix) {
+		return "already exists", nil
+	}
+
+	if !plumbing.HasObject(update.Old) {
+		return "fetch first", nil
+	}
+
+	fastForward, err := plumbing.IsAncestor(update.Old, update.New)
+	if err != nil {
+		return "", err
+	}
+	if !fastForward {
+		return "non-fast-forward", nil
+	}
+
+	return "", nil
+}
+
+// runPrePushHook gives the pre-push hook the remote and its url as
+// arguments and a line for every reference about to be updated on stdin.
+func runPrePushHook(remote string, url string, updates []*pushUpdate) error {
+	var input strings.Builder
+	for _, update := range updates {
+		if update.status != "" {
+			continue
+		}
+
+		source := "(delete)"
+		if update.source != "" {
+			source = update.source
+			if name, ok := ExpandRefName(update.source); ok {
+				source = name
+			}
+		}
+		fmt.Fprintf(&input, "%s %s %s %s\n", source, hookObjectName(update.New), update.Name, hookObjectName(update.Old))
+	}
+
+	return runHook(hookPrePush, strings.NewReader(input.String()), remote, url)
+}
+
+func pushedCommits(updates []transport.RefUpdate) [][]byte {
+	include := make([][]byte, 0, len(updates))
+	for _, update := range updates {
+		if update.New != nil {
+			include = append(include, update.New)
+		}
+	}
+
+	return include
+}
+
+func knownRemoteCommits(remoteRefs map[string][]byte) [][]byte {
+	exclude := make([][]byte, 0, len(remoteRefs))
+	for _, hash := range remoteRefs {
+		if plumbing.HasObject(hash) {
+			exclude = append(exclude, hash)
+		}
+	}
+
+	return exclude
+}
+
+func writePushPack(w io.Writer, updates []transport.RefUpdate, remoteRefs map[string][]byte, progress io.Writer) error {
+	objects, err := plumbing.ReachableObjects(pushedCommits(updates), knownRemoteCommits(remoteRefs))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(progress, "Enumerating objects: %d, done.\n", len(objects))
+
+	packWriter, err := plumbing.NewPackWriter(w, len(objects))
+	if err != nil {
+		return err
+	}
+
+	for i, hash := range objects {
+		err = packWriter.Add(hash)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(progress, "Writing objects: %3d%% (%d/%d)\r", (i+1)*100/len(objects), i+1, len(objects))
+	}
+
+	_, err = packWriter.Close()
+	if err != nil {
+		return err
+	}
+
+	if len(objects) > 0 {
+		fmt.Fprintf(progress, "Writing objects: 100%% (%d/%d), done.\n", len(objects), len(objects))
+	}
+
+	return nil
+}
+
+func reportPush(progress io.Writer, remote string, updates []*pushUpdate, statuses []transport.RefStatus) {
+	results := make(map[string]transport.RefStatus)
+	for _, status := range statuses {
+		results[status.Name] = status
+	}
+
+	for _, update := range updates {
+		destination := strings.TrimPrefix(strings.TrimPrefix(update.Name, headsPrefix), tagsPrefix)
+		names := destination
+		if update.source != "" {
+			names = fmt.Sprintf("%s -> %s", strings.TrimPrefix(strings.TrimPrefix(update.source, headsPrefix), tagsPrefix), destination)
+		}
+
+		result, reported := results[update.Name]
+		switch {
+		case update.status == "up to date":
+			fmt.Fprintf(progress, " = %-17s %s\n", "[up to date]", names)
+		case update.status != "":
+			fmt.Fprintf(progress, " ! %-17s %s (%s)\n", "[rejected]", names, update.status)
+		case !reported:
+			fmt.Fprintf(progress, " ! %-17s %s (atomic push failed)\n", "[rejected]", names)
+		case !result.OK:
+			fmt.Fprintf(progress, " ! %-17s %s (%s)\n", "[remote rejected]", names, result.Reason)
+		case update.New == nil:
+			fmt.Fprintf(progress, " - %-17s %s\n", "[deleted]", names)
+		case update.Old == nil:
+			label := "[new branch]"
+			if strings.HasPrefix(update.Name, tagsPrefix) {
+				label = "[new tag]"
+			}
+			fmt.Fprintf(progress, " * %-17s %s\n", label, names)
+		case update.forced:
+			fmt.Fprintf(progress, " + %s...%s %s (forced update)\n", shortHash(update.Old), shortHash(update.New), names)
+		default:
+			fmt.Fprintf(progress, "   %s..%s  %s\n", shortHash(update.Old), shortHash(update.New), names)
+		}
+	}
+}
+
+// trackingRefName returns the remote-tracking reference of a branch on a
+// remote. Remotes given by url have none.
+func trackingRefName(remote string, name string) (string, bool) {
+	if !strings.HasPrefix(name, headsPrefix) || !config.Has(fmt.Sprintf("remote.%s.url", remote)) {
+		return "", false
+	}
+
+	return fmt.Sprintf("refs/remotes/%s/%s", remote, strings.TrimPrefix(name, headsPrefix)), true
+}
+
+func updateTrackingRef(remote string, name string, updates []*pushUpdate) error {
+	tracking, ok := trackingRefName(remote, name)
+	if !ok {
+		return nil
+	}
+
+	for _, update := range updates {
+		if update.Name != name {
+			continue
+		}
+		if update.New == nil {
+			return plumbing.DeleteRef(tracking)
+		}
+		return plumbing.WriteRef(tracking, update.New)
+	}
+
+	return nil
+}
@@ -0,0 +1,82 @@
+package core
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/untanky/git-charged/plumbing"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestPushToURLReportsForcedUpdates(t *testing.T) {
+	serverDirectory, first := initTestServer(t)
+	server := serveTestRepository(t, serverDirectory, true)
+
+	changeToTestDirectory(t)
+	err := Clone(CloneParams{URL: server.URL, Progress: io.Discard})
+	if err != nil {
+		t.Fatalf("Clone() = %s", err)
+	}
+
+	tree := writeTestTree(t, plumbing.TreeEntry{Mode: plumbing.ObjectTypeFile | 0644, Name: "README.md", Hash: writeTestBlob(t, "fast-forward\n")})
+	second := writeTestCommit(t, tree, "Second commit\n", first)
+	tree = writeTestTree(t, plumbing.TreeEntry{Mode: plumbing.ObjectTypeFile | 0644, Name: "README.md", Hash: writeTestBlob(t, "rewritten\n")})
+	rewritten := writeTestCommit(t, tree, "Rewritten commit\n", first)
+
+	tests := []struct {
+		name     string
+		commit   []byte
+		params   PushParams
+		expected string
+	}{
+		{
+			name:     "forced fast-forward",
+			commit:   second,
+			params:   PushParams{Force: true},
+			expected: "   " + hex.EncodeToString(first)[:7] + ".." + hex.EncodeToString(second)[:7] + "  main -> main\n",
+		},
+		{
+			name:     "lease",
+			commit:   rewritten,
+			params:   PushParams{Leases: map[string]string{"refs/heads/main": hex.EncodeToString(second)}},
+			expected: " + " + hex.EncodeToString(second)[:7] + "..." + hex.EncodeToString(rewritten)[:7] + " main -> main (forced update)\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := plumbing.WriteRef("refs/heads/main", test.commit)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var progress bytes.Buffer
+			params := test.params
+			params.Remote = server.URL
+			params.RefSpecs = []string{"main"}
+			params.NoVerify = true
+			params.Progress = &progress
+			_, err = Push(params)
+			if err != nil {
+				t.Fatalf("Push() = %s\n%s", err, progress.String())
+			}
+			if !strings.Contains(progress.String(), test.expected) {
+				t.Errorf("Push() progress = %q, want it to contain %q", progress.String(), test.expected)
+			}
+
+			var remote []byte
+			err = withRepository(serverDirectory, func() error {
+				remote, err = plumbing.ResolveRef("refs/heads/main")
+				return err
+			})
+			if err != nil || !bytes.Equal(remote, test.commit) {
+				t.Errorf("remote main = %x, %v, want %x", remote, err, test.commit)
+			}
+		})
+	}
+
+	hash, err := plumbing.ResolveRef("refs/remotes/origin/main")
+	if err != nil || !bytes.Equal(hash, first) {
+		t.Errorf("refs/remotes/origin/main = %x, %v, want %x", hash, err, first)
+	}
+}
@@ -0,0 +1,789 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/untanky/git-charged/config"
+	"github.com/untanky/git-charged/plumbing"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	rebaseDirectoryName = "rebase-merge"
+
+	rebaseHeadNameFile    = "head-name"
+	rebaseOntoFile        = "onto"
+	rebaseOrigHeadFile    = "orig-head"
+	rebaseTodoFile        = "git-rebase-todo"
+	rebaseDoneFile        = "done"
+	rebaseMessageNumber   = "msgnum"
+	rebaseEndFile         = "end"
+	rebaseInteractiveFile = "interactive"
+	// stopped-sha is the commit the rebase stopped at, because of a
+	// conflict or an edit command. amend is only written for edits.
+	rebaseStoppedFile      = "stopped-sha"
+	rebaseAmendFile        = "amend"
+	rebaseMessageFile      = "message"
+	rebaseAuthorScriptFile = "author-script"
+
+	detachedHeadName = "detached HEAD"
+
+	RebasePick   = "pick"
+	RebaseReword = "reword"
+	RebaseEdit   = "edit"
+	RebaseSquash = "squash"
+	RebaseFixup  = "fixup"
+	RebaseDrop   = "drop"
+)
+
+var (
+	ErrNoRebaseInProgress = errors.New("no rebase in progress")
+
+	RebaseCommands = []string{RebasePick, RebaseReword, RebaseEdit, RebaseSquash, RebaseFixup, RebaseDrop}
+)
+
+// RebaseStep is an instruction of the todo list of a rebase.
+type RebaseStep struct {
+	Command string
+	Hash    []byte
+	Subject string
+}
+
+func (s RebaseStep) String() string {
+	return fmt.Sprintf("%s %s %s", s.Command, shortHash(s.Hash), s.Subject)
+}
+
+type RebaseParams struct {
+	// Upstream is the branch to compare against: the commits of the
+	// current branch that are not in Upstream are replayed. Defaults to
+	// the upstream branch configured for the current branch.
+	Upstream string
+	// Onto is the commit to replay them on, Upstream by default.
+	Onto string
+	// Branch is checked out before rebasing if it is set.
+	Branch string
+	// Interactive lets the user change the todo list with EditTodo first.
+	Interactive bool
+	EditTodo    func(steps []RebaseStep) ([]RebaseStep, error)
+	// EditMessage is used for reword and squash. Messages are kept as they
+	// are if it is nil.
+	EditMessage MessageEditor
+	Progress    io.Writer
+}
+
+type RebaseContinueParams struct {
+	EditMessage MessageEditor
+	Progress    io.Writer
+}
+
+type rebaseState struct {
+	// headName is the branch being rebased, or "detached HEAD".
+	headName    string
+	onto        []byte
+	origHead    []byte
+	todo        []RebaseStep
+	done        []RebaseStep
+	interactive bool
+}
+
+func rebaseDirectory() string {
+	return gitFile(rebaseDirectoryName)
+}
+
+func rebaseFile(name string) string {
+	return path.Join(rebaseDirectory(), name)
+}
+
+func isRebasing() bool {
+	_, err := os.Stat(rebaseDirectory())
+	return err == nil
+}
+
+// Rebase replays the commits of the current branch that are not in the
+// upstream on top of it. It stops on conflicts with a ConflictError and at
+// commits marked for editing; ContinueRebase picks up from there.
+func Rebase(params RebaseParams) error {
+	if isRebasing() {
+		return fmt.Errorf("a rebase is already in progress, continue or abort it first")
+	}
+	if sequenceInProgress() {
+		return fmt.Errorf("a cherry-pick or revert is in progress, continue or abort it first")
+	}
+
+	changed, err := hasLocalChanges()
+	if err != nil {
+		return err
+	}
+	if changed {
+		return ErrLocalChanges
+	}
+
+	if params.Branch != "" {
+		hash, err := plumbing.ResolveRef(headsPrefix + params.Branch)
+		if err != nil {
+			return fmt.Errorf("no such branch %s", params.Branch)
+		}
+		err = checkoutCommit(hash, params.Branch)
+		if err != nil {
+			return err
+		}
+	}
+
+	head, err := plumbing.ResolveRef(plumbing.HEAD)
+	if err != nil {
+		return fmt.Errorf("you do not have the initial commit yet")
+	}
+
+	if params.Upstream == "" {
+		params.Upstream, err = branchUpstream()
+		if err != nil {
+			return err
+		}
+	}
+
+	upstream, err := resolveCommit(params.Upstream)
+	if err != nil {
+		return err
+	}
+	onto := upstream
+	if params.Onto != "" {
+		onto, err = resolveCommit(params.Onto)
+		if err != nil {
+			return err
+		}
+	}
+
+	steps, linear, err := rebaseSteps(upstream, head)
+	if err != nil {
+		return err
+	}
+
+	if !params.Interactive && linear && bytes.Equal(upstream, onto) {
+		if upToDate, err := plumbing.IsAncestor(onto, head); err == nil && upToDate {
+			if params.Progress != nil {
+				fmt.Fprintln(params.Progress, "Current branch is up to date.")
+			}
+			return nil
+		}
+	}
+
+	if params.Interactive && params.EditTodo != nil {
+		steps, err = params.EditTodo(steps)
+		if err != nil {
+			return err
+		}
+		if len(steps) == 0 {
+			return fmt.Errorf("nothing to do")
+		}
+		if steps[0].Command == RebaseSquash || steps[0].Command == RebaseFixup {
+			return fmt.Errorf("cannot %s without a previous commit", steps[0].Command)
+		}
+	}
+
+	headName := detachedHeadName
+	if branch, ok := currentBranch(); ok {
+		headName = headsPrefix + branch
+	}
+
+	state := &rebaseState{
+		headName:    headName,
+		onto:        onto,
+		origHead:    head,
+		todo:        steps,
+		done:        make([]RebaseStep, 0, len(steps)),
+		interactive: params.Interactive,
+	}
+
+	err = os.MkdirAll(rebaseDirectory(), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	err = writeRebaseState(state)
+	if err != nil {
+		return err
+	}
+
+	err = checkoutCommit(onto, "")
+	if err != nil {
+		return err
+	}
+
+	return runRebase(state, params.EditMessage, params.Progress)
+}
+
+// branchUpstream returns the remote-tracking branch that the current
+// branch is configured to merge with.
+func branchUpstream() (string, error) {
+	branch, ok := currentBranch()
+	if !ok {
+		return "", errors.New("you are not currently on a branch")
+	}
+
+	remote, hasRemote := config.Get(fmt.Sprintf("branch.%s.remote", branch))
+	merge, hasMerge := config.Get(fmt.Sprintf("branch.%s.merge", branch))
+	if !hasRemote || !hasMerge {
+		return "", fmt.Errorf("there is no tracking information for branch %s", branch)
+	}
+
+	if remote == "." {
+		return merge, nil
+	}
+	return "refs/remotes/" + remote + "/" + strings.TrimPrefix(merge, headsPrefix), nil
+}
+
+// rebaseSteps lists the commits between upstream and head, oldest first.
+// Merge commits are left out like git does; linear reports whether there
+// were none.
+func rebaseSteps(upstream []byte, head []byte) ([]RebaseStep, bool, error) {
+	hashes, err := rangeCommits(hex.EncodeToString(upstream), hex.EncodeToString(head))
+	if err != nil {
+		return nil, false, err
+	}
+
+	steps := make([]RebaseStep, 0, len(hashes))
+	linear := true
+	for i := len(hashes) - 1; i >= 0; i-- {
+		commit, err := plumbing.ReadCommit(hashes[i])
+		if err != nil {
+			return nil, false, err
+		}
+		if len(commit.Parents) > 1 {
+			linear = false
+			continue
+		}
+
+		subject, _, _ := strings.Cut(commit.Message, "\n")
+		steps = append(steps, RebaseStep{Command: RebasePick, Hash: hashes[i], Subject: subject})
+	}
+
+	return steps, linear, nil
+}
+
+func formatRebaseSteps(steps []RebaseStep) string {
+	var builder strings.Builder
+	for _, step := range steps {
+		builder.WriteString(step.String() + "\n")
+	}
+	return builder.String()
+}
+
+func parseRebaseSteps(content string) ([]RebaseStep, error) {
+	steps := make([]RebaseStep, 0)
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(line, " ", 3)
+		command := expandRebaseCommand(fields[0])
+		if command == "" || len(fields) < 2 {
+			return nil, fmt.Errorf("malformed rebase instruction %q", line)
+		}
+
+		hash, err := resolveCommit(fields[1])
+		if err != nil {
+			return nil, err
+		}
+
+		step := RebaseStep{Command: command, Hash: hash}
+		if len(fields) == 3 {
+			step.Subject = fields[2]
+		}
+		steps = append(steps, step)
+	}
+
+	return steps, scanner.Err()
+}
+
+// expandRebaseCommand accepts the single letter abbreviations of git.
+func expandRebaseCommand(command string) string {
+	for _, candidate := range RebaseCommands {
+		if command == candidate || command == candidate[:1] {
+			return candidate
+		}
+	}
+	return ""
+}
+
+func writeRebaseState(state *rebaseState) error {
+	files := map[string]string{
+		rebaseHeadNameFile:  state.headName + "\n",
+		rebaseOntoFile:      hex.EncodeToString(state.onto) + "\n",
+		rebaseOrigHeadFile:  hex.EncodeToString(state.origHead) + "\n",
+		rebaseTodoFile:      formatRebaseSteps(state.todo),
+		rebaseDoneFile:      formatRebaseSteps(state.done),
+		rebaseMessageNumber: strconv.Itoa(len(state.done)) + "\n",
+		rebaseEndFile:       strconv.Itoa(len(state.done)+len(state.todo)) + "\n",
+	}
+	if state.interactive {
+		files[rebaseInteractiveFile] = ""
+	}
+
+	for name, content := range files {
+		err := os.WriteFile(rebaseFile(name), []byte(content), 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func readRebaseState() (*rebaseState, error) {
+	if !isRebasing() {
+		return nil, ErrNoRebaseInProgress
+	}
+
+	read := func(name string) (string, error) {
+		content, err := os.ReadFile(rebaseFile(name))
+		return strings.TrimSpace(string(content)), err
+	}
+
+	state := &rebaseState{}
+	headName, err := read(rebaseHeadNameFile)
+	if err != nil {
+		return nil, err
+	}
+	state.headName = headName
+
+	for name, hash := range map[string]*[]byte{rebaseOntoFile: &state.onto, rebaseOrigHeadFile: &state.origHead} {
+		content, err := read(name)
+		if err != nil {
+			return nil, err
+		}
+		*hash, err = hex.DecodeString(content)
+		if err != nil {
+			return nil, fmt.Errorf("malformed %s: %w", name, err)
+		}
+	}
+
+	for name, steps := range map[string]*[]RebaseStep{rebaseTodoFile: &state.todo, rebaseDoneFile: &state.done} {
+		content, err := read(name)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		*steps, err = parseRebaseSteps(content)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = os.Stat(rebaseFile(rebaseInteractiveFile))
+	state.interactive = err == nil
+
+	return state, nil
+}
+
+func runRebase(state *rebaseState, editMessage MessageEditor, progress io.Writer) error {
+	for len(state.todo) > 0 {
+		step := state.todo[0]
+		state.todo = state.todo[1:]
+		state.done = append(state.done, step)
+
+		err := writeRebaseState(state)
+		if err != nil {
+			return err
+		}
+
+		stopped, err := applyRebaseStep(step, editMessage, progress)
+		if err != nil || stopped {
+			return err
+		}
+	}
+
+	return finishRebase(state, progress)
+}
+
+// applyRebaseStep replays a single instruction. It reports whether the
+// rebase stopped to let the user edit the commit.
+func applyRebaseStep(step RebaseStep, editMessage MessageEditor, progress io.Writer) (bool, error) {
+	if step.Command == RebaseDrop {
+		return false, nil
+	}
+
+	commit, err := plumbing.ReadCommit(step.Hash)
+	if err != nil {
+		return false, fmt.Errorf("cannot read commit %x: %w", step.Hash, err)
+	}
+
+	head, err := plumbing.ResolveRef(plumbing.HEAD)
+	if err != nil {
+		return false, err
+	}
+
+	squashing := step.Command == RebaseSquash || step.Command == RebaseFixup
+	message := commit.Message
+	if squashing {
+		message, err = squashMessage(head, commit.Message, step.Command)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if !squashing && len(commit.Parents) == 1 && bytes.Equal(commit.Parents[0], head) {
+		// The commit can be reused as it is.
+		err = checkoutTree(commit.Tree, false)
+		if err != nil {
+			return false, err
+		}
+		err = plumbing.WriteRef(plumbing.HEAD, step.Hash)
+		if err != nil {
+			return false, err
+		}
+	} else {
+		conflicts, headTree, err := mergeCommitChanges(step.Hash, commit, 0, false)
+		if err != nil {
+			return false, err
+		}
+
+		if len(conflicts) > 0 {
+			err = stopRebase(step, commit, conflictMessage(message, conflicts), false)
+			if err != nil {
+				return false, err
+			}
+
+			subject, _, _ := strings.Cut(commit.Message, "\n")
+			return false, fmt.Errorf("could not apply %s... %s: %w", shortHash(step.Hash), subject, &ConflictError{Paths: conflicts})
+		}
+
+		err = commitRebaseStep(step, commit, message, headTree, editMessage, progress)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if step.Command == RebaseReword {
+		err = rewordHead(editMessage)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if step.Command == RebaseEdit {
+		err = stopRebase(step, commit, commit.Message, true)
+		if err != nil {
+			return false, err
+		}
+
+		if progress != nil {
+			fmt.Fprintf(progress, "Stopped at %s... %s\n", shortHash(step.Hash), step.Subject)
+			fmt.Fprintln(progress, "You can amend the commit now and continue the rebase when you are satisfied with your changes.")
+		}
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// commitRebaseStep commits the changes of a replayed commit, or amends
+// HEAD with them for squash and fixup. Commits whose changes are already
+// upstream are dropped.
+func commitRebaseStep(step RebaseStep, commit *plumbing.Commit, message string, headTree []byte, editMessage MessageEditor, progress io.Writer) error {
+	index, err := plumbing.ReadIndex()
+	if err != nil {
+		return fmt.Errorf("cannot read index: %w", err)
+	}
+	tree, err := writeIndexTree(index)
+	if err != nil {
+		return err
+	}
+
+	switch step.Command {
+	case RebaseSquash, RebaseFixup:
+		if step.Command == RebaseSquash && editMessage != nil {
+			message, err = editMessage(message)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = amendCommit(stripComments(message))
+		return err
+	}
+
+	if bytes.Equal(tree, headTree) {
+		if progress != nil {
+			fmt.Fprintf(progress, "dropping %s %s -- patch contents already upstream\n", shortHash(step.Hash), step.Subject)
+		}
+		return nil
+	}
+
+	message, err = runCommitMessageHook(hookPrepareCommitMsg, message, "message")
+	if err != nil {
+		return err
+	}
+
+	_, err = commitIndex(message, &commit.Author)
+	return err
+}
+
+// squashMessage combines the message of HEAD with the message of a commit
+// that is squashed into it. A fixup keeps the message of HEAD.
+func squashMessage(head []byte, message string, command string) (string, error) {
+	headCommit, err := plumbing.ReadCommit(head)
+	if err != nil {
+		return "", err
+	}
+	if command == RebaseFixup {
+		return headCommit.Message, nil
+	}
+
+	return fmt.Sprintf("# This is a combination of 2 commits.\n# This is the 1st commit message:\n\n%s\n# This is the commit message #2:\n\n%s",
+		strings.TrimRight(headCommit.Message, "\n")+"\n", message), nil
+}
+
+func rewordHead(editMessage MessageEditor) error {
+	if editMessage == nil {
+		return nil
+	}
+
+	head, err := plumbing.ResolveRef(plumbing.HEAD)
+	if err != nil {
+		return err
+	}
+	commit, err := plumbing.ReadCommit(head)
+	if err != nil {
+		return err
+	}
+
+	message, err := editMessage(commit.Message)
+	if err != nil {
+		return err
+	}
+	message = stripComments(message)
+	if message == "" {
+		return fmt.Errorf("aborting commit due to empty commit message")
+	}
+
+	_, err = amendCommit(message)
+	return err
+}
+
+// stopRebase records where the rebase stopped, with the message to commit
+// on continue.
+func stopRebase(step RebaseStep, commit *plumbing.Commit, message string, amend bool) error {
+	head, err := plumbing.ResolveRef(plumbing.HEAD)
+	if err != nil {
+		return err
+	}
+
+	author := commit.Author
+	files := map[string]string{
+		rebaseStoppedFile: hex.EncodeToString(step.Hash) + "\n",
+		rebaseMessageFile: message,
+		rebaseAuthorScriptFile: fmt.Sprintf("GIT_AUTHOR_NAME='%s'\nGIT_AUTHOR_EMAIL='%s'\nGIT_AUTHOR_DATE='@%d %s'\n",
+			author.Name, author.Email, author.Timestamp.Unix(), author.Timestamp.Format("-0700")),
+	}
+	if amend {
+		files[rebaseAmendFile] = hex.EncodeToString(head) + "\n"
+	}
+
+	for name, content := range files {
+		err = os.WriteFile(rebaseFile(name), []byte(content), 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func clearRebaseStop() error {
+	for _, name := range []string{rebaseStoppedFile, rebaseMessageFile, rebaseAuthorScriptFile, rebaseAmendFile} {
+		err := os.Remove(rebaseFile(name))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// finishRebase points the rebased branch at the result and checks it out
+// again.
+func finishRebase(state *rebaseState, progress io.Writer) error {
+	head, err := plumbing.ResolveRef(plumbing.HEAD)
+	if err != nil {
+		return err
+	}
+
+	if state.headName != detachedHeadName {
+		err = plumbing.WriteRef(state.headName, head)
+		if err != nil {
+			return err
+		}
+		err = plumbing.WriteSymbolicRef(plumbing.HEAD, state.headName)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = os.RemoveAll(rebaseDirectory())
+	if err != nil {
+		return err
+	}
+
+	if progress != nil {
+		fmt.Fprintf(progress, "Successfully rebased and updated %s.\n", state.headName)
+	}
+
+	return nil
+}
+
+// ContinueRebase commits the resolved conflicts or the edited commit the
+// rebase stopped at and replays the remaining commits. Conflicting paths
+// are staged from the working tree.
+func ContinueRebase(params RebaseContinueParams) error {
+	state, err := readRebaseState()
+	if err != nil {
+		return err
+	}
+
+	index, err := plumbing.ReadIndex()
+	if err != nil {
+		return fmt.Errorf("cannot read index: %w", err)
+	}
+	err = resolveConflicts(index)
+	if err != nil {
+		return err
+	}
+	err = plumbing.WriteIndex(index)
+	if err != nil {
+		return err
+	}
+
+	stopped, err := os.ReadFile(rebaseFile(rebaseStoppedFile))
+	if err == nil && len(state.done) > 0 {
+		step := state.done[len(state.done)-1]
+
+		head, err := plumbing.ResolveRef(plumbing.HEAD)
+		if err != nil {
+			return err
+		}
+		headCommit, err := plumbing.ReadCommit(head)
+		if err != nil {
+			return err
+		}
+
+		_, statErr := os.Stat(rebaseFile(rebaseAmendFile))
+		editing := statErr == nil
+
+		switch {
+		case editing:
+			// Changes staged while editing are added to the commit.
+			tree, err := writeIndexTree(index)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(tree, headCommit.Tree) {
+				_, err = amendCommit(headCommit.Message)
+				if err != nil {
+					return err
+				}
+			}
+		default:
+			hash, err := hex.DecodeString(strings.TrimSpace(string(stopped)))
+			if err != nil {
+				return fmt.Errorf("malformed %s: %w", rebaseStoppedFile, err)
+			}
+			commit, err := plumbing.ReadCommit(hash)
+			if err != nil {
+				return err
+			}
+
+			message, err := os.ReadFile(rebaseFile(rebaseMessageFile))
+			if err != nil {
+				return err
+			}
+
+			err = commitRebaseStep(step, commit, stripComments(string(message)), headCommit.Tree, params.EditMessage, params.Progress)
+			if err != nil {
+				return err
+			}
+
+			if step.Command == RebaseReword {
+				err = rewordHead(params.EditMessage)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	err = clearRebaseStop()
+	if err != nil {
+		return err
+	}
+
+	return runRebase(state, params.EditMessage, params.Progress)
+}
+
+// SkipRebase discards the changes of the commit the rebase stopped at and
+// continues with the next one.
+func SkipRebase(params RebaseContinueParams) error {
+	state, err := readRebaseState()
+	if err != nil {
+		return err
+	}
+
+	head, err := plumbing.ResolveRef(plumbing.HEAD)
+	if err != nil {
+		return err
+	}
+	commit, err := plumbing.ReadCommit(head)
+	if err != nil {
+		return err
+	}
+
+	err = checkoutTree(commit.Tree, true)
+	if err != nil {
+		return err
+	}
+
+	err = clearRebaseStop()
+	if err != nil {
+		return err
+	}
+
+	return runRebase(state, params.EditMessage, params.Progress)
+}
+
+// AbortRebase stops the rebase and restores the branch as it was before.
+func AbortRebase() error {
+	state, err := readRebaseState()
+	if err != nil {
+		return err
+	}
+
+	commit, err := plumbing.ReadCommit(state.origHead)
+	if err != nil {
+		return err
+	}
+
+	err = checkoutTree(commit.Tree, true)
+	if err != nil {
+		return err
+	}
+
+	if state.headName != detachedHeadName {
+		err = plumbing.WriteSymbolicRef(plumbing.HEAD, state.headName)
+	} else {
+		err = plumbing.WriteRef(plumbing.HEAD, state.origHead)
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.RemoveAll(rebaseDirectory())
+}
@@ -0,0 +1,238 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/untanky/git-charged/plumbing"
+	"os"
+	"strings"
+	"testing"
+)
+
+// initRebaseTest creates a main branch and a topic branch with two commits
+// that add "a" and "b" on top of their common base, and checks out topic.
+// With conflicting set, main and the first topic commit change "file".
+func initRebaseTest(t *testing.T, conflicting bool) (main []byte, topic [][]byte) {
+	t.Helper()
+
+	initTestRepository(t)
+	setTestConfig(t, map[string]string{"user.name": "Rebaser", "user.email": "rebaser@example.com"})
+
+	file := func(name string, content string) plumbing.TreeEntry {
+		return plumbing.TreeEntry{Mode: plumbing.ObjectTypeFile | 0644, Name: name, Hash: writeTestBlob(t, content)}
+	}
+
+	base := writeTestCommit(t, writeTestTree(t, file("file", "base\n")), "Base\n")
+	mainFile := file("main", "main\n")
+	if conflicting {
+		mainFile = file("file", "main\n")
+	}
+	main = writeTestCommit(t, writeTestTree(t, file("file", "base\n"), mainFile), "Main\n", base)
+
+	first := []plumbing.TreeEntry{file("a", "a\n"), file("file", "base\n")}
+	if conflicting {
+		first[1] = file("file", "topic\n")
+	}
+	topicA := writeTestCommit(t, writeTestTree(t, first...), "Add a\n", base)
+	tree := writeTestTree(t, append(first, file("b", "b\n"))...)
+	topicB := writeTestCommit(t, tree, "Add b\n", topicA)
+
+	err := plumbing.WriteRef("refs/heads/main", main)
+	if err == nil {
+		err = plumbing.WriteRef("refs/heads/topic", topicB)
+	}
+	if err == nil {
+		err = plumbing.WriteSymbolicRef(plumbing.HEAD, "refs/heads/topic")
+	}
+	if err == nil {
+		err = checkoutTree(tree, false)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return main, [][]byte{topicA, topicB}
+}
+
+// rebasedHistory returns the messages of the commits on topic since main,
+// oldest first, and checks that they are on top of main.
+func rebasedHistory(t *testing.T, main []byte) []string {
+	t.Helper()
+
+	hash, err := plumbing.ResolveRef("refs/heads/topic")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var messages []string
+	for !bytes.Equal(hash, main) {
+		commit, err := plumbing.ReadCommit(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(commit.Parents) != 1 {
+			t.Fatalf("commit %x is not on top of main", hash)
+		}
+		messages = append([]string{commit.Message}, messages...)
+		hash = commit.Parents[0]
+	}
+	return messages
+}
+
+func TestRebase(t *testing.T) {
+	main, _ := initRebaseTest(t, false)
+
+	err := Rebase(RebaseParams{Upstream: "main"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages := rebasedHistory(t, main)
+	if strings.Join(messages, "") != "Add a\nAdd b\n" {
+		t.Errorf("rebased commits = %q", messages)
+	}
+	if branch, ok := currentBranch(); !ok || branch != "topic" {
+		t.Errorf("current branch = %q, %t, want topic", branch, ok)
+	}
+	for _, name := range []string{"a", "b", "main"} {
+		if _, err := os.Stat(name); err != nil {
+			t.Errorf("%s is missing after the rebase: %v", name, err)
+		}
+	}
+	if isRebasing() {
+		t.Errorf("rebase directory left behind")
+	}
+}
+
+func TestRebaseInteractive(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands []string
+		messages []string
+	}{
+		{name: "fixup", commands: []string{RebasePick, RebaseFixup}, messages: []string{"Add a\n"}},
+		{name: "squash", commands: []string{RebasePick, RebaseSquash}, messages: []string{"Add a\n\nAdd b\n"}},
+		{name: "drop", commands: []string{RebasePick, RebaseDrop}, messages: []string{"Add a\n"}},
+		{name: "reword", commands: []string{RebaseReword, RebasePick}, messages: []string{"Reworded\n", "Add b\n"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			main, _ := initRebaseTest(t, false)
+
+			err := Rebase(RebaseParams{
+				Upstream:    "main",
+				Interactive: true,
+				EditTodo: func(steps []RebaseStep) ([]RebaseStep, error) {
+					for i := range steps {
+						steps[i].Command = test.commands[i]
+					}
+					return steps, nil
+				},
+				EditMessage: func(message string) (string, error) {
+					if test.name == "reword" {
+						return "Reworded\n", nil
+					}
+					return message, nil
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			messages := rebasedHistory(t, main)
+			if len(messages) != len(test.messages) {
+				t.Fatalf("rebased commits = %q, want %q", messages, test.messages)
+			}
+			for i, message := range messages {
+				if message != test.messages[i] {
+					t.Errorf("commit %d = %q, want %q", i, message, test.messages[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRebaseConflict(t *testing.T) {
+	t.Run("abort", func(t *testing.T) {
+		_, topic := initRebaseTest(t, true)
+
+		err := Rebase(RebaseParams{Upstream: "main"})
+		var conflict *ConflictError
+		if !errors.As(err, &conflict) {
+			t.Fatalf("Rebase() = %v, want a conflict", err)
+		}
+
+		err = AbortRebase()
+		if err != nil {
+			t.Fatal(err)
+		}
+		head, _ := plumbing.ResolveRef(plumbing.HEAD)
+		if !bytes.Equal(head, topic[1]) {
+			t.Errorf("HEAD = %x after abort, want %x", head, topic[1])
+		}
+		if content, _ := os.ReadFile("file"); string(content) != "topic\n" {
+			t.Errorf("file = %q after abort", content)
+		}
+		if isRebasing() {
+			t.Errorf("rebase directory left behind")
+		}
+	})
+
+	t.Run("continue", func(t *testing.T) {
+		main, _ := initRebaseTest(t, true)
+
+		err := Rebase(RebaseParams{Upstream: "main"})
+		var conflict *ConflictError
+		if !errors.As(err, &conflict) || len(conflict.Paths) != 1 || conflict.Paths[0] != "file" {
+			t.Fatalf("Rebase() = %v, want a conflict in file", err)
+		}
+
+		err = os.WriteFile("file", []byte("resolved\n"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ContinueRebase(RebaseContinueParams{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		messages := rebasedHistory(t, main)
+		if len(messages) != 2 || !strings.HasPrefix(messages[0], "Add a\n") || messages[1] != "Add b\n" {
+			t.Errorf("rebased commits = %q", messages)
+		}
+		if content, _ := os.ReadFile("file"); string(content) != "resolved\n" {
+			t.Errorf("file = %q after continue", content)
+		}
+	})
+}
+
+func TestParseRebaseSteps(t *testing.T) {
+	_, topic := initRebaseTest(t, false)
+
+	steps, err := parseRebaseSteps(fmt.Sprintf("# comment\n\np %x Add a\ns %x\nfixup %x Add b\n", topic[0], topic[1], topic[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []RebaseStep{
+		{Command: RebasePick, Hash: topic[0], Subject: "Add a"},
+		{Command: RebaseSquash, Hash: topic[1]},
+		{Command: RebaseFixup, Hash: topic[1], Subject: "Add b"},
+	}
+	if len(steps) != len(want) {
+		t.Fatalf("parseRebaseSteps() = %v, want %v", steps, want)
+	}
+	for i := range want {
+		if steps[i].Command != want[i].Command || !bytes.Equal(steps[i].Hash, want[i].Hash) || steps[i].Subject != want[i].Subject {
+			t.Errorf("step %d = %v, want %v", i, steps[i], want[i])
+		}
+	}
+
+	for _, content := range []string{"pick\n", fmt.Sprintf("merge %x\n", topic[0]), "pick 0000000\n"} {
+		_, err = parseRebaseSteps(content)
+		if err == nil {
+			t.Errorf("parseRebaseSteps(%q) accepted it", content)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package core
+
+import (
+	"fmt"
+	"github.com/untanky/git-charged/plumbing"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	BumpMajor = "major"
+	BumpMinor = "minor"
+	BumpPatch = "patch"
+
+	defaultVersionPrefix = "v"
+)
+
+var releaseTagPattern = regexp.MustCompile(`^(v?)(\d+)\.(\d+)\.(\d+)$`)
+
+type ReleaseParams struct {
+	// Bump is the part of the version to increase: BumpMajor, BumpMinor or
+	// BumpPatch. It is derived from the commits if it is empty.
+	Bump string
+}
+
+// ReleasePlan is the next release of HEAD.
+type ReleasePlan struct {
+	// PreviousTag is empty for the first release.
+	PreviousTag string
+	Tag         string
+	Version     string
+	Bump        string
+	// Notes list the changes since the previous release in the sections of
+	// Keep a Changelog.
+	Notes string
+}
+
+// semanticVersion is a release version without pre-release or build
+// metadata.
+type semanticVersion struct {
+	prefix string
+	major  int
+	minor  int
+	patch  int
+}
+
+func (v semanticVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.major, v.minor, v.patch)
+}
+
+func (v semanticVersion) less(other semanticVersion) bool {
+	if v.major != other.major {
+		return v.major < other.major
+	}
+	if v.minor != other.minor {
+		return v.minor < other.minor
+	}
+	return v.patch < other.patch
+}
+
+func (v semanticVersion) bump(part string) semanticVersion {
+	switch part {
+	case BumpMajor:
+		return semanticVersion{prefix: v.prefix, major: v.major + 1}
+	case BumpMinor:
+		return semanticVersion{prefix: v.prefix, major: v.major, minor: v.minor + 1}
+	default:
+		return semanticVersion{prefix: v.prefix, major: v.major, minor: v.minor, patch: v.patch + 1}
+	}
+}
+
+// PlanRelease finds the newest version tag HEAD contains and computes the
+// next version from the Conventional Commits since: a breaking change
+// bumps the major version, a feature the minor version and anything else
+// the patch version.
+func PlanRelease(params ReleaseParams) (ReleasePlan, error) {
+	switch params.Bump {
+	case "", BumpMajor, BumpMinor, BumpPatch:
+	default:
+		return ReleasePlan{}, fmt.Errorf("unknown version part '%s'", params.Bump)
+	}
+
+	head, err := plumbing.ResolveRef(plumbing.HEAD)
+	if err != nil {
+		return ReleasePlan{}, fmt.Errorf("cannot resolve HEAD: %w", err)
+	}
+
+	previous, previousTag, err := latestReleaseTag(head)
+	if err != nil {
+		return ReleasePlan{}, err
+	}
+
+	revisions := []string{plumbing.HEAD}
+	changes := plumbing.HEAD
+	if previousTag != "" {
+		revisions = append(revisions, "^"+previousTag)
+		changes = previousTag + ".." + plumbing.HEAD
+	}
+	entries, err := Log(LogParams{Revisions: revisions, NoMailmap: true})
+	if err != nil {
+		return ReleasePlan{}, err
+	}
+	if len(entries) == 0 {
+		return ReleasePlan{}, fmt.Errorf("nothing to release since %s", previousTag)
+	}
+
+	bump := params.Bump
+	if bump == "" {
+		bump = BumpPatch
+		for _, entry := range entries {
+			commit, err := ParseConventionalCommit(entry.Commit.Message)
+			if err != nil {
+				continue
+			}
+			if commit.Breaking {
+				bump = BumpMajor
+				break
+			}
+			if commit.Type == "feat" {
+				bump = BumpMinor
+			}
+		}
+	}
+
+	next := previous.bump(bump)
+	plan := ReleasePlan{
+		PreviousTag: previousTag,
+		Tag:         next.prefix + next.String(),
+		Version:     next.String(),
+		Bump:        bump,
+	}
+
+	release, err := Changelog(ChangelogParams{Range: changes, Version: plan.Version})
+	if err != nil {
+		return ReleasePlan{}, err
+	}
+	_, plan.Notes, _ = strings.Cut(release.Markdown, "\n")
+	plan.Notes = strings.TrimLeft(plan.Notes, "\n")
+
+	return plan, nil
+}
+
+// latestReleaseTag returns the highest version tagged on an ancestor of
+// head, or version 0.0.0 if there is none.
+func latestReleaseTag(head []byte) (semanticVersion, string, error) {
+	tags, err := ListTags()
+	if err != nil {
+		return semanticVersion{}, "", err
+	}
+
+	type versionTag struct {
+		version semanticVersion
+		name    string
+	}
+	versions := make([]versionTag, 0)
+	for _, tag := range tags {
+		match := releaseTagPattern.FindStringSubmatch(tag)
+		if match == nil {
+			continue
+		}
+
+		major, _ := strconv.Atoi(match[2])
+		minor, _ := strconv.Atoi(match[3])
+		patch, _ := strconv.Atoi(match[4])
+		versions = append(versions, versionTag{
+			version: semanticVersion{prefix: match[1], major: major, minor: minor, patch: patch},
+			name:    tag,
+		})
+	}
+
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[j].version.less(versions[i].version)
+	})
+
+	for _, version := range versions {
+		hash, err := plumbing.ResolveRef(tagsPrefix + version.name)
+		if err != nil {
+			return semanticVersion{}, "", err
+		}
+		commit, err := peelTo(hash, plumbing.KindCommit)
+		if err != nil {
+			continue
+		}
+
+		ancestor, err := plumbing.IsAncestor(commit, head)
+		if err != nil {
+			return semanticVersion{}, "", err
+		}
+		if ancestor {
+			return version.version, version.name, nil
+		}
+	}
+
+	return semanticVersion{prefix: defaultVersionPrefix}, "", nil
+}
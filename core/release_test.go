@@ -0,0 +1,121 @@
+package core
+
+import (
+	"github.com/untanky/git-charged/plumbing"
+	"testing"
+)
+
+func TestSemanticVersionBump(t *testing.T) {
+	version := semanticVersion{prefix: "v", major: 1, minor: 2, patch: 3}
+
+	tests := []struct {
+		part string
+		want string
+	}{
+		{part: BumpMajor, want: "2.0.0"},
+		{part: BumpMinor, want: "1.3.0"},
+		{part: BumpPatch, want: "1.2.4"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.part, func(t *testing.T) {
+			bumped := version.bump(test.part)
+			if bumped.String() != test.want || bumped.prefix != version.prefix {
+				t.Errorf("bump(%s) = %s%s, want v%s", test.part, bumped.prefix, bumped, test.want)
+			}
+		})
+	}
+}
+
+func TestPlanRelease(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages []string
+		tags     map[string]int
+		params   ReleaseParams
+		previous string
+		tag      string
+		bump     string
+	}{
+		{
+			name:     "first release",
+			messages: []string{"fix: a bug\n"},
+			tag:      "v0.0.1",
+			bump:     BumpPatch,
+		},
+		{
+			name:     "fixes bump the patch version",
+			messages: []string{"feat: start\n", "fix: a bug\n", "docs: explain\n"},
+			tags:     map[string]int{"v1.2.3": 0},
+			previous: "v1.2.3",
+			tag:      "v1.2.4",
+			bump:     BumpPatch,
+		},
+		{
+			name:     "features bump the minor version",
+			messages: []string{"feat: start\n", "fix: a bug\n", "feat: more\n"},
+			tags:     map[string]int{"1.2.3": 0},
+			previous: "1.2.3",
+			tag:      "1.3.0",
+			bump:     BumpMinor,
+		},
+		{
+			name:     "breaking changes bump the major version",
+			messages: []string{"feat: start\n", "feat: more\n", "fix: a bug\n\nBREAKING CHANGE: it was used\n"},
+			tags:     map[string]int{"v1.2.3": 0},
+			previous: "v1.2.3",
+			tag:      "v2.0.0",
+			bump:     BumpMajor,
+		},
+		{
+			name:     "highest version on HEAD",
+			messages: []string{"feat: start\n", "feat: more\n", "fix: a bug\n"},
+			tags:     map[string]int{"v1.0.0": 0, "v1.10.0": 1, "v1.9.0": 2, "not-a-version": 2},
+			previous: "v1.10.0",
+			tag:      "v1.10.1",
+			bump:     BumpPatch,
+		},
+		{
+			name:     "given bump",
+			messages: []string{"feat: start\n", "fix: a bug\n"},
+			tags:     map[string]int{"v1.2.3": 0},
+			params:   ReleaseParams{Bump: BumpMajor},
+			previous: "v1.2.3",
+			tag:      "v2.0.0",
+			bump:     BumpMajor,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			commits := writeTestHistory(t, test.messages...)
+			for tag, commit := range test.tags {
+				err := plumbing.WriteRef(tagsPrefix+tag, commits[commit])
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			plan, err := PlanRelease(test.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if plan.PreviousTag != test.previous || plan.Tag != test.tag || plan.Bump != test.bump {
+				t.Errorf("PlanRelease() = %s after %q with a %s bump, want %s after %q with a %s bump", plan.Tag, plan.PreviousTag, plan.Bump, test.tag, test.previous, test.bump)
+			}
+		})
+	}
+}
+
+func TestPlanReleaseWithoutChanges(t *testing.T) {
+	commits := writeTestHistory(t, "feat: start\n")
+	err := plumbing.WriteRef(tagsPrefix+"v1.0.0", commits[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = PlanRelease(ReleaseParams{})
+	if err == nil {
+		t.Errorf("PlanRelease() succeeded without commits since v1.0.0")
+	}
+}
@@ -0,0 +1,151 @@
+package core
+
+import (
+	"fmt"
+	"github.com/untanky/git-charged/config"
+	"github.com/untanky/git-charged/plumbing"
+	"os"
+	"path"
+	"strings"
+)
+
+const gitDirFilePrefix = "gitdir: "
+
+func init() {
+	// Linked worktrees have a .git file that points to their git directory.
+	if gitDirectory, ok := findGitDirectory("."); ok && gitDirectory != gitDirectoryName {
+		useGitDirectory(gitDirectory)
+	}
+}
+
+// OpenRepository changes into directory and points the plumbing package at
+// its git directory. Regular and bare repositories as well as linked
+// worktrees are supported.
+func OpenRepository(directory string) error {
+	err := os.Chdir(directory)
+	if err != nil {
+		return fmt.Errorf("cannot open repository: %w", err)
+	}
+
+	if gitDirectory, ok := findGitDirectory("."); ok {
+		useGitDirectory(gitDirectory)
+		return nil
+	}
+	if isGitDirectory(".") {
+		useGitDirectory(".")
+		return nil
+	}
+
+	return fmt.Errorf("%s is not a git repository", directory)
+}
+
+func useGitDirectory(gitDirectory string) {
+	plumbing.SetDirectory(gitDirectory)
+	config.SetRepositoryConfig(localConfigPath())
+	config.ReloadConfig()
+}
+
+// isGitDirectory reports whether directory is a git directory. The git
+// directory of a linked worktree only has a HEAD of its own and shares
+// the objects and references of the directory named in its commondir file.
+func isGitDirectory(directory string) bool {
+	if _, err := os.Stat(path.Join(directory, "HEAD")); err != nil {
+		return false
+	}
+
+	common := directory
+	if content, err := os.ReadFile(path.Join(directory, "commondir")); err == nil {
+		common = strings.TrimSpace(string(content))
+		if !path.IsAbs(common) {
+			common = path.Join(directory, common)
+		}
+	}
+
+	for _, name := range []string{"objects", "refs"} {
+		if _, err := os.Stat(path.Join(common, name)); err != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// findGitDirectory returns the git directory of a working tree, which is
+// either its .git directory or the directory a .git file points to.
+func findGitDirectory(workingTree string) (string, bool) {
+	name := path.Join(workingTree, gitDirectoryName)
+
+	info, err := os.Stat(name)
+	if err != nil {
+		return "", false
+	}
+	if info.IsDir() {
+		return name, isGitDirectory(name)
+	}
+
+	content, err := os.ReadFile(name)
+	if err != nil || !strings.HasPrefix(string(content), gitDirFilePrefix) {
+		return "", false
+	}
+
+	gitDirectory := strings.TrimSpace(strings.TrimPrefix(string(content), gitDirFilePrefix))
+	if !path.IsAbs(gitDirectory) {
+		gitDirectory = path.Join(workingTree, gitDirectory)
+	}
+
+	return gitDirectory, isGitDirectory(gitDirectory)
+}
+
+// isWorkingTree reports whether directory is the top of a working tree.
+func isWorkingTree(directory string) bool {
+	_, ok := findGitDirectory(directory)
+	return ok
+}
+
+// gitFile returns the path of a state file of the current worktree, such
+// as MERGE_MSG.
+func gitFile(name string) string {
+	return path.Join(plumbing.Directory(), name)
+}
+
+// localConfigPath returns the config file of the current repository.
+func localConfigPath() string {
+	return path.Join(plumbing.CommonDirectory(), "config")
+}
+
+// inDirectory runs task with directory as the working directory and
+// restores the current repository afterwards. It is used to operate on
+// nested repositories such as submodules.
+func inDirectory(directory string, task func() error) error {
+	workingDirectory, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	gitDirectory := plumbing.Directory()
+	configPath := config.RepositoryConfig()
+
+	defer func() {
+		os.Chdir(workingDirectory)
+		plumbing.SetDirectory(gitDirectory)
+		config.SetRepositoryConfig(configPath)
+		config.ReloadConfig()
+	}()
+
+	err = os.Chdir(directory)
+	if err != nil {
+		return err
+	}
+
+	return task()
+}
+
+// withRepository runs task on the repository in directory.
+func withRepository(directory string, task func() error) error {
+	return inDirectory(directory, func() error {
+		err := OpenRepository(".")
+		if err != nil {
+			return err
+		}
+		return task()
+	})
+}
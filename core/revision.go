@@ -0,0 +1,169 @@
+package core
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/untanky/git-charged/plumbing"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	hexPattern            = regexp.MustCompile(`^[0-9a-fA-F]{4,40}$`)
+	revisionSuffixPattern = regexp.MustCompile(`(\^\{[a-z]*\}|\^[0-9]*|~[0-9]*)$`)
+)
+
+// ExpandRefName resolves a short reference name like "main" or "v1.0" to
+// the full name of an existing reference, using git's lookup order.
+func ExpandRefName(name string) (string, bool) {
+	candidates := []string{
+		name,
+		"refs/" + name,
+		tagsPrefix + name,
+		headsPrefix + name,
+		"refs/remotes/" + name,
+		"refs/remotes/" + name + "/" + plumbing.HEAD,
+	}
+
+	for _, candidate := range candidates {
+		if candidate != plumbing.HEAD && !strings.HasPrefix(candidate, "refs/") {
+			continue
+		}
+		if _, err := plumbing.ResolveRef(candidate); err == nil {
+			return candidate, true
+		}
+	}
+
+	return "", false
+}
+
+// ResolveRevision turns a revision such as "HEAD~2", "v1.0^{}", "main" or an
+// abbreviated object id into the object id it names.
+func ResolveRevision(revision string) ([]byte, error) {
+	if revision == "@" {
+		revision = plumbing.HEAD
+	}
+
+	if match := revisionSuffixPattern.FindStringIndex(revision); match != nil && match[0] > 0 {
+		base, err := ResolveRevision(revision[:match[0]])
+		if err != nil {
+			return nil, err
+		}
+
+		return applyRevisionSuffix(base, revision[match[0]:])
+	}
+
+	if name, ok := ExpandRefName(revision); ok {
+		return plumbing.ResolveRef(name)
+	}
+
+	if hexPattern.MatchString(revision) {
+		if len(revision) == 40 {
+			hash, _ := hex.DecodeString(revision)
+			if plumbing.HasObject(hash) {
+				return hash, nil
+			}
+		}
+
+		hashes, err := plumbing.FindObjects(revision)
+		if err != nil {
+			return nil, err
+		}
+		if len(hashes) == 1 {
+			return hashes[0], nil
+		}
+		if len(hashes) > 1 {
+			return nil, fmt.Errorf("short object id %s is ambiguous", revision)
+		}
+	}
+
+	return nil, fmt.Errorf("unknown revision %q", revision)
+}
+
+func applyRevisionSuffix(hash []byte, suffix string) ([]byte, error) {
+	switch {
+	case strings.HasPrefix(suffix, "^{"):
+		kind := plumbing.ObjectKind(strings.TrimSuffix(strings.TrimPrefix(suffix, "^{"), "}"))
+		return peelTo(hash, kind)
+	case strings.HasPrefix(suffix, "^"):
+		n := 1
+		if len(suffix) > 1 {
+			n, _ = strconv.Atoi(suffix[1:])
+		}
+		commit, err := readPeeledCommit(hash)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return peelTo(hash, plumbing.KindCommit)
+		}
+		if n > len(commit.Parents) {
+			return nil, fmt.Errorf("commit %x has no parent %d", hash, n)
+		}
+		return commit.Parents[n-1], nil
+	default:
+		n := 1
+		if len(suffix) > 1 {
+			n, _ = strconv.Atoi(suffix[1:])
+		}
+		hash, err := peelTo(hash, plumbing.KindCommit)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < n; i++ {
+			commit, err := plumbing.ReadCommit(hash)
+			if err != nil {
+				return nil, err
+			}
+			if len(commit.Parents) == 0 {
+				return nil, fmt.Errorf("commit %x has no parent", hash)
+			}
+			hash = commit.Parents[0]
+		}
+		return hash, nil
+	}
+}
+
+// peelTo dereferences tags and commits until an object of the given kind
+// is reached. An empty kind peels tags only.
+func peelTo(hash []byte, kind plumbing.ObjectKind) ([]byte, error) {
+	hash, actualKind, err := plumbing.Peel(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if kind == "" || kind == actualKind {
+		return hash, nil
+	}
+
+	if actualKind == plumbing.KindCommit && kind == plumbing.KindTree {
+		commit, err := plumbing.ReadCommit(hash)
+		if err != nil {
+			return nil, err
+		}
+		return commit.Tree, nil
+	}
+
+	return nil, fmt.Errorf("object %x is a %s, not a %s", hash, actualKind, kind)
+}
+
+func readPeeledCommit(hash []byte) (*plumbing.Commit, error) {
+	hash, err := peelTo(hash, plumbing.KindCommit)
+	if err != nil {
+		return nil, err
+	}
+
+	return plumbing.ReadCommit(hash)
+}
+
+// currentBranch returns the branch HEAD points to, or false when HEAD is
+// detached.
+func currentBranch() (string, bool) {
+	target, ok, err := plumbing.ReadSymbolicRef(plumbing.HEAD)
+	if err != nil || !ok || !strings.HasPrefix(target, headsPrefix) {
+		return "", false
+	}
+
+	return strings.TrimPrefix(target, headsPrefix), true
+}
@@ -0,0 +1,320 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/untanky/git-charged/config"
+	"github.com/untanky/git-charged/plumbing"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+const (
+	signatureFormatOpenPGP = "openpgp"
+	signatureFormatX509    = "x509"
+	signatureFormatSSH     = "ssh"
+
+	// sshSignatureNamespace keeps signatures made for git from being valid
+	// for other uses of the same key, and the other way around.
+	sshSignatureNamespace = "git"
+)
+
+var ErrNoSignature = errors.New("no signature found")
+
+// SignatureVerification describes a good signature.
+type SignatureVerification struct {
+	Format string
+	// Signer is the principal of an SSH signature or the user ID of a GPG
+	// signature.
+	Signer string
+	// Output is what the signing program reported.
+	Output string
+}
+
+func signingFormat() string {
+	format, ok := config.Get("gpg.format")
+	if !ok {
+		return signatureFormatOpenPGP
+	}
+
+	return format
+}
+
+func signingProgram(format string) string {
+	if program, ok := config.Get(fmt.Sprintf("gpg.%s.program", format)); ok {
+		return program
+	}
+
+	switch format {
+	case signatureFormatSSH:
+		return "ssh-keygen"
+	case signatureFormatX509:
+		return "gpgsm"
+	}
+
+	if program, ok := config.Get("gpg.program"); ok {
+		return program
+	}
+	return "gpg"
+}
+
+// signatureFormat tells the format of an armored signature by its first
+// line.
+func signatureFormat(signature []byte) string {
+	switch {
+	case bytes.HasPrefix(signature, []byte("-----BEGIN SSH SIGNATURE-----")):
+		return signatureFormatSSH
+	case bytes.HasPrefix(signature, []byte("-----BEGIN SIGNED MESSAGE-----")):
+		return signatureFormatX509
+	}
+
+	return signatureFormatOpenPGP
+}
+
+func signCommitsByDefault() bool {
+	sign, _ := config.Get("commit.gpgsign")
+	return sign == "true"
+}
+
+func signTagsByDefault() bool {
+	sign, _ := config.Get("tag.gpgsign")
+	return sign == "true"
+}
+
+// signCommit signs a commit if commit.gpgsign is set.
+func signCommit(commit *plumbing.Commit) error {
+	if !signCommitsByDefault() {
+		return nil
+	}
+
+	signature, err := signPayload(commit.Payload(), commit.Committer)
+	if err != nil {
+		return err
+	}
+
+	commit.Signature = signature
+	return nil
+}
+
+// signPayload signs a commit or tag payload with user.signingkey, using the
+// tool selected by gpg.format. GPG falls back to the key of the signer's
+// identity.
+func signPayload(payload []byte, signer plumbing.AuthorData) (string, error) {
+	format := signingFormat()
+	key, hasKey := config.Get("user.signingkey")
+
+	var signature []byte
+	var err error
+	switch format {
+	case signatureFormatSSH:
+		if !hasKey {
+			return "", fmt.Errorf("cannot sign: user.signingkey needs to be set for ssh signing")
+		}
+		signature, err = signSSH(payload, signingProgram(format), key)
+	case signatureFormatOpenPGP, signatureFormatX509:
+		if !hasKey {
+			key = fmt.Sprintf("%s <%s>", signer.Name, signer.Email)
+		}
+		signature, err = signGPG(payload, signingProgram(format), key)
+	default:
+		return "", fmt.Errorf("cannot sign: unsupported gpg.format %q", format)
+	}
+	if err != nil {
+		return "", fmt.Errorf("cannot sign: %w", err)
+	}
+
+	return string(signature), nil
+}
+
+func signGPG(payload []byte, program string, key string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(program, "--status-fd=2", "-bsau", key)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil || !strings.Contains(stderr.String(), "[GNUPG:] SIG_CREATED ") {
+		return nil, fmt.Errorf("%s failed to sign the data: %s", program, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.Bytes(), nil
+}
+
+func signSSH(payload []byte, program string, key string) ([]byte, error) {
+	args := []string{"-Y", "sign", "-n", sshSignatureNamespace}
+
+	// A literal public key selects a private key held by the ssh-agent.
+	literal, isLiteral := strings.CutPrefix(key, "key::")
+	if isLiteral || strings.HasPrefix(key, "ssh-") {
+		if !isLiteral {
+			literal = key
+		}
+
+		file, err := writeTemporaryFile(".git_signing_key_tmp", []byte(literal+"\n"))
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(file)
+
+		args = append(args, "-U", "-f", file)
+	} else {
+		args = append(args, "-f", expandHome(key))
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(program, args...)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return nil, fmt.Errorf("%s failed to sign the data: %s", program, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// verifySignature checks a signature of a commit or tag payload. SSH
+// signatures must be made by a key in gpg.ssh.allowedSignersFile for the
+// principal it lists the key for.
+func verifySignature(payload []byte, signature []byte) (SignatureVerification, error) {
+	if len(signature) == 0 {
+		return SignatureVerification{}, ErrNoSignature
+	}
+
+	format := signatureFormat(signature)
+	signatureFile, err := writeTemporaryFile(".git_vtag_tmp", signature)
+	if err != nil {
+		return SignatureVerification{}, err
+	}
+	defer os.Remove(signatureFile)
+
+	if format == signatureFormatSSH {
+		return verifySSH(payload, signingProgram(format), signatureFile)
+	}
+	return verifyGPG(payload, format, signingProgram(format), signatureFile)
+}
+
+func verifyGPG(payload []byte, format string, program string, signatureFile string) (SignatureVerification, error) {
+	var status, stderr bytes.Buffer
+	cmd := exec.Command(program, "--keyid-format=long", "--status-fd=1", "--verify", signatureFile, "-")
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout = &status
+	cmd.Stderr = &stderr
+
+	runErr := cmd.Run()
+
+	verification := SignatureVerification{Format: format, Output: stderr.String()}
+	for _, line := range strings.Split(status.String(), "\n") {
+		if goodSignature, ok := strings.CutPrefix(line, "[GNUPG:] GOODSIG "); ok {
+			_, verification.Signer, _ = strings.Cut(goodSignature, " ")
+		}
+	}
+	if runErr != nil || verification.Signer == "" {
+		return verification, fmt.Errorf("bad signature: %s", strings.TrimSpace(stderr.String()))
+	}
+
+	return verification, nil
+}
+
+func verifySSH(payload []byte, program string, signatureFile string) (SignatureVerification, error) {
+	verification := SignatureVerification{Format: signatureFormatSSH}
+
+	allowedSigners, ok := config.Get("gpg.ssh.allowedSignersFile")
+	if !ok {
+		return verification, fmt.Errorf("gpg.ssh.allowedSignersFile needs to be configured and exist for ssh signature verification")
+	}
+	allowedSigners = expandHome(allowedSigners)
+
+	var principals, stderr bytes.Buffer
+	cmd := exec.Command(program, "-Y", "find-principals", "-f", allowedSigners, "-s", signatureFile)
+	cmd.Stdout = &principals
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return verification, fmt.Errorf("bad signature: no principal matched: %s", strings.TrimSpace(stderr.String()))
+	}
+
+	args := []string{"-Y", "verify", "-n", sshSignatureNamespace, "-f", allowedSigners, "-s", signatureFile}
+	if revocations, ok := config.Get("gpg.ssh.revocationFile"); ok {
+		args = append(args, "-r", expandHome(revocations))
+	}
+
+	for _, principal := range strings.Fields(principals.String()) {
+		var output bytes.Buffer
+		cmd := exec.Command(program, append(args, "-I", principal)...)
+		cmd.Stdin = bytes.NewReader(payload)
+		cmd.Stdout = &output
+		cmd.Stderr = &output
+
+		if err := cmd.Run(); err == nil {
+			verification.Signer = principal
+			verification.Output = output.String()
+			return verification, nil
+		}
+		verification.Output = output.String()
+	}
+
+	return verification, fmt.Errorf("bad signature: %s", strings.TrimSpace(verification.Output))
+}
+
+// VerifyCommit checks the signature of a commit.
+func VerifyCommit(revision string) (SignatureVerification, error) {
+	hash, err := resolveCommit(revision)
+	if err != nil {
+		return SignatureVerification{}, err
+	}
+
+	data, err := plumbing.ReadObjectOfKind(hash, plumbing.KindCommit)
+	if err != nil {
+		return SignatureVerification{}, fmt.Errorf("cannot read commit %x: %w", hash, err)
+	}
+
+	return verifySignature(plumbing.SplitCommitSignature(data))
+}
+
+// VerifyTag checks the signature of an annotated tag.
+func VerifyTag(name string) (SignatureVerification, error) {
+	hash, err := ResolveRevision(name)
+	if err != nil {
+		return SignatureVerification{}, err
+	}
+
+	data, err := plumbing.ReadObjectOfKind(hash, plumbing.KindTag)
+	if err != nil {
+		return SignatureVerification{}, fmt.Errorf("%s is not an annotated tag: %w", name, err)
+	}
+
+	return verifySignature(plumbing.SplitTagSignature(data))
+}
+
+func writeTemporaryFile(pattern string, content []byte) (string, error) {
+	file, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return "", fmt.Errorf("cannot create temporary file: %w", err)
+	}
+
+	_, err = file.Write(content)
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("cannot write temporary file: %w", err)
+	}
+
+	return file.Name(), nil
+}
+
+func expandHome(filename string) string {
+	if rest, ok := strings.CutPrefix(filename, "~/"); ok {
+		return path.Join(os.Getenv("HOME"), rest)
+	}
+
+	return filename
+}
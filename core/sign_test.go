@@ -0,0 +1,158 @@
+package core
+
+import (
+	"errors"
+	"github.com/untanky/git-charged/plumbing"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+)
+
+// initSigningTest creates a repository that signs commits and tags with a
+// new SSH key, which is the only allowed signer for signer@example.com.
+func initSigningTest(t *testing.T) {
+	t.Helper()
+
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen is not installed")
+	}
+
+	initTestRepository(t)
+
+	key := path.Join(t.TempDir(), "id_ed25519")
+	output, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-C", "", "-f", key).CombinedOutput()
+	if err != nil {
+		t.Fatalf("ssh-keygen: %v: %s", err, output)
+	}
+	publicKey, err := os.ReadFile(key + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowedSigners := path.Join(t.TempDir(), "allowed_signers")
+	err = os.WriteFile(allowedSigners, []byte("signer@example.com "+string(publicKey)), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setTestConfig(t, map[string]string{
+		"user.name":                  "Signer",
+		"user.email":                 "signer@example.com",
+		"user.signingkey":            key,
+		"gpg.format":                 "ssh",
+		"gpg.ssh.allowedSignersFile": allowedSigners,
+		"commit.gpgsign":             "true",
+	})
+}
+
+func TestSignAndVerifySSH(t *testing.T) {
+	initSigningTest(t)
+
+	hash, err := commitIndex("Signed\n", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commit, err := plumbing.ReadCommit(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signatureFormat([]byte(commit.Signature)) != signatureFormatSSH {
+		t.Fatalf("commit signature = %q, want an SSH signature", commit.Signature)
+	}
+
+	verification, err := VerifyCommit("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verification.Format != signatureFormatSSH || verification.Signer != "signer@example.com" {
+		t.Errorf("VerifyCommit() = %+v", verification)
+	}
+
+	_, err = CreateTag(CreateTagParams{Name: "v1", Message: "Version 1\n", Sign: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	verification, err = VerifyTag("v1")
+	if err != nil || verification.Signer != "signer@example.com" {
+		t.Errorf("VerifyTag() = %+v, %v", verification, err)
+	}
+
+	// The signature of the commit does not cover a different message.
+	commit.Message = "Forged\n"
+	forged, err := plumbing.WriteObject(commit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = VerifyCommit(shortHash(forged))
+	if err == nil || !strings.Contains(err.Error(), "bad signature") {
+		t.Errorf("VerifyCommit() of a forged commit = %v, want a bad signature", err)
+	}
+}
+
+func TestVerifyUnsignedCommit(t *testing.T) {
+	initTestRepository(t)
+	hash := writeTestCommit(t, writeTestTree(t), "Unsigned\n")
+
+	_, err := VerifyCommit(shortHash(hash))
+	if !errors.Is(err, ErrNoSignature) {
+		t.Errorf("VerifyCommit() = %v, want %v", err, ErrNoSignature)
+	}
+}
+
+func TestSignPayloadNeedsSSHKey(t *testing.T) {
+	initTestRepository(t)
+	setTestConfig(t, map[string]string{"gpg.format": "ssh"})
+
+	_, err := signPayload([]byte("payload"), plumbing.AuthorData{Name: "A", Email: "a@example.com"})
+	if err == nil || !strings.Contains(err.Error(), "user.signingkey") {
+		t.Errorf("signPayload() = %v, want to ask for user.signingkey", err)
+	}
+
+	setTestConfig(t, map[string]string{"gpg.format": "pgp"})
+	_, err = signPayload([]byte("payload"), plumbing.AuthorData{Name: "A", Email: "a@example.com"})
+	if err == nil || !strings.Contains(err.Error(), "unsupported gpg.format") {
+		t.Errorf("signPayload() = %v, want an unsupported format", err)
+	}
+}
+
+func TestSigningProgram(t *testing.T) {
+	tests := []struct {
+		config  map[string]string
+		format  string
+		program string
+	}{
+		{format: signatureFormatOpenPGP, program: "gpg"},
+		{format: signatureFormatSSH, program: "ssh-keygen"},
+		{format: signatureFormatX509, program: "gpgsm"},
+		{config: map[string]string{"gpg.program": "gpg2"}, format: signatureFormatOpenPGP, program: "gpg2"},
+		{config: map[string]string{"gpg.program": "gpg2"}, format: signatureFormatSSH, program: "ssh-keygen"},
+		{config: map[string]string{"gpg.program": "gpg2", "gpg.openpgp.program": "gpg3"}, format: signatureFormatOpenPGP, program: "gpg3"},
+		{config: map[string]string{"gpg.ssh.program": "/opt/ssh-keygen"}, format: signatureFormatSSH, program: "/opt/ssh-keygen"},
+	}
+
+	for _, test := range tests {
+		initTestRepository(t)
+		setTestConfig(t, test.config)
+
+		if program := signingProgram(test.format); program != test.program {
+			t.Errorf("signingProgram(%q) with %v = %q, want %q", test.format, test.config, program, test.program)
+		}
+	}
+}
+
+func TestSignatureFormat(t *testing.T) {
+	tests := map[string]string{
+		"-----BEGIN PGP SIGNATURE-----\n":  signatureFormatOpenPGP,
+		"-----BEGIN SSH SIGNATURE-----\n":  signatureFormatSSH,
+		"-----BEGIN SIGNED MESSAGE-----\n": signatureFormatX509,
+		"-----BEGIN PGP MESSAGE-----\nxyz": signatureFormatOpenPGP,
+	}
+
+	for signature, format := range tests {
+		if got := signatureFormat([]byte(signature)); got != format {
+			t.Errorf("signatureFormat(%q) = %q, want %q", signature, got, format)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/untanky/git-charged/plumbing"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	fileMode       = plumbing.ObjectTypeFile | 0644
+	executableMode = plumbing.ObjectTypeFile | 0755
+	symlinkMode    = plumbing.ObjectTypeSymbolicLink
+	gitLinkMode    = plumbing.ObjectTypeGitLink
+)
+
+// hashWorkingPath stores the content of a path in the working directory as
+// an object and returns its hash and tree mode. File content passes
+// through filter first. Directories that contain a repository of their own
+// are recorded as gitlinks to their HEAD commit.
+func hashWorkingPath(name string, filter *contentFilter) ([]byte, uint16, fs.FileInfo, error) {
+	info, err := os.Lstat(name)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	switch {
+	case info.IsDir():
+		if !isWorkingTree(name) {
+			return nil, 0, nil, fmt.Errorf("%s is a directory", name)
+		}
+
+		var hash []byte
+		err = withRepository(name, func() error {
+			hash, err = plumbing.ResolveRef(plumbing.HEAD)
+			return err
+		})
+		if err != nil {
+			return nil, 0, nil, fmt.Errorf("submodule %s has no commit checked out", name)
+		}
+		return hash, gitLinkMode, info, nil
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(name)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+
+		hash, err := plumbing.WriteObject(plumbing.NewBlob(uint32(len(target)), strings.NewReader(target)))
+		return hash, symlinkMode, info, err
+	default:
+		content, err := os.ReadFile(name)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+
+		content, err = filter.clean(name, content)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+
+		hash, err := plumbing.WriteObject(plumbing.NewBlob(uint32(len(content)), bytes.NewReader(content)))
+		if err != nil {
+			return nil, 0, nil, fmt.Errorf("cannot write %s: %w", name, err)
+		}
+
+		mode := uint16(fileMode)
+		if info.Mode()&0111 != 0 {
+			mode = executableMode
+		}
+		return hash, mode, info, nil
+	}
+}
+
+// stagePath updates the index entry of a path from the working directory.
+func stagePath(index *plumbing.Index, name string, filter *contentFilter) error {
+	hash, mode, info, err := hashWorkingPath(name, filter)
+	if err != nil {
+		return err
+	}
+
+	entry := plumbing.NewIndexEntry(name, hash, uint32(mode), info)
+	if existing, ok := index.Find(name); ok {
+		*existing = entry
+		return nil
+	}
+
+	index.Entries = append(index.Entries, entry)
+	index.Sort()
+
+	return nil
+}
+
+// writeIndexTree writes the trees described by the index and returns the
+// hash of the root tree. Gitlink entries are kept as they are.
+func writeIndexTree(index *plumbing.Index) ([]byte, error) {
+	entries := make([]plumbing.IndexEntry, 0, len(index.Entries))
+	for _, entry := range index.Entries {
+		if entry.Stage != 0 {
+			return nil, fmt.Errorf("%s has unresolved conflicts", entry.Name)
+		}
+		entries = append(entries, entry)
+	}
+
+	return writeTreeEntries(entries, "")
+}
+
+func writeTreeEntries(entries []plumbing.IndexEntry, prefix string) ([]byte, error) {
+	tree := plumbing.NewTree()
+
+	directories := make(map[string][]plumbing.IndexEntry)
+	names := make([]string, 0)
+	for _, entry := range entries {
+		name := strings.TrimPrefix(entry.Name, prefix)
+		directory, _, isNested := strings.Cut(name, "/")
+		if !isNested {
+			tree.AddObject(uint16(entry.Mode), name, entry.Hash)
+			continue
+		}
+
+		if _, ok := directories[directory]; !ok {
+			names = append(names, directory)
+		}
+		directories[directory] = append(directories[directory], entry)
+	}
+
+	sort.Strings(names)
+	for _, directory := range names {
+		hash, err := writeTreeEntries(directories[directory], prefix+directory+"/")
+		if err != nil {
+			return nil, err
+		}
+		tree.AddObject(plumbing.ObjectTypeDirectory, directory, hash)
+	}
+
+	return plumbing.WriteObject(tree)
+}
+
+// snapshotWorkingTree returns a copy of the index whose entries describe
+// the working tree instead. Deleted files are left out, and files whose
+// size and modification time match the index are not hashed again.
+func snapshotWorkingTree(index *plumbing.Index) (*plumbing.Index, error) {
+	filter := newContentFilter(workingTreeAttributes())
+	snapshot := &plumbing.Index{Entries: make([]plumbing.IndexEntry, 0, len(index.Entries))}
+
+	seen := make(map[string]bool, len(index.Entries))
+	for _, entry := range index.Entries {
+		if seen[entry.Name] {
+			continue
+		}
+		seen[entry.Name] = true
+
+		// Files outside of a sparse checkout are unchanged by definition.
+		if entry.SkipWorktree {
+			snapshot.Entries = append(snapshot.Entries, entry)
+			continue
+		}
+
+		info, err := os.Lstat(entry.Name)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		unchanged := entry.Stage == 0 && !info.IsDir() && int64(entry.Size) == info.Size() && entry.ModifyTime.Equal(info.ModTime())
+		uninitialized := entry.Mode&0xf000 == gitLinkMode && !isWorkingTree(entry.Name)
+		if unchanged || uninitialized {
+			entry.Stage = 0
+			snapshot.Entries = append(snapshot.Entries, entry)
+			continue
+		}
+
+		hash, mode, info, err := hashWorkingPath(entry.Name, filter)
+		if err != nil {
+			return nil, err
+		}
+		snapshot.Entries = append(snapshot.Entries, plumbing.NewIndexEntry(entry.Name, hash, uint32(mode), info))
+	}
+
+	return snapshot, nil
+}
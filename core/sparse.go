@@ -0,0 +1,370 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"github.com/untanky/git-charged/config"
+	"github.com/untanky/git-charged/plumbing"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+const coneModeHeader = "/*\n!/*/\n"
+
+// sparseCone is a cone mode sparse checkout. Recursive directories are
+// checked out completely; of their parent directories only the files
+// directly inside them are. Files at the top level are always included.
+type sparseCone struct {
+	recursive map[string]bool
+	parents   map[string]bool
+}
+
+func sparseCheckoutFile() string {
+	return path.Join(plumbing.Directory(), "info", "sparse-checkout")
+}
+
+func isSparseCheckout() bool {
+	enabled, _ := config.Get("core.sparseCheckout")
+	return enabled == "true"
+}
+
+// readSparseCheckout returns the cone of the current worktree, or nil if
+// sparse checkout is disabled.
+func readSparseCheckout() (*sparseCone, error) {
+	if !isSparseCheckout() {
+		return nil, nil
+	}
+
+	if cone, _ := config.Get("core.sparseCheckoutCone"); cone == "false" {
+		return nil, fmt.Errorf("only cone mode sparse checkouts are supported")
+	}
+
+	content, err := os.ReadFile(sparseCheckoutFile())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return parseConePatterns(string(content))
+}
+
+// parseConePatterns reads the restricted gitignore patterns git writes in
+// cone mode: "/dir/" includes a directory recursively, and a following
+// "!/dir/*/" turns it into a parent directory.
+func parseConePatterns(content string) (*sparseCone, error) {
+	cone := &sparseCone{recursive: make(map[string]bool), parents: make(map[string]bool)}
+
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "" || strings.HasPrefix(line, "#") || line == "/*" || line == "!/*/":
+		case strings.HasPrefix(line, "!/") && strings.HasSuffix(line, "/*/"):
+			directory := unescapeConePattern(strings.TrimSuffix(strings.TrimPrefix(line, "!/"), "/*/"))
+			delete(cone.recursive, directory)
+			cone.parents[directory] = true
+		case strings.HasPrefix(line, "/") && strings.HasSuffix(line, "/") && !hasConeWildcard(line):
+			directory := unescapeConePattern(strings.Trim(line, "/"))
+			if !cone.parents[directory] {
+				cone.recursive[directory] = true
+			}
+		default:
+			return nil, fmt.Errorf("sparse-checkout pattern %q is not a cone mode pattern", line)
+		}
+	}
+
+	return cone, nil
+}
+
+// hasConeWildcard reports whether a pattern has a wildcard that is not
+// escaped, which cone mode patterns of directories never have.
+func hasConeWildcard(pattern string) bool {
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '*', '?', '[':
+			return true
+		}
+	}
+	return false
+}
+
+func unescapeConePattern(pattern string) string {
+	var builder strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] == '\\' && i+1 < len(pattern) {
+			i++
+		}
+		builder.WriteByte(pattern[i])
+	}
+	return builder.String()
+}
+
+func escapeConePattern(directory string) string {
+	var builder strings.Builder
+	for _, c := range directory {
+		if strings.ContainsRune(`\*?[]!#`, c) {
+			builder.WriteByte('\\')
+		}
+		builder.WriteRune(c)
+	}
+	return builder.String()
+}
+
+// includes reports whether a file belongs to the sparse checkout.
+func (c *sparseCone) includes(name string) bool {
+	directory := path.Dir(name)
+	if directory == "." || c.parents[directory] {
+		return true
+	}
+
+	for ; directory != "."; directory = path.Dir(directory) {
+		if c.recursive[directory] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// includesDirectory reports whether any file below directory can belong to
+// the sparse checkout.
+func (c *sparseCone) includesDirectory(directory string) bool {
+	return c.parents[directory] || c.includes(path.Join(directory, "file"))
+}
+
+func (c *sparseCone) directories() []string {
+	directories := make([]string, 0, len(c.recursive))
+	for directory := range c.recursive {
+		directories = append(directories, directory)
+	}
+	sort.Strings(directories)
+
+	return directories
+}
+
+// formatConePatterns writes the cone mode patterns that include the given
+// directories recursively.
+func formatConePatterns(directories []string) string {
+	cone := &sparseCone{recursive: make(map[string]bool), parents: make(map[string]bool)}
+	for _, directory := range directories {
+		directory = path.Clean(strings.Trim(directory, "/"))
+		if directory != "." {
+			cone.recursive[directory] = true
+		}
+	}
+
+	// Directories inside other recursive directories are redundant.
+	for directory := range cone.recursive {
+		for parent := path.Dir(directory); parent != "."; parent = path.Dir(parent) {
+			if cone.recursive[parent] {
+				delete(cone.recursive, directory)
+				break
+			}
+		}
+	}
+
+	for directory := range cone.recursive {
+		for parent := path.Dir(directory); parent != "."; parent = path.Dir(parent) {
+			cone.parents[parent] = true
+		}
+	}
+
+	parents := make([]string, 0, len(cone.parents))
+	for parent := range cone.parents {
+		parents = append(parents, parent)
+	}
+	sort.Strings(parents)
+
+	var builder strings.Builder
+	builder.WriteString(coneModeHeader)
+	for _, parent := range parents {
+		fmt.Fprintf(&builder, "/%s/\n!/%s/*/\n", escapeConePattern(parent), escapeConePattern(parent))
+	}
+	for _, directory := range cone.directories() {
+		fmt.Fprintf(&builder, "/%s/\n", escapeConePattern(directory))
+	}
+
+	return builder.String()
+}
+
+// applySparseCheckout adds the files of the index that belong to the cone
+// to the working tree and removes the others. Files with local changes are
+// kept. A nil cone restores all files.
+func applySparseCheckout(cone *sparseCone, progress io.Writer) error {
+	index, err := plumbing.ReadIndex()
+	if err != nil {
+		return fmt.Errorf("cannot read index: %w", err)
+	}
+
+	missing := make([][]byte, 0)
+	for _, entry := range index.Entries {
+		if entry.SkipWorktree && (cone == nil || cone.includes(entry.Name)) {
+			missing = append(missing, entry.Hash)
+		}
+	}
+	err = plumbing.FetchMissingObjects(missing)
+	if err != nil {
+		return fmt.Errorf("cannot fetch missing objects: %w", err)
+	}
+
+	filter := newContentFilter(workingTreeAttributes())
+	for i := range index.Entries {
+		entry := &index.Entries[i]
+		if entry.Stage != 0 || entry.Mode&0xf000 == gitLinkMode {
+			continue
+		}
+
+		included := cone == nil || cone.includes(entry.Name)
+		switch {
+		case included && entry.SkipWorktree:
+			err = createParentDirectories(entry.Name)
+			if err != nil {
+				return err
+			}
+
+			treeEntry := plumbing.TreeEntry{Name: path.Base(entry.Name), Mode: uint16(entry.Mode), Hash: entry.Hash}
+			err = checkoutEntry(entry.Name, treeEntry, filter)
+			if err != nil {
+				return fmt.Errorf("cannot check out %s: %w", entry.Name, err)
+			}
+
+			info, err := os.Lstat(entry.Name)
+			if err != nil {
+				return err
+			}
+			*entry = plumbing.NewIndexEntry(entry.Name, entry.Hash, entry.Mode, info)
+		case !included && !entry.SkipWorktree:
+			modified, err := isModified(*entry, filter)
+			if err != nil {
+				return err
+			}
+			if modified {
+				if progress != nil {
+					fmt.Fprintf(progress, "warning: not removing %s, it has local changes\n", entry.Name)
+				}
+				continue
+			}
+
+			err = removeWorkingFile(entry.Name)
+			if err != nil {
+				return err
+			}
+			entry.SkipWorktree = true
+		}
+	}
+
+	return plumbing.WriteIndex(index)
+}
+
+// isModified reports whether the working tree file of an index entry
+// differs from the entry. Missing files are not modified.
+func isModified(entry plumbing.IndexEntry, filter *contentFilter) (bool, error) {
+	info, err := os.Lstat(entry.Name)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if int64(entry.Size) == info.Size() && entry.ModifyTime.Equal(info.ModTime()) {
+		return false, nil
+	}
+
+	hash, mode, _, err := hashWorkingPath(entry.Name, filter)
+	if err != nil {
+		return false, err
+	}
+
+	return !(fileVersion{hash: hash, mode: uint32(mode)}).equal(fileVersion{hash: entry.Hash, mode: entry.Mode}), nil
+}
+
+// SparseCheckoutDirectories lists the directories of the sparse checkout.
+func SparseCheckoutDirectories() ([]string, error) {
+	cone, err := readSparseCheckout()
+	if err != nil {
+		return nil, err
+	}
+	if cone == nil {
+		return nil, fmt.Errorf("this worktree is not sparse")
+	}
+
+	return cone.directories(), nil
+}
+
+// SetSparseCheckout enables a cone mode sparse checkout of the given
+// directories and updates the working tree. Without directories only the
+// files at the top level are checked out.
+func SetSparseCheckout(directories []string, progress io.Writer) error {
+	err := os.MkdirAll(path.Dir(sparseCheckoutFile()), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	patterns := formatConePatterns(directories)
+	err = os.WriteFile(sparseCheckoutFile(), []byte(patterns), 0644)
+	if err != nil {
+		return err
+	}
+
+	for _, value := range [][2]string{{"core.sparseCheckout", "true"}, {"core.sparseCheckoutCone", "true"}} {
+		err = config.SetValue(localConfigPath(), value[0], value[1])
+		if err != nil {
+			return err
+		}
+	}
+	config.ReloadConfig()
+
+	cone, err := parseConePatterns(patterns)
+	if err != nil {
+		return err
+	}
+
+	return applySparseCheckout(cone, progress)
+}
+
+// AddSparseCheckout adds directories to the sparse checkout.
+func AddSparseCheckout(directories []string, progress io.Writer) error {
+	existing, err := SparseCheckoutDirectories()
+	if err != nil {
+		return err
+	}
+
+	return SetSparseCheckout(append(existing, directories...), progress)
+}
+
+// ReapplySparseCheckout updates the working tree after files were checked
+// out or kept outside of the sparse checkout.
+func ReapplySparseCheckout(progress io.Writer) error {
+	cone, err := readSparseCheckout()
+	if err != nil {
+		return err
+	}
+	if cone == nil {
+		return fmt.Errorf("this worktree is not sparse")
+	}
+
+	return applySparseCheckout(cone, progress)
+}
+
+// DisableSparseCheckout checks out all files again. The patterns are kept
+// so the same sparse checkout can be enabled again.
+func DisableSparseCheckout(progress io.Writer) error {
+	err := applySparseCheckout(nil, progress)
+	if err != nil {
+		return err
+	}
+
+	err = config.SetValue(localConfigPath(), "core.sparseCheckout", "false")
+	if err != nil {
+		return err
+	}
+	config.ReloadConfig()
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package core
+
+import (
+	"slices"
+	"testing"
+)
+
+// The expected patterns are the ones git sparse-checkout set writes for
+// the same directories.
+func TestFormatConePatterns(t *testing.T) {
+	tests := []struct {
+		name        string
+		directories []string
+		patterns    string
+	}{
+		{
+			name:     "top level only",
+			patterns: "/*\n!/*/\n",
+		},
+		{
+			name:        "nested directories",
+			directories: []string{"a/b", "c", "a/b/d", "e/f/g/"},
+			patterns:    "/*\n!/*/\n/a/\n!/a/*/\n/e/\n!/e/*/\n/e/f/\n!/e/f/*/\n/a/b/\n/c/\n/e/f/g/\n",
+		},
+		{
+			name:        "special characters",
+			directories: []string{"x*y"},
+			patterns:    "/*\n!/*/\n/x\\*y/\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			patterns := formatConePatterns(test.directories)
+			if patterns != test.patterns {
+				t.Errorf("formatConePatterns(%q) = %q, want %q", test.directories, patterns, test.patterns)
+			}
+		})
+	}
+}
+
+func TestParseConePatterns(t *testing.T) {
+	cone, err := parseConePatterns(formatConePatterns([]string{"a/b", "c", "e/f/g", "x*y"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if directories := cone.directories(); !slices.Equal(directories, []string{"a/b", "c", "e/f/g", "x*y"}) {
+		t.Errorf("directories() = %q", directories)
+	}
+
+	tests := []struct {
+		name     string
+		included bool
+	}{
+		{name: "top", included: true},
+		{name: "a/file", included: true},
+		{name: "a/other/file", included: false},
+		{name: "a/b/file", included: true},
+		{name: "a/b/deep/file", included: true},
+		{name: "c/file", included: true},
+		{name: "d/file", included: false},
+		{name: "e/f/file", included: true},
+		{name: "e/f/h/file", included: false},
+		{name: "e/f/g/h/file", included: true},
+		{name: "x*y/file", included: true},
+		{name: "xzy/file", included: false},
+	}
+
+	for _, test := range tests {
+		if included := cone.includes(test.name); included != test.included {
+			t.Errorf("includes(%q) = %t, want %t", test.name, included, test.included)
+		}
+	}
+
+	for directory, included := range map[string]bool{"a": true, "a/b/deep": true, "a/other": false, "e": true, "e/f/h": false, "d": false} {
+		if cone.includesDirectory(directory) != included {
+			t.Errorf("includesDirectory(%q) = %t, want %t", directory, !included, included)
+		}
+	}
+}
+
+func TestParseConePatternsRejectsOtherPatterns(t *testing.T) {
+	for _, patterns := range []string{"/*\n!/*/\n*.go\n", "/*\n!/*/\n/a/*/b/\n", "/*\n!/*/\nfile\n"} {
+		_, err := parseConePatterns(patterns)
+		if err == nil {
+			t.Errorf("parseConePatterns(%q) succeeded", patterns)
+		}
+	}
+}
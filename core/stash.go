@@ -0,0 +1,530 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/untanky/git-charged/plumbing"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const stashRef = "refs/stash"
+
+var (
+	ErrNoLocalChanges = errors.New("no local changes to save")
+	ErrNoStashEntries = errors.New("no stash entries found")
+
+	stashNamePattern = regexp.MustCompile(`^(?:(?:refs/)?stash@\{(\d+)\}|(\d+))$`)
+)
+
+// StashEntry is a stash saved on refs/stash. Entries are numbered from the
+// most recent one, which is stash@{0}.
+type StashEntry struct {
+	Number  int
+	Hash    []byte
+	Message string
+}
+
+func (e StashEntry) Name() string {
+	return fmt.Sprintf("stash@{%d}", e.Number)
+}
+
+// StashChange is a file that a stash modifies.
+type StashChange struct {
+	// Status is 'A' for added, 'M' for modified and 'D' for deleted files.
+	Status byte
+	Path   string
+}
+
+type StashPushParams struct {
+	Message  string
+	Progress io.Writer
+}
+
+type StashApplyParams struct {
+	// Stash names the entry to apply, such as "stash@{1}" or "1". The most
+	// recent entry is used if it is empty.
+	Stash string
+	// Index also restores the changes that were staged.
+	Index    bool
+	Progress io.Writer
+}
+
+// fileVersion is the content of a path in a tree or index. A missing path
+// has no hash.
+type fileVersion struct {
+	hash []byte
+	mode uint32
+}
+
+func (v fileVersion) exists() bool {
+	return v.hash != nil
+}
+
+func (v fileVersion) equal(other fileVersion) bool {
+	return bytes.Equal(v.hash, other.hash) && v.mode == other.mode
+}
+
+func treeVersions(treeHash []byte) (map[string]fileVersion, error) {
+	versions := make(map[string]fileVersion)
+	if treeHash == nil {
+		return versions, nil
+	}
+
+	return versions, collectTreeVersions(treeHash, "", versions)
+}
+
+func collectTreeVersions(treeHash []byte, prefix string, versions map[string]fileVersion) error {
+	tree, err := plumbing.ReadTree(treeHash)
+	if err != nil {
+		return fmt.Errorf("cannot read tree %x: %w", treeHash, err)
+	}
+
+	for _, entry := range tree.Entries() {
+		name := path.Join(prefix, entry.Name)
+		if entry.IsDirectory() {
+			err = collectTreeVersions(entry.Hash, name, versions)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		versions[name] = fileVersion{hash: entry.Hash, mode: uint32(entry.Mode)}
+	}
+
+	return nil
+}
+
+func indexVersions(index *plumbing.Index) map[string]fileVersion {
+	versions := make(map[string]fileVersion, len(index.Entries))
+	for _, entry := range index.Entries {
+		versions[entry.Name] = fileVersion{hash: entry.Hash, mode: entry.Mode}
+	}
+	return versions
+}
+
+// changedPaths lists the paths that differ between two sets of versions in
+// sorted order.
+func changedPaths(from map[string]fileVersion, to map[string]fileVersion) []string {
+	paths := make([]string, 0)
+	for name, version := range from {
+		if !version.equal(to[name]) {
+			paths = append(paths, name)
+		}
+	}
+	for name := range to {
+		if _, ok := from[name]; !ok {
+			paths = append(paths, name)
+		}
+	}
+	sort.Strings(paths)
+
+	return paths
+}
+
+// headDescription describes the commit HEAD points to like git does in
+// stash messages, for example "main: 1a2b3c4 Fix typo".
+func headDescription(head []byte, commit *plumbing.Commit) string {
+	branch, ok := currentBranch()
+	if !ok {
+		branch = "(no branch)"
+	}
+
+	subject, _, _ := strings.Cut(commit.Message, "\n")
+	return fmt.Sprintf("%s: %s %s", branch, shortHash(head), subject)
+}
+
+// PushStash saves the staged and unstaged changes of tracked files on
+// refs/stash and resets the working tree and index to HEAD. The stash is a
+// commit of the working tree whose parents are HEAD and a commit of the
+// index, like git's.
+func PushStash(params StashPushParams) (StashEntry, error) {
+	head, err := plumbing.ResolveRef(plumbing.HEAD)
+	if err != nil {
+		return StashEntry{}, fmt.Errorf("you do not have the initial commit yet")
+	}
+
+	headCommit, err := plumbing.ReadCommit(head)
+	if err != nil {
+		return StashEntry{}, err
+	}
+
+	index, err := plumbing.ReadIndex()
+	if err != nil {
+		return StashEntry{}, fmt.Errorf("cannot read index: %w", err)
+	}
+
+	indexTree, err := writeIndexTree(index)
+	if err != nil {
+		return StashEntry{}, err
+	}
+
+	workingTree, err := snapshotWorkingTree(index)
+	if err != nil {
+		return StashEntry{}, err
+	}
+
+	workingTreeHash, err := writeIndexTree(workingTree)
+	if err != nil {
+		return StashEntry{}, err
+	}
+
+	if bytes.Equal(indexTree, headCommit.Tree) && bytes.Equal(workingTreeHash, headCommit.Tree) {
+		return StashEntry{}, ErrNoLocalChanges
+	}
+
+	me, err := currentIdentity()
+	if err != nil {
+		return StashEntry{}, err
+	}
+
+	description := headDescription(head, headCommit)
+	indexCommit, err := plumbing.WriteObject(&plumbing.Commit{
+		Tree:      indexTree,
+		Parents:   [][]byte{head},
+		Author:    me,
+		Committer: me,
+		Message:   "index on " + description + "\n",
+	})
+	if err != nil {
+		return StashEntry{}, err
+	}
+
+	message := "WIP on " + description
+	if params.Message != "" {
+		branch, _, _ := strings.Cut(description, ":")
+		message = fmt.Sprintf("On %s: %s", branch, params.Message)
+	}
+
+	stash, err := plumbing.WriteObject(&plumbing.Commit{
+		Tree:      workingTreeHash,
+		Parents:   [][]byte{head, indexCommit},
+		Author:    me,
+		Committer: me,
+		Message:   message + "\n",
+	})
+	if err != nil {
+		return StashEntry{}, err
+	}
+
+	previous, _ := plumbing.ResolveRef(stashRef)
+	err = plumbing.WriteRef(stashRef, stash)
+	if err != nil {
+		return StashEntry{}, err
+	}
+
+	err = plumbing.AppendReflog(stashRef, plumbing.ReflogEntry{Old: previous, New: stash, Committer: me, Message: message})
+	if err != nil {
+		return StashEntry{}, err
+	}
+
+	err = checkoutTree(headCommit.Tree, true)
+	if err != nil {
+		return StashEntry{}, err
+	}
+
+	if params.Progress != nil {
+		fmt.Fprintf(params.Progress, "Saved working directory and index state %s\n", message)
+	}
+
+	return StashEntry{Number: 0, Hash: stash, Message: message}, nil
+}
+
+// ListStashes returns the stash entries, most recent first.
+func ListStashes() ([]StashEntry, error) {
+	log, err := plumbing.ReadReflog(stashRef)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]StashEntry, 0, len(log))
+	for i := len(log) - 1; i >= 0; i-- {
+		entries = append(entries, StashEntry{Number: len(entries), Hash: log[i].New, Message: log[i].Message})
+	}
+
+	return entries, nil
+}
+
+// FindStash looks up a stash entry by a name like "stash@{2}" or "2". An
+// empty name selects the most recent entry.
+func FindStash(name string) (StashEntry, error) {
+	entries, err := ListStashes()
+	if err != nil {
+		return StashEntry{}, err
+	}
+	if len(entries) == 0 {
+		return StashEntry{}, ErrNoStashEntries
+	}
+	if name == "" {
+		return entries[0], nil
+	}
+
+	match := stashNamePattern.FindStringSubmatch(name)
+	if match == nil {
+		return StashEntry{}, fmt.Errorf("%s is not a valid stash reference", name)
+	}
+
+	number, _ := strconv.Atoi(match[1] + match[2])
+	if number >= len(entries) {
+		return StashEntry{}, fmt.Errorf("stash@{%d} does not exist, there are only %d stash entries", number, len(entries))
+	}
+
+	return entries[number], nil
+}
+
+// readStash returns the trees a stash was made from: the base commit, the
+// index and the working tree.
+func readStash(entry StashEntry) ([]byte, []byte, []byte, error) {
+	commit, err := plumbing.ReadCommit(entry.Hash)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(commit.Parents) < 2 {
+		return nil, nil, nil, fmt.Errorf("%s is not a stash commit", entry.Name())
+	}
+
+	base, err := plumbing.ReadCommit(commit.Parents[0])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	index, err := plumbing.ReadCommit(commit.Parents[1])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return base.Tree, index.Tree, commit.Tree, nil
+}
+
+// StashChanges lists the files a stash changes compared to the commit it
+// was made on.
+func StashChanges(entry StashEntry) ([]StashChange, error) {
+	baseTree, _, workingTree, err := readStash(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	base, err := treeVersions(baseTree)
+	if err != nil {
+		return nil, err
+	}
+
+	stashed, err := treeVersions(workingTree)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make([]StashChange, 0)
+	for _, name := range changedPaths(base, stashed) {
+		status := byte('M')
+		switch {
+		case !base[name].exists():
+			status = 'A'
+		case !stashed[name].exists():
+			status = 'D'
+		}
+		changes = append(changes, StashChange{Status: status, Path: name})
+	}
+
+	return changes, nil
+}
+
+// ApplyStash restores the changes of a stash on top of the current HEAD.
+// Files changed by the stash must not have local changes and must not have
+// been changed by HEAD since the stash was created; no content merge is
+// attempted.
+func ApplyStash(params StashApplyParams) (StashEntry, error) {
+	entry, err := FindStash(params.Stash)
+	if err != nil {
+		return StashEntry{}, err
+	}
+
+	baseTree, indexTree, workingTree, err := readStash(entry)
+	if err != nil {
+		return StashEntry{}, err
+	}
+
+	var headTree []byte
+	if head, err := plumbing.ResolveRef(plumbing.HEAD); err == nil {
+		commit, err := plumbing.ReadCommit(head)
+		if err != nil {
+			return StashEntry{}, err
+		}
+		headTree = commit.Tree
+	}
+
+	base, err := treeVersions(baseTree)
+	if err != nil {
+		return StashEntry{}, err
+	}
+	stashedIndex, err := treeVersions(indexTree)
+	if err != nil {
+		return StashEntry{}, err
+	}
+	stashed, err := treeVersions(workingTree)
+	if err != nil {
+		return StashEntry{}, err
+	}
+	current, err := treeVersions(headTree)
+	if err != nil {
+		return StashEntry{}, err
+	}
+
+	index, err := plumbing.ReadIndex()
+	if err != nil {
+		return StashEntry{}, fmt.Errorf("cannot read index: %w", err)
+	}
+	staged := indexVersions(index)
+
+	snapshot, err := snapshotWorkingTree(index)
+	if err != nil {
+		return StashEntry{}, err
+	}
+	working := indexVersions(snapshot)
+
+	changed := changedPaths(base, stashed)
+	if params.Index {
+		changed = append(changed, changedPaths(base, stashedIndex)...)
+	}
+
+	for _, name := range changed {
+		if stashed[name].equal(current[name]) && (!params.Index || stashedIndex[name].equal(current[name])) {
+			continue
+		}
+		if !base[name].equal(current[name]) {
+			return StashEntry{}, fmt.Errorf("cannot apply %s: %s was changed since the stash was created", entry.Name(), name)
+		}
+		if !staged[name].equal(current[name]) || !working[name].equal(staged[name]) {
+			return StashEntry{}, fmt.Errorf("cannot apply %s: your local changes to %s would be overwritten", entry.Name(), name)
+		}
+		if _, err := os.Lstat(name); !current[name].exists() && err == nil {
+			return StashEntry{}, fmt.Errorf("cannot apply %s: untracked file %s would be overwritten", entry.Name(), name)
+		}
+	}
+
+	filter := newContentFilter(treeAttributes(workingTree))
+	for _, name := range changedPaths(base, stashed) {
+		version := stashed[name]
+		if !version.exists() {
+			err = removeWorkingFile(name)
+			if err != nil {
+				return StashEntry{}, err
+			}
+			continue
+		}
+
+		err = createParentDirectories(name)
+		if err != nil {
+			return StashEntry{}, err
+		}
+
+		err = checkoutEntry(name, plumbing.TreeEntry{Name: path.Base(name), Mode: uint16(version.mode), Hash: version.hash}, filter)
+		if err != nil {
+			return StashEntry{}, fmt.Errorf("cannot check out %s: %w", name, err)
+		}
+
+		// New files are staged so they are not left untracked.
+		if !base[name].exists() {
+			setIndexEntry(index, name, version, true)
+		}
+	}
+
+	if params.Index {
+		for _, name := range changedPaths(base, stashedIndex) {
+			if version := stashedIndex[name]; version.exists() {
+				setIndexEntry(index, name, version, version.equal(stashed[name]))
+			} else {
+				removeIndexEntry(index, name)
+			}
+		}
+	}
+
+	err = plumbing.WriteIndex(index)
+	if err != nil {
+		return StashEntry{}, fmt.Errorf("cannot write index: %w", err)
+	}
+
+	return entry, nil
+}
+
+// setIndexEntry stages a version of a path. The stat information of the
+// working tree file is recorded only if withStat is set, because it must
+// not be trusted for content that differs from the file.
+func setIndexEntry(index *plumbing.Index, name string, version fileVersion, withStat bool) {
+	var info os.FileInfo
+	if withStat {
+		info, _ = os.Lstat(name)
+	}
+
+	entry := plumbing.NewIndexEntry(name, version.hash, version.mode, info)
+	if existing, ok := index.Find(name); ok {
+		*existing = entry
+		return
+	}
+
+	index.Entries = append(index.Entries, entry)
+	index.Sort()
+}
+
+func removeIndexEntry(index *plumbing.Index, name string) {
+	entries := index.Entries[:0]
+	for _, entry := range index.Entries {
+		if entry.Name != name {
+			entries = append(entries, entry)
+		}
+	}
+	index.Entries = entries
+}
+
+// DropStash removes a stash entry. refs/stash is moved to the next most
+// recent entry or deleted with the last one.
+func DropStash(name string) (StashEntry, error) {
+	entry, err := FindStash(name)
+	if err != nil {
+		return StashEntry{}, err
+	}
+
+	log, err := plumbing.ReadReflog(stashRef)
+	if err != nil {
+		return StashEntry{}, err
+	}
+
+	position := len(log) - 1 - entry.Number
+	log = append(log[:position], log[position+1:]...)
+
+	err = plumbing.WriteReflog(stashRef, log)
+	if err != nil {
+		return StashEntry{}, err
+	}
+
+	if len(log) == 0 {
+		return entry, plumbing.DeleteRef(stashRef)
+	}
+
+	return entry, plumbing.WriteRef(stashRef, log[len(log)-1].New)
+}
+
+// PopStash applies a stash and drops it if it applied cleanly.
+func PopStash(params StashApplyParams) (StashEntry, error) {
+	entry, err := ApplyStash(params)
+	if err != nil {
+		return StashEntry{}, err
+	}
+
+	entry, err = DropStash(entry.Name())
+	if err != nil {
+		return StashEntry{}, err
+	}
+
+	if params.Progress != nil {
+		fmt.Fprintf(params.Progress, "Dropped %s (%x)\n", entry.Name(), entry.Hash)
+	}
+
+	return entry, nil
+}
@@ -0,0 +1,183 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"github.com/untanky/git-charged/plumbing"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// initStashTest creates a repository with a commit of the files "staged"
+// and "unstaged" checked out, and changes both with only "staged" added to
+// the index.
+func initStashTest(t *testing.T) []byte {
+	t.Helper()
+
+	initTestRepository(t)
+	setTestConfig(t, map[string]string{"user.name": "Tester", "user.email": "tester@example.com"})
+	tree := writeTestTree(t,
+		plumbing.TreeEntry{Mode: plumbing.ObjectTypeFile | 0644, Name: "staged", Hash: writeTestBlob(t, "base\n")},
+		plumbing.TreeEntry{Mode: plumbing.ObjectTypeFile | 0644, Name: "unstaged", Hash: writeTestBlob(t, "base\n")},
+	)
+	commit := writeTestCommit(t, tree, "Base\n")
+
+	err := plumbing.WriteRef("refs/heads/main", commit)
+	if err == nil {
+		err = plumbing.WriteSymbolicRef(plumbing.HEAD, "refs/heads/main")
+	}
+	if err == nil {
+		err = checkoutTree(tree, false)
+	}
+	for _, name := range []string{"staged", "unstaged"} {
+		if err == nil {
+			err = os.WriteFile(name, []byte("changed\n"), 0644)
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index, err := plumbing.ReadIndex()
+	if err == nil {
+		err = stagePath(index, "staged", newContentFilter(workingTreeAttributes()))
+	}
+	if err == nil {
+		err = plumbing.WriteIndex(index)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return commit
+}
+
+// stagedContent returns the content of a path in the index.
+func stagedContent(t *testing.T, name string) string {
+	t.Helper()
+
+	index, err := plumbing.ReadIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, ok := index.Find(name)
+	if !ok {
+		t.Fatalf("%s is not in the index", name)
+	}
+	content, err := plumbing.ReadObjectOfKind(entry.Hash, plumbing.KindBlob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestStashRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		index  bool
+		staged string
+	}{
+		{name: "without the index", staged: "base\n"},
+		{name: "with the index", index: true, staged: "changed\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			head := initStashTest(t)
+
+			entry, err := PushStash(StashPushParams{Message: "work in progress"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if entry.Message != "On main: work in progress" {
+				t.Errorf("stash message = %q", entry.Message)
+			}
+			for _, name := range []string{"staged", "unstaged"} {
+				if content, _ := os.ReadFile(name); string(content) != "base\n" {
+					t.Errorf("%s = %q after push, want it reset", name, content)
+				}
+			}
+
+			changes, err := StashChanges(entry)
+			want := []StashChange{{Status: 'M', Path: "staged"}, {Status: 'M', Path: "unstaged"}}
+			if err != nil || !slices.Equal(changes, want) {
+				t.Errorf("StashChanges() = %v, %v, want %v", changes, err, want)
+			}
+
+			_, err = PushStash(StashPushParams{})
+			if !errors.Is(err, ErrNoLocalChanges) {
+				t.Errorf("PushStash() without changes = %v, want %v", err, ErrNoLocalChanges)
+			}
+
+			_, err = PopStash(StashApplyParams{Stash: "stash@{0}", Index: test.index})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range []string{"staged", "unstaged"} {
+				if content, _ := os.ReadFile(name); string(content) != "changed\n" {
+					t.Errorf("%s = %q after pop, want %q", name, content, "changed\n")
+				}
+			}
+			if staged := stagedContent(t, "staged"); staged != test.staged {
+				t.Errorf("staged content = %q after pop, want %q", staged, test.staged)
+			}
+
+			current, err := plumbing.ResolveRef(plumbing.HEAD)
+			if err != nil || !bytes.Equal(current, head) {
+				t.Errorf("HEAD = %x, %v, want %x", current, err, head)
+			}
+			if entries, err := ListStashes(); err != nil || len(entries) != 0 {
+				t.Errorf("ListStashes() = %v, %v after pop, want none", entries, err)
+			}
+		})
+	}
+}
+
+func TestFindStash(t *testing.T) {
+	initStashTest(t)
+
+	first, err := PushStash(StashPushParams{Message: "first"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile("unstaged", []byte("second\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := PushStash(StashPushParams{Message: "second"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		hash []byte
+	}{
+		{name: "", hash: second.Hash},
+		{name: "0", hash: second.Hash},
+		{name: "stash@{1}", hash: first.Hash},
+		{name: "refs/stash@{1}", hash: first.Hash},
+		{name: "stash@{2}"},
+		{name: "stash"},
+	}
+
+	for _, test := range tests {
+		entry, err := FindStash(test.name)
+		switch {
+		case test.hash == nil && err == nil:
+			t.Errorf("FindStash(%q) = %s, want an error", test.name, entry.Name())
+		case test.hash != nil && (err != nil || !bytes.Equal(entry.Hash, test.hash)):
+			t.Errorf("FindStash(%q) = %x, %v, want %x", test.name, entry.Hash, err, test.hash)
+		}
+	}
+
+	dropped, err := DropStash("stash@{1}")
+	if err != nil || !strings.HasSuffix(dropped.Message, "first") {
+		t.Errorf("DropStash() = %+v, %v", dropped, err)
+	}
+	if entry, err := FindStash(""); err != nil || !bytes.Equal(entry.Hash, second.Hash) {
+		t.Errorf("FindStash() = %x, %v after dropping the older entry, want %x", entry.Hash, err, second.Hash)
+	}
+}
@@ -0,0 +1,183 @@
+package core
+
+import (
+	"github.com/untanky/git-charged/plumbing"
+	"os"
+	"path"
+	"sort"
+)
+
+// FileStatus is a path that differs between HEAD, the index and the
+// working tree.
+type FileStatus struct {
+	// Status is 'A' for added, 'M' for modified and 'D' for deleted files.
+	Status byte
+	Path   string
+}
+
+type StatusResult struct {
+	// Branch is the current branch, or empty if HEAD is detached.
+	Branch string
+	// Head is the commit HEAD points to, or nil before the first commit.
+	Head []byte
+	// Staged are the changes of the index compared to HEAD.
+	Staged []FileStatus
+	// Unstaged are the changes of the working tree compared to the index.
+	Unstaged []FileStatus
+	// Unmerged are paths with unresolved conflicts.
+	Unmerged  []string
+	Untracked []string
+	// Sparse is set in a sparse checkout, where SparsePercentage of the
+	// tracked files are present in the working tree.
+	Sparse           bool
+	SparsePercentage int
+}
+
+func fileStatuses(from map[string]fileVersion, to map[string]fileVersion) []FileStatus {
+	statuses := make([]FileStatus, 0)
+	for _, name := range changedPaths(from, to) {
+		status := byte('M')
+		switch {
+		case !from[name].exists():
+			status = 'A'
+		case !to[name].exists():
+			status = 'D'
+		}
+		statuses = append(statuses, FileStatus{Status: status, Path: name})
+	}
+
+	return statuses
+}
+
+// Status compares HEAD, the index and the working tree and lists the
+// untracked files that are not ignored.
+func Status() (*StatusResult, error) {
+	result := &StatusResult{}
+	result.Branch, _ = currentBranch()
+	result.Sparse = isSparseCheckout()
+
+	var headTree []byte
+	if head, err := plumbing.ResolveRef(plumbing.HEAD); err == nil {
+		commit, err := plumbing.ReadCommit(head)
+		if err != nil {
+			return nil, err
+		}
+		result.Head = head
+		headTree = commit.Tree
+	}
+
+	head, err := treeVersions(headTree)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := plumbing.ReadIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	merged := &plumbing.Index{Entries: make([]plumbing.IndexEntry, 0, len(index.Entries))}
+	present := 0
+	for _, entry := range index.Entries {
+		if entry.Stage != 0 {
+			if len(result.Unmerged) == 0 || result.Unmerged[len(result.Unmerged)-1] != entry.Name {
+				result.Unmerged = append(result.Unmerged, entry.Name)
+			}
+			continue
+		}
+		merged.Entries = append(merged.Entries, entry)
+
+		if !entry.SkipWorktree {
+			present++
+		}
+	}
+	if len(merged.Entries) > 0 {
+		result.SparsePercentage = present * 100 / len(merged.Entries)
+	}
+
+	staged := indexVersions(merged)
+	snapshot, err := snapshotWorkingTree(merged)
+	if err != nil {
+		return nil, err
+	}
+
+	result.Staged = fileStatuses(head, staged)
+	result.Unstaged = fileStatuses(staged, indexVersions(snapshot))
+
+	tracked := trackedPaths(index)
+
+	result.Untracked, err = untrackedFiles(".", tracked, newIgnoreMatcher())
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// trackedPaths returns the set of files of an index, and of their parent
+// directories with a trailing slash, as untrackedFiles expects it.
+func trackedPaths(index *plumbing.Index) map[string]bool {
+	tracked := make(map[string]bool, len(index.Entries))
+	for _, entry := range index.Entries {
+		tracked[entry.Name] = true
+		for directory := path.Dir(entry.Name); directory != "."; directory = path.Dir(directory) {
+			tracked[directory+"/"] = true
+		}
+	}
+	return tracked
+}
+
+// untrackedFiles lists the files below directory that are neither tracked
+// nor ignored. Directories without tracked files are listed as a whole
+// with a trailing slash, like git does.
+func untrackedFiles(directory string, tracked map[string]bool, ignore *ignoreMatcher) ([]string, error) {
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	untracked := make([]string, 0)
+	for _, entry := range entries {
+		name := path.Join(directory, entry.Name())
+		if entry.Name() == gitDirectoryName || tracked[name] {
+			continue
+		}
+
+		if !entry.IsDir() {
+			if !ignore.isIgnored(name, false) {
+				untracked = append(untracked, name)
+			}
+			continue
+		}
+
+		if ignore.isIgnored(name, true) {
+			continue
+		}
+
+		if !tracked[name+"/"] {
+			// Nested repositories are shown even if they are empty.
+			if isWorkingTree(name) {
+				untracked = append(untracked, name+"/")
+				continue
+			}
+
+			files, err := untrackedFiles(name, tracked, ignore)
+			if err != nil {
+				return nil, err
+			}
+			if len(files) > 0 {
+				untracked = append(untracked, name+"/")
+			}
+			continue
+		}
+
+		files, err := untrackedFiles(name, tracked, ignore)
+		if err != nil {
+			return nil, err
+		}
+		untracked = append(untracked, files...)
+	}
+
+	sort.Strings(untracked)
+	return untracked, nil
+}
@@ -0,0 +1,482 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/untanky/git-charged/config"
+	"github.com/untanky/git-charged/plumbing"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+const gitModulesFile = ".gitmodules"
+
+type Submodule struct {
+	Name   string
+	Path   string
+	URL    string
+	Branch string
+}
+
+// SubmoduleState describes a submodule like the first column of
+// `git submodule status`.
+type SubmoduleState byte
+
+const (
+	SubmoduleUpToDate       SubmoduleState = ' '
+	SubmoduleNotInitialized SubmoduleState = '-'
+	SubmoduleModified       SubmoduleState = '+'
+)
+
+type SubmoduleStatus struct {
+	Submodule
+	State SubmoduleState
+	// Recorded is the commit the superproject's index points at.
+	Recorded []byte
+	// Current is the commit checked out in the submodule, if any.
+	Current []byte
+	// Ref names a reference of the submodule pointing at Current.
+	Ref string
+}
+
+type UpdateSubmodulesParams struct {
+	Paths []string
+	// Init registers submodules that are not initialized yet.
+	Init      bool
+	Recursive bool
+	Progress  io.Writer
+}
+
+type AddSubmoduleParams struct {
+	URL      string
+	Path     string
+	Branch   string
+	Progress io.Writer
+}
+
+// ReadSubmodules parses the .gitmodules file of the working directory.
+func ReadSubmodules() ([]Submodule, error) {
+	file, err := os.Open(gitModulesFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Submodule{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	values, err := config.ParseConfigFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse %s: %w", gitModulesFile, err)
+	}
+
+	byName := make(map[string]*Submodule)
+	for key, value := range values {
+		if !strings.HasPrefix(key, "submodule.") {
+			continue
+		}
+
+		name, variable, ok := cutLast(strings.TrimPrefix(key, "submodule."), ".")
+		if !ok {
+			continue
+		}
+
+		submodule, ok := byName[name]
+		if !ok {
+			submodule = &Submodule{Name: name}
+			byName[name] = submodule
+		}
+
+		switch variable {
+		case "path":
+			submodule.Path = value
+		case "url":
+			submodule.URL = value
+		case "branch":
+			submodule.Branch = value
+		}
+	}
+
+	submodules := make([]Submodule, 0, len(byName))
+	for _, submodule := range byName {
+		if submodule.Path == "" || submodule.URL == "" {
+			continue
+		}
+		submodules = append(submodules, *submodule)
+	}
+	sort.Slice(submodules, func(i, j int) bool {
+		return submodules[i].Path < submodules[j].Path
+	})
+
+	return submodules, nil
+}
+
+func cutLast(s string, separator string) (string, string, bool) {
+	i := strings.LastIndex(s, separator)
+	if i < 0 {
+		return s, "", false
+	}
+	return s[:i], s[i+len(separator):], true
+}
+
+// selectSubmodules returns the submodules whose path is one of paths, or
+// all submodules if paths is empty.
+func selectSubmodules(paths []string) ([]Submodule, error) {
+	submodules, err := ReadSubmodules()
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return submodules, nil
+	}
+
+	selected := make([]Submodule, 0, len(paths))
+	for _, p := range paths {
+		p = path.Clean(p)
+		found := false
+		for _, submodule := range submodules {
+			if submodule.Path == p {
+				selected = append(selected, submodule)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no submodule mapping found in %s for path '%s'", gitModulesFile, p)
+		}
+	}
+
+	return selected, nil
+}
+
+// recordedSubmoduleCommit returns the commit the index records for a
+// submodule path.
+func recordedSubmoduleCommit(index *plumbing.Index, submodule Submodule) ([]byte, error) {
+	entry, ok := index.Find(submodule.Path)
+	if !ok || entry.Mode&0xf000 != gitLinkMode {
+		return nil, fmt.Errorf("submodule %s is not recorded in the index", submodule.Path)
+	}
+
+	return entry.Hash, nil
+}
+
+func isSubmodulePopulated(submodule Submodule) bool {
+	return isWorkingTree(submodule.Path)
+}
+
+func SubmoduleStatuses(paths []string) ([]SubmoduleStatus, error) {
+	submodules, err := selectSubmodules(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := plumbing.ReadIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]SubmoduleStatus, 0, len(submodules))
+	for _, submodule := range submodules {
+		recorded, err := recordedSubmoduleCommit(index, submodule)
+		if err != nil {
+			return nil, err
+		}
+
+		status := SubmoduleStatus{
+			Submodule: submodule,
+			State:     SubmoduleNotInitialized,
+			Recorded:  recorded,
+		}
+
+		if isSubmodulePopulated(submodule) {
+			err = withRepository(submodule.Path, func() error {
+				status.Current, err = plumbing.ResolveRef(plumbing.HEAD)
+				if err != nil {
+					return err
+				}
+				status.Ref = describeCommit(status.Current)
+				return nil
+			})
+			if err != nil {
+				return nil, fmt.Errorf("cannot read submodule %s: %w", submodule.Path, err)
+			}
+
+			status.State = SubmoduleUpToDate
+			if !bytes.Equal(status.Current, status.Recorded) {
+				status.State = SubmoduleModified
+			}
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+// describeCommit names a reference pointing at hash, preferring tags.
+func describeCommit(hash []byte) string {
+	for _, prefix := range []string{tagsPrefix, headsPrefix, "refs/remotes/"} {
+		refs, err := plumbing.ListRefs(prefix)
+		if err != nil {
+			continue
+		}
+		for _, ref := range refs {
+			peeled, _, err := plumbing.Peel(ref.Hash)
+			if err == nil && bytes.Equal(peeled, hash) {
+				return strings.TrimPrefix(ref.Name, "refs/")
+			}
+		}
+	}
+
+	return ""
+}
+
+// InitSubmodules copies the URLs of submodules from .gitmodules to the
+// repository config, which marks them as active.
+func InitSubmodules(paths []string, progress io.Writer) error {
+	submodules, err := selectSubmodules(paths)
+	if err != nil {
+		return err
+	}
+
+	for _, submodule := range submodules {
+		key := fmt.Sprintf("submodule.%s.url", submodule.Name)
+		if config.Has(key) {
+			continue
+		}
+
+		resolved, err := resolveSubmoduleURL(submodule.URL)
+		if err != nil {
+			return err
+		}
+
+		err = config.SetValue(localConfigPath(), key, resolved)
+		if err != nil {
+			return err
+		}
+		err = config.SetValue(localConfigPath(), fmt.Sprintf("submodule.%s.active", submodule.Name), "true")
+		if err != nil {
+			return err
+		}
+
+		if progress != nil {
+			fmt.Fprintf(progress, "Submodule '%s' (%s) registered for path '%s'\n", submodule.Name, resolved, submodule.Path)
+		}
+	}
+
+	config.ReloadConfig()
+
+	return nil
+}
+
+// resolveSubmoduleURL resolves URLs like "../lib.git" relative to the URL
+// of the superproject's default remote.
+func resolveSubmoduleURL(submoduleURL string) (string, error) {
+	if !strings.HasPrefix(submoduleURL, "./") && !strings.HasPrefix(submoduleURL, "../") {
+		return submoduleURL, nil
+	}
+
+	base, ok := config.Get(fmt.Sprintf("remote.%s.url", defaultRemote))
+	if !ok {
+		workingDirectory, err := os.Getwd()
+		if err != nil {
+			return "", err
+		}
+		base = workingDirectory
+	}
+	base = strings.TrimSuffix(base, "/")
+
+	if parsed, err := url.Parse(base); err == nil && parsed.Scheme != "" && parsed.Host != "" {
+		parsed.Path = path.Join(parsed.Path, submoduleURL)
+		return parsed.String(), nil
+	}
+
+	// scp-like URLs such as git@example.com:owner/repository.git
+	if host, repositoryPath, ok := strings.Cut(base, ":"); ok && !strings.Contains(host, "/") {
+		return host + ":" + path.Join(repositoryPath, submoduleURL), nil
+	}
+
+	return path.Join(base, submoduleURL), nil
+}
+
+// UpdateSubmodules clones missing submodules and checks out the commits
+// recorded by the superproject.
+func UpdateSubmodules(params UpdateSubmodulesParams) error {
+	if params.Init {
+		err := InitSubmodules(params.Paths, params.Progress)
+		if err != nil {
+			return err
+		}
+	}
+
+	submodules, err := selectSubmodules(params.Paths)
+	if err != nil {
+		return err
+	}
+
+	index, err := plumbing.ReadIndex()
+	if err != nil {
+		return err
+	}
+
+	for _, submodule := range submodules {
+		submoduleURL, ok := config.Get(fmt.Sprintf("submodule.%s.url", submodule.Name))
+		if !ok {
+			continue
+		}
+
+		recorded, err := recordedSubmoduleCommit(index, submodule)
+		if err != nil {
+			return err
+		}
+
+		err = updateSubmodule(submodule, submoduleURL, recorded, params)
+		if err != nil {
+			return fmt.Errorf("cannot update submodule %s: %w", submodule.Path, err)
+		}
+	}
+
+	return nil
+}
+
+func updateSubmodule(submodule Submodule, submoduleURL string, recorded []byte, params UpdateSubmodulesParams) error {
+	if !isSubmodulePopulated(submodule) {
+		err := cloneSubmodule(submodule.Path, submoduleURL, submodule.Branch, params.Progress)
+		if err != nil {
+			return err
+		}
+	}
+
+	return withRepository(submodule.Path, func() error {
+		current, _ := plumbing.ResolveRef(plumbing.HEAD)
+		if !bytes.Equal(current, recorded) {
+			if !plumbing.HasObject(recorded) {
+				_, err := Fetch(FetchParams{Remote: defaultRemote, Progress: params.Progress})
+				if err != nil {
+					return err
+				}
+			}
+
+			commit, err := plumbing.ReadCommit(recorded)
+			if err != nil {
+				return fmt.Errorf("commit %x is not available from %s", recorded, submoduleURL)
+			}
+
+			err = checkoutTree(commit.Tree, false)
+			if err != nil {
+				return err
+			}
+
+			err = plumbing.WriteRef(plumbing.HEAD, recorded)
+			if err != nil {
+				return err
+			}
+
+			if params.Progress != nil {
+				fmt.Fprintf(params.Progress, "Submodule path '%s': checked out '%x'\n", submodule.Path, recorded)
+			}
+		}
+
+		if !params.Recursive {
+			return nil
+		}
+
+		recursive := params
+		recursive.Paths = nil
+		recursive.Init = true
+		return UpdateSubmodules(recursive)
+	})
+}
+
+func cloneSubmodule(directory string, submoduleURL string, branch string, progress io.Writer) error {
+	if entries, err := os.ReadDir(directory); err == nil && len(entries) > 0 {
+		return fmt.Errorf("destination path '%s' already exists and is not an empty directory", directory)
+	}
+
+	err := os.MkdirAll(directory, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	if progress != nil {
+		fmt.Fprintf(progress, "Cloning into '%s'...\n", directory)
+	}
+
+	return inDirectory(directory, func() error {
+		return Clone(CloneParams{
+			URL:      submoduleURL,
+			Branch:   branch,
+			Progress: progress,
+		})
+	})
+}
+
+// AddSubmodule clones a repository into the working directory, records it
+// in .gitmodules and stages it as a gitlink.
+func AddSubmodule(params AddSubmoduleParams) error {
+	submodulePath := params.Path
+	if submodulePath == "" {
+		submodulePath = strings.TrimSuffix(path.Base(strings.TrimSuffix(params.URL, "/")), ".git")
+	}
+	submodulePath = path.Clean(submodulePath)
+
+	index, err := plumbing.ReadIndex()
+	if err != nil {
+		return err
+	}
+	if _, ok := index.Find(submodulePath); ok {
+		return fmt.Errorf("'%s' already exists in the index", submodulePath)
+	}
+
+	resolved, err := resolveSubmoduleURL(params.URL)
+	if err != nil {
+		return err
+	}
+
+	if !isWorkingTree(submodulePath) {
+		err = cloneSubmodule(submodulePath, resolved, params.Branch, params.Progress)
+		if err != nil {
+			os.RemoveAll(submodulePath)
+			return err
+		}
+	}
+
+	name := submodulePath
+	values := [][2]string{
+		{fmt.Sprintf("submodule.%s.path", name), submodulePath},
+		{fmt.Sprintf("submodule.%s.url", name), params.URL},
+	}
+	if params.Branch != "" {
+		values = append(values, [2]string{fmt.Sprintf("submodule.%s.branch", name), params.Branch})
+	}
+	for _, value := range values {
+		err = config.SetValue(gitModulesFile, value[0], value[1])
+		if err != nil {
+			return err
+		}
+	}
+
+	err = config.SetValue(localConfigPath(), fmt.Sprintf("submodule.%s.url", name), resolved)
+	if err != nil {
+		return err
+	}
+	err = config.SetValue(localConfigPath(), fmt.Sprintf("submodule.%s.active", name), "true")
+	if err != nil {
+		return err
+	}
+	config.ReloadConfig()
+
+	filter := newContentFilter(workingTreeAttributes())
+	for _, name := range []string{gitModulesFile, submodulePath} {
+		err = stagePath(index, name, filter)
+		if err != nil {
+			return err
+		}
+	}
+
+	return plumbing.WriteIndex(index)
+}
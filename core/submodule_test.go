@@ -0,0 +1,82 @@
+package core
+
+import (
+	"os"
+	"path"
+	"slices"
+	"testing"
+)
+
+func TestReadSubmodules(t *testing.T) {
+	changeToTestDirectory(t)
+	err := os.WriteFile(gitModulesFile, []byte(`[submodule "lib"]
+	path = vendor/lib
+	url = https://example.com/lib.git
+	branch = stable
+[submodule "a \"quoted\" name"]
+	path = quoted
+	url = ../quoted.git
+[submodule "no-url"]
+	path = no-url
+[submodule "dotted.name"]
+	url = git@example.com:dotted.git
+	path = dotted
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	submodules, err := ReadSubmodules()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Submodule{
+		{Name: "dotted.name", Path: "dotted", URL: "git@example.com:dotted.git"},
+		{Name: "a \"quoted\" name", Path: "quoted", URL: "../quoted.git"},
+		{Name: "lib", Path: "vendor/lib", URL: "https://example.com/lib.git", Branch: "stable"},
+	}
+	if !slices.Equal(submodules, want) {
+		t.Errorf("ReadSubmodules() = %+v, want %+v", submodules, want)
+	}
+}
+
+func TestReadSubmodulesWithoutGitModules(t *testing.T) {
+	changeToTestDirectory(t)
+
+	submodules, err := ReadSubmodules()
+	if err != nil || len(submodules) != 0 {
+		t.Errorf("ReadSubmodules() = %v, %v, want no submodules", submodules, err)
+	}
+}
+
+func TestResolveSubmoduleURL(t *testing.T) {
+	directory := initTestRepository(t)
+
+	tests := []struct {
+		name   string
+		remote string
+		url    string
+		want   string
+	}{
+		{name: "without a remote", url: "../lib.git", want: path.Join(path.Dir(directory), "lib.git")},
+		{name: "absolute", remote: "https://example.com/group/project.git", url: "https://other.example.com/lib.git", want: "https://other.example.com/lib.git"},
+		{name: "sibling over https", remote: "https://example.com/group/project.git", url: "../lib.git", want: "https://example.com/group/lib.git"},
+		{name: "below over https", remote: "https://example.com/group/project/", url: "./lib", want: "https://example.com/group/project/lib"},
+		{name: "scp-like", remote: "git@example.com:group/project.git", url: "../lib.git", want: "git@example.com:group/lib.git"},
+		{name: "local path", remote: "/srv/git/project.git", url: "../lib.git", want: "/srv/git/lib.git"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.remote != "" {
+				setTestConfig(t, map[string]string{"remote.origin.url": test.remote})
+			}
+
+			resolved, err := resolveSubmoduleURL(test.url)
+			if err != nil || resolved != test.want {
+				t.Errorf("resolveSubmoduleURL(%q) = %q, %v, want %q", test.url, resolved, err, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package core
+
+import (
+	"fmt"
+	"github.com/untanky/git-charged/plumbing"
+	"strings"
+)
+
+type CreateTagParams struct {
+	Name string
+	// Revision is the object to tag, HEAD if empty.
+	Revision string
+	// Annotate creates a tag object with a message instead of a plain
+	// reference. A message implies it.
+	Annotate bool
+	Message  string
+	// Sign makes the tag a signed annotated tag. Annotated tags are also
+	// signed if tag.gpgsign is set.
+	Sign  bool
+	Force bool
+}
+
+// CreateTag creates a lightweight tag, or an annotated tag object if there
+// is a message or the tag is signed.
+func CreateTag(params CreateTagParams) ([]byte, error) {
+	name := tagsPrefix + params.Name
+	if params.Name == "" || strings.HasPrefix(params.Name, "-") || strings.Contains(params.Name, "..") {
+		return nil, fmt.Errorf("'%s' is not a valid tag name", params.Name)
+	}
+
+	if _, err := plumbing.ResolveRef(name); err == nil && !params.Force {
+		return nil, fmt.Errorf("tag '%s' already exists", params.Name)
+	}
+
+	revision := params.Revision
+	if revision == "" {
+		revision = plumbing.HEAD
+	}
+	target, err := ResolveRevision(revision)
+	if err != nil {
+		return nil, err
+	}
+
+	annotate := params.Annotate || params.Sign || params.Message != ""
+	if !annotate {
+		err = plumbing.WriteRef(name, target)
+		if err != nil {
+			return nil, fmt.Errorf("cannot write tag: %w", err)
+		}
+		return target, nil
+	}
+
+	message := stripComments(params.Message)
+	if message == "" {
+		return nil, fmt.Errorf("no tag message")
+	}
+
+	kind, _, err := plumbing.ReadObject(target)
+	if err != nil {
+		return nil, err
+	}
+
+	me, err := currentIdentity()
+	if err != nil {
+		return nil, err
+	}
+
+	tag := &plumbing.Tag{
+		Object:  target,
+		Type:    kind,
+		Name:    params.Name,
+		Tagger:  me,
+		Message: message,
+	}
+	if params.Sign || signTagsByDefault() {
+		tag.Signature, err = signPayload(tag.Payload(), me)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	hash, err := plumbing.WriteObject(tag)
+	if err != nil {
+		return nil, fmt.Errorf("cannot write tag: %w", err)
+	}
+
+	err = plumbing.WriteRef(name, hash)
+	if err != nil {
+		return nil, fmt.Errorf("cannot write tag: %w", err)
+	}
+
+	return hash, nil
+}
+
+// ListTags returns the names of all tags.
+func ListTags() ([]string, error) {
+	refs, err := plumbing.ListRefs(tagsPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list tags: %w", err)
+	}
+
+	names := make([]string, len(refs))
+	for i, ref := range refs {
+		names[i] = strings.TrimPrefix(ref.Name, tagsPrefix)
+	}
+
+	return names, nil
+}
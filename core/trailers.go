@@ -0,0 +1,379 @@
+package core
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/untanky/git-charged/config"
+	"github.com/untanky/git-charged/plumbing"
+	"strings"
+)
+
+// TrailerIfExists says what to do when a trailer with the same token is
+// already in a message, like git's trailer.ifexists.
+type TrailerIfExists string
+
+const (
+	// TrailerAddIfDifferentNeighbor adds the trailer unless the last trailer
+	// is the same. It is git's default.
+	TrailerAddIfDifferentNeighbor TrailerIfExists = "addIfDifferentNeighbor"
+	// TrailerAddIfDifferent adds the trailer unless the same trailer is
+	// anywhere in the message.
+	TrailerAddIfDifferent TrailerIfExists = "addIfDifferent"
+	TrailerAdd            TrailerIfExists = "add"
+	// TrailerReplace removes the last trailer with the same token and adds
+	// the new one.
+	TrailerReplace   TrailerIfExists = "replace"
+	TrailerDoNothing TrailerIfExists = "doNothing"
+)
+
+const (
+	signedOffByToken = "Signed-off-by"
+	fixesToken       = "Fixes"
+)
+
+// trailerCutLine ends the part of a message that is committed, as written by
+// git commit --verbose.
+const trailerCutLine = "# ------------------------ >8 ------------------------"
+
+// gitTrailerPrefixes are the trailers git writes itself. A trailer block
+// containing one of them may also contain other lines.
+var gitTrailerPrefixes = []string{signedOffByToken + ": ", "(cherry picked from commit "}
+
+// Trailer is a "Token: value" line at the end of a commit message, such as
+// "Signed-off-by: Name <email>".
+type Trailer struct {
+	Token string
+	Value string
+}
+
+func (t Trailer) String() string {
+	return t.Token + trailerSeparators()[:1] + " " + t.Value
+}
+
+// trailerItem is a line of a trailer block. Lines that are not trailers
+// are kept as they are in text.
+type trailerItem struct {
+	trailer   Trailer
+	isTrailer bool
+	text      string
+}
+
+// trailerBlock is a commit message split around its trailers.
+type trailerBlock struct {
+	before string
+	items  []trailerItem
+	after  string
+}
+
+// ParseTrailer parses a trailer given as "Token: value" or "Token=value".
+func ParseTrailer(trailer string) (Trailer, error) {
+	token, value := trailer, ""
+	if i := strings.IndexAny(trailer, trailerSeparators()+"="); i >= 0 {
+		token, value = trailer[:i], trailer[i+1:]
+	}
+
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return Trailer{}, fmt.Errorf("empty trailer token in '%s'", trailer)
+	}
+
+	return Trailer{Token: token, Value: strings.TrimSpace(value)}, nil
+}
+
+// ParseTrailers returns the trailers of a commit message. Values continued
+// on indented lines are joined into one line.
+func ParseTrailers(message string) []Trailer {
+	trailers := make([]Trailer, 0)
+	for _, item := range splitTrailers(message).items {
+		if item.isTrailer {
+			trailers = append(trailers, Trailer{Token: item.trailer.Token, Value: unfoldTrailerValue(item.trailer.Value)})
+		}
+	}
+
+	return trailers
+}
+
+// AddTrailers adds trailers to a commit message in order, each following
+// trailer.<token>.ifexists or trailer.ifexists.
+func AddTrailers(message string, trailers []Trailer) string {
+	for _, trailer := range trailers {
+		message = AddTrailer(message, trailer, configuredTrailerIfExists(trailer.Token))
+	}
+
+	return message
+}
+
+// AddTrailer adds a trailer to the end of the trailer block of a commit
+// message, which is started if there is none. Tokens are compared
+// case-insensitively, and so are values to find the same trailer.
+func AddTrailer(message string, trailer Trailer, ifExists TrailerIfExists) string {
+	block := splitTrailers(message)
+
+	last := -1
+	for i, item := range block.items {
+		if item.isTrailer && strings.EqualFold(item.trailer.Token, trailer.Token) {
+			last = i
+		}
+	}
+
+	if last >= 0 {
+		switch ifExists {
+		case TrailerDoNothing:
+			return message
+		case TrailerReplace:
+			block.items = append(block.items[:last], block.items[last+1:]...)
+		case TrailerAdd:
+		case TrailerAddIfDifferent:
+			for _, item := range block.items {
+				if item.isTrailer && sameTrailer(item.trailer, trailer) {
+					return message
+				}
+			}
+		default:
+			neighbor := block.items[len(block.items)-1]
+			if neighbor.isTrailer && sameTrailer(neighbor.trailer, trailer) {
+				return message
+			}
+		}
+	}
+
+	block.items = append(block.items, trailerItem{trailer: trailer, isTrailer: true})
+	return block.String()
+}
+
+// DedupeTrailers removes trailers that repeat an earlier one with the same
+// token and value.
+func DedupeTrailers(message string) string {
+	block := splitTrailers(message)
+
+	items := make([]trailerItem, 0, len(block.items))
+	for _, item := range block.items {
+		duplicate := false
+		for _, kept := range items {
+			if item.isTrailer && kept.isTrailer && sameTrailer(item.trailer, kept.trailer) {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			items = append(items, item)
+		}
+	}
+
+	if len(items) == len(block.items) {
+		return message
+	}
+	block.items = items
+	return block.String()
+}
+
+// SignoffTrailer returns the Signed-off-by trailer of the configured user.
+func SignoffTrailer() (Trailer, error) {
+	identity, err := currentIdentity()
+	if err != nil {
+		return Trailer{}, err
+	}
+
+	return Trailer{Token: signedOffByToken, Value: fmt.Sprintf("%s <%s>", identity.Name, identity.Email)}, nil
+}
+
+// FixesTrailer returns a trailer naming the commit a change fixes, in the
+// form `Fixes: 123456789abc ("subject")`.
+func FixesTrailer(revision string) (Trailer, error) {
+	hash, err := resolveCommit(revision)
+	if err != nil {
+		return Trailer{}, err
+	}
+
+	commit, err := plumbing.ReadCommit(hash)
+	if err != nil {
+		return Trailer{}, err
+	}
+
+	subject, _ := splitCommitMessage(commit.Message)
+	return Trailer{Token: fixesToken, Value: fmt.Sprintf("%s (\"%s\")", hex.EncodeToString(hash)[:12], subject)}, nil
+}
+
+func (b trailerBlock) String() string {
+	var builder strings.Builder
+	builder.WriteString(b.before)
+	if !endsWithBlankLine(b.before) {
+		builder.WriteByte('\n')
+	}
+
+	for _, item := range b.items {
+		if item.isTrailer {
+			builder.WriteString(item.trailer.String())
+		} else {
+			builder.WriteString(item.text)
+		}
+		builder.WriteByte('\n')
+	}
+
+	builder.WriteString(b.after)
+	return builder.String()
+}
+
+// splitTrailers finds the trailer block of a message the way git
+// interpret-trailers does: it is the last paragraph before any trailing
+// comments, if it is not the title and either all of its lines are
+// trailers, or a quarter of them are and one was written by git.
+func splitTrailers(message string) trailerBlock {
+	if message != "" && !strings.HasSuffix(message, "\n") {
+		message += "\n"
+	}
+
+	lines := strings.SplitAfter(message, "\n")
+	lines = lines[:len(lines)-1]
+
+	end := len(lines)
+	for i, line := range lines {
+		if strings.TrimRight(line, "\n") == trailerCutLine {
+			end = i
+			break
+		}
+	}
+	// Like git, the first line is never part of the trailing comments.
+	for end > 1 && (isCommentLine(lines[end-1]) || isBlankLine(lines[end-1])) {
+		end--
+	}
+
+	title := 0
+	for title < end && !isBlankLine(lines[title]) {
+		title++
+	}
+
+	start := end
+	trailerLines, otherLines, continuationLines := 0, 0, 0
+	recognized := false
+	for i := end - 1; i >= title; i-- {
+		line := lines[i]
+		if isCommentLine(line) {
+			continue
+		}
+		if isBlankLine(line) {
+			if trailerLines > 0 && (otherLines == 0 || recognized && trailerLines*3 >= otherLines) {
+				start = i + 1
+			}
+			break
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			continuationLines++
+			continue
+		}
+
+		gitTrailer := false
+		for _, prefix := range gitTrailerPrefixes {
+			if strings.HasPrefix(line, prefix) {
+				gitTrailer = true
+			}
+		}
+		if gitTrailer || findTrailerSeparator(line) > 0 {
+			trailerLines += 1 + continuationLines
+			recognized = recognized || gitTrailer
+		} else {
+			otherLines += 1 + continuationLines
+		}
+		continuationLines = 0
+	}
+
+	block := trailerBlock{
+		before: strings.Join(lines[:start], ""),
+		items:  make([]trailerItem, 0),
+		after:  strings.Join(lines[end:], ""),
+	}
+
+	for _, line := range lines[start:end] {
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case isCommentLine(line):
+			continue
+		case (line[0] == ' ' || line[0] == '\t') && len(block.items) > 0:
+			last := &block.items[len(block.items)-1]
+			if last.isTrailer {
+				last.trailer.Value += "\n" + line
+			} else {
+				last.text += "\n" + line
+			}
+			continue
+		}
+
+		if separator := findTrailerSeparator(line); separator > 0 {
+			block.items = append(block.items, trailerItem{
+				trailer:   Trailer{Token: strings.TrimSpace(line[:separator]), Value: strings.TrimSpace(line[separator+1:])},
+				isTrailer: true,
+			})
+		} else {
+			block.items = append(block.items, trailerItem{text: line})
+		}
+	}
+
+	return block
+}
+
+// findTrailerSeparator returns the position of the separator after the
+// token of a trailer line, or -1 if the line is not a trailer. Tokens
+// consist of letters, digits and dashes, and may be followed by spaces.
+func findTrailerSeparator(line string) int {
+	separators := trailerSeparators()
+	spaces := false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case strings.IndexByte(separators, c) >= 0:
+			return i
+		case !spaces && (c == '-' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'):
+		case i > 0 && (c == ' ' || c == '\t'):
+			spaces = true
+		default:
+			return -1
+		}
+	}
+
+	return -1
+}
+
+func trailerSeparators() string {
+	if separators, ok := config.Get("trailer.separators"); ok && separators != "" {
+		return separators
+	}
+	return ":"
+}
+
+func configuredTrailerIfExists(token string) TrailerIfExists {
+	if ifExists, ok := config.Get("trailer." + token + ".ifexists"); ok {
+		return TrailerIfExists(ifExists)
+	}
+	if ifExists, ok := config.Get("trailer.ifexists"); ok {
+		return TrailerIfExists(ifExists)
+	}
+	return TrailerAddIfDifferentNeighbor
+}
+
+func sameTrailer(a Trailer, b Trailer) bool {
+	return strings.EqualFold(a.Token, b.Token) && strings.EqualFold(a.Value, b.Value)
+}
+
+func unfoldTrailerValue(value string) string {
+	lines := strings.Split(value, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	return strings.Join(lines, " ")
+}
+
+func isCommentLine(line string) bool {
+	return strings.HasPrefix(line, "#")
+}
+
+func isBlankLine(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+func endsWithBlankLine(text string) bool {
+	lines := strings.SplitAfter(text, "\n")
+	if len(lines) < 2 {
+		return false
+	}
+	return isBlankLine(lines[len(lines)-2])
+}
@@ -0,0 +1,149 @@
+package core
+
+import (
+	"slices"
+	"testing"
+)
+
+// The expected trailers are the output of git interpret-trailers --parse
+// for the same messages.
+func TestParseTrailers(t *testing.T) {
+	tests := []struct {
+		name     string
+		message  string
+		trailers []Trailer
+	}{
+		{
+			name:     "trailer block",
+			message:  "Subject\n\nBody.\n\nSigned-off-by: A <a@example.com>\nReviewed-by: B\n",
+			trailers: []Trailer{{"Signed-off-by", "A <a@example.com>"}, {"Reviewed-by", "B"}},
+		},
+		{
+			name:     "title only",
+			message:  "Fixes: something\n",
+			trailers: []Trailer{},
+		},
+		{
+			name:     "continued value",
+			message:  "Subject\n\nCo-authored-by: A\n  <a@example.com>\n",
+			trailers: []Trailer{{"Co-authored-by", "A <a@example.com>"}},
+		},
+		{
+			name:     "spaces before the separator",
+			message:  "Subject\n\nAcked-by : A\n",
+			trailers: []Trailer{{"Acked-by", "A"}},
+		},
+		{
+			name:     "other lines with a git trailer",
+			message:  "Subject\n\nSigned-off-by: A\nsome text\nmore text\n",
+			trailers: []Trailer{{"Signed-off-by", "A"}},
+		},
+		{
+			name:     "other lines without a git trailer",
+			message:  "Subject\n\nKey: value\nsome text\n",
+			trailers: []Trailer{},
+		},
+		{
+			name:     "trailing comments",
+			message:  "Subject\n\nAcked-by: A\n# Please enter the commit message\n\n",
+			trailers: []Trailer{{"Acked-by", "A"}},
+		},
+		{
+			name:     "cut line",
+			message:  "Subject\n\nAcked-by: A\n" + trailerCutLine + "\nTested-by: B\n",
+			trailers: []Trailer{{"Acked-by", "A"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			trailers := ParseTrailers(test.message)
+			if !slices.Equal(trailers, test.trailers) {
+				t.Errorf("ParseTrailers(%q) = %q, want %q", test.message, trailers, test.trailers)
+			}
+		})
+	}
+}
+
+func TestAddTrailer(t *testing.T) {
+	signoff := Trailer{Token: "Signed-off-by", Value: "A <a@example.com>"}
+
+	tests := []struct {
+		name     string
+		message  string
+		ifExists TrailerIfExists
+		want     string
+	}{
+		{
+			name:     "starts a block",
+			message:  "Subject\n",
+			ifExists: TrailerAddIfDifferentNeighbor,
+			want:     "Subject\n\nSigned-off-by: A <a@example.com>\n",
+		},
+		{
+			name:     "appends to a block",
+			message:  "Subject\n\nAcked-by: B\n",
+			ifExists: TrailerAddIfDifferentNeighbor,
+			want:     "Subject\n\nAcked-by: B\nSigned-off-by: A <a@example.com>\n",
+		},
+		{
+			name:     "keeps the same neighbor",
+			message:  "Subject\n\nsigned-off-by: a <A@example.com>\n",
+			ifExists: TrailerAddIfDifferentNeighbor,
+			want:     "Subject\n\nsigned-off-by: a <A@example.com>\n",
+		},
+		{
+			name:     "adds if the same is not the neighbor",
+			message:  "Subject\n\nSigned-off-by: A <a@example.com>\nAcked-by: B\n",
+			ifExists: TrailerAddIfDifferentNeighbor,
+			want:     "Subject\n\nSigned-off-by: A <a@example.com>\nAcked-by: B\nSigned-off-by: A <a@example.com>\n",
+		},
+		{
+			name:     "keeps the same anywhere",
+			message:  "Subject\n\nSigned-off-by: A <a@example.com>\nAcked-by: B\n",
+			ifExists: TrailerAddIfDifferent,
+			want:     "Subject\n\nSigned-off-by: A <a@example.com>\nAcked-by: B\n",
+		},
+		{
+			name:     "always adds",
+			message:  "Subject\n\nSigned-off-by: A <a@example.com>\n",
+			ifExists: TrailerAdd,
+			want:     "Subject\n\nSigned-off-by: A <a@example.com>\nSigned-off-by: A <a@example.com>\n",
+		},
+		{
+			name:     "replaces the last",
+			message:  "Subject\n\nSigned-off-by: C\nAcked-by: B\n",
+			ifExists: TrailerReplace,
+			want:     "Subject\n\nAcked-by: B\nSigned-off-by: A <a@example.com>\n",
+		},
+		{
+			name:     "does nothing",
+			message:  "Subject\n\nSigned-off-by: C\n",
+			ifExists: TrailerDoNothing,
+			want:     "Subject\n\nSigned-off-by: C\n",
+		},
+		{
+			name:     "keeps trailing comments",
+			message:  "Subject\n\n# comment\n",
+			ifExists: TrailerAddIfDifferentNeighbor,
+			want:     "Subject\n\nSigned-off-by: A <a@example.com>\n\n# comment\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			message := AddTrailer(test.message, signoff, test.ifExists)
+			if message != test.want {
+				t.Errorf("AddTrailer(%q, %s) = %q, want %q", test.message, test.ifExists, message, test.want)
+			}
+		})
+	}
+}
+
+func TestDedupeTrailers(t *testing.T) {
+	message := "Subject\n\nAcked-by: A\nReviewed-by: B\nacked-by: a\n"
+	want := "Subject\n\nAcked-by: A\nReviewed-by: B\n"
+	if deduped := DedupeTrailers(message); deduped != want {
+		t.Errorf("DedupeTrailers(%q) = %q, want %q", message, deduped, want)
+	}
+}
@@ -0,0 +1,89 @@
+package core
+
+import (
+	"regexp"
+	"strings"
+)
+
+// compileWildcard turns a gitignore style pattern into a regular expression
+// matching slash separated paths. "*" and "?" do not match slashes, "**"
+// matches any number of directories.
+func compileWildcard(pattern string) (*regexp.Regexp, error) {
+	var builder strings.Builder
+	builder.WriteString("^")
+
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			builder.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "/**") && i+3 == len(pattern):
+			builder.WriteString("/.*")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			builder.WriteString(".*")
+			i++
+		case c == '*':
+			builder.WriteString("[^/]*")
+		case c == '?':
+			builder.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				builder.WriteString(regexp.QuoteMeta(string(c)))
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			builder.WriteString("[" + class + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			builder.WriteString(regexp.QuoteMeta(string(pattern[i])))
+		default:
+			builder.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	builder.WriteString("$")
+	return regexp.Compile(builder.String())
+}
+
+// wildcardPattern is a compiled gitignore style pattern relative to the
+// directory of the file it was read from.
+type wildcardPattern struct {
+	base     string
+	regexp   *regexp.Regexp
+	basename bool
+}
+
+func newWildcardPattern(pattern string, base string) (*wildcardPattern, error) {
+	// Patterns without a slash match the name at any depth.
+	basename := !strings.Contains(strings.TrimSuffix(pattern, "/"), "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+
+	compiled, err := compileWildcard(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	return &wildcardPattern{base: base, regexp: compiled, basename: basename}, nil
+}
+
+func (p *wildcardPattern) matches(name string) bool {
+	if p.base != "" {
+		if !strings.HasPrefix(name, p.base+"/") {
+			return false
+		}
+		name = strings.TrimPrefix(name, p.base+"/")
+	}
+
+	if p.basename {
+		name = name[strings.LastIndex(name, "/")+1:]
+	}
+
+	return p.regexp.MatchString(name)
+}
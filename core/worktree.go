@@ -0,0 +1,490 @@
+package core
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/untanky/git-charged/config"
+	"github.com/untanky/git-charged/plumbing"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	worktreesDirectoryName = "worktrees"
+
+	worktreeGitDirFile = "gitdir"
+	worktreeLockedFile = "locked"
+)
+
+// Worktree is a working tree of the repository. The main worktree is the
+// one the repository was created with; linked worktrees are administered
+// in $GIT_COMMON_DIR/worktrees/<name>.
+type Worktree struct {
+	Path string
+	// Name is the directory of a linked worktree in .git/worktrees. It is
+	// empty for the main worktree.
+	Name string
+	Head []byte
+	// Branch is the reference HEAD points to, or empty if HEAD is
+	// detached.
+	Branch string
+	Bare   bool
+	Locked bool
+	// LockReason is the content of the locked file, if any.
+	LockReason string
+	// Prunable explains why a linked worktree can be pruned. It is empty
+	// if the worktree still exists.
+	Prunable string
+}
+
+type AddWorktreeParams struct {
+	Path string
+	// Commitish is checked out in the new worktree. A local branch name
+	// is checked out as a branch unless Detach is set. Defaults to HEAD.
+	Commitish string
+	// NewBranch creates a branch at Commitish and checks it out.
+	NewBranch string
+	Detach    bool
+	// Force allows checking out a branch that is already checked out in
+	// another worktree and resetting an existing branch with NewBranch.
+	Force    bool
+	Progress io.Writer
+}
+
+func worktreesDirectory() string {
+	return path.Join(plumbing.CommonDirectory(), worktreesDirectoryName)
+}
+
+// readWorktreeHead reads the HEAD of another worktree from its git
+// directory.
+func readWorktreeHead(gitDirectory string) ([]byte, string, error) {
+	content, err := os.ReadFile(path.Join(gitDirectory, plumbing.HEAD))
+	if err != nil {
+		return nil, "", err
+	}
+
+	value := strings.TrimSpace(string(content))
+	if branch, ok := strings.CutPrefix(value, "ref: "); ok {
+		// The branch does not exist yet in an empty repository.
+		hash, _ := plumbing.ResolveRef(branch)
+		return hash, branch, nil
+	}
+
+	hash, err := hex.DecodeString(value)
+	if err != nil {
+		return nil, "", fmt.Errorf("HEAD of %s is malformed", gitDirectory)
+	}
+
+	return hash, "", nil
+}
+
+func mainWorktree() (Worktree, error) {
+	commonDirectory, err := filepath.Abs(plumbing.CommonDirectory())
+	if err != nil {
+		return Worktree{}, err
+	}
+
+	worktree := Worktree{Path: commonDirectory}
+	if bare, _ := config.Get("core.bare"); bare == "true" || path.Base(commonDirectory) != gitDirectoryName {
+		worktree.Bare = true
+	} else {
+		worktree.Path = path.Dir(commonDirectory)
+	}
+
+	worktree.Head, worktree.Branch, err = readWorktreeHead(commonDirectory)
+	return worktree, err
+}
+
+func linkedWorktree(name string) (Worktree, error) {
+	administrativeDirectory := path.Join(worktreesDirectory(), name)
+	worktree := Worktree{Name: name}
+
+	if reason, err := os.ReadFile(path.Join(administrativeDirectory, worktreeLockedFile)); err == nil {
+		worktree.Locked = true
+		worktree.LockReason = strings.TrimSpace(string(reason))
+	}
+
+	gitDirFile, err := os.ReadFile(path.Join(administrativeDirectory, worktreeGitDirFile))
+	if err != nil {
+		worktree.Prunable = "gitdir file does not exist"
+		return worktree, nil
+	}
+
+	worktree.Path = path.Dir(strings.TrimSpace(string(gitDirFile)))
+	if _, err := os.Stat(worktree.Path); err != nil {
+		worktree.Prunable = "gitdir file points to non-existent location"
+	}
+
+	worktree.Head, worktree.Branch, err = readWorktreeHead(administrativeDirectory)
+	if err != nil && worktree.Prunable == "" {
+		return Worktree{}, err
+	}
+
+	return worktree, nil
+}
+
+// ListWorktrees returns the main worktree followed by the linked
+// worktrees.
+func ListWorktrees() ([]Worktree, error) {
+	main, err := mainWorktree()
+	if err != nil {
+		return nil, err
+	}
+	worktrees := []Worktree{main}
+
+	entries, err := os.ReadDir(worktreesDirectory())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		worktree, err := linkedWorktree(entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		worktrees = append(worktrees, worktree)
+	}
+
+	return worktrees, nil
+}
+
+// findWorktree looks up a linked worktree by its path.
+func findWorktree(worktreePath string) (Worktree, error) {
+	absolute, err := filepath.Abs(worktreePath)
+	if err != nil {
+		return Worktree{}, err
+	}
+
+	worktrees, err := ListWorktrees()
+	if err != nil {
+		return Worktree{}, err
+	}
+
+	for _, worktree := range worktrees {
+		if worktree.Path != absolute {
+			continue
+		}
+		if worktree.Name == "" {
+			return Worktree{}, fmt.Errorf("'%s' is a main working tree", worktreePath)
+		}
+		return worktree, nil
+	}
+
+	return Worktree{}, fmt.Errorf("'%s' is not a working tree", worktreePath)
+}
+
+// checkedOutAt returns the path of the worktree that has branch checked
+// out.
+func checkedOutAt(branch string) (string, bool, error) {
+	worktrees, err := ListWorktrees()
+	if err != nil {
+		return "", false, err
+	}
+
+	for _, worktree := range worktrees {
+		if worktree.Branch == branch && !worktree.Bare && worktree.Prunable == "" {
+			return worktree.Path, true, nil
+		}
+	}
+
+	return "", false, nil
+}
+
+// uniqueWorktreeName derives the name of the administrative directory of a
+// new worktree from its path, adding a number if the name is taken.
+func uniqueWorktreeName(worktreePath string) string {
+	base := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' || r == ' ' {
+			return '-'
+		}
+		return r
+	}, path.Base(worktreePath))
+
+	name := base
+	for i := 1; ; i++ {
+		if _, err := os.Stat(path.Join(worktreesDirectory(), name)); errors.Is(err, os.ErrNotExist) {
+			return name
+		}
+		name = base + strconv.Itoa(i)
+	}
+}
+
+// AddWorktree creates a linked worktree at params.Path and checks out a
+// branch or commit in it. Without a commit or branch, a branch named after
+// the last path component is checked out and created from HEAD if needed.
+func AddWorktree(params AddWorktreeParams) error {
+	worktreePath, err := filepath.Abs(params.Path)
+	if err != nil {
+		return err
+	}
+
+	if entries, err := os.ReadDir(worktreePath); err == nil && len(entries) > 0 {
+		return fmt.Errorf("'%s' already exists", params.Path)
+	} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	commitish := params.Commitish
+	if commitish == "" {
+		commitish = plumbing.HEAD
+	}
+
+	var branch string
+	createBranch := false
+	switch {
+	case params.NewBranch != "":
+		branch = headsPrefix + params.NewBranch
+		if _, err := plumbing.ResolveRef(branch); err == nil && !params.Force {
+			return fmt.Errorf("a branch named '%s' already exists", params.NewBranch)
+		}
+		createBranch = true
+	case params.Detach:
+	case params.Commitish == "":
+		branch = headsPrefix + path.Base(worktreePath)
+		if _, err := plumbing.ResolveRef(branch); err != nil {
+			createBranch = true
+		} else {
+			commitish = branch
+		}
+	default:
+		if _, err := plumbing.ResolveRef(headsPrefix + params.Commitish); err == nil {
+			branch = headsPrefix + params.Commitish
+		}
+	}
+
+	hash, err := ResolveRevision(commitish)
+	if err != nil {
+		return err
+	}
+	hash, err = peelTo(hash, plumbing.KindCommit)
+	if err != nil {
+		return err
+	}
+	commit, err := plumbing.ReadCommit(hash)
+	if err != nil {
+		return err
+	}
+
+	if branch != "" && !createBranch && !params.Force {
+		if location, ok, err := checkedOutAt(branch); err != nil {
+			return err
+		} else if ok {
+			return fmt.Errorf("'%s' is already checked out at '%s'", strings.TrimPrefix(branch, headsPrefix), location)
+		}
+	}
+
+	if params.Progress != nil {
+		switch {
+		case createBranch:
+			fmt.Fprintf(params.Progress, "Preparing worktree (new branch '%s')\n", strings.TrimPrefix(branch, headsPrefix))
+		case branch != "":
+			fmt.Fprintf(params.Progress, "Preparing worktree (checking out '%s')\n", strings.TrimPrefix(branch, headsPrefix))
+		default:
+			fmt.Fprintf(params.Progress, "Preparing worktree (detached HEAD %s)\n", shortHash(hash))
+		}
+	}
+
+	if createBranch {
+		err = plumbing.WriteRef(branch, hash)
+		if err != nil {
+			return fmt.Errorf("cannot create branch: %w", err)
+		}
+	}
+
+	administrativeDirectory, err := filepath.Abs(path.Join(worktreesDirectory(), uniqueWorktreeName(worktreePath)))
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(administrativeDirectory, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("cannot create worktree directory: %w", err)
+	}
+
+	head := hex.EncodeToString(hash)
+	if branch != "" {
+		head = "ref: " + branch
+	}
+
+	files := map[string]string{
+		path.Join(administrativeDirectory, worktreeGitDirFile): path.Join(worktreePath, gitDirectoryName),
+		path.Join(administrativeDirectory, "commondir"):        "../..",
+		path.Join(administrativeDirectory, plumbing.HEAD):      head,
+		path.Join(worktreePath, gitDirectoryName):              gitDirFilePrefix + administrativeDirectory,
+	}
+
+	err = os.MkdirAll(worktreePath, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("cannot create worktree: %w", err)
+	}
+
+	for name, content := range files {
+		err = os.WriteFile(name, []byte(content+"\n"), 0644)
+		if err != nil {
+			return fmt.Errorf("cannot create worktree: %w", err)
+		}
+	}
+
+	err = withRepository(worktreePath, func() error {
+		err := checkoutTree(commit.Tree, false)
+		if err != nil {
+			return err
+		}
+
+		runPostCheckoutHook(nil, hash)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if params.Progress != nil {
+		subject, _, _ := strings.Cut(commit.Message, "\n")
+		fmt.Fprintf(params.Progress, "HEAD is now at %s %s\n", shortHash(hash), subject)
+	}
+
+	return nil
+}
+
+// hasLocalChanges reports whether the index or the tracked files of the
+// current working tree differ from HEAD.
+func hasLocalChanges() (bool, error) {
+	index, err := plumbing.ReadIndex()
+	if err != nil {
+		return false, err
+	}
+
+	// An index with unresolved conflicts cannot be written as a tree.
+	indexTree, err := writeIndexTree(index)
+	if err != nil {
+		return true, nil
+	}
+
+	var headTree []byte
+	if head, err := plumbing.ResolveRef(plumbing.HEAD); err == nil {
+		commit, err := plumbing.ReadCommit(head)
+		if err != nil {
+			return false, err
+		}
+		headTree = commit.Tree
+	}
+
+	if !bytes.Equal(indexTree, headTree) {
+		return true, nil
+	}
+
+	snapshot, err := snapshotWorkingTree(index)
+	if err != nil {
+		return false, err
+	}
+
+	return len(changedPaths(indexVersions(index), indexVersions(snapshot))) > 0, nil
+}
+
+// hasUntrackedFiles reports whether the current working tree contains
+// files that are neither tracked nor ignored.
+func hasUntrackedFiles() (bool, error) {
+	index, err := plumbing.ReadIndex()
+	if err != nil {
+		return false, err
+	}
+
+	tracked := trackedPaths(index)
+
+	untracked, err := untrackedFiles(".", tracked, newIgnoreMatcher())
+	if err != nil {
+		return false, err
+	}
+
+	return len(untracked) > 0, nil
+}
+
+// RemoveWorktree deletes a linked worktree and its administrative files.
+// Worktrees with local changes, untracked files or a lock are only removed
+// with force.
+func RemoveWorktree(worktreePath string, force bool) error {
+	worktree, err := findWorktree(worktreePath)
+	if err != nil {
+		return err
+	}
+
+	if !force {
+		if worktree.Locked {
+			return fmt.Errorf("cannot remove a locked working tree, use --force to remove it")
+		}
+
+		var modified bool
+		err = withRepository(worktree.Path, func() error {
+			modified, err = hasLocalChanges()
+			if err != nil || modified {
+				return err
+			}
+			modified, err = hasUntrackedFiles()
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("cannot check '%s' for changes: %w", worktreePath, err)
+		}
+		if modified {
+			return fmt.Errorf("'%s' contains modified or untracked files, use --force to delete it", worktreePath)
+		}
+	}
+
+	err = os.RemoveAll(worktree.Path)
+	if err != nil {
+		return err
+	}
+
+	return removeWorktreeDirectory(worktree.Name)
+}
+
+func removeWorktreeDirectory(name string) error {
+	err := os.RemoveAll(path.Join(worktreesDirectory(), name))
+	if err != nil {
+		return err
+	}
+
+	// Like git, remove the worktrees directory with the last worktree.
+	os.Remove(worktreesDirectory())
+
+	return nil
+}
+
+// PruneWorktrees removes the administrative files of linked worktrees
+// whose directory was deleted. Locked worktrees are kept.
+func PruneWorktrees(dryRun bool, progress io.Writer) error {
+	worktrees, err := ListWorktrees()
+	if err != nil {
+		return err
+	}
+
+	for _, worktree := range worktrees {
+		if worktree.Prunable == "" || worktree.Locked {
+			continue
+		}
+
+		if progress != nil {
+			fmt.Fprintf(progress, "Removing %s/%s: %s\n", worktreesDirectoryName, worktree.Name, worktree.Prunable)
+		}
+		if dryRun {
+			continue
+		}
+
+		err = removeWorktreeDirectory(worktree.Name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,163 @@
+package core
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/untanky/git-charged/plumbing"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestRemoveWorktreeKeepsUntrackedFiles(t *testing.T) {
+	directory := initTestRepository(t)
+
+	tree := writeTestTree(t, plumbing.TreeEntry{Mode: plumbing.ObjectTypeFile | 0644, Name: "README.md", Hash: writeTestBlob(t, "hello\n")})
+	commit := writeTestCommit(t, tree, "Initial commit\n")
+	err := plumbing.WriteRef("refs/heads/main", commit)
+	if err == nil {
+		err = plumbing.WriteSymbolicRef(plumbing.HEAD, "refs/heads/main")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		setup   func(worktree string) error
+		removed bool
+	}{
+		{"clean", func(string) error { return nil }, true},
+		{"untracked file", func(worktree string) error {
+			return os.WriteFile(path.Join(worktree, "notes.txt"), []byte("keep me\n"), 0644)
+		}, false},
+		{"ignored file", func(worktree string) error {
+			err := os.WriteFile(path.Join(worktree, ".gitignore"), []byte("*.log\n.gitignore\n"), 0644)
+			if err != nil {
+				return err
+			}
+			return os.WriteFile(path.Join(worktree, "build.log"), []byte("log\n"), 0644)
+		}, true},
+	}
+
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			worktree := path.Join(directory, "worktrees", string(rune('a'+i)))
+			err := AddWorktree(AddWorktreeParams{Path: worktree, Commitish: hex.EncodeToString(commit), Detach: true})
+			if err != nil {
+				t.Fatalf("AddWorktree() = %s", err)
+			}
+			err = test.setup(worktree)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = RemoveWorktree(worktree, false)
+			if _, statErr := os.Stat(worktree); (err == nil) != test.removed || os.IsNotExist(statErr) != test.removed {
+				t.Fatalf("RemoveWorktree() = %v, worktree exists: %v, want removed %v", err, statErr == nil, test.removed)
+			}
+
+			if !test.removed {
+				err = RemoveWorktree(worktree, true)
+				if err != nil {
+					t.Fatalf("RemoveWorktree() with force = %s", err)
+				}
+			}
+		})
+	}
+}
+
+func TestAddListAndPruneWorktrees(t *testing.T) {
+	directory := initTestRepository(t)
+
+	tree := writeTestTree(t, plumbing.TreeEntry{Mode: plumbing.ObjectTypeFile | 0644, Name: "README.md", Hash: writeTestBlob(t, "hello\n")})
+	commit := writeTestCommit(t, tree, "Initial commit\n")
+	err := plumbing.WriteRef("refs/heads/main", commit)
+	if err == nil {
+		err = plumbing.WriteSymbolicRef(plumbing.HEAD, "refs/heads/main")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	feature := path.Join(directory, "linked", "feature")
+	err = AddWorktree(AddWorktreeParams{Path: feature, NewBranch: "feature"})
+	if err != nil {
+		t.Fatalf("AddWorktree() = %s", err)
+	}
+	// Without a commit-ish, a branch named after the directory is created.
+	topic := path.Join(directory, "linked", "topic")
+	err = AddWorktree(AddWorktreeParams{Path: topic})
+	if err != nil {
+		t.Fatalf("AddWorktree() = %s", err)
+	}
+
+	content, err := os.ReadFile(path.Join(feature, "README.md"))
+	if err != nil || string(content) != "hello\n" {
+		t.Errorf("README.md of the worktree = %q, %v", content, err)
+	}
+
+	err = AddWorktree(AddWorktreeParams{Path: path.Join(directory, "linked", "other"), Commitish: "feature"})
+	if err == nil || !strings.Contains(err.Error(), "is already checked out at") {
+		t.Errorf("AddWorktree() of a checked out branch = %v", err)
+	}
+	err = AddWorktree(AddWorktreeParams{Path: feature, Detach: true})
+	if err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("AddWorktree() into a worktree = %v", err)
+	}
+
+	worktrees, err := ListWorktrees()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Worktree{
+		{Path: directory, Branch: "refs/heads/main"},
+		{Path: feature, Name: "feature", Branch: "refs/heads/feature"},
+		{Path: topic, Name: "topic", Branch: "refs/heads/topic"},
+	}
+	if len(worktrees) != len(want) {
+		t.Fatalf("ListWorktrees() = %+v, want %+v", worktrees, want)
+	}
+	for i, worktree := range worktrees {
+		if worktree.Path != want[i].Path || worktree.Name != want[i].Name || worktree.Branch != want[i].Branch || !bytes.Equal(worktree.Head, commit) {
+			t.Errorf("worktree %d = %+v, want %+v at %x", i, worktree, want[i], commit)
+		}
+	}
+
+	// Both worktrees are deleted by hand, but topic is locked.
+	err = os.WriteFile(path.Join(worktreesDirectory(), "topic", worktreeLockedFile), []byte("on a USB stick\n"), 0644)
+	for _, worktree := range []string{feature, topic} {
+		if err == nil {
+			err = os.RemoveAll(worktree)
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var progress bytes.Buffer
+	err = PruneWorktrees(true, &progress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if progress.String() != "Removing worktrees/feature: gitdir file points to non-existent location\n" {
+		t.Errorf("PruneWorktrees() reported %q", progress.String())
+	}
+	if _, err := os.Stat(path.Join(worktreesDirectory(), "feature")); err != nil {
+		t.Errorf("a dry run removed the worktree: %v", err)
+	}
+
+	err = PruneWorktrees(false, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	worktrees, err = ListWorktrees()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(worktrees) != 2 || worktrees[1].Name != "topic" || !worktrees[1].Locked || worktrees[1].LockReason != "on a USB stick" {
+		t.Errorf("ListWorktrees() after pruning = %+v, want only the locked worktree to be kept", worktrees)
+	}
+}
@@ -0,0 +1,165 @@
+package plumbing
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	bundleSignatureV2 = "# v2 git bundle"
+	bundleSignatureV3 = "# v3 git bundle"
+)
+
+var errInvalidBundle = errors.New("not a git bundle")
+
+// Bundle describes the header of a git bundle file. The pack data follows
+// the header in the same file.
+type Bundle struct {
+	Version      int
+	Capabilities map[string]string
+	// Prerequisites are commits the receiving repository must already have.
+	Prerequisites [][]byte
+	Refs          []Ref
+}
+
+// IsBundle reports whether the file at path starts with a bundle signature.
+func IsBundle(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	signature, err := bufio.NewReader(file).ReadString('\n')
+	if err != nil {
+		return false
+	}
+
+	signature = strings.TrimSuffix(signature, "\n")
+	return signature == bundleSignatureV2 || signature == bundleSignatureV3
+}
+
+// ReadBundleHeader parses a bundle header and leaves reader positioned at
+// the start of the pack data.
+func ReadBundleHeader(reader *bufio.Reader) (*Bundle, error) {
+	signature, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, errInvalidBundle
+	}
+
+	bundle := &Bundle{
+		Capabilities:  make(map[string]string),
+		Prerequisites: make([][]byte, 0),
+		Refs:          make([]Ref, 0),
+	}
+	switch strings.TrimSuffix(signature, "\n") {
+	case bundleSignatureV2:
+		bundle.Version = 2
+	case bundleSignatureV3:
+		bundle.Version = 3
+	default:
+		return nil, errInvalidBundle
+	}
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("cannot read bundle header: %w", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return bundle, nil
+		}
+
+		switch {
+		case strings.HasPrefix(line, "@") && bundle.Version == 3:
+			key, value, _ := strings.Cut(line[1:], "=")
+			bundle.Capabilities[key] = value
+		case strings.HasPrefix(line, "-"):
+			// Prerequisites may be followed by the subject of the commit.
+			field, _, _ := strings.Cut(line[1:], " ")
+			hash, err := hex.DecodeString(field)
+			if err != nil {
+				return nil, fmt.Errorf("malformed bundle prerequisite %q", line)
+			}
+			bundle.Prerequisites = append(bundle.Prerequisites, hash)
+		default:
+			field, name, ok := strings.Cut(line, " ")
+			hash, err := hex.DecodeString(field)
+			if !ok || err != nil {
+				return nil, fmt.Errorf("malformed bundle reference %q", line)
+			}
+			bundle.Refs = append(bundle.Refs, Ref{Name: name, Hash: hash})
+		}
+	}
+}
+
+// WriteBundle writes a bundle header followed by a pack containing
+// everything reachable from the bundled refs but not from the
+// prerequisites. It returns the number of objects written.
+func WriteBundle(w io.Writer, bundle *Bundle) (int, error) {
+	if format, ok := bundle.Capabilities["object-format"]; ok && format != "sha1" {
+		return 0, fmt.Errorf("unsupported object format %s", format)
+	}
+
+	writer := bufio.NewWriter(w)
+	switch bundle.Version {
+	case 2:
+		fmt.Fprintln(writer, bundleSignatureV2)
+	case 3:
+		fmt.Fprintln(writer, bundleSignatureV3)
+		for key, value := range bundle.Capabilities {
+			if value == "" {
+				fmt.Fprintf(writer, "@%s\n", key)
+			} else {
+				fmt.Fprintf(writer, "@%s=%s\n", key, value)
+			}
+		}
+	default:
+		return 0, fmt.Errorf("unsupported bundle version %d", bundle.Version)
+	}
+
+	for _, prerequisite := range bundle.Prerequisites {
+		subject := ""
+		if commit, err := ReadCommit(prerequisite); err == nil {
+			subject, _, _ = strings.Cut(commit.Message, "\n")
+		}
+		fmt.Fprintf(writer, "-%x %s\n", prerequisite, subject)
+	}
+
+	include := make([][]byte, 0, len(bundle.Refs))
+	for _, ref := range bundle.Refs {
+		fmt.Fprintf(writer, "%x %s\n", ref.Hash, ref.Name)
+		include = append(include, ref.Hash)
+	}
+	fmt.Fprintln(writer)
+
+	objects, err := ReachableObjects(include, bundle.Prerequisites)
+	if err != nil {
+		return 0, err
+	}
+
+	packWriter, err := NewPackWriter(writer, len(objects))
+	if err != nil {
+		return 0, err
+	}
+
+	for _, hash := range objects {
+		err = packWriter.Add(hash)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	_, err = packWriter.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	return len(objects), writer.Flush()
+}
@@ -0,0 +1,56 @@
+package plumbing
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestReadBundleHeader(t *testing.T) {
+	hash := strings.Repeat("ab", 20)
+	content := "# v3 git bundle\n@object-format=sha1\n@filter\n-" + hash + " Subject of the commit\n" + hash + " refs/heads/main\n\nPACK"
+
+	reader := bufio.NewReader(strings.NewReader(content))
+	bundle, err := ReadBundleHeader(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bundle.Version != 3 || bundle.Capabilities["object-format"] != "sha1" || bundle.Capabilities["filter"] != "" || len(bundle.Capabilities) != 2 {
+		t.Errorf("version %d with capabilities %v", bundle.Version, bundle.Capabilities)
+	}
+	if len(bundle.Prerequisites) != 1 || strings.Repeat("\xab", 20) != string(bundle.Prerequisites[0]) {
+		t.Errorf("prerequisites = %x", bundle.Prerequisites)
+	}
+	if len(bundle.Refs) != 1 || bundle.Refs[0].Name != "refs/heads/main" {
+		t.Errorf("refs = %v", bundle.Refs)
+	}
+	if rest, _ := reader.Peek(4); !bytes.Equal(rest, []byte("PACK")) {
+		t.Errorf("reader is at %q after the header, want the pack", rest)
+	}
+}
+
+func TestReadBundleHeaderRejectsInvalidHeaders(t *testing.T) {
+	hash := strings.Repeat("ab", 20)
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{name: "no signature", content: "PACK"},
+		{name: "unknown version", content: "# v4 git bundle\n\n"},
+		{name: "capability in version 2", content: "# v2 git bundle\n@object-format=sha1\n\n"},
+		{name: "malformed prerequisite", content: "# v2 git bundle\n-xyz\n\n"},
+		{name: "reference without name", content: "# v2 git bundle\n" + hash + "\n\n"},
+		{name: "unterminated header", content: "# v2 git bundle\n" + hash + " refs/heads/main\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ReadBundleHeader(bufio.NewReader(strings.NewReader(test.content)))
+			if err == nil {
+				t.Errorf("ReadBundleHeader(%q) succeeded", test.content)
+			}
+		})
+	}
+}
@@ -1,8 +1,12 @@
 package plumbing
 
 import (
+	"bytes"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -12,30 +16,79 @@ type AuthorData struct {
 	Timestamp time.Time
 }
 
+func (a AuthorData) String() string {
+	return fmt.Sprintf("%s <%s> %d %s", a.Name, a.Email, a.Timestamp.Unix(), a.Timestamp.Format("-0700"))
+}
+
+func ParseAuthorData(value string) (AuthorData, error) {
+	start := strings.LastIndex(value, " <")
+	end := strings.LastIndex(value, "> ")
+	if start < 0 || end < start {
+		return AuthorData{}, fmt.Errorf("malformed identity %q", value)
+	}
+
+	fields := strings.Fields(value[end+2:])
+	if len(fields) != 2 {
+		return AuthorData{}, fmt.Errorf("malformed identity %q", value)
+	}
+
+	seconds, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return AuthorData{}, fmt.Errorf("malformed timestamp %q", value)
+	}
+
+	zone, err := time.Parse("-0700", fields[1])
+	if err != nil {
+		return AuthorData{}, fmt.Errorf("malformed timezone %q", value)
+	}
+	_, offset := zone.Zone()
+
+	return AuthorData{
+		Name:      value[:start],
+		Email:     value[start+2 : end],
+		Timestamp: time.Unix(seconds, 0).In(time.FixedZone(fields[1], offset)),
+	}, nil
+}
+
 type Commit struct {
 	Tree      []byte
-	Parent    []byte
+	Parents   [][]byte
 	Author    AuthorData
 	Committer AuthorData
+	// Signature is the armored signature of the commit's payload, stored in
+	// the gpgsig header.
+	Signature string
 	Message   string
 }
 
-func (c *Commit) WriteTo(w io.Writer) (int64, error) {
-	var data string
-	if c.Parent == nil {
-		data = fmt.Sprintf(`tree %x
-author %s <%s> %d +0200
-committer %s <%s> %d +0200
+const signatureHeader = "gpgsig"
 
-%s`, c.Tree, c.Author.Name, c.Author.Email, c.Author.Timestamp.Unix(), c.Committer.Name, c.Committer.Email, c.Committer.Timestamp.Unix(), c.Message)
-	} else {
-		data = fmt.Sprintf(`tree %x
-parent %x
-author %s <%s> %d +0200
-committer %s %s %d +0200
+// Payload returns the content of the commit without its signature, which is
+// what gets signed.
+func (c *Commit) Payload() []byte {
+	return []byte(c.content(false))
+}
 
-%s`, c.Tree, c.Parent, c.Author.Name, c.Author.Email, c.Author.Timestamp.Unix(), c.Committer.Name, c.Committer.Email, c.Committer.Timestamp.Unix(), c.Message)
+func (c *Commit) content(signed bool) string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "tree %x\n", c.Tree)
+	for _, parent := range c.Parents {
+		fmt.Fprintf(&builder, "parent %x\n", parent)
+	}
+	fmt.Fprintf(&builder, "author %s\n", c.Author)
+	fmt.Fprintf(&builder, "committer %s\n", c.Committer)
+	if signed && c.Signature != "" {
+		// Continuation lines of a header start with a space.
+		signature := strings.TrimSuffix(c.Signature, "\n")
+		fmt.Fprintf(&builder, "%s %s\n", signatureHeader, strings.ReplaceAll(signature, "\n", "\n "))
 	}
+	fmt.Fprintf(&builder, "\n%s", c.Message)
+
+	return builder.String()
+}
+
+func (c *Commit) WriteTo(w io.Writer) (int64, error) {
+	data := c.content(true)
 
 	m, err := fmt.Fprintf(w, "commit %d\000%s", len(data), data)
 	if err != nil {
@@ -44,3 +97,93 @@ committer %s %s %d +0200
 
 	return int64(m), err
 }
+
+func ParseCommit(data []byte) (*Commit, error) {
+	header, message, _ := bytes.Cut(data, []byte("\n\n"))
+
+	commit := &Commit{
+		Parents: make([][]byte, 0, 1),
+		Message: string(message),
+	}
+
+	var signature strings.Builder
+	key := ""
+	for _, line := range strings.Split(string(header), "\n") {
+		if continuation, ok := strings.CutPrefix(line, " "); ok {
+			if key == signatureHeader {
+				signature.WriteString(continuation + "\n")
+			}
+			continue
+		}
+
+		var value string
+		key, value, _ = strings.Cut(line, " ")
+
+		var err error
+		switch key {
+		case signatureHeader:
+			signature.WriteString(value + "\n")
+		case "tree":
+			commit.Tree, err = hex.DecodeString(value)
+		case "parent":
+			var parent []byte
+			parent, err = hex.DecodeString(value)
+			commit.Parents = append(commit.Parents, parent)
+		case "author":
+			commit.Author, err = ParseAuthorData(value)
+		case "committer":
+			commit.Committer, err = ParseAuthorData(value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("malformed commit: %w", err)
+		}
+	}
+
+	if commit.Tree == nil {
+		return nil, fmt.Errorf("malformed commit: missing tree")
+	}
+	commit.Signature = signature.String()
+
+	return commit, nil
+}
+
+// SplitCommitSignature separates the signature from the raw content of a
+// commit. The payload keeps every other header, including ones ParseCommit
+// does not know about, so that it matches what was signed.
+func SplitCommitSignature(data []byte) ([]byte, []byte) {
+	header, message, _ := bytes.Cut(data, []byte("\n\n"))
+
+	var payload, signature bytes.Buffer
+	inSignature := false
+	for _, line := range bytes.Split(header, []byte("\n")) {
+		if continuation, ok := bytes.CutPrefix(line, []byte(" ")); ok && inSignature {
+			signature.Write(continuation)
+			signature.WriteByte('\n')
+			continue
+		}
+
+		value, ok := bytes.CutPrefix(line, []byte(signatureHeader+" "))
+		inSignature = ok
+		if ok {
+			signature.Write(value)
+			signature.WriteByte('\n')
+			continue
+		}
+
+		payload.Write(line)
+		payload.WriteByte('\n')
+	}
+	payload.WriteByte('\n')
+	payload.Write(message)
+
+	return payload.Bytes(), signature.Bytes()
+}
+
+func ReadCommit(hash []byte) (*Commit, error) {
+	data, err := ReadObjectOfKind(hash, KindCommit)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseCommit(data)
+}
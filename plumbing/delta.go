@@ -0,0 +1,95 @@
+package plumbing
+
+import (
+	"errors"
+)
+
+var errInvalidDelta = errors.New("invalid delta")
+
+func readDeltaSize(delta []byte, position int) (uint64, int, error) {
+	var size uint64
+	var shift uint
+	for {
+		if position >= len(delta) {
+			return 0, 0, errInvalidDelta
+		}
+		b := delta[position]
+		position++
+		size |= uint64(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			return size, position, nil
+		}
+	}
+}
+
+func applyDelta(base []byte, delta []byte) ([]byte, error) {
+	sourceSize, position, err := readDeltaSize(delta, 0)
+	if err != nil {
+		return nil, err
+	}
+	if sourceSize != uint64(len(base)) {
+		return nil, errInvalidDelta
+	}
+
+	targetSize, position, err := readDeltaSize(delta, position)
+	if err != nil {
+		return nil, err
+	}
+
+	// An instruction copies at most the whole base or inserts at most 127
+	// bytes, which bounds the size a delta can produce.
+	if targetSize > uint64(len(delta)-position)*uint64(max(len(base), 0x7f)) {
+		return nil, errInvalidDelta
+	}
+
+	target := make([]byte, 0, min(targetSize, uint64(len(base)+len(delta))))
+	for position < len(delta) {
+		instruction := delta[position]
+		position++
+
+		if instruction&0x80 != 0 {
+			var offset, size uint64
+			for i := uint(0); i < 4; i++ {
+				if instruction&(1<<i) != 0 {
+					if position >= len(delta) {
+						return nil, errInvalidDelta
+					}
+					offset |= uint64(delta[position]) << (8 * i)
+					position++
+				}
+			}
+			for i := uint(0); i < 3; i++ {
+				if instruction&(0x10<<i) != 0 {
+					if position >= len(delta) {
+						return nil, errInvalidDelta
+					}
+					size |= uint64(delta[position]) << (8 * i)
+					position++
+				}
+			}
+			if size == 0 {
+				size = 0x10000
+			}
+			if offset+size > uint64(len(base)) || uint64(len(target))+size > targetSize {
+				return nil, errInvalidDelta
+			}
+			target = append(target, base[offset:offset+size]...)
+		} else if instruction != 0 {
+			size := int(instruction)
+			if position+size > len(delta) || uint64(len(target)+size) > targetSize {
+				return nil, errInvalidDelta
+			}
+			target = append(target, delta[position:position+size]...)
+			position += size
+		} else {
+			return nil, errInvalidDelta
+		}
+	}
+
+	if uint64(len(target)) != targetSize {
+		return nil, errInvalidDelta
+	}
+
+	return target, nil
+}
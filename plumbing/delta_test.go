@@ -0,0 +1,65 @@
+package plumbing
+
+import (
+	"errors"
+	"testing"
+)
+
+func encodeDeltaSize(size uint64) []byte {
+	encoded := make([]byte, 0, 10)
+	for size >= 0x80 {
+		encoded = append(encoded, byte(size&0x7f)|0x80)
+		size >>= 7
+	}
+	return append(encoded, byte(size))
+}
+
+func deltaHeader(sourceSize uint64, targetSize uint64) []byte {
+	return append(encodeDeltaSize(sourceSize), encodeDeltaSize(targetSize)...)
+}
+
+func TestApplyDelta(t *testing.T) {
+	base := []byte("hello, world")
+
+	// Copy "hello", insert " there", copy ", world".
+	delta := deltaHeader(uint64(len(base)), 18)
+	delta = append(delta, 0x90, 5)
+	delta = append(delta, 6, ' ', 't', 'h', 'e', 'r', 'e')
+	delta = append(delta, 0x91, 5, 7)
+
+	target, err := applyDelta(base, delta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(target) != "hello there, world" {
+		t.Errorf("applyDelta() = %q", target)
+	}
+}
+
+func TestApplyDeltaRejectsInvalidDeltas(t *testing.T) {
+	base := []byte("hello, world")
+
+	tests := []struct {
+		name  string
+		delta []byte
+	}{
+		{name: "empty", delta: nil},
+		{name: "wrong source size", delta: append(deltaHeader(3, 5), 0x90, 5)},
+		{name: "huge target size", delta: append(deltaHeader(uint64(len(base)), 1<<62), 0x90, 5)},
+		{name: "largest target size", delta: append(deltaHeader(uint64(len(base)), 1<<64-1), 0x90, 5)},
+		{name: "target larger than instructions", delta: append(deltaHeader(uint64(len(base)), 6), 0x90, 5)},
+		{name: "target smaller than instructions", delta: append(deltaHeader(uint64(len(base)), 4), 0x90, 5)},
+		{name: "copy beyond base", delta: append(deltaHeader(uint64(len(base)), 5), 0x91, 5, 10)},
+		{name: "insert beyond delta", delta: append(deltaHeader(uint64(len(base)), 5), 5, 'a')},
+		{name: "reserved instruction", delta: append(deltaHeader(uint64(len(base)), 0), 0)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := applyDelta(base, test.delta)
+			if !errors.Is(err, errInvalidDelta) {
+				t.Errorf("applyDelta() = %v, want errInvalidDelta", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,454 @@
+package plumbing
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"sort"
+)
+
+type packEntry struct {
+	offset     int64
+	end        int64
+	objectType int
+	size       uint64
+	dataOffset int64
+	baseOffset int64
+	baseHash   []byte
+	hash       []byte
+	external   bool
+}
+
+type packIndexer struct {
+	pack     []byte
+	entries  []*packEntry
+	byOffset map[int64]*packEntry
+	byHash   map[string]*packEntry
+
+	cache     map[int64]cachedObject
+	cacheSize int
+}
+
+// IndexPack stores a pack file received from a remote in the object
+// directory and writes the matching index. Objects whose delta base lives
+// outside the pack are written as loose objects instead.
+func IndexPack(reader io.Reader) ([]byte, error) {
+	pack, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read pack: %w", err)
+	}
+
+	hashSize := hashFactory.Size()
+	if len(pack) < 12+hashSize || string(pack[:4]) != "PACK" {
+		return nil, errInvalidPack
+	}
+	if version := binary.BigEndian.Uint32(pack[4:8]); version != 2 && version != 3 {
+		return nil, fmt.Errorf("unsupported pack version %d", version)
+	}
+
+	checksum := pack[len(pack)-hashSize:]
+	hashWriter := hashFactory.New()
+	hashWriter.Write(pack[:len(pack)-hashSize])
+	if !bytes.Equal(hashWriter.Sum(nil), checksum) {
+		return nil, fmt.Errorf("%w: checksum mismatch", errInvalidPack)
+	}
+
+	indexer := &packIndexer{
+		pack:     pack,
+		byOffset: make(map[int64]*packEntry),
+		byHash:   make(map[string]*packEntry),
+		cache:    make(map[int64]cachedObject),
+	}
+
+	err = indexer.parseEntries(binary.BigEndian.Uint32(pack[8:12]))
+	if err != nil {
+		return nil, err
+	}
+
+	err = indexer.resolveEntries()
+	if err != nil {
+		return nil, err
+	}
+
+	indexed := make([]*packEntry, 0, len(indexer.entries))
+	for _, entry := range indexer.entries {
+		if !entry.external {
+			indexed = append(indexed, entry)
+		}
+	}
+	sort.Slice(indexed, func(i, j int) bool {
+		return bytes.Compare(indexed[i].hash, indexed[j].hash) < 0
+	})
+
+	packPath := path.Join(commonDirectory, objectsDirectory, packDirectory)
+	err = os.MkdirAll(packPath, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	name := path.Join(packPath, "pack-"+hex.EncodeToString(checksum))
+	err = writeFileAtomically(name+".pack", pack)
+	if err != nil {
+		return nil, fmt.Errorf("cannot write pack: %w", err)
+	}
+
+	err = writeFileAtomically(name+".idx", indexer.buildIndex(indexed, checksum))
+	if err != nil {
+		return nil, fmt.Errorf("cannot write pack index: %w", err)
+	}
+
+	resetPacks()
+
+	return checksum, nil
+}
+
+func (indexer *packIndexer) parseEntries(count uint32) error {
+	hashSize := hashFactory.Size()
+	reader := bytes.NewReader(indexer.pack[:len(indexer.pack)-hashSize])
+	offset := int64(12)
+
+	for i := uint32(0); i < count; i++ {
+		_, err := reader.Seek(offset, io.SeekStart)
+		if err != nil {
+			return err
+		}
+
+		entry := &packEntry{offset: offset}
+		entry.objectType, entry.size, err = readPackEntryHeader(reader)
+		if err != nil {
+			return fmt.Errorf("%w: %s", errInvalidPack, err)
+		}
+
+		switch entry.objectType {
+		case packObjectOfsDelta:
+			distance, err := readOffsetDelta(reader)
+			if err != nil || distance == 0 || int64(distance) > offset {
+				return fmt.Errorf("%w: bad delta offset", errInvalidPack)
+			}
+			entry.baseOffset = offset - int64(distance)
+		case packObjectRefDelta:
+			entry.baseHash = make([]byte, hashSize)
+			_, err = io.ReadFull(reader, entry.baseHash)
+			if err != nil {
+				return fmt.Errorf("%w: %s", errInvalidPack, err)
+			}
+		default:
+			if _, ok := packObjectKinds[entry.objectType]; !ok {
+				return fmt.Errorf("%w: unknown object type %d", errInvalidPack, entry.objectType)
+			}
+		}
+
+		entry.dataOffset = reader.Size() - int64(reader.Len())
+
+		zlibReader, err := zlib.NewReader(reader)
+		if err != nil {
+			return fmt.Errorf("%w: %s", errInvalidPack, err)
+		}
+		size, err := io.Copy(io.Discard, zlibReader)
+		zlibReader.Close()
+		if err != nil {
+			return fmt.Errorf("%w: %s", errInvalidPack, err)
+		}
+		if uint64(size) != entry.size {
+			return fmt.Errorf("%w: object at offset %d does not have its size", errInvalidPack, offset)
+		}
+
+		entry.end = reader.Size() - int64(reader.Len())
+		offset = entry.end
+
+		indexer.entries = append(indexer.entries, entry)
+		indexer.byOffset[entry.offset] = entry
+	}
+
+	if offset != int64(len(indexer.pack)-hashSize) {
+		return fmt.Errorf("%w: trailing data", errInvalidPack)
+	}
+
+	return nil
+}
+
+func (indexer *packIndexer) resolveEntries() error {
+	pending := indexer.entries
+	allowExternal := false
+
+	for len(pending) > 0 {
+		unresolved := make([]*packEntry, 0)
+		for _, entry := range pending {
+			if !indexer.resolvable(entry, allowExternal) {
+				unresolved = append(unresolved, entry)
+				continue
+			}
+
+			kind, data, err := indexer.object(entry)
+			if err != nil {
+				return err
+			}
+
+			entry.hash, err = HashObject(NewRawObject(kind, data))
+			if err != nil {
+				return err
+			}
+			indexer.byHash[string(entry.hash)] = entry
+
+			if entry.external {
+				_, err = WriteObject(NewRawObject(kind, data))
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		if len(unresolved) == len(pending) {
+			if allowExternal {
+				return fmt.Errorf("%w: missing delta base %x", errInvalidPack, unresolved[0].baseHash)
+			}
+			allowExternal = true
+		}
+
+		pending = unresolved
+	}
+
+	return nil
+}
+
+func (indexer *packIndexer) resolvable(entry *packEntry, allowExternal bool) bool {
+	switch entry.objectType {
+	case packObjectOfsDelta:
+		base, ok := indexer.byOffset[entry.baseOffset]
+		return ok && indexer.resolvable(base, allowExternal)
+	case packObjectRefDelta:
+		if base, ok := indexer.byHash[string(entry.baseHash)]; ok {
+			return base.hash != nil
+		}
+		return allowExternal && HasObject(entry.baseHash)
+	default:
+		return true
+	}
+}
+
+func (indexer *packIndexer) object(entry *packEntry) (ObjectKind, []byte, error) {
+	if cached, ok := indexer.cache[entry.offset]; ok {
+		return cached.kind, cached.data, nil
+	}
+
+	data, err := inflate(bytes.NewReader(indexer.pack[entry.dataOffset:entry.end]), entry.size)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var kind ObjectKind
+	var base []byte
+	switch entry.objectType {
+	case packObjectOfsDelta:
+		baseEntry := indexer.byOffset[entry.baseOffset]
+		kind, base, err = indexer.object(baseEntry)
+		entry.external = baseEntry.external
+	case packObjectRefDelta:
+		if baseEntry, ok := indexer.byHash[string(entry.baseHash)]; ok {
+			kind, base, err = indexer.object(baseEntry)
+			entry.external = baseEntry.external
+		} else {
+			kind, base, err = ReadObject(entry.baseHash)
+			entry.external = true
+		}
+	default:
+		kind = packObjectKinds[entry.objectType]
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	if base != nil {
+		data, err = applyDelta(base, data)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	if indexer.cacheSize+len(data) > maxDeltaCacheSize {
+		indexer.cache = make(map[int64]cachedObject)
+		indexer.cacheSize = 0
+	}
+	indexer.cache[entry.offset] = cachedObject{kind, data}
+	indexer.cacheSize += len(data)
+
+	return kind, data, nil
+}
+
+func (indexer *packIndexer) buildIndex(entries []*packEntry, checksum []byte) []byte {
+	buffer := bytes.NewBuffer(make([]byte, 0, 1024+len(entries)*32))
+	buffer.Write(packIndexMagic)
+	binary.Write(buffer, binary.BigEndian, uint32(2))
+
+	var fanout [256]uint32
+	for _, entry := range entries {
+		fanout[entry.hash[0]]++
+	}
+	var total uint32
+	for i := range fanout {
+		total += fanout[i]
+		binary.Write(buffer, binary.BigEndian, total)
+	}
+
+	for _, entry := range entries {
+		buffer.Write(entry.hash)
+	}
+
+	for _, entry := range entries {
+		crc := crc32.ChecksumIEEE(indexer.pack[entry.offset:entry.end])
+		binary.Write(buffer, binary.BigEndian, crc)
+	}
+
+	largeOffsets := make([]uint64, 0)
+	for _, entry := range entries {
+		if entry.offset < 0x80000000 {
+			binary.Write(buffer, binary.BigEndian, uint32(entry.offset))
+			continue
+		}
+		binary.Write(buffer, binary.BigEndian, uint32(len(largeOffsets))|0x80000000)
+		largeOffsets = append(largeOffsets, uint64(entry.offset))
+	}
+	for _, offset := range largeOffsets {
+		binary.Write(buffer, binary.BigEndian, offset)
+	}
+
+	buffer.Write(checksum)
+
+	hashWriter := hashFactory.New()
+	hashWriter.Write(buffer.Bytes())
+	buffer.Write(hashWriter.Sum(nil))
+
+	return buffer.Bytes()
+}
+
+// MarkPromisorPack records that the objects missing from a pack can be
+// fetched again from the promisor remote it was received from.
+func MarkPromisorPack(checksum []byte) error {
+	name := path.Join(commonDirectory, objectsDirectory, packDirectory, "pack-"+hex.EncodeToString(checksum))
+	return writeFileAtomically(name+".promisor", []byte{})
+}
+
+func writeFileAtomically(filename string, content []byte) error {
+	file, err := os.CreateTemp(path.Dir(filename), "tmp_")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	_, err = file.Write(content)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	err = file.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), filename)
+}
+
+// recordingReader remembers every byte read through it, up to limit bytes
+// unless limit is 0. It implements io.ByteReader so that zlib does not read
+// past the end of a stream.
+type recordingReader struct {
+	reader   *bufio.Reader
+	recorded bytes.Buffer
+	limit    int64
+}
+
+func (r *recordingReader) Read(p []byte) (int, error) {
+	if r.exceeded() {
+		return 0, ErrPackTooLarge
+	}
+	if r.limit > 0 && int64(len(p)) > r.limit-int64(r.recorded.Len())+1 {
+		p = p[:r.limit-int64(r.recorded.Len())+1]
+	}
+	n, err := r.reader.Read(p)
+	r.recorded.Write(p[:n])
+	if err == nil && r.exceeded() {
+		err = ErrPackTooLarge
+	}
+	return n, err
+}
+
+func (r *recordingReader) ReadByte() (byte, error) {
+	if r.exceeded() {
+		return 0, ErrPackTooLarge
+	}
+	b, err := r.reader.ReadByte()
+	if err == nil {
+		r.recorded.WriteByte(b)
+		if r.exceeded() {
+			err = ErrPackTooLarge
+		}
+	}
+	return b, err
+}
+
+func (r *recordingReader) exceeded() bool {
+	return r.limit > 0 && int64(r.recorded.Len()) > r.limit
+}
+
+// ReadPackStream reads exactly one pack from reader, leaving anything that
+// follows it unread, and returns the raw pack. Packs larger than maxSize
+// bytes are rejected with ErrPackTooLarge, unless maxSize is 0.
+func ReadPackStream(reader *bufio.Reader, maxSize int64) ([]byte, error) {
+	recorder := &recordingReader{reader: reader, limit: maxSize}
+
+	header := make([]byte, 12)
+	_, err := io.ReadFull(recorder, header)
+	if err != nil {
+		return nil, err
+	}
+	if string(header[:4]) != "PACK" {
+		return nil, errInvalidPack
+	}
+
+	count := binary.BigEndian.Uint32(header[8:12])
+	for i := uint32(0); i < count; i++ {
+		objectType, _, err := readPackEntryHeader(recorder)
+		if err != nil {
+			return nil, err
+		}
+
+		switch objectType {
+		case packObjectOfsDelta:
+			_, err = readOffsetDelta(recorder)
+		case packObjectRefDelta:
+			_, err = io.ReadFull(recorder, make([]byte, hashFactory.Size()))
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		zlibReader, err := zlib.NewReader(recorder)
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.Copy(io.Discard, zlibReader)
+		zlibReader.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = io.ReadFull(recorder, make([]byte, hashFactory.Size()))
+	if err != nil {
+		return nil, err
+	}
+	// io.ReadFull drops the error of a read that fills its buffer.
+	if recorder.exceeded() {
+		return nil, ErrPackTooLarge
+	}
+
+	return recorder.recorded.Bytes(), nil
+}
@@ -0,0 +1,119 @@
+package plumbing
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// useTestDirectory points the package at an empty git directory for the
+// duration of the test.
+func useTestDirectory(t *testing.T) {
+	t.Helper()
+
+	directory := t.TempDir()
+	for _, name := range []string{path.Join(objectsDirectory, packDirectory), "refs"} {
+		err := os.MkdirAll(path.Join(directory, name), os.ModePerm)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	SetDirectory(directory)
+	t.Cleanup(func() {
+		SetDirectory(".git")
+	})
+}
+
+func TestIndexPackRoundTrip(t *testing.T) {
+	useTestDirectory(t)
+
+	blob, err := WriteObject(NewBlob(6, strings.NewReader("hello\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := NewTree()
+	tree.AddObject(ObjectTypeFile|0644, "hello.txt", blob)
+	treeHash, err := WriteObject(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hashes := [][]byte{blob, treeHash}
+	contents := make([][]byte, len(hashes))
+	var pack bytes.Buffer
+	writer, err := NewPackWriter(&pack, len(hashes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, hash := range hashes {
+		_, contents[i], err = ReadObject(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = writer.Add(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	checksum, err := writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	useTestDirectory(t)
+	indexed, err := IndexPack(&pack)
+	if err != nil {
+		t.Fatalf("IndexPack() = %s", err)
+	}
+	if !bytes.Equal(indexed, checksum) {
+		t.Errorf("IndexPack() = %x, want %x", indexed, checksum)
+	}
+
+	for i, hash := range hashes {
+		_, data, err := ReadObject(hash)
+		if err != nil || !bytes.Equal(data, contents[i]) {
+			t.Errorf("ReadObject(%x) = %q, %v, want %q", hash, data, err, contents[i])
+		}
+	}
+}
+
+func TestIndexPackResolvesDeltas(t *testing.T) {
+	useTestDirectory(t)
+
+	base := []byte("hello, world")
+	baseHash, err := HashObject(NewRawObject(KindBlob, base))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// "hello there, world" as a delta on the base.
+	ofsDelta := deltaHeader(uint64(len(base)), 18)
+	ofsDelta = append(ofsDelta, 0x90, 5, 6, ' ', 't', 'h', 'e', 'r', 'e', 0x91, 5, 7)
+	// "hello" as a delta on the base, referenced by its hash.
+	refDelta := append(deltaHeader(uint64(len(base)), 5), 0x90, 5)
+
+	pack := buildTestPack(t,
+		testPackEntry{objectType: packObjectBlob, data: base},
+		testPackEntry{objectType: packObjectOfsDelta, baseOffset: 12, data: ofsDelta},
+		testPackEntry{objectType: packObjectRefDelta, baseHash: baseHash, data: refDelta},
+	)
+
+	_, err = IndexPack(bytes.NewReader(pack))
+	if err != nil {
+		t.Fatalf("IndexPack() = %s", err)
+	}
+
+	for _, content := range []string{"hello, world", "hello there, world", "hello"} {
+		hash, err := HashObject(NewRawObject(KindBlob, []byte(content)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		kind, data, err := ReadObject(hash)
+		if err != nil || kind != KindBlob || string(data) != content {
+			t.Errorf("ReadObject(%x) = %s, %q, %v, want blob %q", hash, kind, data, err, content)
+		}
+	}
+}
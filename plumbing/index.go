@@ -0,0 +1,192 @@
+package plumbing
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"time"
+)
+
+const (
+	indexFile = "index"
+
+	indexFlagExtended  = 0x4000
+	indexFlagStageMask = 0x3000
+	indexNameMask      = 0x0fff
+
+	indexExtendedFlagSkipWorktree = 0x4000
+)
+
+var indexSignature = []byte("DIRC")
+
+type IndexEntry struct {
+	ChangeTime time.Time
+	ModifyTime time.Time
+	Mode       uint32
+	Size       uint32
+	Hash       []byte
+	Stage      int
+	Name       string
+	// SkipWorktree marks entries outside of a sparse checkout, which are
+	// not present in the working tree.
+	SkipWorktree bool
+}
+
+type Index struct {
+	Entries []IndexEntry
+}
+
+func NewIndexEntry(name string, hash []byte, mode uint32, info fs.FileInfo) IndexEntry {
+	entry := IndexEntry{
+		Mode: mode,
+		Hash: hash,
+		Name: name,
+	}
+	if info != nil {
+		entry.ChangeTime = info.ModTime()
+		entry.ModifyTime = info.ModTime()
+		entry.Size = uint32(info.Size())
+	}
+
+	return entry
+}
+
+func ReadIndex() (*Index, error) {
+	content, err := os.ReadFile(path.Join(gitDirectory, indexFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return &Index{Entries: make([]IndexEntry, 0)}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	hashSize := hashFactory.Size()
+	if len(content) < 12+hashSize || !bytes.Equal(content[:4], indexSignature) {
+		return nil, fmt.Errorf("index file is corrupt")
+	}
+
+	version := binary.BigEndian.Uint32(content[4:8])
+	if version != 2 && version != 3 {
+		return nil, fmt.Errorf("unsupported index version %d", version)
+	}
+
+	count := int(binary.BigEndian.Uint32(content[8:12]))
+	index := &Index{Entries: make([]IndexEntry, 0, count)}
+
+	position := 12
+	for i := 0; i < count; i++ {
+		start := position
+		if position+40+hashSize+2 > len(content) {
+			return nil, fmt.Errorf("index file is truncated")
+		}
+
+		field := func(n int) uint32 {
+			return binary.BigEndian.Uint32(content[start+4*n:])
+		}
+
+		entry := IndexEntry{
+			ChangeTime: time.Unix(int64(field(0)), int64(field(1))),
+			ModifyTime: time.Unix(int64(field(2)), int64(field(3))),
+			Mode:       field(6),
+			Size:       field(9),
+			Hash:       append([]byte(nil), content[start+40:start+40+hashSize]...),
+		}
+		position = start + 40 + hashSize
+
+		flags := binary.BigEndian.Uint16(content[position:])
+		position += 2
+		entry.Stage = int(flags&indexFlagStageMask) >> 12
+		if flags&indexFlagExtended != 0 {
+			extendedFlags := binary.BigEndian.Uint16(content[position:])
+			entry.SkipWorktree = extendedFlags&indexExtendedFlagSkipWorktree != 0
+			position += 2
+		}
+
+		end := bytes.IndexByte(content[position:], 0)
+		if end < 0 {
+			return nil, fmt.Errorf("index file is truncated")
+		}
+		entry.Name = string(content[position : position+end])
+		position += end
+
+		entryLength := position - start
+		position = start + (entryLength+8)&^7
+
+		index.Entries = append(index.Entries, entry)
+	}
+
+	return index, nil
+}
+
+func WriteIndex(index *Index) error {
+	index.Sort()
+
+	buffer := bytes.NewBuffer(make([]byte, 0, 1024))
+	// Version 3 is needed for the extended flags of skipped entries.
+	version := uint32(2)
+	for _, entry := range index.Entries {
+		if entry.SkipWorktree {
+			version = 3
+		}
+	}
+
+	buffer.Write(indexSignature)
+	binary.Write(buffer, binary.BigEndian, version)
+	binary.Write(buffer, binary.BigEndian, uint32(len(index.Entries)))
+
+	for _, entry := range index.Entries {
+		start := buffer.Len()
+		binary.Write(buffer, binary.BigEndian, []uint32{
+			uint32(entry.ChangeTime.Unix()), uint32(entry.ChangeTime.Nanosecond()),
+			uint32(entry.ModifyTime.Unix()), uint32(entry.ModifyTime.Nanosecond()),
+			0, 0,
+			entry.Mode,
+			0, 0,
+			entry.Size,
+		})
+		buffer.Write(entry.Hash)
+
+		flags := uint16(min(len(entry.Name), indexNameMask)) | uint16(entry.Stage<<12)&indexFlagStageMask
+		if entry.SkipWorktree {
+			flags |= indexFlagExtended
+		}
+		binary.Write(buffer, binary.BigEndian, flags)
+		if entry.SkipWorktree {
+			binary.Write(buffer, binary.BigEndian, uint16(indexExtendedFlagSkipWorktree))
+		}
+		buffer.WriteString(entry.Name)
+
+		entryLength := buffer.Len() - start
+		buffer.Write(make([]byte, (entryLength+8)&^7-entryLength))
+	}
+
+	hashWriter := hashFactory.New()
+	hashWriter.Write(buffer.Bytes())
+	buffer.Write(hashWriter.Sum(nil))
+
+	return writeFileAtomically(path.Join(gitDirectory, indexFile), buffer.Bytes())
+}
+
+func (index *Index) Sort() {
+	sort.SliceStable(index.Entries, func(i, j int) bool {
+		if index.Entries[i].Name != index.Entries[j].Name {
+			return index.Entries[i].Name < index.Entries[j].Name
+		}
+		return index.Entries[i].Stage < index.Entries[j].Stage
+	})
+}
+
+func (index *Index) Find(name string) (*IndexEntry, bool) {
+	for i := range index.Entries {
+		if index.Entries[i].Name == name && index.Entries[i].Stage == 0 {
+			return &index.Entries[i], true
+		}
+	}
+
+	return nil, false
+}
@@ -0,0 +1,98 @@
+package plumbing
+
+import (
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// PackWriter streams objects from the object store as an undeltified pack.
+type PackWriter struct {
+	writer     io.Writer
+	hashWriter hash.Hash
+	count      int
+	written    int
+}
+
+func NewPackWriter(w io.Writer, count int) (*PackWriter, error) {
+	hashWriter := hashFactory.New()
+	p := &PackWriter{
+		writer:     io.MultiWriter(w, hashWriter),
+		hashWriter: hashWriter,
+		count:      count,
+	}
+
+	header := make([]byte, 12)
+	copy(header, "PACK")
+	binary.BigEndian.PutUint32(header[4:], 2)
+	binary.BigEndian.PutUint32(header[8:], uint32(count))
+
+	_, err := p.writer.Write(header)
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (p *PackWriter) Add(hash []byte) error {
+	if p.written >= p.count {
+		return fmt.Errorf("pack already contains %d objects", p.count)
+	}
+
+	kind, data, err := ReadObject(hash)
+	if err != nil {
+		return err
+	}
+
+	_, err = p.writer.Write(packEntryHeader(packObjectTypes[kind], uint64(len(data))))
+	if err != nil {
+		return err
+	}
+
+	zlibWriter := zlib.NewWriter(p.writer)
+	_, err = zlibWriter.Write(data)
+	if err != nil {
+		return err
+	}
+
+	err = zlibWriter.Close()
+	if err != nil {
+		return err
+	}
+
+	p.written++
+
+	return nil
+}
+
+// Close writes the pack trailer and returns the pack checksum.
+func (p *PackWriter) Close() ([]byte, error) {
+	if p.written != p.count {
+		return nil, fmt.Errorf("pack announced %d objects but contains %d", p.count, p.written)
+	}
+
+	checksum := p.hashWriter.Sum(nil)
+	_, err := p.writer.Write(checksum)
+	if err != nil {
+		return nil, err
+	}
+
+	return checksum, nil
+}
+
+func packEntryHeader(objectType int, size uint64) []byte {
+	header := make([]byte, 0, 10)
+
+	b := byte(objectType<<4) | byte(size&0x0f)
+	size >>= 4
+	for size > 0 {
+		header = append(header, b|0x80)
+		b = byte(size & 0x7f)
+		size >>= 7
+	}
+
+	return append(header, b)
+}
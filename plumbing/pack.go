@@ -0,0 +1,346 @@
+package plumbing
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	packObjectCommit   = 1
+	packObjectTree     = 2
+	packObjectBlob     = 3
+	packObjectTag      = 4
+	packObjectOfsDelta = 6
+	packObjectRefDelta = 7
+
+	packDirectory = "pack"
+
+	maxDeltaCacheSize = 32 * 1024 * 1024
+	// maxPreallocation bounds the memory reserved for an object before its
+	// data is read, as its size comes from an untrusted pack header.
+	maxPreallocation = 1024 * 1024
+)
+
+var (
+	packIndexMagic = []byte{0xff, 't', 'O', 'c'}
+
+	errInvalidPack = errors.New("invalid pack")
+	// ErrPackTooLarge is returned by ReadPackStream for packs above the
+	// requested maximum size.
+	ErrPackTooLarge = errors.New("pack exceeds maximum size")
+
+	packs []*packFile
+)
+
+var packObjectKinds = map[int]ObjectKind{
+	packObjectCommit: KindCommit,
+	packObjectTree:   KindTree,
+	packObjectBlob:   KindBlob,
+	packObjectTag:    KindTag,
+}
+
+var packObjectTypes = map[ObjectKind]int{
+	KindCommit: packObjectCommit,
+	KindTree:   packObjectTree,
+	KindBlob:   packObjectBlob,
+	KindTag:    packObjectTag,
+}
+
+type cachedObject struct {
+	kind ObjectKind
+	data []byte
+}
+
+type packFile struct {
+	path    string
+	hashes  []byte
+	offsets []uint64
+	fanout  [256]uint32
+	file    *os.File
+
+	cache     map[uint64]cachedObject
+	cacheSize int
+}
+
+func resetPacks() {
+	for _, pack := range packs {
+		if pack.file != nil {
+			pack.file.Close()
+		}
+	}
+	packs = nil
+}
+
+func loadPacks() []*packFile {
+	if packs != nil {
+		return packs
+	}
+
+	packs = make([]*packFile, 0)
+	indexFiles, err := filepath.Glob(path.Join(commonDirectory, objectsDirectory, packDirectory, "pack-*.idx"))
+	if err != nil {
+		return packs
+	}
+
+	for _, indexFile := range indexFiles {
+		pack, err := loadPackIndex(indexFile)
+		if err != nil {
+			continue
+		}
+		packs = append(packs, pack)
+	}
+
+	return packs
+}
+
+func loadPackIndex(indexFile string) (*packFile, error) {
+	content, err := os.ReadFile(indexFile)
+	if err != nil {
+		return nil, err
+	}
+
+	hashSize := hashFactory.Size()
+	if len(content) < 8+256*4+2*hashSize || !bytes.Equal(content[:4], packIndexMagic) {
+		return nil, fmt.Errorf("%s: unsupported pack index", indexFile)
+	}
+	if version := binary.BigEndian.Uint32(content[4:8]); version != 2 {
+		return nil, fmt.Errorf("%s: unsupported pack index version %d", indexFile, version)
+	}
+
+	pack := &packFile{
+		path:  strings.TrimSuffix(indexFile, ".idx") + ".pack",
+		cache: make(map[uint64]cachedObject),
+	}
+
+	position := 8
+	for i := range pack.fanout {
+		pack.fanout[i] = binary.BigEndian.Uint32(content[position:])
+		position += 4
+	}
+
+	count := int(pack.fanout[255])
+	if len(content) < position+count*(hashSize+8)+2*hashSize {
+		return nil, fmt.Errorf("%s: truncated pack index", indexFile)
+	}
+
+	pack.hashes = content[position : position+count*hashSize]
+	position += count * hashSize
+	position += count * 4 // skip CRC32 values
+
+	offsetTable := content[position : position+count*4]
+	largeOffsetTable := content[position+count*4:]
+
+	pack.offsets = make([]uint64, count)
+	for i := 0; i < count; i++ {
+		offset := binary.BigEndian.Uint32(offsetTable[i*4:])
+		if offset&0x80000000 == 0 {
+			pack.offsets[i] = uint64(offset)
+			continue
+		}
+
+		largeIndex := int(offset&0x7fffffff) * 8
+		if largeIndex+8 > len(largeOffsetTable) {
+			return nil, fmt.Errorf("%s: invalid large offset", indexFile)
+		}
+		pack.offsets[i] = binary.BigEndian.Uint64(largeOffsetTable[largeIndex:])
+	}
+
+	return pack, nil
+}
+
+func (p *packFile) hashAt(i int) []byte {
+	hashSize := hashFactory.Size()
+	return p.hashes[i*hashSize : (i+1)*hashSize]
+}
+
+func (p *packFile) find(hash []byte) (uint64, bool) {
+	start := 0
+	if hash[0] > 0 {
+		start = int(p.fanout[hash[0]-1])
+	}
+	end := int(p.fanout[hash[0]])
+
+	i := start + sort.Search(end-start, func(i int) bool {
+		return bytes.Compare(p.hashAt(start+i), hash) >= 0
+	})
+	if i < end && bytes.Equal(p.hashAt(i), hash) {
+		return p.offsets[i], true
+	}
+
+	return 0, false
+}
+
+func (p *packFile) findPrefix(prefix string) [][]byte {
+	first, _ := hex.DecodeString(prefix[:2])
+	start := 0
+	if first[0] > 0 {
+		start = int(p.fanout[first[0]-1])
+	}
+	end := int(p.fanout[first[0]])
+
+	hashes := make([][]byte, 0)
+	for i := start; i < end; i++ {
+		if strings.HasPrefix(hex.EncodeToString(p.hashAt(i)), prefix) {
+			hashes = append(hashes, p.hashAt(i))
+		}
+	}
+
+	return hashes
+}
+
+func (p *packFile) open() error {
+	if p.file != nil {
+		return nil
+	}
+
+	file, err := os.Open(p.path)
+	if err != nil {
+		return err
+	}
+	p.file = file
+
+	return nil
+}
+
+func (p *packFile) readObject(offset uint64) (ObjectKind, []byte, error) {
+	if cached, ok := p.cache[offset]; ok {
+		return cached.kind, cached.data, nil
+	}
+
+	err := p.open()
+	if err != nil {
+		return "", nil, err
+	}
+
+	reader := bufio.NewReader(io.NewSectionReader(p.file, int64(offset), 1<<62))
+	objectType, size, err := readPackEntryHeader(reader)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %w", p.path, err)
+	}
+
+	var baseKind ObjectKind
+	var base []byte
+	switch objectType {
+	case packObjectOfsDelta:
+		distance, err := readOffsetDelta(reader)
+		if err != nil || distance == 0 || distance > offset {
+			return "", nil, fmt.Errorf("%s: %w", p.path, errInvalidPack)
+		}
+		baseKind, base, err = p.readObject(offset - distance)
+		if err != nil {
+			return "", nil, err
+		}
+	case packObjectRefDelta:
+		baseHash := make([]byte, hashFactory.Size())
+		_, err = io.ReadFull(reader, baseHash)
+		if err != nil {
+			return "", nil, fmt.Errorf("%s: %w", p.path, err)
+		}
+		baseKind, base, err = ReadObject(baseHash)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	data, err := inflate(reader, size)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %w", p.path, err)
+	}
+
+	var kind ObjectKind
+	if base != nil {
+		kind = baseKind
+		data, err = applyDelta(base, data)
+		if err != nil {
+			return "", nil, fmt.Errorf("%s: %w", p.path, err)
+		}
+	} else {
+		var ok bool
+		kind, ok = packObjectKinds[objectType]
+		if !ok {
+			return "", nil, fmt.Errorf("%s: %w", p.path, errInvalidPack)
+		}
+	}
+
+	if p.cacheSize+len(data) > maxDeltaCacheSize {
+		p.cache = make(map[uint64]cachedObject)
+		p.cacheSize = 0
+	}
+	p.cache[offset] = cachedObject{kind, data}
+	p.cacheSize += len(data)
+
+	return kind, data, nil
+}
+
+func readPackEntryHeader(reader io.ByteReader) (int, uint64, error) {
+	b, err := reader.ReadByte()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	objectType := int(b>>4) & 0x7
+	size := uint64(b & 0x0f)
+	shift := uint(4)
+	for b&0x80 != 0 {
+		b, err = reader.ReadByte()
+		if err != nil {
+			return 0, 0, err
+		}
+		size |= uint64(b&0x7f) << shift
+		shift += 7
+	}
+
+	return objectType, size, nil
+}
+
+func readOffsetDelta(reader io.ByteReader) (uint64, error) {
+	b, err := reader.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+
+	offset := uint64(b & 0x7f)
+	for b&0x80 != 0 {
+		b, err = reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		offset = ((offset + 1) << 7) | uint64(b&0x7f)
+	}
+
+	return offset, nil
+}
+
+// inflate decompresses an object that the pack header says has size bytes.
+// Memory is allocated as the data arrives, so a bogus size cannot exhaust
+// it.
+func inflate(reader io.Reader, size uint64) ([]byte, error) {
+	zlibReader, err := zlib.NewReader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errInvalidPack, err)
+	}
+	defer zlibReader.Close()
+
+	buffer := bytes.NewBuffer(make([]byte, 0, min(size, maxPreallocation)))
+	n, err := io.Copy(buffer, io.LimitReader(zlibReader, int64(min(size, math.MaxInt64))))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errInvalidPack, err)
+	}
+	if uint64(n) != size {
+		return nil, fmt.Errorf("%w: object is shorter than its size", errInvalidPack)
+	}
+
+	return buffer.Bytes(), nil
+}
@@ -0,0 +1,138 @@
+package plumbing
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path"
+	"testing"
+)
+
+type testPackEntry struct {
+	objectType int
+	// size is written to the entry header. It defaults to the length of
+	// data.
+	size       uint64
+	baseOffset uint64
+	baseHash   []byte
+	data       []byte
+}
+
+// buildTestPack builds a pack by hand, so that entries may be deltas or
+// lie about their size.
+func buildTestPack(t *testing.T, entries ...testPackEntry) []byte {
+	t.Helper()
+
+	var pack bytes.Buffer
+	pack.WriteString("PACK")
+	binary.Write(&pack, binary.BigEndian, uint32(2))
+	binary.Write(&pack, binary.BigEndian, uint32(len(entries)))
+
+	for _, entry := range entries {
+		offset := uint64(pack.Len())
+		size := entry.size
+		if size == 0 {
+			size = uint64(len(entry.data))
+		}
+		pack.Write(packEntryHeader(entry.objectType, size))
+
+		switch entry.objectType {
+		case packObjectOfsDelta:
+			pack.Write(encodeOffsetDelta(offset - entry.baseOffset))
+		case packObjectRefDelta:
+			pack.Write(entry.baseHash)
+		}
+
+		zlibWriter := zlib.NewWriter(&pack)
+		zlibWriter.Write(entry.data)
+		err := zlibWriter.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hashWriter := hashFactory.New()
+	hashWriter.Write(pack.Bytes())
+	pack.Write(hashWriter.Sum(nil))
+
+	return pack.Bytes()
+}
+
+func encodeOffsetDelta(distance uint64) []byte {
+	encoded := []byte{byte(distance & 0x7f)}
+	for distance >>= 7; distance > 0; distance >>= 7 {
+		distance--
+		encoded = append([]byte{byte(distance&0x7f) | 0x80}, encoded...)
+	}
+	return encoded
+}
+
+func TestIndexPackRejectsWrongSizes(t *testing.T) {
+	tests := []struct {
+		name string
+		size uint64
+	}{
+		{name: "larger", size: 1 << 40},
+		{name: "smaller", size: 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pack := buildTestPack(t, testPackEntry{objectType: packObjectBlob, size: test.size, data: []byte("hello")})
+
+			_, err := IndexPack(bytes.NewReader(pack))
+			if !errors.Is(err, errInvalidPack) {
+				t.Errorf("IndexPack() = %v, want errInvalidPack", err)
+			}
+		})
+	}
+}
+
+func TestInflateRejectsWrongSizes(t *testing.T) {
+	var compressed bytes.Buffer
+	zlibWriter := zlib.NewWriter(&compressed)
+	zlibWriter.Write([]byte("hello"))
+	zlibWriter.Close()
+
+	data, err := inflate(bytes.NewReader(compressed.Bytes()), 5)
+	if err != nil || string(data) != "hello" {
+		t.Errorf("inflate() = %q, %v", data, err)
+	}
+
+	for _, size := range []uint64{6, 1 << 40, 1<<64 - 1} {
+		_, err := inflate(bytes.NewReader(compressed.Bytes()), size)
+		if !errors.Is(err, errInvalidPack) {
+			t.Errorf("inflate(size %d) = %v, want errInvalidPack", size, err)
+		}
+	}
+}
+
+func TestPacksRejectSelfReferencingDeltas(t *testing.T) {
+	// An offset delta at offset 12 whose base is itself.
+	delta := append(deltaHeader(5, 5), 0x90, 5)
+	pack := buildTestPack(t, testPackEntry{objectType: packObjectOfsDelta, baseOffset: 12, data: delta})
+
+	_, err := IndexPack(bytes.NewReader(pack))
+	if !errors.Is(err, errInvalidPack) {
+		t.Errorf("IndexPack() = %v, want errInvalidPack", err)
+	}
+
+	packPath := path.Join(t.TempDir(), "pack-test.pack")
+	err = os.WriteFile(packPath, pack, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packFile := &packFile{path: packPath, cache: make(map[uint64]cachedObject)}
+	t.Cleanup(func() {
+		if packFile.file != nil {
+			packFile.file.Close()
+		}
+	})
+
+	_, _, err = packFile.readObject(12)
+	if !errors.Is(err, errInvalidPack) {
+		t.Errorf("readObject() = %v, want errInvalidPack", err)
+	}
+}
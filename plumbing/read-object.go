@@ -0,0 +1,227 @@
+package plumbing
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+type ObjectKind string
+
+const (
+	KindCommit ObjectKind = "commit"
+	KindTree   ObjectKind = "tree"
+	KindBlob   ObjectKind = "blob"
+	KindTag    ObjectKind = "tag"
+)
+
+var (
+	ErrObjectNotFound = errors.New("object not found")
+
+	missingObjectHandler func(hashes [][]byte) error
+	fetchingMissing      bool
+)
+
+// SetMissingObjectHandler registers a function that is asked to provide
+// objects which are not in the object store, for example by fetching
+// them from a promisor remote.
+func SetMissingObjectHandler(handler func(hashes [][]byte) error) {
+	missingObjectHandler = handler
+}
+
+// FetchMissingObjects asks the missing object handler for all objects of
+// hashes that are not in the object store.
+func FetchMissingObjects(hashes [][]byte) error {
+	missing := make([][]byte, 0)
+	for _, hash := range hashes {
+		if !HasObject(hash) {
+			missing = append(missing, hash)
+		}
+	}
+
+	if len(missing) == 0 || missingObjectHandler == nil || fetchingMissing {
+		return nil
+	}
+
+	fetchingMissing = true
+	defer func() { fetchingMissing = false }()
+
+	return missingObjectHandler(missing)
+}
+
+type rawObject struct {
+	kind ObjectKind
+	data []byte
+}
+
+func NewRawObject(kind ObjectKind, data []byte) Object {
+	return &rawObject{kind: kind, data: data}
+}
+
+func (o *rawObject) WriteTo(w io.Writer) (n int64, err error) {
+	m, err := fmt.Fprintf(w, "%s %d\000", o.kind, len(o.data))
+	n += int64(m)
+	if err != nil {
+		return n, err
+	}
+
+	m, err = w.Write(o.data)
+	n += int64(m)
+	if err != nil {
+		return n, err
+	}
+
+	return n, nil
+}
+
+func looseObjectPath(hash []byte) string {
+	hexa := hex.EncodeToString(hash)
+	return path.Join(commonDirectory, objectsDirectory, hexa[:2], hexa[2:])
+}
+
+func HasObject(hash []byte) bool {
+	if len(hash) != hashFactory.Size() {
+		return false
+	}
+
+	if _, err := os.Stat(looseObjectPath(hash)); err == nil {
+		return true
+	}
+
+	for _, pack := range loadPacks() {
+		if _, ok := pack.find(hash); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func ReadObject(hash []byte) (ObjectKind, []byte, error) {
+	if len(hash) != hashFactory.Size() {
+		return "", nil, fmt.Errorf("invalid object id %x", hash)
+	}
+
+	kind, data, err := readLooseObject(hash)
+	if err == nil {
+		return kind, data, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return "", nil, err
+	}
+
+	for _, pack := range loadPacks() {
+		if offset, ok := pack.find(hash); ok {
+			return pack.readObject(offset)
+		}
+	}
+
+	if missingObjectHandler != nil && !fetchingMissing {
+		err = FetchMissingObjects([][]byte{hash})
+		if err != nil {
+			return "", nil, fmt.Errorf("cannot fetch missing object %x: %w", hash, err)
+		}
+		if HasObject(hash) {
+			return ReadObject(hash)
+		}
+	}
+
+	return "", nil, fmt.Errorf("%w: %x", ErrObjectNotFound, hash)
+}
+
+func ReadObjectOfKind(hash []byte, kind ObjectKind) ([]byte, error) {
+	actualKind, data, err := ReadObject(hash)
+	if err != nil {
+		return nil, err
+	}
+	if actualKind != kind {
+		return nil, fmt.Errorf("object %x is a %s, not a %s", hash, actualKind, kind)
+	}
+
+	return data, nil
+}
+
+func readLooseObject(hash []byte) (ObjectKind, []byte, error) {
+	file, err := os.Open(looseObjectPath(hash))
+	if err != nil {
+		return "", nil, err
+	}
+	defer file.Close()
+
+	zlibReader, err := zlib.NewReader(file)
+	if err != nil {
+		return "", nil, fmt.Errorf("cannot read object %x: %w", hash, err)
+	}
+	defer zlibReader.Close()
+
+	content, err := io.ReadAll(zlibReader)
+	if err != nil {
+		return "", nil, fmt.Errorf("cannot read object %x: %w", hash, err)
+	}
+
+	header, data, ok := bytes.Cut(content, []byte{0})
+	if !ok {
+		return "", nil, fmt.Errorf("object %x has no header", hash)
+	}
+
+	kind, size, ok := bytes.Cut(header, []byte{' '})
+	if !ok {
+		return "", nil, fmt.Errorf("object %x has a malformed header", hash)
+	}
+
+	expectedSize, err := strconv.Atoi(string(size))
+	if err != nil || expectedSize != len(data) {
+		return "", nil, fmt.Errorf("object %x has a wrong size", hash)
+	}
+
+	return ObjectKind(kind), data, nil
+}
+
+// FindObjects returns the ids of all objects whose hexadecimal id starts
+// with prefix.
+func FindObjects(prefix string) ([][]byte, error) {
+	prefix = strings.ToLower(prefix)
+	if len(prefix) < 4 || len(prefix) > hashFactory.Size()*2 {
+		return nil, fmt.Errorf("invalid object id prefix %q", prefix)
+	}
+	if _, err := hex.DecodeString(prefix[:len(prefix)&^1]); err != nil {
+		return nil, fmt.Errorf("invalid object id prefix %q", prefix)
+	}
+
+	found := make(map[string][]byte)
+
+	entries, err := os.ReadDir(path.Join(commonDirectory, objectsDirectory, prefix[:2]))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, entry := range entries {
+		name := prefix[:2] + entry.Name()
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		hash, err := hex.DecodeString(name)
+		if err == nil && len(hash) == hashFactory.Size() {
+			found[name] = hash
+		}
+	}
+
+	for _, pack := range loadPacks() {
+		for _, hash := range pack.findPrefix(prefix) {
+			found[hex.EncodeToString(hash)] = hash
+		}
+	}
+
+	hashes := make([][]byte, 0, len(found))
+	for _, hash := range found {
+		hashes = append(hashes, hash)
+	}
+
+	return hashes, nil
+}
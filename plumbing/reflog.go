@@ -0,0 +1,119 @@
+package plumbing
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const logsDirectory = "logs"
+
+// ReflogEntry records one update of a reference.
+type ReflogEntry struct {
+	Old       []byte
+	New       []byte
+	Committer AuthorData
+	Message   string
+}
+
+func (e ReflogEntry) String() string {
+	return fmt.Sprintf("%s %s %s\t%s\n", reflogHash(e.Old), reflogHash(e.New), e.Committer, e.Message)
+}
+
+// reflogHash formats a hash for the reflog, where a missing hash is written
+// as zeros.
+func reflogHash(hash []byte) string {
+	if len(hash) == 0 {
+		return strings.Repeat("0", hashFactory.Size()*2)
+	}
+	return hex.EncodeToString(hash)
+}
+
+func reflogPath(name string) string {
+	return path.Join(refDirectory(name), logsDirectory, filepath.FromSlash(name))
+}
+
+// ReadReflog returns the log of a reference, oldest entry first.
+func ReadReflog(name string) ([]ReflogEntry, error) {
+	file, err := os.Open(reflogPath(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return []ReflogEntry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entries := make([]ReflogEntry, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		header, message, _ := strings.Cut(scanner.Text(), "\t")
+
+		fields := strings.SplitN(header, " ", 3)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("reflog of %s is malformed", name)
+		}
+
+		old, err := hex.DecodeString(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("reflog of %s is malformed", name)
+		}
+
+		hash, err := hex.DecodeString(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("reflog of %s is malformed", name)
+		}
+
+		committer, err := ParseAuthorData(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("reflog of %s is malformed: %w", name, err)
+		}
+
+		entries = append(entries, ReflogEntry{Old: old, New: hash, Committer: committer, Message: message})
+	}
+
+	return entries, scanner.Err()
+}
+
+// AppendReflog adds an entry to the log of a reference.
+func AppendReflog(name string, entry ReflogEntry) error {
+	filename := reflogPath(name)
+	err := os.MkdirAll(path.Dir(filename), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.WriteString(entry.String())
+	return err
+}
+
+// WriteReflog replaces the log of a reference. An empty log is removed.
+func WriteReflog(name string, entries []ReflogEntry) error {
+	filename := reflogPath(name)
+
+	if len(entries) == 0 {
+		err := os.Remove(filename)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	var builder strings.Builder
+	for _, entry := range entries {
+		builder.WriteString(entry.String())
+	}
+
+	return writeFileAtomically(filename, []byte(builder.String()))
+}
@@ -0,0 +1,350 @@
+package plumbing
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	HEAD = "HEAD"
+
+	symbolicRefPrefix = "ref: "
+	packedRefsFile    = "packed-refs"
+	maxSymbolicDepth  = 5
+)
+
+var (
+	ErrRefNotFound    = errors.New("reference not found")
+	ErrInvalidRefName = errors.New("invalid reference name")
+)
+
+type Ref struct {
+	Name string
+	Hash []byte
+}
+
+// perWorktreeRefPrefixes are the references that every worktree has on
+// its own, in addition to HEAD and the other pseudo references outside of
+// refs/.
+var perWorktreeRefPrefixes = []string{"refs/bisect/", "refs/worktree/", "refs/rewritten/"}
+
+func isPerWorktreeRef(name string) bool {
+	if !strings.HasPrefix(name, "refs/") {
+		return true
+	}
+
+	for _, prefix := range perWorktreeRefPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// refDirectory returns the directory that stores a reference.
+func refDirectory(name string) string {
+	if isPerWorktreeRef(name) {
+		return gitDirectory
+	}
+	return commonDirectory
+}
+
+// CheckRefFormat checks a reference name against the rules of git
+// check-ref-format, which among others keep it from leaving the refs
+// directory. Names outside of refs/ must be pseudo references like HEAD or
+// FETCH_HEAD.
+func CheckRefFormat(name string) error {
+	if !isValidRefName(name) {
+		return fmt.Errorf("%w: %s", ErrInvalidRefName, name)
+	}
+	return nil
+}
+
+func isValidRefName(name string) bool {
+	if name == "" || name == "@" || strings.Contains(name, "..") || strings.Contains(name, "@{") || strings.HasSuffix(name, ".") {
+		return false
+	}
+
+	if !strings.HasPrefix(name, "refs/") {
+		for i := 0; i < len(name); i++ {
+			if !('A' <= name[i] && name[i] <= 'Z' || name[i] == '_' || name[i] == '-') {
+				return false
+			}
+		}
+		return true
+	}
+
+	for i := 0; i < len(name); i++ {
+		if name[i] < ' ' || name[i] == 0x7f || strings.IndexByte(" ~^:?*[\\", name[i]) >= 0 {
+			return false
+		}
+	}
+
+	for _, component := range strings.Split(name, "/") {
+		if component == "" || strings.HasPrefix(component, ".") || strings.HasSuffix(component, ".lock") {
+			return false
+		}
+	}
+
+	return true
+}
+
+func refPath(name string) string {
+	return path.Join(refDirectory(name), filepath.FromSlash(name))
+}
+
+// ReadSymbolicRef returns the target of a symbolic reference such as HEAD.
+func ReadSymbolicRef(name string) (string, bool, error) {
+	content, err := os.ReadFile(refPath(name))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+
+	value := strings.TrimSpace(string(content))
+	if !strings.HasPrefix(value, symbolicRefPrefix) {
+		return "", false, nil
+	}
+
+	return strings.TrimPrefix(value, symbolicRefPrefix), true, nil
+}
+
+func ResolveRef(name string) ([]byte, error) {
+	for i := 0; i < maxSymbolicDepth; i++ {
+		content, err := os.ReadFile(refPath(name))
+		if errors.Is(err, os.ErrNotExist) || errors.Is(err, fs.ErrInvalid) {
+			return resolvePackedRef(name)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		value := strings.TrimSpace(string(content))
+		if strings.HasPrefix(value, symbolicRefPrefix) {
+			name = strings.TrimPrefix(value, symbolicRefPrefix)
+			continue
+		}
+
+		hash, err := hex.DecodeString(value)
+		if err != nil || len(hash) != hashFactory.Size() {
+			return nil, fmt.Errorf("reference %s is malformed", name)
+		}
+
+		return hash, nil
+	}
+
+	return nil, fmt.Errorf("reference %s is nested too deeply", name)
+}
+
+func resolvePackedRef(name string) ([]byte, error) {
+	refs, err := readPackedRefs()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ref := range refs {
+		if ref.Name == name {
+			return ref.Hash, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrRefNotFound, name)
+}
+
+func readPackedRefs() ([]Ref, error) {
+	file, err := os.Open(path.Join(commonDirectory, packedRefsFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	refs := make([]Ref, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") || strings.HasPrefix(line, "^") {
+			continue
+		}
+
+		value, name, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+
+		hash, err := hex.DecodeString(value)
+		if err != nil {
+			continue
+		}
+
+		refs = append(refs, Ref{Name: name, Hash: hash})
+	}
+
+	return refs, scanner.Err()
+}
+
+func WriteRef(name string, hash []byte) error {
+	err := CheckRefFormat(name)
+	if err != nil {
+		return err
+	}
+
+	filename := refPath(name)
+	err = os.MkdirAll(path.Dir(filename), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomically(filename, []byte(hex.EncodeToString(hash)+"\n"))
+}
+
+func WriteSymbolicRef(name string, target string) error {
+	err := CheckRefFormat(name)
+	if err != nil {
+		return err
+	}
+	err = CheckRefFormat(target)
+	if err != nil {
+		return err
+	}
+
+	filename := refPath(name)
+	err = os.MkdirAll(path.Dir(filename), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomically(filename, []byte(symbolicRefPrefix+target+"\n"))
+}
+
+// UpdateRef writes a reference through the symbolic reference chain, so
+// updating HEAD moves the branch it points to.
+func UpdateRef(name string, hash []byte) error {
+	for i := 0; i < maxSymbolicDepth; i++ {
+		target, ok, err := ReadSymbolicRef(name)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return WriteRef(name, hash)
+		}
+		name = target
+	}
+
+	return fmt.Errorf("reference %s is nested too deeply", name)
+}
+
+func ListRefs(prefix string) ([]Ref, error) {
+	refsByName := make(map[string][]byte)
+
+	packedRefs, err := readPackedRefs()
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range packedRefs {
+		if strings.HasPrefix(ref.Name, prefix) {
+			refsByName[ref.Name] = ref.Hash
+		}
+	}
+
+	roots := []string{commonDirectory}
+	if gitDirectory != commonDirectory {
+		roots = append(roots, gitDirectory)
+	}
+
+	for _, root := range roots {
+		err = filepath.WalkDir(path.Join(root, "refs"), func(filename string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, os.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			if entry.IsDir() {
+				return nil
+			}
+
+			relative, err := filepath.Rel(root, filename)
+			if err != nil {
+				return err
+			}
+
+			name := filepath.ToSlash(relative)
+			if !strings.HasPrefix(name, prefix) || refDirectory(name) != root {
+				return nil
+			}
+
+			hash, err := ResolveRef(name)
+			if err != nil {
+				return nil
+			}
+			refsByName[name] = hash
+
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	refs := make([]Ref, 0, len(refsByName))
+	for name, hash := range refsByName {
+		refs = append(refs, Ref{Name: name, Hash: hash})
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].Name < refs[j].Name
+	})
+
+	return refs, nil
+}
+
+func DeleteRef(name string) error {
+	err := CheckRefFormat(name)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(refPath(name))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	packedRefs, err := readPackedRefs()
+	if err != nil {
+		return err
+	}
+
+	found := false
+	remaining := make([]Ref, 0, len(packedRefs))
+	for _, ref := range packedRefs {
+		if ref.Name == name {
+			found = true
+			continue
+		}
+		remaining = append(remaining, ref)
+	}
+	if !found {
+		return nil
+	}
+
+	var builder strings.Builder
+	builder.WriteString("# pack-refs with: sorted\n")
+	for _, ref := range remaining {
+		builder.WriteString(fmt.Sprintf("%x %s\n", ref.Hash, ref.Name))
+	}
+
+	return writeFileAtomically(path.Join(commonDirectory, packedRefsFile), []byte(builder.String()))
+}
@@ -0,0 +1,58 @@
+package plumbing
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckRefFormat(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"HEAD", true},
+		{"FETCH_HEAD", true},
+		{"refs/heads/main", true},
+		{"refs/heads/feature/x", true},
+		{"refs/tags/v1.0", true},
+		{"refs/heads/@", true},
+		{"refs/heads/a@b", true},
+		{"refs/heads/ümlaut", true},
+		{"", false},
+		{"@", false},
+		{"head", false},
+		{"../config", false},
+		{"refs/", false},
+		{"refs/tags/../../../fetched-escape", false},
+		{"refs/heads/..", false},
+		{"refs/heads/a..b", false},
+		{"refs/heads/.hidden", false},
+		{"refs/heads/a.lock", false},
+		{"refs/heads/a.lock/b", false},
+		{"refs/heads/a.", false},
+		{"refs/heads/a b", false},
+		{"refs/heads/a~1", false},
+		{"refs/heads/a^", false},
+		{"refs/heads/a:b", false},
+		{"refs/heads/a?", false},
+		{"refs/heads/a*", false},
+		{"refs/heads/a[", false},
+		{"refs/heads/a\\b", false},
+		{"refs/heads/a\x01", false},
+		{"refs/heads/a\x7f", false},
+		{"refs/heads/a@{1}", false},
+		{"refs/heads//a", false},
+		{"refs/heads/a/", false},
+		{"/refs/heads/a", false},
+	}
+
+	for _, test := range tests {
+		err := CheckRefFormat(test.name)
+		if test.valid && err != nil {
+			t.Errorf("CheckRefFormat(%q) = %s, want valid", test.name, err)
+		}
+		if !test.valid && !errors.Is(err, ErrInvalidRefName) {
+			t.Errorf("CheckRefFormat(%q) = %v, want ErrInvalidRefName", test.name, err)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package plumbing
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+const shallowFile = "shallow"
+
+var shallowCommits map[string]bool
+
+// ReadShallow lists the commits whose parents are missing on purpose
+// because the repository was cloned or fetched with a limited depth.
+func ReadShallow() ([][]byte, error) {
+	content, err := os.ReadFile(path.Join(commonDirectory, shallowFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([][]byte, 0)
+	for _, line := range strings.Fields(string(content)) {
+		hash, err := hex.DecodeString(line)
+		if err != nil {
+			continue
+		}
+		hashes = append(hashes, hash)
+	}
+
+	return hashes, nil
+}
+
+func WriteShallow(hashes [][]byte) error {
+	shallowCommits = nil
+	filename := path.Join(commonDirectory, shallowFile)
+
+	if len(hashes) == 0 {
+		err := os.Remove(filename)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	sort.Slice(hashes, func(i, j int) bool {
+		return bytes.Compare(hashes[i], hashes[j]) < 0
+	})
+
+	var builder strings.Builder
+	for _, hash := range hashes {
+		builder.WriteString(hex.EncodeToString(hash) + "\n")
+	}
+
+	return writeFileAtomically(filename, []byte(builder.String()))
+}
+
+func IsShallow(hash []byte) bool {
+	if shallowCommits == nil {
+		shallowCommits = make(map[string]bool)
+		hashes, _ := ReadShallow()
+		for _, shallow := range hashes {
+			shallowCommits[string(shallow)] = true
+		}
+	}
+
+	return shallowCommits[string(hash)]
+}
@@ -0,0 +1,54 @@
+package plumbing
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestShallowRoundTrip(t *testing.T) {
+	useTestDirectory(t)
+
+	first := bytes.Repeat([]byte{0x22}, 20)
+	second := bytes.Repeat([]byte{0x11}, 20)
+	other := bytes.Repeat([]byte{0x33}, 20)
+
+	err := WriteShallow([][]byte{first, second})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(path.Join(commonDirectory, shallowFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "1111111111111111111111111111111111111111\n2222222222222222222222222222222222222222\n"
+	if string(content) != want {
+		t.Errorf("shallow = %q, want the sorted commits %q", content, want)
+	}
+
+	hashes, err := ReadShallow()
+	if err != nil || len(hashes) != 2 || !bytes.Equal(hashes[0], second) || !bytes.Equal(hashes[1], first) {
+		t.Errorf("ReadShallow() = %x, %v", hashes, err)
+	}
+	if !IsShallow(first) || !IsShallow(second) || IsShallow(other) {
+		t.Errorf("IsShallow() does not match the shallow commits")
+	}
+
+	// Deepening a clone to its full history removes the file.
+	err = WriteShallow(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path.Join(commonDirectory, shallowFile)); !os.IsNotExist(err) {
+		t.Errorf("shallow still exists: %v", err)
+	}
+	if IsShallow(first) {
+		t.Errorf("IsShallow() remembers a removed shallow commit")
+	}
+	hashes, err = ReadShallow()
+	if err != nil || len(hashes) != 0 {
+		t.Errorf("ReadShallow() = %x, %v, want none", hashes, err)
+	}
+}
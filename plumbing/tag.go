@@ -0,0 +1,137 @@
+package plumbing
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type Tag struct {
+	Object  []byte
+	Type    ObjectKind
+	Name    string
+	Tagger  AuthorData
+	Message string
+	// Signature is the armored signature of the tag's payload, which follows
+	// the message.
+	Signature string
+}
+
+// signatureStarts are the first lines of the armored signatures that can
+// follow the message of a tag.
+var signatureStarts = []string{
+	"-----BEGIN PGP SIGNATURE-----",
+	"-----BEGIN PGP MESSAGE-----",
+	"-----BEGIN SIGNED MESSAGE-----",
+	"-----BEGIN SSH SIGNATURE-----",
+}
+
+// Payload returns the content of the tag without its signature, which is
+// what gets signed.
+func (t *Tag) Payload() []byte {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "object %x\n", t.Object)
+	fmt.Fprintf(&builder, "type %s\n", t.Type)
+	fmt.Fprintf(&builder, "tag %s\n", t.Name)
+	fmt.Fprintf(&builder, "tagger %s\n", t.Tagger)
+	fmt.Fprintf(&builder, "\n%s", t.Message)
+
+	return []byte(builder.String())
+}
+
+func (t *Tag) WriteTo(w io.Writer) (int64, error) {
+	data := string(t.Payload()) + t.Signature
+
+	m, err := fmt.Fprintf(w, "tag %d\000%s", len(data), data)
+	if err != nil {
+		return int64(m), err
+	}
+
+	return int64(m), err
+}
+
+func ParseTag(data []byte) (*Tag, error) {
+	payload, signature := SplitTagSignature(data)
+	header, message, _ := bytes.Cut(payload, []byte("\n\n"))
+
+	tag := &Tag{
+		Message:   string(message),
+		Signature: string(signature),
+	}
+
+	for _, line := range strings.Split(string(header), "\n") {
+		key, value, _ := strings.Cut(line, " ")
+
+		var err error
+		switch key {
+		case "object":
+			tag.Object, err = hex.DecodeString(value)
+		case "type":
+			tag.Type = ObjectKind(value)
+		case "tag":
+			tag.Name = value
+		case "tagger":
+			tag.Tagger, err = ParseAuthorData(value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("malformed tag: %w", err)
+		}
+	}
+
+	if tag.Object == nil {
+		return nil, fmt.Errorf("malformed tag: missing object")
+	}
+
+	return tag, nil
+}
+
+// SplitTagSignature separates the signature at the end of the raw content of
+// a tag from the signed payload before it.
+func SplitTagSignature(data []byte) ([]byte, []byte) {
+	start := len(data)
+	for offset := 0; offset < len(data); {
+		line := data[offset:]
+		if end := bytes.IndexByte(line, '\n'); end >= 0 {
+			line = line[:end+1]
+		}
+
+		for _, signatureStart := range signatureStarts {
+			if bytes.HasPrefix(line, []byte(signatureStart)) {
+				start = offset
+			}
+		}
+		offset += len(line)
+	}
+
+	return data[:start], data[start:]
+}
+
+func ReadTag(hash []byte) (*Tag, error) {
+	data, err := ReadObjectOfKind(hash, KindTag)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseTag(data)
+}
+
+// Peel follows annotated tags until it reaches an object that is not a tag.
+func Peel(hash []byte) ([]byte, ObjectKind, error) {
+	for {
+		kind, data, err := ReadObject(hash)
+		if err != nil {
+			return nil, "", err
+		}
+		if kind != KindTag {
+			return hash, kind, nil
+		}
+
+		tag, err := ParseTag(data)
+		if err != nil {
+			return nil, "", err
+		}
+		hash = tag.Object
+	}
+}
@@ -4,6 +4,9 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 const (
@@ -11,27 +14,42 @@ const (
 	ObjectTypeDirectory    = 0b0100_0000_0000_0000
 	ObjectTypeSymbolicLink = 0b1010_0000_0000_0000
 	ObjectTypeGitLink      = 0b1110_0000_0000_0000
+
+	objectTypeMask = 0b1111_0000_0000_0000
 )
 
 type Tree interface {
 	Object
 	AddObject(mode uint16, name string, hash []byte)
+	Entries() []TreeEntry
+}
+
+type TreeEntry struct {
+	Mode uint16
+	Name string
+	Hash []byte
+}
+
+func (entry *TreeEntry) IsDirectory() bool {
+	return entry.Mode&objectTypeMask == ObjectTypeDirectory
 }
 
-type treeEntry struct {
-	mode uint16
-	name string
-	hash []byte
+func (entry *TreeEntry) IsSymbolicLink() bool {
+	return entry.Mode&objectTypeMask == ObjectTypeSymbolicLink
 }
 
-func (entry *treeEntry) WriteTo(writer io.Writer) (n int64, err error) {
-	m, err := fmt.Fprintf(writer, "%o %s\000", entry.mode, entry.name)
+func (entry *TreeEntry) IsGitLink() bool {
+	return entry.Mode&objectTypeMask == ObjectTypeGitLink
+}
+
+func (entry *TreeEntry) WriteTo(writer io.Writer) (n int64, err error) {
+	m, err := fmt.Fprintf(writer, "%o %s\000", entry.Mode, entry.Name)
 	n += int64(m)
 	if err != nil {
 		return n, err
 	}
 
-	m, err = writer.Write(entry.hash)
+	m, err = writer.Write(entry.Hash)
 	n += int64(m)
 	if err != nil {
 		return n, err
@@ -40,23 +58,96 @@ func (entry *treeEntry) WriteTo(writer io.Writer) (n int64, err error) {
 	return n, nil
 }
 
+// sortKey orders entries the way git does: directories compare as if their
+// name had a trailing slash.
+func (entry *TreeEntry) sortKey() string {
+	if entry.IsDirectory() {
+		return entry.Name + "/"
+	}
+	return entry.Name
+}
+
 type tree struct {
-	entries []treeEntry
+	entries []TreeEntry
 }
 
 func NewTree() Tree {
 	return &tree{
-		entries: make([]treeEntry, 0),
+		entries: make([]TreeEntry, 0),
 	}
 }
 
+func ParseTree(data []byte) (Tree, error) {
+	t := &tree{
+		entries: make([]TreeEntry, 0),
+	}
+
+	hashSize := hashFactory.Size()
+	for len(data) > 0 {
+		header, rest, ok := bytes.Cut(data, []byte{0})
+		if !ok || len(rest) < hashSize {
+			return nil, fmt.Errorf("malformed tree entry")
+		}
+
+		mode, name, ok := bytes.Cut(header, []byte{' '})
+		if !ok {
+			return nil, fmt.Errorf("malformed tree entry")
+		}
+
+		parsedMode, err := strconv.ParseUint(string(mode), 8, 16)
+		if err != nil {
+			return nil, fmt.Errorf("malformed tree entry mode %q", mode)
+		}
+
+		if !isValidTreeEntryName(string(name)) {
+			return nil, fmt.Errorf("invalid tree entry name %q", name)
+		}
+
+		t.entries = append(t.entries, TreeEntry{
+			Mode: uint16(parsedMode),
+			Name: string(name),
+			Hash: rest[:hashSize],
+		})
+		data = rest[hashSize:]
+	}
+
+	return t, nil
+}
+
+// isValidTreeEntryName rejects names that would leave their directory or
+// write into the git directory when checked out.
+func isValidTreeEntryName(name string) bool {
+	if name == "" || name == "." || name == ".." || strings.EqualFold(name, ".git") {
+		return false
+	}
+
+	return !strings.ContainsAny(name, "/\x00")
+}
+
+func ReadTree(hash []byte) (Tree, error) {
+	data, err := ReadObjectOfKind(hash, KindTree)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseTree(data)
+}
+
 func (t *tree) AddObject(mode uint16, name string, hash []byte) {
-	t.entries = append(t.entries, treeEntry{mode, name, hash})
+	t.entries = append(t.entries, TreeEntry{mode, name, hash})
+}
+
+func (t *tree) Entries() []TreeEntry {
+	return t.entries
 }
 
 func (t *tree) WriteTo(writer io.Writer) (n int64, err error) {
 	buffer := bytes.NewBuffer(make([]byte, 0, 1024))
 
+	sort.SliceStable(t.entries, func(i, j int) bool {
+		return t.entries[i].sortKey() < t.entries[j].sortKey()
+	})
+
 	for _, entry := range t.entries {
 		_, err = entry.WriteTo(buffer)
 		if err != nil {
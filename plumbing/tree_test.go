@@ -0,0 +1,30 @@
+package plumbing
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseTreeRejectsUnsafeNames(t *testing.T) {
+	hash := bytes.Repeat([]byte{0xab}, hashFactory.Size())
+
+	for _, name := range []string{"", ".", "..", ".git", ".GIT", ".Git", "a/b", "../escape"} {
+		data := append([]byte("100644 "+name+"\x00"), hash...)
+		_, err := ParseTree(data)
+		if err == nil {
+			t.Errorf("ParseTree accepted entry name %q", name)
+		}
+	}
+
+	for _, name := range []string{"file", ".gitignore", ".git-blame-ignore-revs", "...", "git"} {
+		data := append([]byte("100644 "+name+"\x00"), hash...)
+		tree, err := ParseTree(data)
+		if err != nil {
+			t.Errorf("ParseTree rejected entry name %q: %s", name, err)
+			continue
+		}
+		if entries := tree.Entries(); len(entries) != 1 || entries[0].Name != name {
+			t.Errorf("ParseTree(%q) = %v", name, entries)
+		}
+	}
+}
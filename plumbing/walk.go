@@ -0,0 +1,264 @@
+package plumbing
+
+import (
+	"bytes"
+	"container/heap"
+	"errors"
+	"io"
+)
+
+type walkItem struct {
+	hash   []byte
+	commit *Commit
+}
+
+type walkQueue []walkItem
+
+func (q walkQueue) Len() int { return len(q) }
+func (q walkQueue) Less(i, j int) bool {
+	return q[i].commit.Committer.Timestamp.After(q[j].commit.Committer.Timestamp)
+}
+func (q walkQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *walkQueue) Push(x any)   { *q = append(*q, x.(walkItem)) }
+func (q *walkQueue) Pop() any {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+// CommitWalker iterates over the history reachable from a set of commits,
+// newest commit first, skipping everything reachable from hidden commits.
+type CommitWalker struct {
+	queue  walkQueue
+	seen   map[string]bool
+	hidden map[string]bool
+}
+
+func NewCommitWalker() *CommitWalker {
+	return &CommitWalker{
+		queue:  make(walkQueue, 0),
+		seen:   make(map[string]bool),
+		hidden: make(map[string]bool),
+	}
+}
+
+func (w *CommitWalker) Push(hash []byte) error {
+	if w.seen[string(hash)] {
+		return nil
+	}
+
+	commit, err := ReadCommit(hash)
+	if err != nil {
+		return err
+	}
+
+	w.seen[string(hash)] = true
+	heap.Push(&w.queue, walkItem{hash, commit})
+
+	return nil
+}
+
+// Hide excludes the given commit and all of its ancestors from the walk.
+func (w *CommitWalker) Hide(hash []byte) error {
+	hider := NewCommitWalker()
+	err := hider.Push(hash)
+	if err != nil {
+		return err
+	}
+
+	for {
+		hiddenHash, _, err := hider.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		w.hidden[string(hiddenHash)] = true
+	}
+}
+
+func (w *CommitWalker) Next() ([]byte, *Commit, error) {
+	for w.queue.Len() > 0 {
+		item := heap.Pop(&w.queue).(walkItem)
+
+		// The parents of shallow commits are not part of the repository.
+		parents := item.commit.Parents
+		if IsShallow(item.hash) {
+			parents = nil
+		}
+
+		for _, parent := range parents {
+			if w.hidden[string(parent)] {
+				continue
+			}
+			err := w.Push(parent)
+			if errors.Is(err, ErrObjectNotFound) {
+				continue
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+
+		if w.hidden[string(item.hash)] {
+			continue
+		}
+
+		return item.hash, item.commit, nil
+	}
+
+	return nil, nil, io.EOF
+}
+
+// ReachableObjects lists every object reachable from include that is not
+// reachable from exclude, like `git rev-list --objects include ^exclude`.
+// Excluded objects missing from the local store are ignored.
+func ReachableObjects(include [][]byte, exclude [][]byte) ([][]byte, error) {
+	objects := make([][]byte, 0)
+	seen := make(map[string]bool)
+
+	walker := NewCommitWalker()
+	for _, hash := range exclude {
+		commit, kind, err := Peel(hash)
+		if err != nil || kind != KindCommit {
+			continue
+		}
+
+		err = walker.Hide(commit)
+		if err != nil {
+			return nil, err
+		}
+
+		tip, err := ReadCommit(commit)
+		if err != nil {
+			return nil, err
+		}
+
+		err = markTree(tip.Tree, seen, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, hash := range include {
+		var kind ObjectKind
+		for {
+			var data []byte
+			var err error
+			kind, data, err = ReadObject(hash)
+			if err != nil {
+				return nil, err
+			}
+			if kind != KindTag {
+				break
+			}
+
+			if !seen[string(hash)] {
+				seen[string(hash)] = true
+				objects = append(objects, hash)
+			}
+
+			tag, err := ParseTag(data)
+			if err != nil {
+				return nil, err
+			}
+			hash = tag.Object
+		}
+
+		var err error
+		switch kind {
+		case KindCommit:
+			err = walker.Push(hash)
+		case KindTree:
+			err = markTree(hash, seen, &objects)
+		default:
+			if !seen[string(hash)] {
+				seen[string(hash)] = true
+				objects = append(objects, hash)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for {
+		hash, commit, err := walker.Next()
+		if errors.Is(err, io.EOF) {
+			return objects, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if seen[string(hash)] {
+			continue
+		}
+		seen[string(hash)] = true
+		objects = append(objects, hash)
+
+		err = markTree(commit.Tree, seen, &objects)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// markTree records a tree and everything below it as seen, appending
+// objects that were not seen before to collected if it is not nil.
+func markTree(hash []byte, seen map[string]bool, collected *[][]byte) error {
+	if seen[string(hash)] {
+		return nil
+	}
+	seen[string(hash)] = true
+	if collected != nil {
+		*collected = append(*collected, hash)
+	}
+
+	tree, err := ReadTree(hash)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range tree.Entries() {
+		switch {
+		case entry.IsGitLink():
+			continue
+		case entry.IsDirectory():
+			err = markTree(entry.Hash, seen, collected)
+			if err != nil {
+				return err
+			}
+		case !seen[string(entry.Hash)]:
+			seen[string(entry.Hash)] = true
+			if collected != nil {
+				*collected = append(*collected, entry.Hash)
+			}
+		}
+	}
+
+	return nil
+}
+
+func IsAncestor(ancestor []byte, descendant []byte) (bool, error) {
+	walker := NewCommitWalker()
+	err := walker.Push(descendant)
+	if err != nil {
+		return false, err
+	}
+
+	for {
+		hash, _, err := walker.Next()
+		if errors.Is(err, io.EOF) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if bytes.Equal(hash, ancestor) {
+			return true, nil
+		}
+	}
+}
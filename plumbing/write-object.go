@@ -5,10 +5,10 @@ import (
 	"crypto"
 	_ "crypto/sha1"
 	"encoding/hex"
-	"fmt"
 	"io"
 	"os"
 	"path"
+	"strings"
 )
 
 type Object interface {
@@ -23,19 +23,60 @@ var (
 	hashFactory = crypto.SHA1
 
 	gitDirectory = ".git"
+	// commonDirectory holds the objects and references shared by all
+	// worktrees of a repository. It differs from gitDirectory only in
+	// linked worktrees.
+	commonDirectory = ".git"
 )
 
+const commonDirFile = "commondir"
+
+// SetDirectory selects the git directory to operate on. The git directory
+// of a linked worktree names the shared directory in its commondir file.
 func SetDirectory(directory string) {
 	gitDirectory = directory
+	commonDirectory = directory
+	if content, err := os.ReadFile(path.Join(directory, commonDirFile)); err == nil {
+		commonDirectory = strings.TrimSpace(string(content))
+		if !path.IsAbs(commonDirectory) {
+			commonDirectory = path.Join(directory, commonDirectory)
+		}
+	}
+
+	shallowCommits = nil
+	resetPacks()
+}
+
+func Directory() string {
+	return gitDirectory
+}
+
+// CommonDirectory returns the directory with the objects, references and
+// configuration shared by all worktrees.
+func CommonDirectory() string {
+	return commonDirectory
+}
+
+func HashObject(object Object) ([]byte, error) {
+	hashWriter := hashFactory.New()
+
+	_, err := object.WriteTo(hashWriter)
+	if err != nil {
+		return nil, err
+	}
+
+	return hashWriter.Sum(nil), nil
 }
 
 func WriteObject(object Object) ([]byte, error) {
 	hashWriter := hashFactory.New()
-	temporaryFilename := path.Join(gitDirectory, "temp", "0")
-	file, err := os.Create(temporaryFilename)
+	file, err := os.CreateTemp(path.Join(commonDirectory, objectsDirectory), "tmp_obj_")
 	if err != nil {
 		return nil, err
 	}
+	temporaryFilename := file.Name()
+	defer os.Remove(temporaryFilename)
+
 	zlibWriter := zlib.NewWriter(file)
 	writer := io.MultiWriter(hashWriter, zlibWriter)
 
@@ -52,14 +93,16 @@ func WriteObject(object Object) ([]byte, error) {
 	hash := hashWriter.Sum(nil)
 	hexa := hex.EncodeToString(hash)
 
-	fmt.Println(hexa)
+	if HasObject(hash) {
+		return hash, nil
+	}
 
-	err = os.Mkdir(path.Join(gitDirectory, objectsDirectory, hexa[:2]), 0755)
+	err = os.Mkdir(path.Join(commonDirectory, objectsDirectory, hexa[:2]), 0755)
 	if err != nil && !os.IsExist(err) {
 		return nil, err
 	}
 
-	filename := path.Join(gitDirectory, objectsDirectory, hexa[:2], hexa[2:])
+	filename := path.Join(commonDirectory, objectsDirectory, hexa[:2], hexa[2:])
 
 	err = os.Rename(temporaryFilename, filename)
 	if err != nil {
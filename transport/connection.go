@@ -0,0 +1,152 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/untanky/git-charged/config"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const (
+	ServiceUploadPack  = "git-upload-pack"
+	ServiceReceivePack = "git-receive-pack"
+
+	protocolVersion2 = "version=2"
+)
+
+// connection abstracts over stateful transports (a local process or ssh)
+// and stateless smart HTTP, where every request is a separate round trip.
+type connection interface {
+	advertisement() (io.Reader, error)
+	request(body []byte) (io.ReadCloser, error)
+	Close() error
+}
+
+func connect(endpoint *Endpoint, service string) (connection, error) {
+	switch endpoint.Protocol {
+	case ProtocolFile:
+		return newProcessConnection(exec.Command(service, endpoint.Path), service)
+	case ProtocolSSH:
+		return newProcessConnection(sshCommand(endpoint, service), service)
+	case ProtocolHTTP, ProtocolHTTPS:
+		return newHTTPConnection(endpoint, service), nil
+	default:
+		return nil, fmt.Errorf("unsupported protocol %q", endpoint.Protocol)
+	}
+}
+
+func sshCommand(endpoint *Endpoint, service string) *exec.Cmd {
+	command, ok := os.LookupEnv("GIT_SSH_COMMAND")
+	if !ok {
+		command, ok = config.Get("core.sshCommand")
+	}
+	if !ok {
+		command = "ssh"
+	}
+
+	args := strings.Fields(command)
+	args = append(args, "-o", "SendEnv=GIT_PROTOCOL")
+	if endpoint.Port != "" {
+		args = append(args, "-p", endpoint.Port)
+	}
+
+	host := endpoint.Host
+	if endpoint.User != "" {
+		host = endpoint.User + "@" + host
+	}
+	args = append(args, "--", host, fmt.Sprintf("%s %s", service, ShellQuote(endpoint.Path)))
+
+	return exec.Command(args[0], args[1:]...)
+}
+
+// ShellQuote quotes value as a single word for a POSIX shell, like the
+// paths in the commands run over ssh or by filter drivers.
+func ShellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+type processConnection struct {
+	command *exec.Cmd
+	stdin   io.WriteCloser
+	stdout  *bufio.Reader
+}
+
+func newProcessConnection(command *exec.Cmd, service string) (*processConnection, error) {
+	command.Env = os.Environ()
+	if service == ServiceUploadPack {
+		command.Env = append(command.Env, "GIT_PROTOCOL="+protocolVersion2)
+	}
+	command.Stderr = os.Stderr
+
+	stdin, err := command.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, err := command.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	err = command.Start()
+	if err != nil {
+		return nil, fmt.Errorf("cannot start %s: %w", command.Path, err)
+	}
+
+	return &processConnection{
+		command: command,
+		stdin:   stdin,
+		stdout:  bufio.NewReader(stdout),
+	}, nil
+}
+
+func (c *processConnection) advertisement() (io.Reader, error) {
+	return c.stdout, nil
+}
+
+func (c *processConnection) request(body []byte) (io.ReadCloser, error) {
+	_, err := c.stdin.Write(body)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.NopCloser(c.stdout), nil
+}
+
+func (c *processConnection) Close() error {
+	writeFlush(c.stdin)
+	c.stdin.Close()
+
+	return c.command.Wait()
+}
+
+// readAdvertisementHeader skips the "# service=..." announcement that smart
+// HTTP servers put in front of the actual advertisement.
+func readAdvertisementHeader(reader *packetReader, service string) (packetKind, string, error) {
+	kind, line, err := reader.ReadLine()
+	if err != nil {
+		return kind, line, err
+	}
+
+	if kind == packetData && line == "# service="+service {
+		for kind != packetFlush {
+			kind, _, err = reader.ReadLine()
+			if err != nil {
+				return kind, "", err
+			}
+		}
+		return reader.ReadLine()
+	}
+
+	return kind, line, nil
+}
+
+func requestBody(build func(buffer *bytes.Buffer)) []byte {
+	buffer := bytes.NewBuffer(make([]byte, 0, 1024))
+	build(buffer)
+	return buffer.Bytes()
+}
@@ -0,0 +1,130 @@
+package transport
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	ProtocolFile  = "file"
+	ProtocolSSH   = "ssh"
+	ProtocolHTTP  = "http"
+	ProtocolHTTPS = "https"
+)
+
+var scpLikeURL = regexp.MustCompile(`^(?:([^@/]+)@)?([^:/]+):(.+)$`)
+
+type Endpoint struct {
+	Protocol string
+	User     string
+	Password string
+	Host     string
+	Port     string
+	Path     string
+}
+
+func ParseEndpoint(rawURL string) (*Endpoint, error) {
+	if strings.Contains(rawURL, "://") {
+		parsed, err := url.Parse(rawURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid remote url %q: %w", rawURL, err)
+		}
+
+		endpoint := &Endpoint{
+			Protocol: parsed.Scheme,
+			Host:     parsed.Hostname(),
+			Port:     parsed.Port(),
+			Path:     parsed.Path,
+		}
+		if parsed.User != nil {
+			endpoint.User = parsed.User.Username()
+			endpoint.Password, _ = parsed.User.Password()
+		}
+
+		switch endpoint.Protocol {
+		case ProtocolFile, ProtocolHTTP, ProtocolHTTPS:
+		case ProtocolSSH, "git+ssh", "ssh+git":
+			endpoint.Protocol = ProtocolSSH
+		default:
+			return nil, fmt.Errorf("unsupported protocol %q", endpoint.Protocol)
+		}
+
+		err = checkSSHArguments(endpoint)
+		if err != nil {
+			return nil, err
+		}
+
+		return endpoint, nil
+	}
+
+	if match := scpLikeURL.FindStringSubmatch(rawURL); match != nil && !isLocalPath(rawURL) {
+		endpoint := &Endpoint{
+			Protocol: ProtocolSSH,
+			User:     match[1],
+			Host:     match[2],
+			Path:     match[3],
+		}
+		err := checkSSHArguments(endpoint)
+		if err != nil {
+			return nil, err
+		}
+
+		return endpoint, nil
+	}
+
+	absolutePath, err := filepath.Abs(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Endpoint{
+		Protocol: ProtocolFile,
+		Path:     filepath.ToSlash(absolutePath),
+	}, nil
+}
+
+// checkSSHArguments rejects users and hosts that ssh would take for an
+// option, such as -oProxyCommand=..., like git does.
+func checkSSHArguments(endpoint *Endpoint) error {
+	if endpoint.Protocol != ProtocolSSH {
+		return nil
+	}
+	if strings.HasPrefix(endpoint.User, "-") {
+		return fmt.Errorf("strange username %q blocked", endpoint.User)
+	}
+	if strings.HasPrefix(endpoint.Host, "-") {
+		return fmt.Errorf("strange hostname %q blocked", endpoint.Host)
+	}
+	return nil
+}
+
+func isLocalPath(rawURL string) bool {
+	colon := strings.Index(rawURL, ":")
+	slash := strings.Index(rawURL, "/")
+	return slash >= 0 && slash < colon
+}
+
+func (e *Endpoint) String() string {
+	switch e.Protocol {
+	case ProtocolFile:
+		return "file://" + e.Path
+	case ProtocolSSH:
+		host := e.Host
+		if e.User != "" {
+			host = e.User + "@" + host
+		}
+		if e.Port != "" {
+			return fmt.Sprintf("ssh://%s:%s/%s", host, e.Port, strings.TrimPrefix(e.Path, "/"))
+		}
+		return fmt.Sprintf("%s:%s", host, e.Path)
+	default:
+		u := url.URL{Scheme: e.Protocol, Host: e.Host, Path: e.Path}
+		if e.Port != "" {
+			u.Host = fmt.Sprintf("%s:%s", e.Host, e.Port)
+		}
+		return u.String()
+	}
+}
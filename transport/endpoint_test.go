@@ -0,0 +1,49 @@
+package transport
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseEndpoint(t *testing.T) {
+	tests := []struct {
+		url      string
+		expected Endpoint
+	}{
+		{"https://example.com/repo.git", Endpoint{Protocol: ProtocolHTTPS, Host: "example.com", Path: "/repo.git"}},
+		{"ssh://git@example.com:2222/repo.git", Endpoint{Protocol: ProtocolSSH, User: "git", Host: "example.com", Port: "2222", Path: "/repo.git"}},
+		{"git@example.com:repo.git", Endpoint{Protocol: ProtocolSSH, User: "git", Host: "example.com", Path: "repo.git"}},
+		{"/srv/repo.git", Endpoint{Protocol: ProtocolFile, Path: "/srv/repo.git"}},
+	}
+
+	for _, test := range tests {
+		endpoint, err := ParseEndpoint(test.url)
+		if err != nil || *endpoint != test.expected {
+			t.Errorf("ParseEndpoint(%q) = %+v, %v, want %+v", test.url, endpoint, err, test.expected)
+		}
+	}
+}
+
+func TestParseEndpointRejectsOptionsForSSH(t *testing.T) {
+	for _, url := range []string{
+		"-oProxyCommand=touch:repo",
+		"-oProxyCommand=touch@example.com:repo",
+		"ssh://-oProxyCommand=touch/repo",
+		"ssh://-oProxyCommand=touch@example.com/repo",
+	} {
+		endpoint, err := ParseEndpoint(url)
+		if err == nil {
+			t.Errorf("ParseEndpoint(%q) = %+v, want an error", url, endpoint)
+		}
+	}
+}
+
+func TestSSHCommandEndsOptions(t *testing.T) {
+	t.Setenv("GIT_SSH_COMMAND", "ssh")
+
+	command := sshCommand(&Endpoint{Protocol: ProtocolSSH, User: "git", Host: "example.com", Path: "repo.git"}, ServiceUploadPack)
+	expected := []string{"ssh", "-o", "SendEnv=GIT_PROTOCOL", "--", "git@example.com", "git-upload-pack 'repo.git'"}
+	if !slices.Equal(command.Args, expected) {
+		t.Errorf("sshCommand() = %q, want %q", command.Args, expected)
+	}
+}
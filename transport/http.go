@@ -0,0 +1,104 @@
+package transport
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const userAgent = "git-charged/0.1"
+
+type httpConnection struct {
+	client   *http.Client
+	endpoint *Endpoint
+	service  string
+}
+
+func newHTTPConnection(endpoint *Endpoint, service string) *httpConnection {
+	return &httpConnection{
+		client:   http.DefaultClient,
+		endpoint: endpoint,
+		service:  service,
+	}
+}
+
+func (c *httpConnection) url(suffix string) string {
+	return strings.TrimSuffix(c.endpoint.String(), "/") + suffix
+}
+
+func (c *httpConnection) authenticate(request *http.Request) {
+	if c.service == ServiceUploadPack {
+		request.Header.Set("Git-Protocol", protocolVersion2)
+	}
+
+	authenticateRequest(request, c.endpoint)
+}
+
+// authenticateRequest adds the user agent and the credentials for endpoint
+// to an HTTP request.
+func authenticateRequest(request *http.Request, endpoint *Endpoint) {
+	request.Header.Set("User-Agent", userAgent)
+
+	if endpoint.User != "" {
+		request.SetBasicAuth(endpoint.User, endpoint.Password)
+		return
+	}
+
+	if token := os.Getenv("GITHUB_TOKEN"); token != "" && endpoint.Host == "github.com" {
+		request.SetBasicAuth("x-access-token", token)
+	}
+}
+
+func (c *httpConnection) do(request *http.Request) (io.ReadCloser, error) {
+	c.authenticate(request)
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		response.Body.Close()
+		return nil, fmt.Errorf("%s %s: %s", request.Method, request.URL.Redacted(), response.Status)
+	}
+
+	return response.Body, nil
+}
+
+func (c *httpConnection) advertisement() (io.Reader, error) {
+	request, err := http.NewRequest(http.MethodGet, c.url("/info/refs?service="+c.service), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := c.do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	content, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.NewReader(content), nil
+}
+
+func (c *httpConnection) request(body []byte) (io.ReadCloser, error) {
+	request, err := http.NewRequest(http.MethodPost, c.url("/"+c.service), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", fmt.Sprintf("application/x-%s-request", c.service))
+	request.Header.Set("Accept", fmt.Sprintf("application/x-%s-result", c.service))
+
+	return c.do(request)
+}
+
+func (c *httpConnection) Close() error {
+	return nil
+}
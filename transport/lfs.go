@@ -0,0 +1,281 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	lfsMediaType = "application/vnd.git-lfs+json"
+
+	LFSOperationDownload = "download"
+	LFSOperationUpload   = "upload"
+)
+
+var ErrLFSObjectNotFound = errors.New("LFS object not found on server")
+
+// LFSObject identifies a file stored with Git LFS by its SHA-256.
+type LFSObject struct {
+	OID  string `json:"oid"`
+	Size int64  `json:"size"`
+}
+
+type lfsBatchRequest struct {
+	Operation string      `json:"operation"`
+	Transfers []string    `json:"transfers"`
+	Objects   []LFSObject `json:"objects"`
+	HashAlgo  string      `json:"hash_algo"`
+}
+
+type lfsAction struct {
+	Href   string            `json:"href"`
+	Header map[string]string `json:"header"`
+}
+
+type lfsError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type lfsObjectResult struct {
+	LFSObject
+	Actions map[string]lfsAction `json:"actions"`
+	Error   *lfsError            `json:"error"`
+}
+
+type lfsBatchResponse struct {
+	Transfer string            `json:"transfer"`
+	Objects  []lfsObjectResult `json:"objects"`
+}
+
+// LFSClient talks to a Git LFS server using the batch API and the basic
+// transfer adapter.
+type LFSClient struct {
+	client   *http.Client
+	endpoint *Endpoint
+	url      string
+}
+
+// NewLFSClient returns a client for the LFS server of a git remote, which
+// lives at <remote>.git/info/lfs by convention.
+func NewLFSClient(remoteURL string) (*LFSClient, error) {
+	endpoint, err := ParseEndpoint(remoteURL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch endpoint.Protocol {
+	case ProtocolHTTP, ProtocolHTTPS:
+	case ProtocolSSH:
+		// LFS is served over HTTPS even for repositories cloned over ssh.
+		endpoint = &Endpoint{Protocol: ProtocolHTTPS, Host: endpoint.Host, Path: "/" + strings.TrimPrefix(endpoint.Path, "/")}
+	default:
+		return nil, fmt.Errorf("no LFS server known for %s", remoteURL)
+	}
+
+	lfsURL := strings.TrimSuffix(endpoint.String(), "/")
+	if !strings.HasSuffix(lfsURL, ".git") {
+		lfsURL += ".git"
+	}
+
+	return &LFSClient{client: http.DefaultClient, endpoint: endpoint, url: lfsURL + "/info/lfs"}, nil
+}
+
+// NewLFSClientForURL returns a client for an explicitly configured LFS
+// server URL like the one in lfs.url.
+func NewLFSClientForURL(lfsURL string) (*LFSClient, error) {
+	endpoint, err := ParseEndpoint(lfsURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LFSClient{client: http.DefaultClient, endpoint: endpoint, url: strings.TrimSuffix(lfsURL, "/")}, nil
+}
+
+func (c *LFSClient) batch(operation string, objects []LFSObject) ([]lfsObjectResult, error) {
+	body, err := json.Marshal(lfsBatchRequest{
+		Operation: operation,
+		Transfers: []string{"basic"},
+		Objects:   objects,
+		HashAlgo:  "sha256",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, c.url+"/objects/batch", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", lfsMediaType)
+	request.Header.Set("Content-Type", lfsMediaType)
+	authenticateRequest(request, c.endpoint)
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("LFS batch %s: %s", operation, response.Status)
+	}
+
+	var result lfsBatchResponse
+	err = json.NewDecoder(response.Body).Decode(&result)
+	if err != nil {
+		return nil, fmt.Errorf("malformed LFS batch response: %w", err)
+	}
+	if result.Transfer != "" && result.Transfer != "basic" {
+		return nil, fmt.Errorf("unsupported LFS transfer adapter %q", result.Transfer)
+	}
+
+	// The OIDs name local files, so only the requested objects are
+	// accepted.
+	requested := make(map[LFSObject]bool, len(objects))
+	for _, object := range objects {
+		requested[object] = true
+	}
+	for _, object := range result.Objects {
+		if !requested[object.LFSObject] {
+			return nil, fmt.Errorf("LFS server answered for unrequested object %s of size %d", object.OID, object.Size)
+		}
+	}
+
+	return result.Objects, nil
+}
+
+func (c *LFSClient) doAction(method string, action lfsAction, body io.Reader, size int64) (*http.Response, error) {
+	request, err := http.NewRequest(method, action.Href, body)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range action.Header {
+		request.Header.Set(key, value)
+	}
+	if body != nil {
+		request.ContentLength = size
+		if request.Header.Get("Content-Type") == "" {
+			request.Header.Set("Content-Type", "application/octet-stream")
+		}
+	}
+	if request.Header.Get("Authorization") == "" && request.URL.Hostname() == c.endpoint.Host {
+		authenticateRequest(request, c.endpoint)
+	} else {
+		request.Header.Set("User-Agent", userAgent)
+	}
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		response.Body.Close()
+		return nil, fmt.Errorf("%s %s: %s", method, request.URL.Redacted(), response.Status)
+	}
+
+	return response, nil
+}
+
+// Download fetches objects from the server and passes their content to
+// store.
+func (c *LFSClient) Download(objects []LFSObject, store func(object LFSObject, content io.Reader) error) error {
+	if len(objects) == 0 {
+		return nil
+	}
+
+	results, err := c.batch(LFSOperationDownload, objects)
+	if err != nil {
+		return err
+	}
+
+	for _, result := range results {
+		if result.Error != nil {
+			if result.Error.Code == http.StatusNotFound {
+				return fmt.Errorf("%w: %s", ErrLFSObjectNotFound, result.OID)
+			}
+			return fmt.Errorf("cannot download %s: %s", result.OID, result.Error.Message)
+		}
+
+		action, ok := result.Actions[LFSOperationDownload]
+		if !ok {
+			return fmt.Errorf("server did not provide a download for %s", result.OID)
+		}
+
+		response, err := c.doAction(http.MethodGet, action, nil, 0)
+		if err != nil {
+			return err
+		}
+
+		err = store(result.LFSObject, response.Body)
+		response.Body.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Upload sends the objects the server does not have yet. open returns the
+// content of an object.
+func (c *LFSClient) Upload(objects []LFSObject, open func(object LFSObject) (io.ReadCloser, error)) error {
+	if len(objects) == 0 {
+		return nil
+	}
+
+	results, err := c.batch(LFSOperationUpload, objects)
+	if err != nil {
+		return err
+	}
+
+	for _, result := range results {
+		if result.Error != nil {
+			return fmt.Errorf("cannot upload %s: %s", result.OID, result.Error.Message)
+		}
+
+		// Objects without an upload action already exist on the server.
+		action, ok := result.Actions[LFSOperationUpload]
+		if !ok {
+			continue
+		}
+
+		content, err := open(result.LFSObject)
+		if err != nil {
+			return err
+		}
+
+		response, err := c.doAction(http.MethodPut, action, content, result.Size)
+		content.Close()
+		if err != nil {
+			return err
+		}
+		response.Body.Close()
+
+		if verify, ok := result.Actions["verify"]; ok {
+			body, err := json.Marshal(result.LFSObject)
+			if err != nil {
+				return err
+			}
+
+			verifyAction := lfsAction{Href: verify.Href, Header: map[string]string{"Accept": lfsMediaType, "Content-Type": lfsMediaType}}
+			for key, value := range verify.Header {
+				verifyAction.Header[key] = value
+			}
+
+			response, err := c.doAction(http.MethodPost, verifyAction, bytes.NewReader(body), int64(len(body)))
+			if err != nil {
+				return fmt.Errorf("cannot verify %s: %w", result.OID, err)
+			}
+			response.Body.Close()
+		}
+	}
+
+	return nil
+}
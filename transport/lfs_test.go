@@ -0,0 +1,197 @@
+package transport
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testLFSServer is a Git LFS server with the basic transfer adapter that
+// keeps objects in memory.
+type testLFSServer struct {
+	*httptest.Server
+
+	mutex   sync.Mutex
+	objects map[string][]byte
+	// answer replaces the objects of every batch response, if set.
+	answer []LFSObject
+}
+
+func newTestLFSServer(t *testing.T) *testLFSServer {
+	server := &testLFSServer{objects: make(map[string][]byte)}
+	server.Server = httptest.NewServer(server)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func (s *testLFSServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	oid := strings.TrimPrefix(r.URL.Path, "/objects/")
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/objects/batch":
+		var request lfsBatchRequest
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if s.answer != nil {
+			request.Objects = s.answer
+		}
+
+		response := lfsBatchResponse{Transfer: "basic", Objects: make([]lfsObjectResult, 0)}
+		for _, object := range request.Objects {
+			result := lfsObjectResult{LFSObject: object, Actions: make(map[string]lfsAction)}
+			href := lfsAction{Href: s.URL + "/objects/" + object.OID}
+			_, stored := s.objects[object.OID]
+			switch {
+			case request.Operation == LFSOperationUpload && !stored:
+				result.Actions[LFSOperationUpload] = href
+			case request.Operation == LFSOperationDownload && stored:
+				result.Actions[LFSOperationDownload] = href
+			case request.Operation == LFSOperationDownload:
+				result.Error = &lfsError{Code: http.StatusNotFound, Message: "Object does not exist"}
+			}
+			response.Objects = append(response.Objects, result)
+		}
+
+		w.Header().Set("Content-Type", lfsMediaType)
+		json.NewEncoder(w).Encode(response)
+	case r.Method == http.MethodPut:
+		content, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.objects[oid] = content
+	case r.Method == http.MethodGet:
+		content, ok := s.objects[oid]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(content)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func testLFSObject(content string) LFSObject {
+	hash := sha256.Sum256([]byte(content))
+	return LFSObject{OID: hex.EncodeToString(hash[:]), Size: int64(len(content))}
+}
+
+func TestLFSClientUploadAndDownload(t *testing.T) {
+	server := newTestLFSServer(t)
+	client, err := NewLFSClientForURL(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contents := map[string]string{}
+	objects := make([]LFSObject, 0)
+	for _, content := range []string{"first large file\n", "second large file\n"} {
+		object := testLFSObject(content)
+		contents[object.OID] = content
+		objects = append(objects, object)
+	}
+
+	opened := 0
+	open := func(object LFSObject) (io.ReadCloser, error) {
+		opened++
+		return io.NopCloser(strings.NewReader(contents[object.OID])), nil
+	}
+	err = client.Upload(objects, open)
+	if err != nil {
+		t.Fatalf("Upload() = %s", err)
+	}
+	if opened != 2 || len(server.objects) != 2 {
+		t.Errorf("uploaded %d objects, server has %d, want 2", opened, len(server.objects))
+	}
+
+	// Objects the server has are not uploaded again.
+	err = client.Upload(objects, open)
+	if err != nil || opened != 2 {
+		t.Errorf("second Upload() = %v after opening %d objects", err, opened)
+	}
+
+	downloaded := map[string]string{}
+	err = client.Download(objects, func(object LFSObject, content io.Reader) error {
+		data, err := io.ReadAll(content)
+		downloaded[object.OID] = string(data)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Download() = %s", err)
+	}
+	for oid, content := range contents {
+		if downloaded[oid] != content {
+			t.Errorf("downloaded %s = %q, want %q", oid, downloaded[oid], content)
+		}
+	}
+}
+
+func TestLFSClientDownloadMissingObject(t *testing.T) {
+	server := newTestLFSServer(t)
+	client, err := NewLFSClientForURL(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = client.Download([]LFSObject{testLFSObject("missing")}, func(LFSObject, io.Reader) error {
+		t.Error("Download() stored a missing object")
+		return nil
+	})
+	if !errors.Is(err, ErrLFSObjectNotFound) {
+		t.Errorf("Download() = %v, want ErrLFSObjectNotFound", err)
+	}
+}
+
+func TestLFSClientRejectsUnrequestedObjects(t *testing.T) {
+	requested := testLFSObject("requested")
+
+	tests := []struct {
+		name   string
+		answer LFSObject
+	}{
+		{name: "other OID", answer: LFSObject{OID: strings.Repeat("../", 18) + "etc/passwd", Size: requested.Size}},
+		{name: "other size", answer: LFSObject{OID: requested.OID, Size: requested.Size + 1}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestLFSServer(t)
+			server.objects[test.answer.OID] = []byte("secret")
+			server.answer = []LFSObject{test.answer}
+			client, err := NewLFSClientForURL(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = client.Upload([]LFSObject{requested}, func(object LFSObject) (io.ReadCloser, error) {
+				t.Errorf("Upload() opened %s", object.OID)
+				return io.NopCloser(strings.NewReader("")), nil
+			})
+			if err == nil {
+				t.Error("Upload() accepted an unrequested object")
+			}
+
+			err = client.Download([]LFSObject{requested}, func(object LFSObject, _ io.Reader) error {
+				t.Errorf("Download() stored %s", object.OID)
+				return nil
+			})
+			if err == nil {
+				t.Error("Download() accepted an unrequested object")
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package transport
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+type packetKind int
+
+const (
+	packetData packetKind = iota
+	packetFlush
+	packetDelimiter
+	packetResponseEnd
+)
+
+const (
+	maxPacketLength = 65520
+
+	sidebandData     = 1
+	sidebandProgress = 2
+	sidebandError    = 3
+)
+
+var errUnexpectedPacket = errors.New("unexpected packet")
+
+type packetReader struct {
+	reader io.Reader
+	header [4]byte
+	buffer [maxPacketLength]byte
+}
+
+func newPacketReader(reader io.Reader) *packetReader {
+	return &packetReader{reader: reader}
+}
+
+// Read returns the next packet. The returned slice is only valid until the
+// next call.
+func (r *packetReader) Read() (packetKind, []byte, error) {
+	_, err := io.ReadFull(r.reader, r.header[:])
+	if err != nil {
+		return 0, nil, err
+	}
+
+	length, err := strconv.ParseUint(string(r.header[:]), 16, 16)
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid packet length %q", r.header[:])
+	}
+
+	switch length {
+	case 0:
+		return packetFlush, nil, nil
+	case 1:
+		return packetDelimiter, nil, nil
+	case 2:
+		return packetResponseEnd, nil, nil
+	case 3:
+		return 0, nil, fmt.Errorf("invalid packet length %q", r.header[:])
+	}
+	if length > maxPacketLength {
+		return 0, nil, fmt.Errorf("packet too long: %d", length)
+	}
+
+	data := r.buffer[:length-4]
+	_, err = io.ReadFull(r.reader, data)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return packetData, data, nil
+}
+
+// ReadLine returns the next data packet as a string without its trailing
+// newline, or the empty string together with the kind of a special packet.
+func (r *packetReader) ReadLine() (packetKind, string, error) {
+	kind, data, err := r.Read()
+	if err != nil {
+		return kind, "", err
+	}
+
+	return kind, string(bytes.TrimSuffix(data, []byte("\n"))), nil
+}
+
+func writePacket(w io.Writer, data []byte) error {
+	if len(data) > maxPacketLength-4 {
+		return fmt.Errorf("packet too long: %d", len(data))
+	}
+
+	_, err := fmt.Fprintf(w, "%04x", len(data)+4)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+func writePacketLine(w io.Writer, format string, args ...any) error {
+	return writePacket(w, []byte(fmt.Sprintf(format, args...)+"\n"))
+}
+
+func writeFlush(w io.Writer) error {
+	_, err := io.WriteString(w, "0000")
+	return err
+}
+
+func writeDelimiter(w io.Writer) error {
+	_, err := io.WriteString(w, "0001")
+	return err
+}
+
+// remoteProgress prefixes every line of progress output with "remote: ",
+// treating carriage returns as line ends so that updating counters work.
+type remoteProgress struct {
+	writer      io.Writer
+	atLineStart bool
+}
+
+func newRemoteProgress(writer io.Writer) io.Writer {
+	if writer == nil {
+		return nil
+	}
+	return &remoteProgress{writer: writer, atLineStart: true}
+}
+
+func (p *remoteProgress) Write(data []byte) (int, error) {
+	var buffer bytes.Buffer
+	for _, b := range data {
+		if p.atLineStart {
+			buffer.WriteString("remote: ")
+		}
+		buffer.WriteByte(b)
+		p.atLineStart = b == '\n' || b == '\r'
+	}
+
+	_, err := p.writer.Write(buffer.Bytes())
+	if err != nil {
+		return 0, err
+	}
+
+	return len(data), nil
+}
+
+// demultiplexSideband copies the side-band encoded stream until a flush
+// packet, sending pack data to pack and progress messages to progress.
+func demultiplexSideband(reader *packetReader, pack io.Writer, progress io.Writer) error {
+	for {
+		kind, data, err := reader.Read()
+		if err != nil {
+			return err
+		}
+		if kind == packetFlush || kind == packetResponseEnd {
+			return nil
+		}
+		if kind != packetData || len(data) == 0 {
+			return errUnexpectedPacket
+		}
+
+		switch data[0] {
+		case sidebandData:
+			_, err = pack.Write(data[1:])
+			if err != nil {
+				return err
+			}
+		case sidebandProgress:
+			if progress != nil {
+				_, err = progress.Write(data[1:])
+				if err != nil {
+					return err
+				}
+			}
+		case sidebandError:
+			return fmt.Errorf("remote error: %s", bytes.TrimSpace(data[1:]))
+		default:
+			return fmt.Errorf("unknown side-band %d", data[0])
+		}
+	}
+}
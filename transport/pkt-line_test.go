@@ -0,0 +1,83 @@
+package transport
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestPacketLineRoundTrip(t *testing.T) {
+	var buffer bytes.Buffer
+	writePacketLine(&buffer, "command=%s", "ls-refs")
+	writeDelimiter(&buffer)
+	writePacket(&buffer, []byte("no newline"))
+	writeFlush(&buffer)
+	buffer.WriteString("0002")
+
+	if got := buffer.String(); got != "0014command=ls-refs\n0001000eno newline00000002" {
+		t.Fatalf("written packets = %q", got)
+	}
+
+	reader := newPacketReader(&buffer)
+	expected := []struct {
+		kind packetKind
+		line string
+	}{
+		{packetData, "command=ls-refs"},
+		{packetDelimiter, ""},
+		{packetData, "no newline"},
+		{packetFlush, ""},
+		{packetResponseEnd, ""},
+	}
+	for _, want := range expected {
+		kind, line, err := reader.ReadLine()
+		if err != nil || kind != want.kind || line != want.line {
+			t.Errorf("ReadLine() = %v, %q, %v, want %v, %q", kind, line, err, want.kind, want.line)
+		}
+	}
+}
+
+func TestPacketLineRejectsInvalidPackets(t *testing.T) {
+	for _, input := range []string{"000", "0003", "zzzz", "ffff", "000ashort"} {
+		_, _, err := newPacketReader(strings.NewReader(input)).Read()
+		if err == nil {
+			t.Errorf("Read(%q) succeeded", input)
+		}
+	}
+
+	err := writePacket(&bytes.Buffer{}, make([]byte, maxPacketLength-3))
+	if err == nil {
+		t.Errorf("writePacket accepted a packet longer than %d bytes", maxPacketLength)
+	}
+	err = writePacket(&bytes.Buffer{}, make([]byte, maxPacketLength-4))
+	if err != nil {
+		t.Errorf("writePacket rejected a packet of %d bytes: %s", maxPacketLength, err)
+	}
+}
+
+func TestDemultiplexSideband(t *testing.T) {
+	var input bytes.Buffer
+	writePacket(&input, []byte("\x01PACK"))
+	writePacket(&input, []byte("\x02Counting objects: 1\r"))
+	writePacket(&input, []byte("\x01data"))
+	writeFlush(&input)
+
+	var pack, progress bytes.Buffer
+	err := demultiplexSideband(newPacketReader(&input), &pack, newRemoteProgress(&progress))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pack.String() != "PACKdata" {
+		t.Errorf("pack = %q", pack.String())
+	}
+	if progress.String() != "remote: Counting objects: 1\r" {
+		t.Errorf("progress = %q", progress.String())
+	}
+
+	input.Reset()
+	writePacket(&input, []byte("\x03access denied\n"))
+	err = demultiplexSideband(newPacketReader(&input), &pack, nil)
+	if err == nil || err.Error() != "remote error: access denied" {
+		t.Errorf("demultiplexSideband() = %v, want remote error", err)
+	}
+}
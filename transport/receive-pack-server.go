@@ -0,0 +1,279 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"github.com/untanky/git-charged/config"
+	"github.com/untanky/git-charged/plumbing"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+var receivePackCapabilities = []string{
+	"report-status",
+	"delete-refs",
+	"side-band-64k",
+	"quiet",
+	"atomic",
+	"ofs-delta",
+	"no-thin",
+	"agent=" + userAgent,
+}
+
+type receivedCommand struct {
+	RefUpdate
+	status string
+}
+
+func writeReceivePackAdvertisement(w io.Writer) error {
+	refs, err := plumbing.ListRefs("refs/")
+	if err != nil {
+		return err
+	}
+
+	capabilities := strings.Join(receivePackCapabilities, " ")
+	if len(refs) == 0 {
+		writePacketLine(w, "%x %s\000%s", ZeroHash, capabilitiesRef, capabilities)
+	}
+	for i, ref := range refs {
+		if i == 0 {
+			writePacketLine(w, "%x %s\000%s", ref.Hash, ref.Name, capabilities)
+		} else {
+			writePacketLine(w, "%x %s", ref.Hash, ref.Name)
+		}
+	}
+
+	return writeFlush(w)
+}
+
+// receivePackRequest is a push read from a client: the reference updates,
+// the requested capabilities and the pack with the new objects.
+type receivePackRequest struct {
+	commands     []*receivedCommand
+	capabilities map[string]bool
+	pack         []byte
+	unpackStatus string
+}
+
+// readReceivePackRequest reads a push from a client. It does not touch the
+// repository, so it needs no lock. Packs above maxPackSize bytes are
+// refused, unless maxPackSize is 0.
+func readReceivePackRequest(reader *bufio.Reader, maxPackSize int64) (*receivePackRequest, error) {
+	request := &receivePackRequest{capabilities: make(map[string]bool), unpackStatus: "ok"}
+
+	packets := newPacketReader(reader)
+	for {
+		kind, line, err := packets.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+		if kind == packetFlush {
+			break
+		}
+
+		line, capabilities, hasCapabilities := strings.Cut(line, "\000")
+		if hasCapabilities {
+			for _, capability := range strings.Fields(capabilities) {
+				request.capabilities[capability] = true
+			}
+		}
+
+		command, err := parseReceivedCommand(line)
+		if err != nil {
+			return nil, err
+		}
+		request.commands = append(request.commands, command)
+	}
+
+	if needsPack(request.commands) {
+		pack, err := plumbing.ReadPackStream(reader, maxPackSize)
+		if err != nil {
+			request.unpackStatus = err.Error()
+		}
+		request.pack = pack
+	}
+
+	return request, nil
+}
+
+// apply stores the pack and updates the references of a push, and returns
+// the status report for the client.
+func (request *receivePackRequest) apply() []byte {
+	if request.pack != nil {
+		_, err := plumbing.IndexPack(bytes.NewReader(request.pack))
+		if err != nil {
+			request.unpackStatus = err.Error()
+		}
+	}
+
+	if request.unpackStatus == "ok" {
+		updateReceivedRefs(request.commands, request.capabilities["atomic"])
+	} else {
+		for _, command := range request.commands {
+			command.status = "unpacker error"
+		}
+	}
+
+	report := bytes.NewBuffer(make([]byte, 0, 1024))
+	writePacketLine(report, "unpack %s", request.unpackStatus)
+	for _, command := range request.commands {
+		if command.status == "" {
+			writePacketLine(report, "ok %s", command.Name)
+		} else {
+			writePacketLine(report, "ng %s %s", command.Name, command.status)
+		}
+	}
+	writeFlush(report)
+
+	return report.Bytes()
+}
+
+// writeReport sends the status report of a push, if the client asked for
+// one.
+func (request *receivePackRequest) writeReport(w io.Writer, report []byte) error {
+	if !request.capabilities["report-status"] {
+		return nil
+	}
+
+	if request.capabilities["side-band-64k"] {
+		_, err := (&sidebandWriter{writer: w, band: sidebandData}).Write(report)
+		if err != nil {
+			return err
+		}
+		return writeFlush(w)
+	}
+
+	_, err := w.Write(report)
+	return err
+}
+
+func parseReceivedCommand(line string) (*receivedCommand, error) {
+	fields := strings.SplitN(line, " ", 3)
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("malformed command %q", line)
+	}
+
+	old, err := hex.DecodeString(fields[0])
+	if err != nil {
+		return nil, fmt.Errorf("malformed command %q", line)
+	}
+
+	new, err := hex.DecodeString(fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed command %q", line)
+	}
+
+	command := &receivedCommand{RefUpdate: RefUpdate{Name: fields[2]}}
+	if !bytes.Equal(old, ZeroHash) {
+		command.Old = old
+	}
+	if !bytes.Equal(new, ZeroHash) {
+		command.New = new
+	}
+
+	return command, nil
+}
+
+func needsPack(commands []*receivedCommand) bool {
+	for _, command := range commands {
+		if command.New != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+func updateReceivedRefs(commands []*receivedCommand, atomic bool) {
+	checkedOut := checkedOutBranches()
+
+	failed := false
+	for _, command := range commands {
+		command.status = checkReceivedCommand(command, checkedOut)
+		if command.status != "" {
+			failed = true
+		}
+	}
+
+	if atomic && failed {
+		for _, command := range commands {
+			if command.status == "" {
+				command.status = "atomic transaction failed"
+			}
+		}
+		return
+	}
+
+	for _, command := range commands {
+		if command.status != "" {
+			continue
+		}
+
+		var err error
+		if command.New == nil {
+			err = plumbing.DeleteRef(command.Name)
+		} else {
+			err = plumbing.WriteRef(command.Name, command.New)
+		}
+		if err != nil {
+			command.status = "failed to update ref"
+		}
+	}
+}
+
+func checkReceivedCommand(command *receivedCommand, checkedOut map[string]bool) string {
+	if !strings.HasPrefix(command.Name, "refs/") || plumbing.CheckRefFormat(command.Name) != nil {
+		return "funny refname"
+	}
+
+	current, err := plumbing.ResolveRef(command.Name)
+	if err != nil {
+		current = nil
+	}
+	if !bytes.Equal(current, command.Old) {
+		return "stale info"
+	}
+
+	if command.New != nil && !plumbing.HasObject(command.New) {
+		return "missing necessary objects"
+	}
+
+	if checkedOut[command.Name] {
+		if denyCurrentBranch, ok := config.Get("receive.denyCurrentBranch"); !ok || denyCurrentBranch != "ignore" {
+			return "branch is currently checked out"
+		}
+	}
+
+	return ""
+}
+
+// checkedOutBranches returns the branches checked out in the main worktree,
+// unless the repository is bare, and in every linked worktree.
+func checkedOutBranches() map[string]bool {
+	heads := make([]string, 0)
+	if bare, _ := config.Get("core.bare"); bare != "true" && path.Base(plumbing.CommonDirectory()) == ".git" {
+		heads = append(heads, path.Join(plumbing.CommonDirectory(), plumbing.HEAD))
+	}
+	if entries, err := os.ReadDir(path.Join(plumbing.CommonDirectory(), "worktrees")); err == nil {
+		for _, entry := range entries {
+			heads = append(heads, path.Join(plumbing.CommonDirectory(), "worktrees", entry.Name(), plumbing.HEAD))
+		}
+	}
+
+	branches := make(map[string]bool)
+	for _, head := range heads {
+		content, err := os.ReadFile(head)
+		if err != nil {
+			continue
+		}
+		if branch, ok := strings.CutPrefix(strings.TrimSpace(string(content)), "ref: "); ok {
+			branches[branch] = true
+		}
+	}
+
+	return branches
+}
@@ -0,0 +1,223 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const capabilitiesRef = "capabilities^{}"
+
+var ZeroHash = make([]byte, 20)
+
+type RefUpdate struct {
+	Name string
+	Old  []byte
+	New  []byte
+}
+
+type RefStatus struct {
+	Name   string
+	OK     bool
+	Reason string
+}
+
+type PushRequest struct {
+	Updates []RefUpdate
+	Atomic  bool
+	// WritePack writes the pack with the objects the remote is missing. It
+	// is not called when the push only deletes references.
+	WritePack func(w io.Writer) error
+	Progress  io.Writer
+}
+
+// ReceivePackSession talks to a remote receive-pack service, which only
+// speaks protocol version 0.
+type ReceivePackSession struct {
+	conn         connection
+	refs         []RemoteRef
+	capabilities map[string]string
+}
+
+func NewReceivePackSession(endpoint *Endpoint) (*ReceivePackSession, error) {
+	conn, err := connect(endpoint, ServiceReceivePack)
+	if err != nil {
+		return nil, err
+	}
+
+	session := &ReceivePackSession{
+		conn:         conn,
+		refs:         make([]RemoteRef, 0),
+		capabilities: make(map[string]string),
+	}
+
+	err = session.readAdvertisement()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("cannot talk to %s: %w", endpoint, err)
+	}
+
+	return session, nil
+}
+
+func (s *ReceivePackSession) readAdvertisement() error {
+	stream, err := s.conn.advertisement()
+	if err != nil {
+		return err
+	}
+
+	reader := newPacketReader(stream)
+	kind, line, err := readAdvertisementHeader(reader, ServiceReceivePack)
+	for ; ; kind, line, err = reader.ReadLine() {
+		if err != nil {
+			return err
+		}
+		if kind == packetFlush {
+			return nil
+		}
+		if kind != packetData {
+			return errUnexpectedPacket
+		}
+
+		line, capabilities, hasCapabilities := strings.Cut(line, "\000")
+		if hasCapabilities {
+			for _, capability := range strings.Fields(capabilities) {
+				key, value, _ := strings.Cut(capability, "=")
+				s.capabilities[key] = value
+			}
+		}
+
+		value, name, ok := strings.Cut(line, " ")
+		if !ok {
+			return fmt.Errorf("malformed reference %q", line)
+		}
+		if name == capabilitiesRef {
+			continue
+		}
+
+		hash, err := hex.DecodeString(value)
+		if err != nil {
+			return fmt.Errorf("malformed reference %q", line)
+		}
+		s.refs = append(s.refs, RemoteRef{Name: name, Hash: hash})
+	}
+}
+
+func (s *ReceivePackSession) Refs() []RemoteRef {
+	return s.refs
+}
+
+func (s *ReceivePackSession) HasCapability(capability string) bool {
+	_, ok := s.capabilities[capability]
+	return ok
+}
+
+func (s *ReceivePackSession) Push(request PushRequest) ([]RefStatus, error) {
+	if len(request.Updates) == 0 {
+		return nil, nil
+	}
+	if request.Atomic && !s.HasCapability("atomic") {
+		return nil, errors.New("the remote does not support atomic pushes")
+	}
+
+	capabilities := []string{"report-status", "agent=" + userAgent}
+	if s.HasCapability("side-band-64k") {
+		capabilities = append(capabilities, "side-band-64k")
+	}
+	if request.Atomic {
+		capabilities = append(capabilities, "atomic")
+	}
+	if request.Progress == nil && s.HasCapability("quiet") {
+		capabilities = append(capabilities, "quiet")
+	}
+
+	buffer := bytes.NewBuffer(make([]byte, 0, 1024))
+	onlyDeletes := true
+	for i, update := range request.Updates {
+		old, new := hashOrZero(update.Old), hashOrZero(update.New)
+		if !bytes.Equal(new, ZeroHash) {
+			onlyDeletes = false
+		}
+
+		line := fmt.Sprintf("%x %x %s", old, new, update.Name)
+		if i == 0 {
+			line += "\000" + strings.Join(capabilities, " ")
+		}
+		writePacketLine(buffer, "%s", line)
+	}
+	writeFlush(buffer)
+
+	if !onlyDeletes {
+		err := request.WritePack(buffer)
+		if err != nil {
+			return nil, fmt.Errorf("cannot write pack: %w", err)
+		}
+	}
+
+	response, err := s.conn.request(buffer.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	defer response.Close()
+
+	reader := newPacketReader(response)
+	if s.HasCapability("side-band-64k") {
+		report := bytes.NewBuffer(make([]byte, 0, 1024))
+		err = demultiplexSideband(reader, report, newRemoteProgress(request.Progress))
+		if err != nil {
+			return nil, err
+		}
+		reader = newPacketReader(report)
+	}
+
+	return readReportStatus(reader)
+}
+
+func readReportStatus(reader *packetReader) ([]RefStatus, error) {
+	kind, line, err := reader.ReadLine()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read push status: %w", err)
+	}
+	if kind != packetData || !strings.HasPrefix(line, "unpack ") {
+		return nil, fmt.Errorf("malformed push status %q", line)
+	}
+	if result := strings.TrimPrefix(line, "unpack "); result != "ok" {
+		return nil, fmt.Errorf("remote failed to unpack: %s", result)
+	}
+
+	statuses := make([]RefStatus, 0)
+	for {
+		kind, line, err = reader.ReadLine()
+		if err != nil {
+			return nil, fmt.Errorf("cannot read push status: %w", err)
+		}
+		if kind == packetFlush {
+			return statuses, nil
+		}
+
+		result, rest, _ := strings.Cut(line, " ")
+		name, reason, _ := strings.Cut(rest, " ")
+		switch result {
+		case "ok":
+			statuses = append(statuses, RefStatus{Name: name, OK: true})
+		case "ng":
+			statuses = append(statuses, RefStatus{Name: name, Reason: reason})
+		default:
+			return nil, fmt.Errorf("malformed push status %q", line)
+		}
+	}
+}
+
+func hashOrZero(hash []byte) []byte {
+	if hash == nil {
+		return ZeroHash
+	}
+	return hash
+}
+
+func (s *ReceivePackSession) Close() error {
+	return s.conn.Close()
+}
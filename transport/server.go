@@ -0,0 +1,344 @@
+package transport
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// idleTimeout ends connections whose client neither sends nor receives
+	// anything for this long.
+	idleTimeout = time.Minute
+
+	// DefaultMaxPackSize bounds the size of pushed packs, which are held in
+	// memory while they are indexed.
+	DefaultMaxPackSize = 1024 * 1024 * 1024
+)
+
+// Server exposes the repository of the plumbing package over smart HTTP
+// and the git:// protocol. Clients are served concurrently, but only one
+// request at a time accesses the repository, because the object store is
+// not safe for concurrent use. Reading requests and sending responses
+// happens outside of that lock, so a slow client does not hold up others.
+type Server struct {
+	// ReceivePack allows clients to push to the repository.
+	ReceivePack bool
+	// MaxPackSize is the size in bytes above which pushed packs are
+	// refused, or 0 for no limit.
+	MaxPackSize int64
+
+	mutex sync.Mutex
+}
+
+func NewServer(receivePack bool) *Server {
+	return &Server{ReceivePack: receivePack, MaxPackSize: DefaultMaxPackSize}
+}
+
+func (s *Server) serviceAllowed(service string) bool {
+	switch service {
+	case ServiceUploadPack:
+		return true
+	case ServiceReceivePack:
+		return s.ReceivePack
+	default:
+		return false
+	}
+}
+
+// ServeSmartHTTP accepts smart HTTP connections until the listener is
+// closed. Connections that stall are closed after a timeout.
+func (s *Server) ServeSmartHTTP(listener net.Listener) error {
+	server := &http.Server{
+		Handler:           s,
+		ReadHeaderTimeout: idleTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+
+	err := server.Serve(listener)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-cache")
+
+	// Deadlines are not supported by every ResponseWriter, such as the
+	// recorders of tests, in which case the connection has none.
+	controller := http.NewResponseController(w)
+	body := idleTimeoutReader{reader: r.Body, setDeadline: controller.SetReadDeadline}
+	writer := idleTimeoutWriter{writer: w, setDeadline: controller.SetWriteDeadline}
+
+	switch {
+	case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/info/refs"):
+		s.serveHTTPAdvertisement(w, writer, r)
+	case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/"+ServiceUploadPack):
+		s.serveHTTPService(w, writer, r, body, ServiceUploadPack)
+	case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/"+ServiceReceivePack):
+		s.serveHTTPService(w, writer, r, body, ServiceReceivePack)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) serveHTTPAdvertisement(w http.ResponseWriter, body io.Writer, r *http.Request) {
+	service := r.URL.Query().Get("service")
+	if !s.serviceAllowed(service) {
+		http.Error(w, "service not enabled", http.StatusForbidden)
+		return
+	}
+
+	if service == ServiceUploadPack && !strings.Contains(r.Header.Get("Git-Protocol"), protocolVersion2) {
+		http.Error(w, "only protocol version 2 is supported", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", fmt.Sprintf("application/x-%s-advertisement", service))
+
+	writer := bufio.NewWriter(body)
+	writePacketLine(writer, "# service=%s", service)
+	writeFlush(writer)
+
+	advertise := writeUploadPackCapabilities
+	if service == ServiceReceivePack {
+		advertise = writeReceivePackAdvertisement
+	}
+	err := s.respond(writer, advertise)
+	if err != nil {
+		log.Printf("cannot advertise %s: %s", service, err)
+		return
+	}
+
+	writer.Flush()
+}
+
+func (s *Server) serveHTTPService(w http.ResponseWriter, response io.Writer, r *http.Request, request io.Reader, service string) {
+	if !s.serviceAllowed(service) {
+		http.Error(w, "service not enabled", http.StatusForbidden)
+		return
+	}
+
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gzipReader, err := gzip.NewReader(request)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer gzipReader.Close()
+		request = gzipReader
+	}
+
+	w.Header().Set("Content-Type", fmt.Sprintf("application/x-%s-result", service))
+
+	reader := bufio.NewReader(request)
+	writer := bufio.NewWriter(response)
+
+	var err error
+	if service == ServiceUploadPack {
+		_, err = s.serveUploadPackCommand(newPacketReader(reader), writer)
+	} else {
+		err = s.serveReceivePack(reader, writer)
+	}
+	if err != nil {
+		log.Printf("%s failed: %s", service, err)
+	}
+
+	writer.Flush()
+}
+
+// serveUploadPackCommand reads a protocol v2 command and answers it. It
+// reports whether the client may send further commands on the same
+// connection.
+func (s *Server) serveUploadPackCommand(reader *packetReader, w io.Writer) (bool, error) {
+	command, err := readUploadPackCommand(reader)
+	if err != nil || command == nil {
+		return false, err
+	}
+
+	return true, s.respond(w, command.answer)
+}
+
+// serveReceivePack reads a push and applies it.
+func (s *Server) serveReceivePack(reader *bufio.Reader, w io.Writer) error {
+	request, err := readReceivePackRequest(reader, s.MaxPackSize)
+	if err != nil || len(request.commands) == 0 {
+		return err
+	}
+
+	s.mutex.Lock()
+	report := request.apply()
+	s.mutex.Unlock()
+
+	return request.writeReport(w, report)
+}
+
+// respond runs answer with the repository locked. Its output, which may be
+// a large pack, is spooled to a temporary file and only sent to the client
+// once the lock is released.
+func (s *Server) respond(w io.Writer, answer func(io.Writer) error) error {
+	spool, err := os.CreateTemp("", "git-charged-response-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	writer := bufio.NewWriter(spool)
+	s.mutex.Lock()
+	err = answer(writer)
+	s.mutex.Unlock()
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = spool.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w, spool)
+	return err
+}
+
+// ServeDaemon accepts git:// connections until the listener is closed.
+func (s *Server) ServeDaemon(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		go func() {
+			defer conn.Close()
+
+			err := s.serveDaemonConnection(conn)
+			if err != nil {
+				log.Printf("git:// connection from %s failed: %s", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// idleTimeoutReader renews the read deadline of a connection before every
+// read, so a stalled client cannot keep it open.
+type idleTimeoutReader struct {
+	reader      io.Reader
+	setDeadline func(time.Time) error
+}
+
+func (r idleTimeoutReader) Read(p []byte) (int, error) {
+	r.setDeadline(time.Now().Add(idleTimeout))
+	return r.reader.Read(p)
+}
+
+// idleTimeoutWriter renews the write deadline of a connection before every
+// write.
+type idleTimeoutWriter struct {
+	writer      io.Writer
+	setDeadline func(time.Time) error
+}
+
+func (w idleTimeoutWriter) Write(p []byte) (int, error) {
+	w.setDeadline(time.Now().Add(idleTimeout))
+	return w.writer.Write(p)
+}
+
+// serveDaemonConnection serves a git:// connection.
+func (s *Server) serveDaemonConnection(conn net.Conn) error {
+	reader := bufio.NewReader(idleTimeoutReader{reader: conn, setDeadline: conn.SetReadDeadline})
+	writer := bufio.NewWriter(idleTimeoutWriter{writer: conn, setDeadline: conn.SetWriteDeadline})
+	packets := newPacketReader(reader)
+
+	kind, request, err := packets.Read()
+	if err != nil {
+		return err
+	}
+	if kind != packetData {
+		return errUnexpectedPacket
+	}
+
+	// The request looks like "git-upload-pack /path\0host=example\0\0version=2\0".
+	fields := strings.Split(string(request), "\000")
+	service, _, _ := strings.Cut(fields[0], " ")
+	version2 := false
+	for _, field := range fields[1:] {
+		if field == protocolVersion2 {
+			version2 = true
+		}
+	}
+
+	if !s.serviceAllowed(service) {
+		writePacketLine(writer, "ERR service not enabled: %s", service)
+		return writer.Flush()
+	}
+
+	if service == ServiceReceivePack {
+		err = s.respond(writer, writeReceivePackAdvertisement)
+		if err != nil {
+			return err
+		}
+		err = writer.Flush()
+		if err != nil {
+			return err
+		}
+
+		if !waitForRequest(reader) {
+			return nil
+		}
+		err = s.serveReceivePack(reader, writer)
+		if err != nil {
+			return err
+		}
+		return writer.Flush()
+	}
+
+	if !version2 {
+		writePacketLine(writer, "ERR only protocol version 2 is supported")
+		return writer.Flush()
+	}
+
+	err = writeUploadPackCapabilities(writer)
+	if err != nil {
+		return err
+	}
+	writer.Flush()
+
+	for waitForRequest(reader) {
+		more, err := s.serveUploadPackCommand(packets, writer)
+		if err != nil {
+			return err
+		}
+
+		err = writer.Flush()
+		if err != nil || !more {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// waitForRequest blocks until the client sends something. It reports
+// false if the connection was closed or timed out instead, which ends the
+// session quietly.
+func waitForRequest(reader *bufio.Reader) bool {
+	_, err := reader.Peek(1)
+	return err == nil
+}
@@ -0,0 +1,316 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"github.com/untanky/git-charged/plumbing"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useTestRepository points the plumbing package at an empty git directory
+// for the duration of the test.
+func useTestRepository(t *testing.T, name string) string {
+	t.Helper()
+
+	directory := path.Join(t.TempDir(), name)
+	for _, subdirectory := range []string{"objects", "refs/heads"} {
+		err := os.MkdirAll(path.Join(directory, subdirectory), os.ModePerm)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	plumbing.SetDirectory(directory)
+	t.Cleanup(func() {
+		plumbing.SetDirectory(".git")
+	})
+
+	return directory
+}
+
+// emptyPack returns a pack without objects.
+func emptyPack() []byte {
+	pack := []byte("PACK")
+	pack = binary.BigEndian.AppendUint32(pack, 2)
+	pack = binary.BigEndian.AppendUint32(pack, 0)
+	checksum := sha1.Sum(pack)
+	return append(pack, checksum[:]...)
+}
+
+func TestServerKeepsServingWhileAClientStalls(t *testing.T) {
+	useTestRepository(t, "repository.git")
+
+	server := httptest.NewServer(NewServer(true))
+	t.Cleanup(server.Close)
+
+	// A push that sends its commands and the start of a pack, then stalls.
+	stalled, stall := io.Pipe()
+	t.Cleanup(func() { stall.Close() })
+	go func() {
+		response, err := http.Post(server.URL+"/"+ServiceReceivePack, "application/x-git-receive-pack-request", stalled)
+		if err == nil {
+			response.Body.Close()
+		}
+	}()
+	var request bytes.Buffer
+	writePacketLine(&request, "%x %x refs/heads/main\000report-status", ZeroHash, bytes.Repeat([]byte{1}, 20))
+	writeFlush(&request)
+	request.Write(emptyPack()[:8])
+	_, err := stall.Write(request.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		httpRequest, _ := http.NewRequest(http.MethodGet, server.URL+"/info/refs?service="+ServiceUploadPack, nil)
+		httpRequest.Header.Set("Git-Protocol", protocolVersion2)
+		response, err := http.DefaultClient.Do(httpRequest)
+		if err == nil {
+			_, err = io.ReadAll(response.Body)
+			response.Body.Close()
+		}
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("advertisement failed: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("a stalled push blocks other clients")
+	}
+}
+
+func TestReadReceivePackRequestLimitsPackSize(t *testing.T) {
+	pack := emptyPack()
+	tests := []struct {
+		maxSize  int64
+		expected string
+	}{
+		{0, "ok"},
+		{int64(len(pack)), "ok"},
+		{int64(len(pack)) - 1, plumbing.ErrPackTooLarge.Error()},
+	}
+
+	for _, test := range tests {
+		var request bytes.Buffer
+		writePacketLine(&request, "%x %x refs/heads/main\000report-status", ZeroHash, bytes.Repeat([]byte{1}, 20))
+		writeFlush(&request)
+		request.Write(pack)
+
+		received, err := readReceivePackRequest(bufio.NewReader(&request), test.maxSize)
+		if err != nil || received.unpackStatus != test.expected {
+			t.Errorf("readReceivePackRequest(maxSize %d) = %+v, %v, want unpack status %q", test.maxSize, received, err, test.expected)
+		}
+	}
+}
+
+func TestCheckedOutBranches(t *testing.T) {
+	tests := []struct {
+		name     string
+		heads    map[string]string
+		expected map[string]bool
+	}{
+		{
+			name:     ".git",
+			heads:    map[string]string{"HEAD": "ref: refs/heads/main", "worktrees/a/HEAD": "ref: refs/heads/feature"},
+			expected: map[string]bool{"refs/heads/main": true, "refs/heads/feature": true},
+		},
+		{
+			name: "bare.git",
+			heads: map[string]string{
+				"HEAD":             "ref: refs/heads/main",
+				"worktrees/a/HEAD": "ref: refs/heads/feature",
+				"worktrees/b/HEAD": strings.Repeat("1", 40),
+			},
+			expected: map[string]bool{"refs/heads/feature": true},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			directory := useTestRepository(t, test.name)
+			for name, content := range test.heads {
+				err := os.MkdirAll(path.Dir(path.Join(directory, name)), os.ModePerm)
+				if err == nil {
+					err = os.WriteFile(path.Join(directory, name), []byte(content+"\n"), 0644)
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			branches := checkedOutBranches()
+			if !maps.Equal(branches, test.expected) {
+				t.Errorf("checkedOutBranches() = %v, want %v", branches, test.expected)
+			}
+
+			status := checkReceivedCommand(&receivedCommand{RefUpdate: RefUpdate{Name: "refs/heads/feature"}}, branches)
+			if status != "branch is currently checked out" {
+				t.Errorf("checkReceivedCommand() = %q, want the branch of the worktree to be refused", status)
+			}
+		})
+	}
+}
+
+func TestServeHTTPServices(t *testing.T) {
+	tests := []struct {
+		name        string
+		receivePack bool
+		method      string
+		target      string
+		protocol    string
+		status      int
+		contentType string
+		firstLine   string
+	}{
+		{
+			name:        "upload-pack advertisement",
+			method:      http.MethodGet,
+			target:      "/repository.git/info/refs?service=" + ServiceUploadPack,
+			protocol:    protocolVersion2,
+			status:      http.StatusOK,
+			contentType: "application/x-git-upload-pack-advertisement",
+			firstLine:   "# service=git-upload-pack\n",
+		},
+		{
+			name:   "upload-pack needs protocol version 2",
+			method: http.MethodGet,
+			target: "/repository.git/info/refs?service=" + ServiceUploadPack,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "receive-pack advertisement is disabled",
+			method: http.MethodGet,
+			target: "/repository.git/info/refs?service=" + ServiceReceivePack,
+			status: http.StatusForbidden,
+		},
+		{
+			name:        "receive-pack advertisement",
+			receivePack: true,
+			method:      http.MethodGet,
+			target:      "/repository.git/info/refs?service=" + ServiceReceivePack,
+			status:      http.StatusOK,
+			contentType: "application/x-git-receive-pack-advertisement",
+			firstLine:   "# service=git-receive-pack\n",
+		},
+		{
+			name:   "receive-pack is disabled",
+			method: http.MethodPost,
+			target: "/repository.git/" + ServiceReceivePack,
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "dumb HTTP",
+			method: http.MethodGet,
+			target: "/repository.git/info/refs",
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "unknown path",
+			method: http.MethodGet,
+			target: "/repository.git/HEAD",
+			status: http.StatusNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useTestRepository(t, "repository.git")
+
+			request := httptest.NewRequest(test.method, test.target, strings.NewReader(""))
+			if test.protocol != "" {
+				request.Header.Set("Git-Protocol", test.protocol)
+			}
+			recorder := httptest.NewRecorder()
+			NewServer(test.receivePack).ServeHTTP(recorder, request)
+
+			if recorder.Code != test.status {
+				t.Fatalf("status = %d, want %d: %s", recorder.Code, test.status, recorder.Body)
+			}
+			if test.status != http.StatusOK {
+				return
+			}
+			if contentType := recorder.Header().Get("Content-Type"); contentType != test.contentType {
+				t.Errorf("Content-Type = %q, want %q", contentType, test.contentType)
+			}
+			_, line, err := newPacketReader(bufio.NewReader(recorder.Body)).Read()
+			if err != nil || string(line) != test.firstLine {
+				t.Errorf("first line = %q, %v, want %q", line, err, test.firstLine)
+			}
+		})
+	}
+}
+
+func TestServeDaemonConnection(t *testing.T) {
+	tests := []struct {
+		name        string
+		receivePack bool
+		request     string
+		firstLine   string
+	}{
+		{
+			name:      "upload-pack",
+			request:   ServiceUploadPack + " /repository.git\000host=localhost\000\000" + protocolVersion2 + "\000",
+			firstLine: "version 2\n",
+		},
+		{
+			name:      "upload-pack needs protocol version 2",
+			request:   ServiceUploadPack + " /repository.git\000host=localhost\000",
+			firstLine: "ERR only protocol version 2 is supported\n",
+		},
+		{
+			name:      "receive-pack is disabled",
+			request:   ServiceReceivePack + " /repository.git\000host=localhost\000",
+			firstLine: "ERR service not enabled: git-receive-pack\n",
+		},
+		{
+			name:      "unknown service",
+			request:   "git-upload-archive /repository.git\000host=localhost\000",
+			firstLine: "ERR service not enabled: git-upload-archive\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useTestRepository(t, "repository.git")
+
+			client, conn := net.Pipe()
+			done := make(chan error, 1)
+			go func() {
+				defer conn.Close()
+				done <- NewServer(test.receivePack).serveDaemonConnection(conn)
+			}()
+
+			var request bytes.Buffer
+			writePacketLine(&request, "%s", test.request)
+			_, err := client.Write(request.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, line, err := newPacketReader(bufio.NewReader(client)).Read()
+			if err != nil || string(line) != test.firstLine {
+				t.Errorf("first line = %q, %v, want %q", line, err, test.firstLine)
+			}
+
+			client.Close()
+			if err := <-done; err != nil {
+				t.Errorf("serveDaemonConnection() = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,334 @@
+package transport
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/untanky/git-charged/plumbing"
+	"io"
+	"strings"
+)
+
+// maxCommandSize bounds the arguments of a command a client may send, such
+// as the wants and haves of a fetch.
+const maxCommandSize = 64 * 1024 * 1024
+
+type fetchArguments struct {
+	wants      [][]byte
+	haves      [][]byte
+	done       bool
+	noProgress bool
+	includeTag bool
+}
+
+func writeUploadPackCapabilities(w io.Writer) error {
+	lines := []string{
+		"version 2",
+		"agent=" + userAgent,
+		"ls-refs=unborn",
+		"fetch",
+		"object-format=sha1",
+	}
+
+	for _, line := range lines {
+		err := writePacketLine(w, "%s", line)
+		if err != nil {
+			return err
+		}
+	}
+
+	return writeFlush(w)
+}
+
+// uploadPackCommand is a protocol v2 command read from a client.
+type uploadPackCommand struct {
+	name      string
+	arguments []string
+}
+
+// readUploadPackCommand reads a single protocol v2 command. It returns nil
+// when the client ends the session.
+func readUploadPackCommand(reader *packetReader) (*uploadPackCommand, error) {
+	command := &uploadPackCommand{arguments: make([]string, 0)}
+	for {
+		kind, line, err := reader.ReadLine()
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if kind == packetFlush && command.name == "" {
+			return nil, nil
+		}
+		if kind == packetDelimiter || kind == packetFlush {
+			break
+		}
+
+		if strings.HasPrefix(line, "command=") {
+			command.name = strings.TrimPrefix(line, "command=")
+		}
+	}
+
+	size := 0
+	for {
+		kind, line, err := reader.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+		if kind == packetFlush {
+			break
+		}
+
+		size += len(line)
+		if size > maxCommandSize {
+			return nil, fmt.Errorf("%s request exceeds %d bytes", command.name, maxCommandSize)
+		}
+		command.arguments = append(command.arguments, line)
+	}
+
+	if command.name != "ls-refs" && command.name != "fetch" {
+		return nil, fmt.Errorf("unknown command %q", command.name)
+	}
+
+	return command, nil
+}
+
+// answer writes the response to a command.
+func (command *uploadPackCommand) answer(w io.Writer) error {
+	if command.name == "ls-refs" {
+		return serveLsRefs(command.arguments, w)
+	}
+	return serveFetch(command.arguments, w)
+}
+
+func serveLsRefs(arguments []string, w io.Writer) error {
+	symrefs, peel, unborn := false, false, false
+	prefixes := make([]string, 0)
+	for _, argument := range arguments {
+		switch {
+		case argument == "symrefs":
+			symrefs = true
+		case argument == "peel":
+			peel = true
+		case argument == "unborn":
+			unborn = true
+		case strings.HasPrefix(argument, "ref-prefix "):
+			prefixes = append(prefixes, strings.TrimPrefix(argument, "ref-prefix "))
+		}
+	}
+
+	matches := func(name string) bool {
+		if len(prefixes) == 0 {
+			return true
+		}
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if matches(plumbing.HEAD) {
+		target, isSymbolic, _ := plumbing.ReadSymbolicRef(plumbing.HEAD)
+		hash, err := plumbing.ResolveRef(plumbing.HEAD)
+
+		line := ""
+		switch {
+		case err == nil:
+			line = fmt.Sprintf("%x HEAD", hash)
+		case unborn && isSymbolic:
+			line = "unborn HEAD"
+		}
+		if line != "" {
+			if symrefs && isSymbolic {
+				line += " symref-target:" + target
+			}
+			writePacketLine(w, "%s", line)
+		}
+	}
+
+	refs, err := plumbing.ListRefs("refs/")
+	if err != nil {
+		return err
+	}
+
+	for _, ref := range refs {
+		if !matches(ref.Name) {
+			continue
+		}
+
+		line := fmt.Sprintf("%x %s", ref.Hash, ref.Name)
+		if symrefs {
+			if target, ok, _ := plumbing.ReadSymbolicRef(ref.Name); ok {
+				line += " symref-target:" + target
+			}
+		}
+		if peel {
+			if peeled, _, err := plumbing.Peel(ref.Hash); err == nil && string(peeled) != string(ref.Hash) {
+				line += fmt.Sprintf(" peeled:%x", peeled)
+			}
+		}
+
+		err = writePacketLine(w, "%s", line)
+		if err != nil {
+			return err
+		}
+	}
+
+	return writeFlush(w)
+}
+
+func parseFetchArguments(arguments []string) (*fetchArguments, error) {
+	parsed := &fetchArguments{
+		wants: make([][]byte, 0),
+		haves: make([][]byte, 0),
+	}
+
+	for _, argument := range arguments {
+		key, value, _ := strings.Cut(argument, " ")
+		switch key {
+		case "want", "have":
+			hash, err := hex.DecodeString(value)
+			if err != nil {
+				return nil, fmt.Errorf("malformed %s %q", key, value)
+			}
+			if key == "want" {
+				if !plumbing.HasObject(hash) {
+					return nil, fmt.Errorf("not our ref %s", value)
+				}
+				parsed.wants = append(parsed.wants, hash)
+			} else {
+				parsed.haves = append(parsed.haves, hash)
+			}
+		case "done":
+			parsed.done = true
+		case "no-progress":
+			parsed.noProgress = true
+		case "include-tag":
+			parsed.includeTag = true
+		}
+	}
+
+	return parsed, nil
+}
+
+func serveFetch(arguments []string, w io.Writer) error {
+	parsed, err := parseFetchArguments(arguments)
+	if err != nil {
+		writePacketLine(w, "ERR %s", err)
+		return err
+	}
+
+	common := make([][]byte, 0)
+	for _, have := range parsed.haves {
+		if plumbing.HasObject(have) {
+			common = append(common, have)
+		}
+	}
+
+	if !parsed.done {
+		writePacketLine(w, "acknowledgments")
+		if len(common) == 0 {
+			writePacketLine(w, "NAK")
+			return writeFlush(w)
+		}
+
+		for _, hash := range common {
+			writePacketLine(w, "ACK %x", hash)
+		}
+		writePacketLine(w, "ready")
+		writeDelimiter(w)
+	}
+
+	objects, err := plumbing.ReachableObjects(parsed.wants, common)
+	if err != nil {
+		return err
+	}
+
+	if parsed.includeTag {
+		objects, err = includeTags(objects)
+		if err != nil {
+			return err
+		}
+	}
+
+	writePacketLine(w, "packfile")
+
+	var progress io.Writer = io.Discard
+	if !parsed.noProgress {
+		progress = &sidebandWriter{writer: w, band: sidebandProgress}
+	}
+	fmt.Fprintf(progress, "Enumerating objects: %d, done.\n", len(objects))
+
+	packWriter, err := plumbing.NewPackWriter(&sidebandWriter{writer: w, band: sidebandData}, len(objects))
+	if err != nil {
+		return err
+	}
+
+	for _, hash := range objects {
+		err = packWriter.Add(hash)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = packWriter.Close()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(progress, "Total %d (delta 0), reused 0 (delta 0)\n", len(objects))
+
+	return writeFlush(w)
+}
+
+// includeTags adds annotated tags that point at objects which are sent.
+func includeTags(objects [][]byte) ([][]byte, error) {
+	sent := make(map[string]bool, len(objects))
+	for _, hash := range objects {
+		sent[string(hash)] = true
+	}
+
+	refs, err := plumbing.ListRefs("refs/tags/")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ref := range refs {
+		if sent[string(ref.Hash)] {
+			continue
+		}
+
+		tag, err := plumbing.ReadTag(ref.Hash)
+		if err != nil || !sent[string(tag.Object)] {
+			continue
+		}
+
+		sent[string(ref.Hash)] = true
+		objects = append(objects, ref.Hash)
+	}
+
+	return objects, nil
+}
+
+// sidebandWriter multiplexes writes onto a side-band channel.
+type sidebandWriter struct {
+	writer io.Writer
+	band   byte
+}
+
+func (s *sidebandWriter) Write(data []byte) (int, error) {
+	written := 0
+	for written < len(data) {
+		chunk := data[written:min(len(data), written+maxPacketLength-5)]
+
+		err := writePacket(s.writer, append([]byte{s.band}, chunk...))
+		if err != nil {
+			return written, err
+		}
+		written += len(chunk)
+	}
+
+	return written, nil
+}
@@ -0,0 +1,365 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/untanky/git-charged/plumbing"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	haveBatchSize = 32
+	maxHaves      = 256
+
+	// InfiniteDepth deepens a shallow repository until it is complete.
+	InfiniteDepth = 0x7fffffff
+)
+
+type RemoteRef struct {
+	Name         string
+	Hash         []byte
+	SymrefTarget string
+	Peeled       []byte
+}
+
+type FetchRequest struct {
+	Wants [][]byte
+	// Haves lists local commits offered during negotiation. It may be nil
+	// when the local repository is empty.
+	Haves *plumbing.CommitWalker
+	// Shallow lists the shallow commits of the local repository.
+	Shallow [][]byte
+	// Depth limits the history to the given number of commits per want.
+	Depth int
+	// DeepenSince limits the history to commits newer than the given time.
+	DeepenSince time.Time
+	// Filter is an object filter like "blob:none" for partial clones.
+	Filter string
+	// IncludeTag asks for annotated tags pointing at the sent objects.
+	IncludeTag bool
+	Pack       io.Writer
+	Progress   io.Writer
+}
+
+// FetchResponse lists the changes to the shallow boundary that the server
+// made while computing the pack.
+type FetchResponse struct {
+	Shallow   [][]byte
+	Unshallow [][]byte
+}
+
+// UploadPackSession talks protocol v2 to a remote upload-pack service.
+type UploadPackSession struct {
+	conn         connection
+	capabilities map[string]string
+}
+
+func NewUploadPackSession(endpoint *Endpoint) (*UploadPackSession, error) {
+	conn, err := connect(endpoint, ServiceUploadPack)
+	if err != nil {
+		return nil, err
+	}
+
+	session := &UploadPackSession{
+		conn:         conn,
+		capabilities: make(map[string]string),
+	}
+
+	err = session.readCapabilities()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("cannot talk to %s: %w", endpoint, err)
+	}
+
+	return session, nil
+}
+
+func (s *UploadPackSession) readCapabilities() error {
+	stream, err := s.conn.advertisement()
+	if err != nil {
+		return err
+	}
+
+	reader := newPacketReader(stream)
+	kind, line, err := readAdvertisementHeader(reader, ServiceUploadPack)
+	if err != nil {
+		return err
+	}
+	if kind != packetData || line != "version 2" {
+		return errors.New("remote does not support protocol version 2")
+	}
+
+	for {
+		kind, line, err = reader.ReadLine()
+		if err != nil {
+			return err
+		}
+		if kind == packetFlush {
+			return nil
+		}
+
+		key, value, _ := strings.Cut(line, "=")
+		s.capabilities[key] = value
+	}
+}
+
+func (s *UploadPackSession) HasCapability(command string, feature string) bool {
+	features, ok := s.capabilities[command]
+	if !ok {
+		return false
+	}
+	if feature == "" {
+		return true
+	}
+
+	for _, f := range strings.Fields(features) {
+		if f == feature {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *UploadPackSession) writeCommand(buffer *bytes.Buffer, command string) {
+	writePacketLine(buffer, "command=%s", command)
+	writePacketLine(buffer, "agent=%s", userAgent)
+	if format, ok := s.capabilities["object-format"]; ok {
+		writePacketLine(buffer, "object-format=%s", format)
+	}
+	writeDelimiter(buffer)
+}
+
+func (s *UploadPackSession) LsRefs(prefixes ...string) ([]RemoteRef, error) {
+	body := requestBody(func(buffer *bytes.Buffer) {
+		s.writeCommand(buffer, "ls-refs")
+		writePacketLine(buffer, "symrefs")
+		writePacketLine(buffer, "peel")
+		if s.HasCapability("ls-refs", "unborn") {
+			writePacketLine(buffer, "unborn")
+		}
+		for _, prefix := range prefixes {
+			writePacketLine(buffer, "ref-prefix %s", prefix)
+		}
+		writeFlush(buffer)
+	})
+
+	response, err := s.conn.request(body)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Close()
+
+	refs := make([]RemoteRef, 0)
+	reader := newPacketReader(response)
+	for {
+		kind, line, err := reader.ReadLine()
+		if err != nil {
+			return nil, fmt.Errorf("cannot list references: %w", err)
+		}
+		if kind == packetFlush || kind == packetResponseEnd {
+			return refs, nil
+		}
+
+		ref, err := parseRemoteRef(line)
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, ref)
+	}
+}
+
+func parseRemoteRef(line string) (RemoteRef, error) {
+	fields := strings.Split(line, " ")
+	if len(fields) < 2 {
+		return RemoteRef{}, fmt.Errorf("malformed reference %q", line)
+	}
+
+	ref := RemoteRef{Name: fields[1]}
+	if fields[0] != "unborn" {
+		hash, err := hex.DecodeString(fields[0])
+		if err != nil {
+			return RemoteRef{}, fmt.Errorf("malformed reference %q", line)
+		}
+		ref.Hash = hash
+	}
+
+	for _, attribute := range fields[2:] {
+		key, value, _ := strings.Cut(attribute, ":")
+		switch key {
+		case "symref-target":
+			ref.SymrefTarget = value
+		case "peeled":
+			peeled, err := hex.DecodeString(value)
+			if err != nil {
+				return RemoteRef{}, fmt.Errorf("malformed reference %q", line)
+			}
+			ref.Peeled = peeled
+		}
+	}
+
+	return ref, nil
+}
+
+func (s *UploadPackSession) Fetch(request FetchRequest) (*FetchResponse, error) {
+	deepen := request.Depth > 0 || !request.DeepenSince.IsZero()
+	if deepen && !s.HasCapability("fetch", "shallow") {
+		return nil, errors.New("remote does not support shallow fetches")
+	}
+	if request.Filter != "" && !s.HasCapability("fetch", "filter") {
+		return nil, errors.New("remote does not support object filters")
+	}
+
+	result := &FetchResponse{}
+	common := make([][]byte, 0)
+	isCommon := make(map[string]bool)
+	haveCount := 0
+
+	for {
+		haves := make([][]byte, 0, haveBatchSize)
+		for request.Haves != nil && len(haves) < haveBatchSize && haveCount < maxHaves {
+			hash, _, err := request.Haves.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			haves = append(haves, hash)
+			haveCount++
+		}
+		done := len(haves) == 0
+
+		body := requestBody(func(buffer *bytes.Buffer) {
+			s.writeCommand(buffer, "fetch")
+			writePacketLine(buffer, "ofs-delta")
+			if request.Progress == nil {
+				writePacketLine(buffer, "no-progress")
+			}
+			if request.IncludeTag {
+				writePacketLine(buffer, "include-tag")
+			}
+			for _, want := range request.Wants {
+				writePacketLine(buffer, "want %x", want)
+			}
+			for _, shallow := range request.Shallow {
+				writePacketLine(buffer, "shallow %x", shallow)
+			}
+			if request.Depth > 0 {
+				writePacketLine(buffer, "deepen %d", request.Depth)
+			}
+			if !request.DeepenSince.IsZero() {
+				writePacketLine(buffer, "deepen-since %d", request.DeepenSince.Unix())
+			}
+			if request.Filter != "" {
+				writePacketLine(buffer, "filter %s", request.Filter)
+			}
+			for _, have := range common {
+				writePacketLine(buffer, "have %x", have)
+			}
+			for _, have := range haves {
+				writePacketLine(buffer, "have %x", have)
+			}
+			if done {
+				writePacketLine(buffer, "done")
+			}
+			writeFlush(buffer)
+		})
+
+		response, err := s.conn.request(body)
+		if err != nil {
+			return nil, err
+		}
+
+		finished, acknowledged, err := s.readFetchResponse(newPacketReader(response), request, result)
+		response.Close()
+		if err != nil {
+			return nil, err
+		}
+		if finished {
+			return result, nil
+		}
+
+		for _, hash := range acknowledged {
+			if isCommon[string(hash)] {
+				continue
+			}
+			isCommon[string(hash)] = true
+			common = append(common, hash)
+			err = request.Haves.Hide(hash)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// readFetchResponse processes the sections of a fetch response. It reports
+// whether the packfile was received and which haves the server acknowledged.
+// Shallow updates are recorded in result.
+func (s *UploadPackSession) readFetchResponse(reader *packetReader, request FetchRequest, result *FetchResponse) (bool, [][]byte, error) {
+	acknowledged := make([][]byte, 0)
+
+	for {
+		kind, section, err := reader.ReadLine()
+		if err != nil {
+			return false, nil, fmt.Errorf("cannot read fetch response: %w", err)
+		}
+		if kind == packetFlush || kind == packetResponseEnd {
+			return false, acknowledged, nil
+		}
+		if kind != packetData {
+			return false, nil, errUnexpectedPacket
+		}
+
+		if section == "packfile" {
+			err = demultiplexSideband(reader, request.Pack, newRemoteProgress(request.Progress))
+			if err != nil {
+				return false, nil, fmt.Errorf("cannot receive pack: %w", err)
+			}
+			return true, acknowledged, nil
+		}
+
+		for {
+			kind, line, err := reader.ReadLine()
+			if err != nil {
+				return false, nil, fmt.Errorf("cannot read fetch response: %w", err)
+			}
+			if kind == packetDelimiter {
+				break
+			}
+			if kind == packetFlush || kind == packetResponseEnd {
+				return false, acknowledged, nil
+			}
+
+			switch {
+			case section == "acknowledgments" && strings.HasPrefix(line, "ACK "):
+				hash, err := hex.DecodeString(strings.TrimPrefix(line, "ACK "))
+				if err != nil {
+					return false, nil, fmt.Errorf("malformed acknowledgment %q", line)
+				}
+				acknowledged = append(acknowledged, hash)
+			case section == "shallow-info":
+				key, value, _ := strings.Cut(line, " ")
+				hash, err := hex.DecodeString(value)
+				if err != nil {
+					return false, nil, fmt.Errorf("malformed shallow info %q", line)
+				}
+				switch key {
+				case "shallow":
+					result.Shallow = append(result.Shallow, hash)
+				case "unshallow":
+					result.Unshallow = append(result.Unshallow, hash)
+				}
+			}
+		}
+	}
+}
+
+func (s *UploadPackSession) Close() error {
+	return s.conn.Close()
+}
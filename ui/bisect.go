@@ -0,0 +1,128 @@
+package ui
+
+import (
+	"fmt"
+	tea "github.com/charmbracelet/bubbletea"
+	"strings"
+)
+
+const defaultBisectHeight = 20
+
+// BisectStep is the progress of a bisection shown by a BisectView.
+type BisectStep struct {
+	// Status describes the remaining steps or the result.
+	Status string
+	// Candidates are the commits that can still be the first bad commit.
+	Candidates []string
+	// Current is the index of the candidate under test, or -1.
+	Current  int
+	Finished bool
+}
+
+type BisectView interface {
+	Run() error
+}
+
+type bisectModel struct {
+	step BisectStep
+	// mark marks the commit under test with a term like "good" and returns
+	// the next step.
+	mark   func(term string) (BisectStep, error)
+	offset int
+	height int
+	err    error
+}
+
+func NewBisectView(step BisectStep, mark func(term string) (BisectStep, error)) BisectView {
+	return bisectModel{
+		step:   step,
+		mark:   mark,
+		height: defaultBisectHeight,
+	}.scrollToCurrent()
+}
+
+func (m bisectModel) Run() error {
+	program := tea.NewProgram(m, tea.WithAltScreen())
+
+	_, err := program.Run()
+	return err
+}
+
+func (m bisectModel) Init() tea.Cmd {
+	return nil
+}
+
+// visibleCandidates is the number of candidates that fit between the
+// status and the help line.
+func (m bisectModel) visibleCandidates() int {
+	return max(1, m.height-strings.Count(m.step.Status, "\n")-5)
+}
+
+func (m bisectModel) scroll(delta int) bisectModel {
+	m.offset = max(0, min(len(m.step.Candidates)-m.visibleCandidates(), m.offset+delta))
+	return m
+}
+
+func (m bisectModel) scrollToCurrent() bisectModel {
+	m.offset = 0
+	if m.step.Current >= 0 {
+		return m.scroll(m.step.Current - m.visibleCandidates()/2)
+	}
+	return m
+}
+
+func (m bisectModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.height = msg.Height
+		return m.scrollToCurrent(), nil
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "ctrl+c", "q":
+			return m, tea.Quit
+		case "down", "j":
+			return m.scroll(1), nil
+		case "up", "k":
+			return m.scroll(-1), nil
+		case "g", "b", "s":
+			if m.step.Finished {
+				return m, nil
+			}
+
+			term := map[string]string{"g": "good", "b": "bad", "s": "skip"}[msg.String()]
+			step, err := m.mark(term)
+			m.err = err
+			if err == nil {
+				m.step = step
+				m = m.scrollToCurrent()
+			}
+		}
+	}
+
+	return m, nil
+}
+
+func (m bisectModel) View() string {
+	var builder strings.Builder
+	builder.WriteString(m.step.Status + "\n\n")
+
+	end := min(m.offset+m.visibleCandidates(), len(m.step.Candidates))
+	for i := m.offset; i < end; i++ {
+		cursor := " "
+		if i == m.step.Current {
+			cursor = ">"
+		}
+		fmt.Fprintf(&builder, "%s %s\n", cursor, m.step.Candidates[i])
+	}
+
+	switch {
+	case m.err != nil:
+		fmt.Fprintf(&builder, "\n✗ %s", m.err)
+	case m.step.Finished:
+		builder.WriteString("\n<Press q to quit>")
+	default:
+		builder.WriteString("\n<Press q to quit; g to mark good; b to mark bad; s to skip>")
+	}
+
+	return builder.String()
+}
@@ -0,0 +1,149 @@
+package ui
+
+import (
+	"fmt"
+	tea "github.com/charmbracelet/bubbletea"
+	"strings"
+)
+
+const defaultBlameHeight = 20
+
+// BlamePage is an annotated file shown by a BlameView.
+type BlamePage struct {
+	Title  string
+	Lines  []string
+	Cursor int
+	// Parent blames the revision before the commit of a line, or is nil if
+	// the page has no history to go back to.
+	Parent func(line int) (BlamePage, error)
+}
+
+type BlameView interface {
+	Run() error
+}
+
+type blameModel struct {
+	// pages is the stack of visited pages; the last one is shown.
+	pages  []BlamePage
+	offset int
+	height int
+	status string
+}
+
+func NewBlameView(page BlamePage) BlameView {
+	return blameModel{
+		pages:  []BlamePage{page},
+		height: defaultBlameHeight,
+	}
+}
+
+func (m blameModel) Run() error {
+	program := tea.NewProgram(m, tea.WithAltScreen())
+
+	_, err := program.Run()
+	return err
+}
+
+func (m blameModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m blameModel) page() *BlamePage {
+	return &m.pages[len(m.pages)-1]
+}
+
+// visibleLines is the number of file lines that fit between the title and
+// the status line.
+func (m blameModel) visibleLines() int {
+	return max(1, m.height-4)
+}
+
+func (m blameModel) moveCursor(delta int) blameModel {
+	page := m.page()
+	page.Cursor = max(0, min(len(page.Lines)-1, page.Cursor+delta))
+	return m.scrollToCursor()
+}
+
+func (m blameModel) scrollToCursor() blameModel {
+	page := m.page()
+	if page.Cursor < m.offset {
+		m.offset = page.Cursor
+	} else if page.Cursor >= m.offset+m.visibleLines() {
+		m.offset = page.Cursor - m.visibleLines() + 1
+	}
+	m.offset = max(0, m.offset)
+	return m
+}
+
+func (m blameModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.height = msg.Height
+		return m.scrollToCursor(), nil
+	case tea.KeyMsg:
+		m.status = ""
+		switch msg.String() {
+		case "ctrl+c", "q":
+			return m, tea.Quit
+		case "down", "j":
+			return m.moveCursor(1), nil
+		case "up", "k":
+			return m.moveCursor(-1), nil
+		case "pgdown", " ":
+			return m.moveCursor(m.visibleLines()), nil
+		case "pgup":
+			return m.moveCursor(-m.visibleLines()), nil
+		case "home", "g":
+			return m.moveCursor(-len(m.page().Lines)), nil
+		case "end", "G":
+			return m.moveCursor(len(m.page().Lines)), nil
+		case "enter", "p":
+			page := m.page()
+			if page.Parent == nil || len(page.Lines) == 0 {
+				m.status = "no parent to blame"
+				return m, nil
+			}
+
+			parent, err := page.Parent(page.Cursor)
+			if err != nil {
+				m.status = err.Error()
+				return m, nil
+			}
+
+			m.pages = append(m.pages, parent)
+			m.offset = 0
+			return m.moveCursor(0), nil
+		case "b", "backspace":
+			if len(m.pages) > 1 {
+				m.pages = m.pages[:len(m.pages)-1]
+				m.offset = 0
+				return m.moveCursor(0), nil
+			}
+		}
+	}
+
+	return m, nil
+}
+
+func (m blameModel) View() string {
+	page := m.page()
+
+	var builder strings.Builder
+	builder.WriteString(page.Title + "\n\n")
+
+	for i := m.offset; i < min(m.offset+m.visibleLines(), len(page.Lines)); i++ {
+		cursor := " "
+		if page.Cursor == i {
+			cursor = ">"
+		}
+		fmt.Fprintf(&builder, "%s %s\n", cursor, page.Lines[i])
+	}
+
+	if m.status != "" {
+		builder.WriteString("\n" + m.status)
+	} else {
+		builder.WriteString("\n<Press q to quit; enter to blame the parent commit; b to go back>")
+	}
+
+	return builder.String()
+}
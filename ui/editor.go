@@ -1,20 +1,50 @@
 package ui
 
 import (
+	"fmt"
 	"github.com/untanky/git-charged/config"
 	"os"
 	"os/exec"
+	"path"
 	"strings"
 )
 
 func OpenEditor(filepath string) error {
+	return runEditor(filepath)
+}
+
+// OpenEditorAt opens a file with the cursor on a line. Editors that are not
+// known to take a line are given just the file.
+func OpenEditorAt(filepath string, line int) error {
+	editor := editorCommand()
+	name := path.Base(strings.Split(editor, " ")[0])
+
+	switch name {
+	case "vi", "vim", "nvim", "nano", "emacs", "emacsclient", "micro", "kak", "hx":
+		return runEditor(fmt.Sprintf("+%d", line), filepath)
+	case "code", "code-insiders", "cursor", "subl", "zed":
+		args := []string{fmt.Sprintf("%s:%d", filepath, line)}
+		if strings.HasPrefix(name, "code") || name == "cursor" {
+			args = append([]string{"--goto"}, args...)
+		}
+		return runEditor(args...)
+	}
+
+	return runEditor(filepath)
+}
+
+func editorCommand() string {
 	editor, ok := config.Get("core.editor")
 	if !ok {
 		editor = "vim"
 	}
 
-	split := strings.Split(editor, " ")
-	cmd := exec.Command(split[0], append(split[1:], filepath)...)
+	return editor
+}
+
+func runEditor(args ...string) error {
+	split := strings.Split(editorCommand(), " ")
+	cmd := exec.Command(split[0], append(split[1:], args...)...)
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	err := cmd.Run()
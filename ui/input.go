@@ -0,0 +1,106 @@
+package ui
+
+import (
+	"fmt"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+type Input interface {
+	// Run asks for a line of text. It returns ErrInterrupted if the user
+	// quits.
+	Run() (string, error)
+}
+
+type inputModel struct {
+	title string
+	value []rune
+
+	// validate rejects a value with the reason shown below it.
+	validate func(value string) error
+	err      error
+
+	done        bool
+	interrupted bool
+}
+
+func NewInput(title string, validate func(value string) error) Input {
+	return inputModel{
+		title:    title,
+		value:    make([]rune, 0),
+		validate: validate,
+	}
+}
+
+func (m inputModel) Run() (string, error) {
+	program := tea.NewProgram(m)
+
+	model, err := program.Run()
+	if err != nil {
+		return "", err
+	}
+
+	result := model.(inputModel)
+	if result.interrupted {
+		return "", ErrInterrupted
+	}
+
+	return string(result.value), nil
+}
+
+func (m inputModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m inputModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	key, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return m, nil
+	}
+
+	switch key.Type {
+	case tea.KeyCtrlC, tea.KeyEsc:
+		m.interrupted = true
+		return m, tea.Quit
+	case tea.KeyEnter:
+		if m.validate != nil {
+			m.err = m.validate(string(m.value))
+			if m.err != nil {
+				return m, nil
+			}
+		}
+		m.done = true
+		return m, tea.Quit
+	case tea.KeyBackspace:
+		if len(m.value) > 0 {
+			m.value = m.value[:len(m.value)-1]
+		}
+	case tea.KeyCtrlU:
+		m.value = m.value[:0]
+	case tea.KeySpace:
+		m.value = append(m.value, ' ')
+	case tea.KeyRunes:
+		m.value = append(m.value, key.Runes...)
+	}
+
+	m.err = nil
+	return m, nil
+}
+
+func (m inputModel) View() string {
+	s := m.title + "\n\n"
+	s += fmt.Sprintf("> %s", string(m.value))
+	if !m.done {
+		s += "█"
+	}
+	s += "\n"
+
+	if m.err != nil {
+		s += fmt.Sprintf("\n✗ %s\n", m.err)
+	}
+
+	if !m.done {
+		s += "\n<Press ctrl+c to quit; ctrl+u to clear; enter to continue>"
+	}
+
+	return s
+}
@@ -0,0 +1,194 @@
+package ui
+
+import (
+	"errors"
+	"fmt"
+	tea "github.com/charmbracelet/bubbletea"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	progressBarWidth = 30
+	progressLines    = 12
+)
+
+var (
+	progressCounter = regexp.MustCompile(`\((\d+)/(\d+)\)`)
+
+	ErrInterrupted = errors.New("interrupted")
+)
+
+type Progress interface {
+	// Run executes task while rendering everything it writes. Lines ending
+	// in a carriage return replace each other, like git's progress meters.
+	Run(task func(progress io.Writer) error) error
+}
+
+type progressLineMsg struct {
+	line     string
+	finished bool
+}
+
+type progressDoneMsg struct {
+	err error
+}
+
+type progressModel struct {
+	title   string
+	lines   []string
+	current string
+
+	done        bool
+	interrupted bool
+	err         error
+}
+
+func NewProgress(title string) Progress {
+	return progressModel{
+		title: title,
+		lines: make([]string, 0),
+	}
+}
+
+func (m progressModel) Run(task func(progress io.Writer) error) error {
+	if !isTerminal(os.Stdout) {
+		fmt.Fprintln(os.Stderr, m.title)
+		return task(os.Stderr)
+	}
+
+	program := tea.NewProgram(m)
+
+	go func() {
+		writer := &progressWriter{program: program}
+		err := task(writer)
+		writer.flush()
+		program.Send(progressDoneMsg{err: err})
+	}()
+
+	model, err := program.Run()
+	if err != nil {
+		return err
+	}
+
+	result := model.(progressModel)
+	if result.interrupted {
+		return ErrInterrupted
+	}
+
+	return result.err
+}
+
+func (m progressModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m progressModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		if msg.String() == "ctrl+c" {
+			m.interrupted = true
+			return m, tea.Quit
+		}
+	case progressLineMsg:
+		if msg.finished {
+			m.lines = append(m.lines, msg.line)
+			m.current = ""
+		} else {
+			m.current = msg.line
+		}
+	case progressDoneMsg:
+		m.done = true
+		m.err = msg.err
+		return m, tea.Quit
+	}
+
+	return m, nil
+}
+
+func (m progressModel) View() string {
+	s := m.title + "\n\n"
+
+	start := max(0, len(m.lines)-progressLines)
+	for _, line := range m.lines[start:] {
+		s += line + "\n"
+	}
+
+	if m.current != "" {
+		s += m.current + "\n"
+		if bar := renderProgressBar(m.current); bar != "" {
+			s += bar + "\n"
+		}
+	}
+
+	if m.done {
+		if m.err != nil {
+			s += fmt.Sprintf("\n✗ %s\n", m.err)
+		} else {
+			s += "\n✓ done\n"
+		}
+	} else {
+		s += "\n<Press ctrl+c to abort>"
+	}
+
+	return s
+}
+
+func renderProgressBar(line string) string {
+	match := progressCounter.FindStringSubmatch(line)
+	if match == nil {
+		return ""
+	}
+
+	done, _ := strconv.Atoi(match[1])
+	total, _ := strconv.Atoi(match[2])
+	if total == 0 {
+		return ""
+	}
+
+	filled := min(progressBarWidth, done*progressBarWidth/total)
+	return "[" + strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled) + "]"
+}
+
+// progressWriter splits the task output into lines and forwards them to
+// the running program.
+type progressWriter struct {
+	program *tea.Program
+	partial []byte
+}
+
+func (w *progressWriter) Write(data []byte) (int, error) {
+	for _, b := range data {
+		switch b {
+		case '\n':
+			w.program.Send(progressLineMsg{line: string(w.partial), finished: true})
+			w.partial = w.partial[:0]
+		case '\r':
+			w.program.Send(progressLineMsg{line: string(w.partial)})
+			w.partial = w.partial[:0]
+		default:
+			w.partial = append(w.partial, b)
+		}
+	}
+
+	return len(data), nil
+}
+
+func (w *progressWriter) flush() {
+	if len(w.partial) > 0 {
+		w.program.Send(progressLineMsg{line: string(w.partial), finished: true})
+		w.partial = w.partial[:0]
+	}
+}
+
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeCharDevice != 0
+}
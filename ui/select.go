@@ -11,9 +11,13 @@ type Select interface {
 }
 
 type selectModel struct {
+	title    string
 	options  []string
 	selected string
 
+	// preview renders details of the option under the cursor.
+	preview func(option string) string
+
 	cursor     int
 	pageCursor int
 	pageSize   int
@@ -23,8 +27,9 @@ type selectModel struct {
 	filteredOptions []string
 }
 
-func NewSelect(options []string) Select {
+func NewSelect(title string, options []string) Select {
 	return selectModel{
+		title:    title,
 		options:  options,
 		selected: "",
 
@@ -38,6 +43,14 @@ func NewSelect(options []string) Select {
 	}
 }
 
+// NewPreviewSelect returns a Select that shows the output of preview for
+// the option under the cursor below the options.
+func NewPreviewSelect(title string, options []string, preview func(option string) string) Select {
+	model := NewSelect(title, options).(selectModel)
+	model.preview = preview
+	return model
+}
+
 func (m selectModel) Run() (string, error) {
 	program := tea.NewProgram(m)
 
@@ -146,7 +159,7 @@ func (m selectModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 }
 
 func (m selectModel) View() string {
-	s := "What .gitignore template do you want to include?\n\n"
+	s := m.title + "\n\n"
 
 	if m.searchMode {
 		s += fmt.Sprintf("Search: %s\n", m.searchTerm)
@@ -161,6 +174,10 @@ func (m selectModel) View() string {
 		s += fmt.Sprintf("%s %s\n", cursor, m.filteredOptions[i])
 	}
 
+	if m.preview != nil && m.cursor < len(m.filteredOptions) {
+		s += "\n" + strings.TrimRight(m.preview(m.filteredOptions[m.cursor]), "\n") + "\n"
+	}
+
 	if m.searchMode {
 		s += "\n<Press ctrl+c to quit; s to search; space to select; enter to continue>"
 	} else {
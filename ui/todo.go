@@ -0,0 +1,136 @@
+package ui
+
+import (
+	"fmt"
+	tea "github.com/charmbracelet/bubbletea"
+	"strings"
+)
+
+// TodoItem is a line of a todo list, such as the commits of an interactive
+// rebase, with the command to run for it.
+type TodoItem struct {
+	Command     string
+	Description string
+}
+
+type TodoEditor interface {
+	// Run returns the edited items, or ErrInterrupted if the user aborted.
+	Run() ([]TodoItem, error)
+}
+
+type todoModel struct {
+	title string
+	items []TodoItem
+	// commands are the available commands. Each is selected with its first
+	// letter.
+	commands []string
+
+	cursor      int
+	confirmed   bool
+	interrupted bool
+}
+
+func NewTodoEditor(title string, items []TodoItem, commands []string) TodoEditor {
+	return todoModel{
+		title:    title,
+		items:    append([]TodoItem(nil), items...),
+		commands: commands,
+	}
+}
+
+func (m todoModel) Run() ([]TodoItem, error) {
+	program := tea.NewProgram(m)
+
+	model, err := program.Run()
+	if err != nil {
+		return nil, err
+	}
+
+	result := model.(todoModel)
+	if result.interrupted || !result.confirmed {
+		return nil, ErrInterrupted
+	}
+
+	return result.items, nil
+}
+
+func (m todoModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m todoModel) moveItem(delta int) todoModel {
+	target := m.cursor + delta
+	if target < 0 || target >= len(m.items) {
+		return m
+	}
+
+	m.items[m.cursor], m.items[target] = m.items[target], m.items[m.cursor]
+	m.cursor = target
+	return m
+}
+
+func (m todoModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		key := msg.String()
+		switch key {
+		case "ctrl+c", "esc", "q":
+			m.interrupted = true
+			return m, tea.Quit
+		case "enter":
+			m.confirmed = true
+			return m, tea.Quit
+		case "down", "j":
+			m.cursor = min(len(m.items)-1, m.cursor+1)
+		case "up", "k":
+			m.cursor = max(0, m.cursor-1)
+		case "shift+down", "J":
+			m = m.moveItem(1)
+		case "shift+up", "K":
+			m = m.moveItem(-1)
+		case " ":
+			for i, command := range m.commands {
+				if command == m.items[m.cursor].Command {
+					m.items[m.cursor].Command = m.commands[(i+1)%len(m.commands)]
+					break
+				}
+			}
+		default:
+			for _, command := range m.commands {
+				if len(m.items) > 0 && key == command[:1] {
+					m.items[m.cursor].Command = command
+				}
+			}
+		}
+	}
+
+	return m, nil
+}
+
+func (m todoModel) View() string {
+	width := 0
+	for _, command := range m.commands {
+		width = max(width, len(command))
+	}
+
+	var builder strings.Builder
+	builder.WriteString(m.title + "\n\n")
+
+	for i, item := range m.items {
+		cursor := " "
+		if m.cursor == i {
+			cursor = ">"
+		}
+		fmt.Fprintf(&builder, "%s %-*s %s\n", cursor, width, item.Command, item.Description)
+	}
+
+	keys := make([]string, 0, len(m.commands))
+	for _, command := range m.commands {
+		keys = append(keys, fmt.Sprintf("%s to %s", command[:1], command))
+	}
+
+	builder.WriteString("\n<Press shift+up/down or K/J to move; space to cycle; " + strings.Join(keys, "; ") + ">")
+	builder.WriteString("\n<Press enter to start; q to abort>")
+
+	return builder.String()
+}